@@ -0,0 +1,308 @@
+package main
+
+// gateway load generator. logs in the seeded accounts (go run . seed -users N), opens websocket connections,
+// focuses a dm per connection and sends messages into it, then reports delivery latency percentiles.
+//
+//	go run ./cmd/loadgen -users 1000 -conns 2 -rate 0.5 -duration 2m
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// messages carry their send time so receivers can measure delivery
+const marker = "loadgen "
+
+type config struct {
+	url      string
+	users    int
+	conns    int
+	rate     float64
+	duration time.Duration
+	dialRate int
+	email    string
+	password string
+}
+
+type stats struct {
+	connected atomic.Int64
+	failed    atomic.Int64
+	sent      atomic.Int64
+	received  atomic.Int64
+	notified  atomic.Int64
+	errors    atomic.Int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+func (s *stats) record(d time.Duration) {
+	s.mu.Lock()
+	s.latencies = append(s.latencies, d)
+	s.mu.Unlock()
+	s.received.Add(1)
+}
+
+type account struct {
+	token         string
+	conversations []string
+}
+
+type event struct {
+	Op   int             `json:"op"`
+	Type string          `json:"t"`
+	Data json.RawMessage `json:"d"`
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.url, "url", "http://localhost:3000", "backend base url")
+	flag.IntVar(&cfg.users, "users", 100, "seeded accounts to log in")
+	flag.IntVar(&cfg.conns, "conns", 1, "gateway connections per account")
+	flag.Float64Var(&cfg.rate, "rate", 0.5, "messages per second per connection, the gateway closes above 2")
+	flag.DurationVar(&cfg.duration, "duration", time.Minute, "how long to send after everyone connected")
+	flag.IntVar(&cfg.dialRate, "dial-rate", 200, "new connections per second")
+	flag.StringVar(&cfg.email, "email", "seed%d@seed.localhost", "account email pattern, %d is 1..users")
+	flag.StringVar(&cfg.password, "password", "password123", "account password")
+	flag.Parse()
+
+	accounts := login(cfg)
+	if len(accounts) == 0 {
+		log.Fatal("no accounts logged in, seed the database first")
+	}
+	log.Printf("logged in %d accounts", len(accounts))
+
+	s := &stats{}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	dialEvery := time.Second / time.Duration(max(cfg.dialRate, 1))
+	for _, acc := range accounts {
+		for range cfg.conns {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runConnection(cfg, acc, s, stop)
+			}()
+			time.Sleep(dialEvery)
+		}
+	}
+	log.Printf("connected %d, failed %d", s.connected.Load(), s.failed.Load())
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	deadline := time.After(cfg.duration)
+
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			log.Printf("conns %d sent %d received %d notified %d errors %d",
+				s.connected.Load(), s.sent.Load(), s.received.Load(), s.notified.Load(), s.errors.Load())
+		case <-deadline:
+			break loop
+		case <-interrupt:
+			break loop
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+	report(s)
+}
+
+// login logs in every account and loads its conversations
+func login(cfg config) []account {
+	accounts := make([]account, 0, cfg.users)
+	for i := 1; i <= cfg.users; i++ {
+		body, _ := json.Marshal(map[string]string{"email": fmt.Sprintf(cfg.email, i), "password": cfg.password})
+
+		var user struct {
+			Token string `json:"token"`
+		}
+		if err := request(http.MethodPost, cfg.url+"/auth/login", "", body, &user); err != nil {
+			log.Printf("login %d: %v", i, err)
+			continue
+		}
+
+		var conversations []struct {
+			ID string `json:"id"`
+		}
+		if err := request(http.MethodGet, cfg.url+"/users/@me/conversations", user.Token, nil, &conversations); err != nil {
+			log.Printf("conversations %d: %v", i, err)
+			continue
+		}
+
+		acc := account{token: user.Token}
+		for _, c := range conversations {
+			acc.conversations = append(acc.conversations, c.ID)
+		}
+		accounts = append(accounts, acc)
+	}
+	return accounts
+}
+
+func request(method, url, token string, body []byte, out any) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return err
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+// runConnection identifies, focuses one of the accounts dms and sends into it until stop
+func runConnection(cfg config, acc account, s *stats, stop chan struct{}) {
+	gatewayURL := "ws" + strings.TrimPrefix(cfg.url, "http") + "/gateway"
+	conn, _, err := websocket.DefaultDialer.Dial(gatewayURL, nil)
+	if err != nil {
+		s.failed.Add(1)
+		return
+	}
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	write := func(message any) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(message)
+	}
+
+	if err := write(map[string]any{"op": 2, "d": map[string]string{"token": acc.token}}); err != nil {
+		s.failed.Add(1)
+		return
+	}
+
+	ready := make(chan struct{})
+	go readLoop(conn, s, ready)
+
+	select {
+	case <-ready:
+	case <-time.After(30 * time.Second):
+		s.failed.Add(1)
+		return
+	}
+	s.connected.Add(1)
+	defer s.connected.Add(-1)
+
+	if len(acc.conversations) == 0 || cfg.rate <= 0 {
+		<-stop
+		return
+	}
+
+	conversationID := acc.conversations[rand.IntN(len(acc.conversations))]
+	write(map[string]any{"op": 4, "d": map[string]string{"conversation_id": conversationID}})
+
+	// spread connections over the interval instead of sending in lockstep
+	interval := time.Duration(float64(time.Second) / cfg.rate)
+	time.Sleep(time.Duration(rand.Int64N(int64(interval))))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := write(map[string]any{"op": 22, "d": map[string]string{
+				"conversation_id": conversationID,
+				"content":         marker + strconv.FormatInt(time.Now().UnixNano(), 10),
+			}})
+			if err != nil {
+				s.errors.Add(1)
+				return
+			}
+			s.sent.Add(1)
+		}
+	}
+}
+
+func readLoop(conn *websocket.Conn, s *stats, ready chan struct{}) {
+	readyOnce := sync.Once{}
+	for {
+		var e event
+		if err := conn.ReadJSON(&e); err != nil {
+			return
+		}
+
+		switch {
+		case e.Op == 12:
+			readyOnce.Do(func() { close(ready) })
+		case e.Op == 0 && e.Type == "DM_MESSAGE_CREATE":
+			var message struct {
+				Content string `json:"content"`
+			}
+			json.Unmarshal(e.Data, &message)
+			if sentAt, ok := strings.CutPrefix(message.Content, marker); ok {
+				if nanos, err := strconv.ParseInt(sentAt, 10, 64); err == nil {
+					s.record(time.Since(time.Unix(0, nanos)))
+				}
+			}
+		case e.Op == 0 && e.Type == "DM_MESSAGE_NOTIFY":
+			s.notified.Add(1)
+		case e.Op == 0 && e.Type == "": // errors are untyped dispatches
+			s.errors.Add(1)
+		}
+	}
+}
+
+func report(s *stats) {
+	s.mu.Lock()
+	latencies := slices.Clone(s.latencies)
+	s.mu.Unlock()
+
+	fmt.Printf("\nsent %d, delivered %d (focused) + %d (notify), errors %d\n",
+		s.sent.Load(), s.received.Load(), s.notified.Load(), s.errors.Load())
+
+	if len(latencies) == 0 {
+		fmt.Println("no deliveries measured")
+		return
+	}
+
+	slices.Sort(latencies)
+	percentile := func(p float64) time.Duration {
+		return latencies[min(int(p*float64(len(latencies))), len(latencies)-1)]
+	}
+
+	fmt.Printf("latency p50 %v  p90 %v  p99 %v  max %v\n",
+		percentile(0.50), percentile(0.90), percentile(0.99), latencies[len(latencies)-1])
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	gomiddlewares "github.com/go-chi/chi/v5/middleware"
 	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
 	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
-	"github.com/hindsightchat/backend/src/middleware"
-	authroutes "github.com/hindsightchat/backend/src/routes/auth"
-	conversationroutes "github.com/hindsightchat/backend/src/routes/conversations"
-	friendroutes "github.com/hindsightchat/backend/src/routes/friends"
-	usersroutes "github.com/hindsightchat/backend/src/routes/users"
+	"github.com/hindsightchat/backend/src/lib/digest"
+	"github.com/hindsightchat/backend/src/lib/discordimport"
+	"github.com/hindsightchat/backend/src/lib/domainverify"
+	"github.com/hindsightchat/backend/src/lib/purge"
+	"github.com/hindsightchat/backend/src/lib/seed"
+	"github.com/hindsightchat/backend/src/lib/sessions"
+	"github.com/hindsightchat/backend/src/router"
+	serverroutes "github.com/hindsightchat/backend/src/routes/servers"
 	websocketroutes "github.com/hindsightchat/backend/src/routes/websocket"
 	"github.com/joho/godotenv"
 )
@@ -31,39 +38,83 @@ func main() {
 	// initialize database
 	database.InitDatabase()
 
+	// `seed` fills a development database and exits
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		runSeed(os.Args[2:])
+		return
+	}
+
 	// wait til valkey is ready
 	valkeydb.WaitUntilReady()
 
-	// start gochi server
+	// unread digest emails for users who opted in
+	go digest.Run()
 
-	r := chi.NewRouter()
+	// hard deletes old soft deleted rows and cleans up orphans
+	go purge.Run()
 
-	r.Use(middleware.CaseSensitiveMiddleware)
-	r.Use(middleware.SaveAuthTokenMiddleware)
-	r.Use(gomiddlewares.Logger)
+	// re-checks the domains servers claim to own
+	go domainverify.Run()
 
-	authroutes.RegisterRoutes(r)
-	friendroutes.RegisterRoutes(r)
-	usersroutes.RegisterRoutes(r)
-	websocketroutes.RegisterRoutes(r)
-	conversationroutes.RegisterRoutes(r)
+	// writes when login tokens were last used, for the idle timeout
+	go sessions.Run()
 
-	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
-		http.NotFound(w, r)
-	})
+	// removes members of temporary invites who got no role in time
+	go serverroutes.RunTemporaryMembers()
+
+	// imports that were running when the last instance stopped
+	discordimport.FailStale()
+
+	// start gochi server
+
+	r := router.New()
 
 	fmt.Println("backend running on http://localhost:" + "3000")
 
-	fmt.Println("\nRoutes:\n")
+	fmt.Print("\nRoutes:\n\n")
 
 	chi.Walk(r, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
 		fmt.Printf("[%s]: '%s' has %d middlewares\n", method, route, len(middlewares))
 		return nil
 	})
 
+	server := &http.Server{Addr: ":3000", Handler: r}
+
+	// on SIGINT / SIGTERM tell gateway clients to reconnect elsewhere before stopping
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		fmt.Println("shutting down")
+
+		websocketroutes.Shutdown()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
 	// serve without showing it to the world (only locally)
-	http.ListenAndServe(":3000", r)
+	server.ListenAndServe()
 
 	// http.ListenAndServe(":3000", r)
 
 }
+
+func runSeed(args []string) {
+	opts := seed.DefaultOptions()
+
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	flags.IntVar(&opts.Users, "users", opts.Users, "generated users besides the base user")
+	flags.IntVar(&opts.FriendsPerUser, "friends", opts.FriendsPerUser, "friendships per generated user")
+	flags.IntVar(&opts.Servers, "servers", opts.Servers, "servers everyone joins")
+	flags.IntVar(&opts.ChannelsPerServer, "channels", opts.ChannelsPerServer, "channels per server")
+	flags.IntVar(&opts.Messages, "messages", opts.Messages, "messages per channel and per dm")
+	flags.Parse(args)
+
+	if err := seed.Run(opts); err != nil {
+		fmt.Println("seed failed:", err)
+		os.Exit(1)
+	}
+}
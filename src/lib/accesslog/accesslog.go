@@ -0,0 +1,41 @@
+package accesslog
+
+// records admins reading user data together with why, kept for compliance.
+// the reason comes from the X-Access-Reason header of the request
+
+import (
+	"net/http"
+	"strings"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/ipban"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	ActionViewUser     = "admin.user.view"
+	ActionViewSessions = "admin.user.sessions"
+	ActionViewReport   = "admin.report.view" // includes the reported message
+)
+
+const maxReasonLength = 500
+
+// Reason returns the access reason given with the request, empty when there is none
+func Reason(r *http.Request) string {
+	reason := strings.TrimSpace(r.Header.Get("X-Access-Reason"))
+	if len(reason) > maxReasonLength {
+		reason = reason[:maxReasonLength]
+	}
+	return reason
+}
+
+// Record stores that actor accessed the targets data
+func Record(r *http.Request, actorID, targetID uuid.UUID, action, reason string) error {
+	return database.DB.Create(&database.DataAccessLog{
+		ActorID:      actorID,
+		TargetUserID: targetID,
+		Action:       action,
+		Reason:       reason,
+		IP:           ipban.ClientIP(r),
+	}).Error
+}
@@ -0,0 +1,142 @@
+package activity
+
+// validation of rich presence activities before they are stored or broadcast
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+var ErrUnknownApplication = errors.New("unknown application")
+
+// text fields are cut to this many runes
+const maxTextLength = 128
+
+// applications and their assets are cached this long, activities update often
+const cacheTTL = 5 * time.Minute
+
+type cachedApplication struct {
+	name     string
+	verified bool
+	assets   map[string]string
+	found    bool
+	loadedAt time.Time
+}
+
+var (
+	cache = make(map[uuid.UUID]cachedApplication)
+	mu    sync.RWMutex
+)
+
+// Sanitize returns a cleaned copy of the activity, resolving its application and asset keys.
+// nil means no activity
+func Sanitize(a *types.Activity) (*types.Activity, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	clean := &types.Activity{
+		SmallText: cleanText(a.SmallText),
+		LargeText: cleanText(a.LargeText),
+		Details:   cleanText(a.Details),
+		State:     cleanText(a.State),
+		AppName:   cleanText(a.AppName),
+	}
+
+	if t := a.Timestamps; t != nil && (t.Start > 0 || t.End > 0) {
+		clean.Timestamps = &types.ActivityTimestamps{Start: max(t.Start, 0), End: max(t.End, 0)}
+		if clean.Timestamps.End != 0 && clean.Timestamps.End < clean.Timestamps.Start {
+			clean.Timestamps.End = 0
+		}
+	}
+
+	if a.ApplicationID != "" {
+		appID, err := uuid.FromString(a.ApplicationID)
+		if err != nil {
+			return nil, ErrUnknownApplication
+		}
+
+		app := lookup(appID)
+		if !app.found {
+			return nil, ErrUnknownApplication
+		}
+
+		// the registered name wins so apps cant pass as each other
+		clean.ApplicationID = appID.String()
+		clean.AppName = app.name
+		clean.Verified = app.verified
+
+		large, small := app.assets[a.LargeImage], app.assets[a.SmallImage]
+		if large != "" || small != "" {
+			clean.LargeImage, clean.SmallImage = a.LargeImage, a.SmallImage
+			clean.Assets = &types.ActivityAssets{LargeURL: large, SmallURL: small}
+		}
+	}
+
+	if clean.AppName == "" && clean.Details == "" && clean.State == "" {
+		return nil, nil
+	}
+
+	return clean, nil
+}
+
+// Invalidate drops the cached application, call after changing it or its assets
+func Invalidate(appID uuid.UUID) {
+	mu.Lock()
+	delete(cache, appID)
+	mu.Unlock()
+}
+
+func lookup(appID uuid.UUID) cachedApplication {
+	mu.RLock()
+	cached, ok := cache[appID]
+	mu.RUnlock()
+
+	if ok && time.Since(cached.loadedAt) < cacheTTL {
+		return cached
+	}
+
+	cached = cachedApplication{assets: make(map[string]string), loadedAt: time.Now()}
+
+	var app database.Application
+	if err := database.DB.Where("id = ?", appID).First(&app).Error; err == nil {
+		cached.found = true
+		cached.name = app.Name
+		cached.verified = app.ActivityVerifiedAt != nil
+
+		var assets []database.ApplicationAsset
+		database.DB.Where("application_id = ?", appID).Find(&assets)
+		for _, asset := range assets {
+			cached.assets[asset.Key] = asset.URL
+		}
+	}
+
+	mu.Lock()
+	cache[appID] = cached
+	mu.Unlock()
+
+	return cached
+}
+
+// cleanText strips control characters and limits the length
+func cleanText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+
+	if runes := []rune(s); len(runes) > maxTextLength {
+		s = string(runes[:maxTextLength])
+	}
+	return s
+}
@@ -0,0 +1,136 @@
+package activityhistory
+
+// "recently played": a rolling history of the apps in a users activity, kept only for users who opted in.
+// an entry opens when an app shows up in their presence and closes when it changes or they go offline
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/usersettings"
+	"github.com/hindsightchat/backend/src/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	maxEntries = 50
+	historyTTL = 30 * 24 * time.Hour
+
+	// quick app switches arent worth remembering
+	minDuration = 30 * time.Second
+
+	// an entry whose close was missed, e.g the instance went down, is dropped after this
+	openTTL = 7 * 24 * time.Hour
+)
+
+type Entry struct {
+	AppName       string    `json:"app_name"`
+	ApplicationID string    `json:"application_id,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	EndedAt       time.Time `json:"ended_at"`
+	Duration      int64     `json:"duration"` // seconds
+}
+
+type openEntry struct {
+	AppName       string    `json:"app_name"`
+	ApplicationID string    `json:"application_id,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+}
+
+// Track follows the users activity as it changes, nil when it cleared or they went offline.
+// the opt in is only looked up when a new app shows up
+func Track(userID uuid.UUID, activity *types.Activity) {
+	ctx := context.Background()
+	rdb := valkeydb.GetValkeyClient()
+	key := valkeydb.ACTIVITY_CURRENT_PREFIX + userID.String()
+
+	var name, appID string
+	if activity != nil {
+		name, appID = activity.AppName, activity.ApplicationID
+	}
+
+	var current *openEntry
+	if data, err := rdb.Get(ctx, key).Bytes(); err == nil {
+		var entry openEntry
+		if json.Unmarshal(data, &entry) == nil {
+			current = &entry
+		}
+	}
+
+	if current != nil && current.AppName == name && current.ApplicationID == appID {
+		return
+	}
+	if current == nil && name == "" {
+		return
+	}
+
+	now := time.Now()
+	if current != nil {
+		rdb.Del(ctx, key)
+		if now.Sub(current.StartedAt) >= minDuration {
+			push(ctx, userID, Entry{
+				AppName:       current.AppName,
+				ApplicationID: current.ApplicationID,
+				StartedAt:     current.StartedAt,
+				EndedAt:       now,
+				Duration:      int64(now.Sub(current.StartedAt) / time.Second),
+			})
+		}
+	}
+
+	if name == "" {
+		return
+	}
+	if settings, err := usersettings.Get(userID); err != nil || !settings.ActivityHistory {
+		return
+	}
+
+	data, err := json.Marshal(openEntry{AppName: name, ApplicationID: appID, StartedAt: now})
+	if err != nil {
+		return
+	}
+	rdb.Set(ctx, key, data, openTTL)
+}
+
+// History returns the users entries, newest first
+func History(userID uuid.UUID) ([]Entry, error) {
+	ctx := context.Background()
+	rdb := valkeydb.GetValkeyClient()
+
+	values, err := rdb.LRange(ctx, valkeydb.ACTIVITY_HISTORY_PREFIX+userID.String(), 0, maxEntries-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(values))
+	for _, value := range values {
+		var entry Entry
+		if json.Unmarshal([]byte(value), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Clear forgets the users history and whatever is being tracked, e.g when they opt out
+func Clear(userID uuid.UUID) error {
+	ctx := context.Background()
+	rdb := valkeydb.GetValkeyClient()
+	return rdb.Del(ctx, valkeydb.ACTIVITY_HISTORY_PREFIX+userID.String(), valkeydb.ACTIVITY_CURRENT_PREFIX+userID.String()).Err()
+}
+
+func push(ctx context.Context, userID uuid.UUID, entry Entry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	key := valkeydb.ACTIVITY_HISTORY_PREFIX + userID.String()
+	pipe := valkeydb.GetValkeyClient().TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, maxEntries-1)
+	pipe.Expire(ctx, key, historyTTL)
+	pipe.Exec(ctx)
+}
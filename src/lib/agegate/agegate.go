@@ -0,0 +1,43 @@
+package agegate
+
+// nsfw channels only show their messages to users an admin verified the age of
+
+import (
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const ErrorMessage = "age verification required for nsfw channels"
+
+// Verified reports whether the users age was verified
+func Verified(userID uuid.UUID) bool {
+	var verifiedAt *time.Time
+	database.DB.Model(&database.User{}).Where("id = ?", userID).Select("age_verified_at").Scan(&verifiedAt)
+	return verifiedAt != nil
+}
+
+// CanRead reports whether the user may read the channels messages
+func CanRead(userID uuid.UUID, channel *database.Channel) bool {
+	return !channel.NSFW || Verified(userID)
+}
+
+// CanReadChannel is CanRead for a channel id, unknown channels count as readable and are checked elsewhere
+func CanReadChannel(userID, channelID uuid.UUID) bool {
+	var nsfw bool
+	database.DB.Model(&database.Channel{}).Where("id = ?", channelID).Select("nsfw").Scan(&nsfw)
+	return !nsfw || Verified(userID)
+}
+
+// ReadableChannelMessages leaves messages of nsfw channels out for users that arent verified
+func ReadableChannelMessages(userID uuid.UUID) func(*gorm.DB) *gorm.DB {
+	verified := Verified(userID)
+	return func(db *gorm.DB) *gorm.DB {
+		if verified {
+			return db
+		}
+		return db.Where("channel_id NOT IN (?)", database.DB.Model(&database.Channel{}).Select("id").Where("nsfw = ?", true))
+	}
+}
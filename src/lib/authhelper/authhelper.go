@@ -8,6 +8,8 @@ import (
 
 	usercache "github.com/hindsightchat/backend/src/lib/cache/user"
 	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/sessions"
+	"github.com/hindsightchat/backend/src/lib/stats"
 	"gorm.io/gorm"
 )
 
@@ -25,6 +27,14 @@ func GetUserIDFromToken(token string) (string, error) {
 		return "", err
 	}
 
+	if sessions.Idle(&found) {
+		return "", nil
+	}
+	sessions.Touch(&found)
+
+	// counts towards daily / monthly active users
+	stats.RecordActive(found.UserID.String())
+
 	return found.UserID.String(), nil
 }
 
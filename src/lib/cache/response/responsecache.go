@@ -0,0 +1,182 @@
+package responsecache
+
+// opt-in valkey cache for hot read endpoints. handlers check access first and then let Respond serve the
+// cached success response, answering If-None-Match and If-Modified-Since with 304. mutations call Invalidate
+// with the same key
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+)
+
+var errNoValkey = errors.New("valkey not connected")
+
+// how long the etag of a response is remembered, Last-Modified only moves when the etag changes
+const validatorTTL = 24 * time.Hour
+
+// ServerChannelsKey is the key of GET /servers/{id}/channels
+func ServerChannelsKey(serverID string) string {
+	return "server_channels:" + serverID
+}
+
+// UserKey is the key of GET /users/{id}
+func UserKey(userID string) string {
+	return "user:" + userID
+}
+
+// ServerKey is the key of GET /servers/{id}, per member since it carries their joined_at
+func ServerKey(serverID, userID string) string {
+	return "server:" + serverID + ":" + userID
+}
+
+// Respond writes the success response for key, from valkey when cached, else from load which is then cached
+// for ttl. nothing is written when load fails, the handler sends its own error
+func Respond(w http.ResponseWriter, r *http.Request, key string, ttl time.Duration, load func() (any, error)) error {
+	body, err := get(r.Context(), key)
+	if err != nil {
+		data, err := load()
+		if err != nil {
+			return err
+		}
+
+		body, err = marshal(data)
+		if err != nil {
+			return err
+		}
+
+		if rdb := valkeydb.GetValkeyClient(); rdb != nil {
+			rdb.Set(r.Context(), valkeydb.RESPONSE_CACHE_PREFIX+key, body, ttl)
+		}
+	}
+
+	write(w, r, key, body)
+	return nil
+}
+
+// Conditional writes data as a success response with the same validators as Respond, without caching it.
+// for responses that are cheap to build but still worth a 304
+func Conditional(w http.ResponseWriter, r *http.Request, key string, data any) error {
+	body, err := marshal(data)
+	if err != nil {
+		return err
+	}
+
+	write(w, r, key, body)
+	return nil
+}
+
+// same envelope as httpresponder.SendSuccessResponse
+func marshal(data any) ([]byte, error) {
+	body, err := json.Marshal(map[string]any{"data": data, "success": true})
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+// ETag is the tag Conditional sends for data, for handlers that take it back as If-Match
+func ETag(data any) (string, error) {
+	body, err := marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return etagOf(body), nil
+}
+
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func write(w http.ResponseWriter, r *http.Request, key string, body []byte) {
+	etag := etagOf(body)
+	modified := lastModified(r.Context(), key, etag)
+
+	// clients revalidate every time, unchanged responses cost a 304
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// notModified checks the conditional headers, If-None-Match wins when both are sent
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if header := r.Header.Get("If-Modified-Since"); header != "" {
+		since, err := http.ParseTime(header)
+		return err == nil && !modified.After(since)
+	}
+
+	return false
+}
+
+// lastModified is when the response got its current etag. the validator outlives the cached body and
+// Invalidate, so rebuilding an unchanged response keeps the old time
+func lastModified(ctx context.Context, key, etag string) time.Time {
+	now := time.Now().Truncate(time.Second)
+
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return now
+	}
+
+	validatorKey := valkeydb.RESPONSE_CACHE_PREFIX + key + ":validator"
+	if stored, err := rdb.Get(ctx, validatorKey).Result(); err == nil {
+		storedTag, unix, _ := strings.Cut(stored, " ")
+		if seconds, err := strconv.ParseInt(unix, 10, 64); err == nil && storedTag == etag {
+			rdb.Expire(ctx, validatorKey, validatorTTL)
+			return time.Unix(seconds, 0)
+		}
+	}
+
+	rdb.Set(ctx, validatorKey, etag+" "+strconv.FormatInt(now.Unix(), 10), validatorTTL)
+	return now
+}
+
+// Invalidate drops cached responses, call it after the data behind them changed
+func Invalidate(ctx context.Context, keys ...string) {
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil || len(keys) == 0 {
+		return
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = valkeydb.RESPONSE_CACHE_PREFIX + key
+	}
+	rdb.Del(ctx, prefixed...)
+}
+
+func get(ctx context.Context, key string) ([]byte, error) {
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return nil, errNoValkey
+	}
+	return rdb.Get(ctx, valkeydb.RESPONSE_CACHE_PREFIX+key).Bytes()
+}
@@ -0,0 +1,193 @@
+package commands
+
+// slash command definitions and option validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	OptionString  = "string"
+	OptionInteger = "integer"
+	OptionNumber  = "number"
+	OptionBoolean = "boolean"
+	OptionUser    = "user"
+	OptionChannel = "channel"
+)
+
+const maxOptions = 25
+
+var validName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+var optionTypes = map[string]bool{
+	OptionString:  true,
+	OptionInteger: true,
+	OptionNumber:  true,
+	OptionBoolean: true,
+	OptionUser:    true,
+	OptionChannel: true,
+}
+
+type Choice struct {
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+}
+
+type Option struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required,omitempty"`
+	Choices     []Choice `json:"choices,omitempty"`
+}
+
+// Validate checks a command definition before it is stored
+func Validate(name, description string, options []Option) error {
+	if !validName.MatchString(name) {
+		return errors.New("name must be 1-32 lowercase letters, numbers, dashes or underscores")
+	}
+	if description == "" || len(description) > 100 {
+		return errors.New("description must be between 1 and 100 characters")
+	}
+	if len(options) > maxOptions {
+		return fmt.Errorf("a command can have at most %d options", maxOptions)
+	}
+
+	seen := make(map[string]bool, len(options))
+	seenOptional := false
+
+	for _, opt := range options {
+		if !validName.MatchString(opt.Name) {
+			return fmt.Errorf("invalid option name %q", opt.Name)
+		}
+		if seen[opt.Name] {
+			return fmt.Errorf("duplicate option %q", opt.Name)
+		}
+		seen[opt.Name] = true
+
+		if opt.Description == "" || len(opt.Description) > 100 {
+			return fmt.Errorf("option %q needs a description of at most 100 characters", opt.Name)
+		}
+		if !optionTypes[opt.Type] {
+			return fmt.Errorf("option %q has unknown type %q", opt.Name, opt.Type)
+		}
+
+		// keeps client side argument parsing simple
+		if opt.Required && seenOptional {
+			return errors.New("required options must come before optional ones")
+		}
+		if !opt.Required {
+			seenOptional = true
+		}
+
+		for _, choice := range opt.Choices {
+			if _, err := coerce(opt.Type, choice.Value); err != nil {
+				return fmt.Errorf("choice %q of option %q: %v", choice.Name, opt.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Resolve checks the values a user provided against the definition and normalizes them
+func Resolve(options []Option, provided map[string]any) (map[string]any, error) {
+	resolved := make(map[string]any, len(provided))
+
+	known := make(map[string]bool, len(options))
+	for _, opt := range options {
+		known[opt.Name] = true
+
+		raw, ok := provided[opt.Name]
+		if !ok || raw == nil {
+			if opt.Required {
+				return nil, fmt.Errorf("missing required option %q", opt.Name)
+			}
+			continue
+		}
+
+		value, err := coerce(opt.Type, raw)
+		if err != nil {
+			return nil, fmt.Errorf("option %q: %v", opt.Name, err)
+		}
+
+		if len(opt.Choices) > 0 && !matchesChoice(opt.Choices, value) {
+			return nil, fmt.Errorf("option %q must be one of the listed choices", opt.Name)
+		}
+
+		resolved[opt.Name] = value
+	}
+
+	for name := range provided {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown option %q", name)
+		}
+	}
+
+	return resolved, nil
+}
+
+func coerce(optionType string, raw any) (any, error) {
+	switch optionType {
+	case OptionString:
+		value, ok := raw.(string)
+		if !ok {
+			return nil, errors.New("expected a string")
+		}
+		if len(value) > 6000 {
+			return nil, errors.New("value too long")
+		}
+		return value, nil
+	case OptionInteger:
+		value, ok := raw.(float64) // json numbers
+		if !ok || value != float64(int64(value)) {
+			return nil, errors.New("expected an integer")
+		}
+		return int64(value), nil
+	case OptionNumber:
+		value, ok := raw.(float64)
+		if !ok {
+			return nil, errors.New("expected a number")
+		}
+		return value, nil
+	case OptionBoolean:
+		value, ok := raw.(bool)
+		if !ok {
+			return nil, errors.New("expected a boolean")
+		}
+		return value, nil
+	case OptionUser, OptionChannel:
+		str, ok := raw.(string)
+		if !ok {
+			return nil, errors.New("expected an id")
+		}
+		id, err := uuid.FromString(str)
+		if err != nil {
+			return nil, errors.New("expected an id")
+		}
+		return id.String(), nil
+	}
+
+	return nil, errors.New("unknown option type")
+}
+
+func matchesChoice(choices []Choice, value any) bool {
+	for _, choice := range choices {
+		// choices went through json too, compare the normalized forms
+		if choiceKey(choice.Value) == choiceKey(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func choiceKey(value any) string {
+	if f, ok := value.(float64); ok && f == float64(int64(f)) {
+		return fmt.Sprint(int64(f))
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,91 @@
+package consent
+
+// terms of service / privacy policy versions users have to accept.
+// versions come from TOS_VERSION and PRIVACY_VERSION, leaving one empty disables that check
+
+import (
+	"os"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+type Versions struct {
+	Tos     string `json:"tos_version,omitempty"`
+	Privacy string `json:"privacy_version,omitempty"`
+}
+
+// Current returns the versions users must have accepted
+func Current() Versions {
+	return Versions{
+		Tos:     os.Getenv("TOS_VERSION"),
+		Privacy: os.Getenv("PRIVACY_VERSION"),
+	}
+}
+
+// Enforced reports whether any document has to be accepted at all
+func Enforced() bool {
+	current := Current()
+	return current.Tos != "" || current.Privacy != ""
+}
+
+// Outstanding returns the documents the user still has to accept, empty fields are up to date
+func Outstanding(user *database.User) Versions {
+	current := Current()
+	outstanding := Versions{}
+
+	// bots are covered by their owner's acceptance
+	if user.IsBot {
+		return outstanding
+	}
+
+	if current.Tos != "" && user.TosVersion != current.Tos {
+		outstanding.Tos = current.Tos
+	}
+	if current.Privacy != "" && user.PrivacyVersion != current.Privacy {
+		outstanding.Privacy = current.Privacy
+	}
+
+	return outstanding
+}
+
+// UpToDate reports whether the user accepted every current document
+func UpToDate(user *database.User) bool {
+	return Outstanding(user) == Versions{}
+}
+
+// Accept records acceptance of the current versions for the user
+func Accept(tx *gorm.DB, userID uuid.UUID, ip string) error {
+	current := Current()
+	now := time.Now()
+
+	return tx.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]any{}
+
+		if current.Tos != "" {
+			record := database.ConsentRecord{UserID: userID, Document: database.ConsentDocumentTos, Version: current.Tos, AcceptedAt: now, IP: ip}
+			if err := tx.Create(&record).Error; err != nil {
+				return err
+			}
+			updates["tos_version"] = current.Tos
+			updates["tos_accepted_at"] = now
+		}
+
+		if current.Privacy != "" {
+			record := database.ConsentRecord{UserID: userID, Document: database.ConsentDocumentPrivacy, Version: current.Privacy, AcceptedAt: now, IP: ip}
+			if err := tx.Create(&record).Error; err != nil {
+				return err
+			}
+			updates["privacy_version"] = current.Privacy
+			updates["privacy_accepted_at"] = now
+		}
+
+		if len(updates) == 0 {
+			return nil
+		}
+
+		return tx.Model(&database.User{}).Where("id = ?", userID).Updates(updates).Error
+	})
+}
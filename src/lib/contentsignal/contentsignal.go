@@ -0,0 +1,188 @@
+package contentsignal
+
+// escalation hook for messages that match configured patterns, e.g self harm or emergencies.
+// off unless CONTENT_SIGNALS=true, patterns come from CONTENT_SIGNAL_PATTERNS_FILE with one
+// "category regex" per line. a signal only carries ids and the category, never the content:
+// registered hooks (the gateway tells server moderators) and CONTENT_SIGNAL_URL get the same.
+// dms are only checked with CONTENT_SIGNAL_DMS=true and only ever reach the url, encrypted ones never
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	uuid "github.com/satori/go.uuid"
+)
+
+// one signal per author and category in this window, a spiral of messages shouldnt page anyone every time
+const cooldown = 10 * time.Minute
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+type pattern struct {
+	category string
+	re       *regexp.Regexp
+}
+
+// Signal is a message that matched, ServerID and ChannelID or ConversationID are set
+type Signal struct {
+	Category       string     `json:"category"`
+	MessageID      uuid.UUID  `json:"message_id"`
+	AuthorID       uuid.UUID  `json:"author_id"`
+	ServerID       *uuid.UUID `json:"server_id,omitempty"`
+	ChannelID      *uuid.UUID `json:"channel_id,omitempty"`
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+	At             time.Time  `json:"at"`
+}
+
+// Hook gets every signal, it runs in the background
+type Hook func(Signal)
+
+var (
+	patterns []pattern
+	loadOnce sync.Once
+
+	hooks   []Hook
+	hooksMu sync.RWMutex
+)
+
+// Enabled reports whether this instance opted in
+func Enabled() bool {
+	return os.Getenv("CONTENT_SIGNALS") == "true"
+}
+
+// DMsEnabled reports whether direct messages are checked too
+func DMsEnabled() bool {
+	return Enabled() && os.Getenv("CONTENT_SIGNAL_DMS") == "true"
+}
+
+// Register adds a hook, call it on startup
+func Register(hook Hook) {
+	hooksMu.Lock()
+	hooks = append(hooks, hook)
+	hooksMu.Unlock()
+}
+
+func load() {
+	path := os.Getenv("CONTENT_SIGNAL_PATTERNS_FILE")
+	if path == "" {
+		log.Println("[contentsignal] CONTENT_SIGNAL_PATTERNS_FILE not set, nothing will match")
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("[contentsignal] failed to open patterns: %v", err)
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		category, expr, ok := strings.Cut(text, " ")
+		if !ok {
+			log.Printf("[contentsignal] line %d: expected \"category regex\"", line)
+			continue
+		}
+		re, err := regexp.Compile(strings.TrimSpace(expr))
+		if err != nil {
+			log.Printf("[contentsignal] line %d: %v", line, err)
+			continue
+		}
+		patterns = append(patterns, pattern{category: category, re: re})
+	}
+	log.Printf("[contentsignal] loaded %d patterns", len(patterns))
+}
+
+// match returns the category of the first pattern the content matches
+func match(content string) (string, bool) {
+	loadOnce.Do(load)
+	for _, p := range patterns {
+		if p.re.MatchString(content) {
+			return p.category, true
+		}
+	}
+	return "", false
+}
+
+// CheckChannelMessage raises a signal when the message matches, call it in the background
+func CheckChannelMessage(serverID, channelID, messageID, authorID uuid.UUID, content string) {
+	if !Enabled() {
+		return
+	}
+	check(Signal{MessageID: messageID, AuthorID: authorID, ServerID: &serverID, ChannelID: &channelID}, content)
+}
+
+// CheckDirectMessage is CheckChannelMessage for dms, encrypted ones cant be read and are never checked
+func CheckDirectMessage(conversationID, messageID, authorID uuid.UUID, content string, encrypted bool) {
+	if encrypted || !DMsEnabled() {
+		return
+	}
+	check(Signal{MessageID: messageID, AuthorID: authorID, ConversationID: &conversationID}, content)
+}
+
+func check(signal Signal, content string) {
+	category, ok := match(content)
+	if !ok {
+		return
+	}
+
+	if rdb := valkeydb.GetValkeyClient(); rdb != nil {
+		key := valkeydb.CONTENT_SIGNAL_PREFIX + signal.AuthorID.String() + ":" + category
+		if first, err := rdb.SetNX(context.Background(), key, "1", cooldown).Result(); err == nil && !first {
+			return
+		}
+	}
+
+	signal.Category = category
+	signal.At = time.Now()
+
+	// dms stay between the participants and the configured endpoint
+	if signal.ServerID != nil {
+		hooksMu.RLock()
+		registered := append([]Hook{}, hooks...)
+		hooksMu.RUnlock()
+		for _, hook := range registered {
+			hook(signal)
+		}
+	}
+
+	sendToEndpoint(signal)
+}
+
+func sendToEndpoint(signal Signal) {
+	url := os.Getenv("CONTENT_SIGNAL_URL")
+	if url == "" {
+		return
+	}
+
+	body, err := json.Marshal(signal)
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[contentsignal] endpoint request failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("[contentsignal] endpoint answered %d", resp.StatusCode)
+	}
+}
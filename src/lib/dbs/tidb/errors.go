@@ -0,0 +1,28 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// mysql / tidb error number of a unique constraint violation
+const errDuplicateEntry = 1062
+
+// DuplicateKey reports whether err is a unique constraint violation, along with the name of the
+// violated index, e.g idx_users_email
+func DuplicateKey(err error) (string, bool) {
+	var mysqlErr *mysqldriver.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != errDuplicateEntry {
+		return "", false
+	}
+
+	// Duplicate entry 'value' for key 'users.idx_users_email', the table prefix is missing on older servers
+	key := mysqlErr.Message[strings.LastIndex(mysqlErr.Message, " ")+1:]
+	key = strings.Trim(key, "'")
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		key = key[i+1:]
+	}
+	return key, true
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// cyrillic and greek letters that look exactly like latin ones, folded so аlice can't pass for alice.
+// applied after case folding, so only lowercase forms are listed
+var confusables = strings.NewReplacer(
+	"а", "a", "в", "b", "е", "e", "к", "k", "м", "m", "н", "h", "о", "o", "р", "p", "с", "c", "т", "t",
+	"у", "y", "х", "x", "ѕ", "s", "і", "i", "ј", "j", "ԁ", "d", "ӏ", "l", "ԛ", "q", "ԝ", "w",
+	"α", "a", "ο", "o", "ρ", "p", "ν", "v", "ι", "i", "κ", "k", "τ", "t", "υ", "u", "χ", "x",
+)
+
+// NormalizeHandle is the form handles are compared in, so handles differing only in case, width or
+// look-alike letters are the same handle
+func NormalizeHandle(handle string) string {
+	folded := cases.Fold().String(norm.NFKC.String(handle))
+	return norm.NFKC.String(confusables.Replace(folded))
+}
+
+// FullUsername is the username of the user with the handle on domain, what people see and type
+func FullUsername(handle, domain string) string {
+	if handle == "" {
+		return domain
+	}
+	return handle + "." + domain
+}
+
+// SplitHandle splits a handle written as name@domain, false when it isnt written that way
+func SplitHandle(value string) (handle, domain string, ok bool) {
+	handle, domain, ok = strings.Cut(value, "@")
+	if !ok || domain == "" {
+		return "", "", false
+	}
+	return handle, domain, true
+}
+
+// ByHandle finds the user written as name@domain, or by the full username (name.domain) otherwise.
+// handles are matched in their normalized form, domains case insensitively
+func ByHandle(value string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if handle, domain, ok := SplitHandle(value); ok {
+			return db.Where("normalized_handle = ? AND domain = ?", NormalizeHandle(handle), strings.ToLower(domain))
+		}
+
+		// handles cant contain dots, so the name ends at the first one. without one it is the user of the domain itself
+		match := db.Session(&gorm.Session{NewDB: true}).Where("normalized_handle = '' AND domain = ?", strings.ToLower(value))
+		if handle, domain, ok := strings.Cut(value, "."); ok {
+			match = match.Or("normalized_handle = ? AND domain = ?", NormalizeHandle(handle), strings.ToLower(domain))
+		}
+		return db.Where(match)
+	}
+}
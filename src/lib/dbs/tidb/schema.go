@@ -21,18 +21,56 @@ func (base *BaseModel) BeforeCreate(tx *gorm.DB) (err error) {
 
 type User struct {
 	BaseModel
-	Username string `gorm:"type:varchar(50);uniqueIndex;not null"`
-	Domain   string `gorm:"type:varchar(100);not null"` // e.g .aurality.stream
+	// handle and domain together identify the user, alice@a.com and alice@b.com are different people
+	Handle   string `gorm:"type:varchar(50);not null;default:'';uniqueIndex:idx_users_handle_domain"`                                      // empty when the user is the domain itself
+	Domain   string `gorm:"type:varchar(100);not null;uniqueIndex:idx_users_handle_domain;uniqueIndex:idx_users_normalized_handle_domain"` // e.g .aurality.stream
+	Username string `gorm:"type:varchar(160);index;not null"`                                                                              // handle.domain as shown to people, see FullUsername
+	// NormalizeHandle of the handle, set on save. lookups use it, so look-alike handles can't be registered twice
+	NormalizedHandle string `gorm:"type:varchar(160);not null;default:'';uniqueIndex:idx_users_normalized_handle_domain"`
 
 	Email    string `gorm:"type:varchar(100);uniqueIndex;not null"`
 	Password string `gorm:"type:varchar(255);not null"`
 
 	ProfilePicURL string `gorm:"type:varchar(255)"` // URL to profile picture
 
+	// profile
+	Bio         string `gorm:"type:varchar(800)"` // up to 190 characters
+	BannerURL   string `gorm:"type:varchar(255)"`
+	AccentColor *int   // 0xRRGGBB, null for the client default
+
+	// bumped on every profile change, the If-Match precondition of profile updates
+	ProfileVersion int64 `gorm:"not null;default:0"`
+
 	IsDomainVerified bool `gorm:"not null;default:false"`
 
 	Status string `gorm:"type:varchar(20);not null;default:'online'"`
 
+	LastSeenAt *time.Time // when the last gateway connection closed
+
+	IsBot bool `gorm:"not null;default:false"` // bot user owned by an application
+
+	// instance administration
+	IsAdmin        bool       `gorm:"not null;default:false"`
+	DisabledAt     *time.Time `gorm:"index"` // set when an admin disables the account
+	DisabledReason string     `gorm:"type:varchar(500)"`
+
+	// shadow restriction, the user isnt told. friend requests and dms to non-friends are stored but never delivered
+	RestrictedAt     *time.Time `gorm:"index"`
+	RestrictedReason string     `gorm:"type:varchar(500)"`
+
+	RegistrationIP string `gorm:"type:varchar(45)"`
+
+	// set when a revoked or expired login token keeps being presented, likely a stolen cookie. cleared by an admin
+	TokenReuseAt *time.Time `gorm:"index"`
+
+	AgeVerifiedAt *time.Time // set by an admin, needed to read nsfw channels
+
+	// last accepted legal documents, see ConsentRecord for the full history
+	TosVersion        string `gorm:"type:varchar(32)"`
+	TosAcceptedAt     *time.Time
+	PrivacyVersion    string `gorm:"type:varchar(32)"`
+	PrivacyAcceptedAt *time.Time
+
 	// Relations
 	Tokens            []UserToken      `gorm:"foreignKey:UserID"`
 	OwnedServers      []Server         `gorm:"foreignKey:OwnerID"`
@@ -48,11 +86,19 @@ type User struct {
 	FriendshipsAsUser2     []Friendship    `gorm:"foreignKey:User2ID"`
 }
 
+func (u *User) BeforeSave(tx *gorm.DB) (err error) {
+	u.NormalizedHandle = NormalizeHandle(u.Handle)
+	return
+}
+
 type UserToken struct {
 	BaseModel
 	UserID    uuid.UUID `gorm:"type:char(36);not null;index"`
 	Token     string    `gorm:"type:char(64);not null;uniqueIndex"`
 	ExpiresAt int64     `gorm:"not null;index"`
+	IP        string    `gorm:"type:varchar(45)"` // ip the session was created from
+	// last request made with the token, written in batches so it can lag a few minutes
+	LastUsedAt *time.Time
 
 	User User `gorm:"foreignKey:UserID"`
 }
@@ -64,7 +110,21 @@ type Server struct {
 	Icon        string    `gorm:"type:varchar(255)"` // URL to server icon
 	OwnerID     uuid.UUID `gorm:"type:char(36);not null;index"`
 
-	OwnedDomain string `gorm:"type:varchar(100);uniqueIndex"` // e.g. mydomain.com
+	OwnedDomain *string `gorm:"type:varchar(100);uniqueIndex"` // e.g. mydomain.com, null when the server claims none
+
+	// proof of OwnedDomain, see domainverify. the token is published in dns or under /.well-known
+	DomainStatus            string `gorm:"type:varchar(16)"` // pending, verified or failed, empty without a domain
+	DomainVerificationToken string `gorm:"type:varchar(64)"`
+	DomainVerifiedAt        *time.Time
+	DomainCheckedAt         *time.Time `gorm:"index"`
+
+	TranslationEnabled bool `gorm:"not null;default:false"` // members may machine translate messages
+	MessageTombstones  bool `gorm:"not null;default:false"` // deleted messages show up as placeholders in history
+
+	// bumped on every settings update, the If-Match precondition of PATCH requests
+	Version int64 `gorm:"not null;default:0"`
+
+	MemberCount int64 `gorm:"not null;default:0"` // kept up to date by membercount on join and leave
 
 	Owner    User           `gorm:"foreignKey:OwnerID"`
 	Channels []Channel      `gorm:"foreignKey:ServerID"`
@@ -72,6 +132,12 @@ type Server struct {
 	Roles    []Role         `gorm:"foreignKey:ServerID"`
 }
 
+const (
+	DomainStatusPending  = "pending"
+	DomainStatusVerified = "verified"
+	DomainStatusFailed   = "failed" // was verified once, the proof is gone since
+)
+
 // permission role within server
 type Role struct {
 	BaseModel
@@ -81,6 +147,7 @@ type Role struct {
 	Permissions uint64    `gorm:"not null;default:0"`
 	Position    int       `gorm:"not null;default:0"` // role hierarchy position, higher means more priority
 	IsDefault   bool      `gorm:"not null;default:false"`
+	Mentionable bool      `gorm:"not null;default:false"` // <@&role_id> in a message reaches the members with the role
 
 	Server Server `gorm:"foreignKey:ServerID"`
 }
@@ -92,37 +159,166 @@ type ServerMember struct {
 	UserID   uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_server_user"`
 	JoinedAt time.Time `gorm:"not null"`
 
+	// the members activity is left out of presence sent to this server, friends and dms still see it
+	HideActivity bool `gorm:"not null;default:false"`
+
+	// joined through a temporary invite and has no role yet, removed once the grace period is over
+	Temporary bool `gorm:"not null;default:false;index"`
+
 	Server Server `gorm:"foreignKey:ServerID"`
 	User   User   `gorm:"foreignKey:UserID"`
 	Roles  []Role `gorm:"many2many:server_member_roles;"`
 }
 
+// invite codes let people join a server, created by members with the create invites permission
+type Invite struct {
+	BaseModel
+	Code      string     `gorm:"type:varchar(16);not null;uniqueIndex"`
+	ServerID  uuid.UUID  `gorm:"type:char(36);not null;index"`
+	CreatorID uuid.UUID  `gorm:"type:char(36);not null"`
+	MaxUses   int        `gorm:"not null;default:0"` // 0 for unlimited
+	Uses      int        `gorm:"not null;default:0"`
+	ExpiresAt *time.Time // null when it never expires
+	Temporary bool       `gorm:"not null;default:false"` // members who join through it are temporary
+
+	Server  Server `gorm:"foreignKey:ServerID"`
+	Creator User   `gorm:"foreignKey:CreatorID"`
+}
+
 // channel represents a channel within a server
 type Channel struct {
 	BaseModel
-	ServerID    uuid.UUID `gorm:"type:char(36);not null;index"`
-	Name        string    `gorm:"type:varchar(100);not null"`
-	Description string    `gorm:"type:varchar(500)"`
-	Type        int       `gorm:"not null;default:0"` // 0=text, 1=voice
-	Position    int       `gorm:"not null;default:0"`
+	ServerID    uuid.UUID  `gorm:"type:char(36);not null;index"`
+	Name        string     `gorm:"type:varchar(100);not null"`
+	Description string     `gorm:"type:varchar(500)"`
+	Type        int        `gorm:"not null;default:0"` // 0=text, 1=voice, 2=announcement
+	Position    int        `gorm:"not null;default:0"`
+	Topic       string     `gorm:"type:varchar(1024)"`
+	NSFW        bool       `gorm:"column:nsfw;not null;default:false"` // messages are only shown to age verified users
+	CategoryID  *uuid.UUID `gorm:"type:char(36);index"`                // nil for channels outside any category
 
 	Server   Server           `gorm:"foreignKey:ServerID"`
+	Category *ChannelCategory `gorm:"foreignKey:CategoryID"`
 	Messages []ChannelMessage `gorm:"foreignKey:ChannelID"`
 }
 
+// named group of channels in a server, clients show the channels under it ordered by their own position
+type ChannelCategory struct {
+	BaseModel
+	ServerID uuid.UUID `gorm:"type:char(36);not null;index"`
+	Name     string    `gorm:"type:varchar(100);not null"`
+	Position int       `gorm:"not null;default:0"`
+}
+
+const (
+	ChannelTypeText         = 0
+	ChannelTypeVoice        = 1
+	ChannelTypeAnnouncement = 2 // other servers can follow it, published posts are mirrored into their channels
+)
+
+// channel of another server that gets the published posts of an announcement channel
+type ChannelFollow struct {
+	BaseModel
+	SourceChannelID uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_channel_follow"`
+	TargetChannelID uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_channel_follow;index"`
+	CreatorID       uuid.UUID `gorm:"type:char(36);not null"`
+
+	SourceChannel Channel `gorm:"foreignKey:SourceChannelID"`
+	TargetChannel Channel `gorm:"foreignKey:TargetChannelID"`
+}
+
+// scheduled event of a server, held in one of its channels or at an external location
+type ScheduledEvent struct {
+	BaseModel
+	ServerID    uuid.UUID `gorm:"type:char(36);not null;index"`
+	CreatorID   uuid.UUID `gorm:"type:char(36);not null"`
+	Title       string    `gorm:"type:varchar(100);not null"`
+	Description string    `gorm:"type:varchar(1000)"`
+	StartsAt    time.Time `gorm:"not null;index"`
+	EndsAt      *time.Time
+	ChannelID   *uuid.UUID `gorm:"type:char(36)"`
+	Location    string     `gorm:"type:varchar(255)"` // set instead of ChannelID for events elsewhere
+
+	RemindedAt *time.Time `gorm:"index"` // reminder went out to the rsvps
+
+	Creator User     `gorm:"foreignKey:CreatorID"`
+	Channel *Channel `gorm:"foreignKey:ChannelID"`
+}
+
+// rsvp answers
+const (
+	RSVPGoing      = "going"
+	RSVPInterested = "interested"
+)
+
+// EventRSVP is a members answer to a scheduled event, both answers get the reminder
+type EventRSVP struct {
+	BaseModel
+	EventID uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_event_rsvp_user"`
+	UserID  uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_event_rsvp_user;index"`
+	Status  string    `gorm:"type:varchar(16);not null"`
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
 // channel message represents a message in a server channel
 type ChannelMessage struct {
 	BaseModel
 	ChannelID   uuid.UUID  `gorm:"type:char(36);not null;index"`
-	AuthorID    uuid.UUID  `gorm:"type:char(36);not null;index"`
+	AuthorID    uuid.UUID  `gorm:"type:char(36);not null;index;uniqueIndex:idx_bridge_remote_message"`
 	Content     string     `gorm:"type:text;not null"`
 	Attachments string     `gorm:"type:json"` // JSON array of attachments
 	ReplyToID   *uuid.UUID `gorm:"type:char(36);index"`
 	EditedAt    *time.Time
 
+	InteractionID *uuid.UUID `gorm:"type:char(36)"`       // set when the message answers a slash command
+	WebhookID     *uuid.UUID `gorm:"type:char(36);index"` // set when posted by a webhook, author is then the webhook creator
+	Embeds        *string    `gorm:"type:json"`           // JSON array of embeds, null for regular messages
+
+	// name and avatar a webhook posted under, overrides included
+	WebhookName   string `gorm:"type:varchar(80)"`
+	WebhookAvatar string `gorm:"type:varchar(255)"`
+
+	// @everyone / @here in the content took effect, only when the author may use them
+	MentionEveryone bool `gorm:"not null;default:false"`
+
+	// provenance for messages mirrored in by a bridge, author is then the bridge bot.
+	// RemoteID is the id on the remote network and doubles as the dedupe key per bridge
+	RemoteProtocol string     `gorm:"type:varchar(16)"`
+	RemoteID       *string    `gorm:"type:varchar(255);uniqueIndex:idx_bridge_remote_message"`
+	PuppetID       *uuid.UUID `gorm:"type:char(36)"`
+
+	// position in the channel, see MessageCounter. 0 for messages older than the counters
+	Seq int64 `gorm:"not null;default:0"`
+
+	// PublishedAt is set once a post of an announcement channel went out to its followers.
+	// mirrored posts point at the original, they cant be edited in the following channel
+	PublishedAt     *time.Time
+	SourceMessageID *uuid.UUID `gorm:"type:char(36);index"`
+	SourceChannelID *uuid.UUID `gorm:"type:char(36)"`
+
 	Channel Channel         `gorm:"foreignKey:ChannelID"`
 	Author  User            `gorm:"foreignKey:AuthorID"`
 	ReplyTo *ChannelMessage `gorm:"foreignKey:ReplyToID"`
+	Webhook *Webhook        `gorm:"foreignKey:WebhookID"`
+}
+
+// ChannelReadState is how far a member read a channel, moved by message acks.
+// channels without one count as read up to when the member joined the server
+type ChannelReadState struct {
+	BaseModel
+	UserID     uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_channel_read_user"`
+	ChannelID  uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_channel_read_user"`
+	LastReadAt time.Time `gorm:"not null"`
+}
+
+// MessageCounter keeps message counts of a channel or conversation so they never need a table scan.
+// Total goes down on delete, Sequence only ever goes up and numbers new messages
+type MessageCounter struct {
+	BaseModel
+	TargetID uuid.UUID `gorm:"type:char(36);not null;uniqueIndex"` // channel or conversation id
+	Total    int64     `gorm:"not null;default:0"`
+	Sequence int64     `gorm:"not null;default:0"`
 }
 
 // DMConversation represents a DM conversation (1:1 or group)
@@ -131,6 +327,20 @@ type DMConversation struct {
 	Name    string `gorm:"type:varchar(100)"`      // Only for group DMs
 	IsGroup bool   `gorm:"not null;default:false"` // true if group DM, false if 1:1 so frontend figures out the name based on participants
 
+	MessageTombstones bool `gorm:"not null;default:false"` // deleted messages show up as placeholders in history
+
+	ParticipantCount int64 `gorm:"not null;default:0"` // kept up to date by membercount on join and leave
+
+	// set on conversations recreated from another platforms data export, e.g discord
+	ImportedFrom string `gorm:"type:varchar(16)"`
+
+	// the server the group was turned into, a group can be upgraded once
+	UpgradedToServerID *uuid.UUID `gorm:"type:char(36)"`
+
+	// who created the group, the only one who can post while the group is announcement only
+	OwnerID          *uuid.UUID `gorm:"type:char(36)"`
+	AnnouncementOnly bool       `gorm:"not null;default:false"`
+
 	Participants []DMParticipant `gorm:"foreignKey:ConversationID"`
 	Messages     []DirectMessage `gorm:"foreignKey:ConversationID"`
 }
@@ -143,6 +353,19 @@ type DMParticipant struct {
 	JoinedAt       time.Time `gorm:"not null"`
 	LastReadAt     *time.Time
 
+	// created_at of the newest message one of the participants clients received, for delivery ticks
+	LastDeliveredAt *time.Time
+
+	// hidden conversations are left out of lists and notifications and need a passcode to read
+	Hidden       bool   `gorm:"not null;default:false"`
+	PasscodeHash string `gorm:"type:varchar(60)"` // bcrypt
+
+	// your typing indicators aren't sent to the other participants
+	HideTyping bool `gorm:"not null;default:false"`
+
+	// group admins can add, remove and rename like the owner, only the owner makes admins
+	IsAdmin bool `gorm:"not null;default:false"`
+
 	Conversation DMConversation `gorm:"foreignKey:ConversationID"`
 	User         User           `gorm:"foreignKey:UserID"`
 }
@@ -162,12 +385,41 @@ type DirectMessage struct {
 	Attachments    string     `gorm:"type:json"`
 	ReplyToID      *uuid.UUID `gorm:"type:char(36);index"`
 	EditedAt       *time.Time
+	Shadowed       bool    `gorm:"not null;default:false"` // sent by a restricted user, only visible to the author and their friends
+	Embeds         *string `gorm:"type:json"`              // JSON array of embeds, e.g the card of a shared channel message
+
+	// end to end encrypted, Content is ciphertext the server never reads
+	Encrypted      bool   `gorm:"not null;default:false"`
+	SenderDeviceID string `gorm:"type:varchar(64)"`
+
+	// position in the conversation, see MessageCounter. 0 for messages older than the counters
+	Seq int64 `gorm:"not null;default:0"`
 
 	Conversation DMConversation `gorm:"foreignKey:ConversationID"`
 	Author       User           `gorm:"foreignKey:AuthorID"`
 	ReplyTo      *DirectMessage `gorm:"foreignKey:ReplyToID"`
 }
 
+// import job states
+const (
+	ImportStatusPending = "pending"
+	ImportStatusRunning = "running"
+	ImportStatusDone    = "done"
+	ImportStatusFailed  = "failed"
+)
+
+// import of another platforms data export into the users dms
+type ImportJob struct {
+	BaseModel
+	UserID        uuid.UUID `gorm:"type:char(36);not null;index"`
+	Source        string    `gorm:"type:varchar(16);not null"` // e.g discord
+	Status        string    `gorm:"type:varchar(16);not null"`
+	Error         string    `gorm:"type:varchar(500)"`
+	Conversations int       `gorm:"not null;default:0"`
+	Messages      int64     `gorm:"not null;default:0"`
+	FinishedAt    *time.Time
+}
+
 // friend request status
 type FriendRequestStatus int
 
@@ -183,11 +435,24 @@ type FriendRequest struct {
 	SenderID   uuid.UUID           `gorm:"type:char(36);not null;index"`
 	ReceiverID uuid.UUID           `gorm:"type:char(36);not null;index"`
 	Status     FriendRequestStatus `gorm:"not null;default:0"`
+	Shadowed   bool                `gorm:"not null;default:false"` // sent by a restricted user, hidden from the receiver
+
+	// FriendRequestPendingKey of the pair while pending, null otherwise. the unique index allows
+	// one pending request between two users in either direction
+	PendingKey *string `gorm:"type:varchar(73);uniqueIndex"`
 
 	Sender   User `gorm:"foreignKey:SenderID"`
 	Receiver User `gorm:"foreignKey:ReceiverID"`
 }
 
+// FriendRequestPendingKey identifies a pair of users regardless of who sent the request
+func FriendRequestPendingKey(a, b uuid.UUID) string {
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return a.String() + ":" + b.String()
+}
+
 // friendship represents an established friendship between two users
 // user1_id is always < user2_id to prevent duplicates
 type Friendship struct {
@@ -222,23 +487,441 @@ func (f *Friendship) BeforeCreate(tx *gorm.DB) (err error) {
 	return
 }
 
+// report status
+type ReportStatus int
+
+const (
+	ReportOpen      ReportStatus = 0
+	ReportReviewing ReportStatus = 1
+	ReportResolved  ReportStatus = 2
+	ReportDismissed ReportStatus = 3
+)
+
+// what a report points at
+const (
+	ReportTargetMessage = "message"
+	ReportTargetUser    = "user"
+	ReportTargetServer  = "server"
+)
+
+// reason categories a reporter can pick from
+var ReportReasons = map[string]bool{
+	"spam":          true,
+	"harassment":    true,
+	"hate":          true,
+	"nsfw":          true,
+	"violence":      true,
+	"self_harm":     true,
+	"impersonation": true,
+	"other":         true,
+}
+
+// report filed by a user against a message, user or server
+type Report struct {
+	BaseModel
+	ReporterID uuid.UUID    `gorm:"type:char(36);not null;index"`
+	TargetType string       `gorm:"type:varchar(20);not null;index:idx_report_target"`
+	TargetID   uuid.UUID    `gorm:"type:char(36);not null;index:idx_report_target"`
+	Reason     string       `gorm:"type:varchar(32);not null"`
+	Details    string       `gorm:"type:varchar(1000)"`
+	Status     ReportStatus `gorm:"not null;default:0;index"`
+
+	// context captured at report time so moderators can act even if the target changes
+	ReportedUserID *uuid.UUID `gorm:"type:char(36);index"`
+	ServerID       *uuid.UUID `gorm:"type:char(36);index"`
+	ChannelID      *uuid.UUID `gorm:"type:char(36)"`
+	ConversationID *uuid.UUID `gorm:"type:char(36)"`
+	MessageContent string     `gorm:"type:text"`
+
+	ResolvedByID   *uuid.UUID `gorm:"type:char(36)"`
+	ResolvedAt     *time.Time
+	ResolutionNote string `gorm:"type:varchar(1000)"`
+
+	Reporter User `gorm:"foreignKey:ReporterID"`
+}
+
+// banned ip range, single addresses are stored as /32 or /128
+type IPBan struct {
+	BaseModel
+	CIDR        string     `gorm:"type:varchar(50);not null;uniqueIndex"`
+	Reason      string     `gorm:"type:varchar(500)"`
+	CreatedByID uuid.UUID  `gorm:"type:char(36);not null"`
+	ExpiresAt   *time.Time `gorm:"index"` // nil means permanent
+}
+
+// sha256 (hex) of a file that may not be uploaded
+type BlockedHash struct {
+	BaseModel
+	Hash        string    `gorm:"type:char(64);not null;uniqueIndex"`
+	Reason      string    `gorm:"type:varchar(500)"`
+	CreatedByID uuid.UUID `gorm:"type:char(36);not null"`
+}
+
+// upload that matched a blocked hash, kept outside of the served storage for review
+type QuarantinedUpload struct {
+	BaseModel
+	Hash       string    `gorm:"type:char(64);not null;index"`
+	UploaderID uuid.UUID `gorm:"type:char(36);not null;index"`
+	Path       string    `gorm:"type:varchar(255);not null"` // relative to the quarantine directory
+}
+
+// handle that can't be registered, on top of the built in reserved ones, see nameblock
+type BlockedName struct {
+	BaseModel
+	Name        string    `gorm:"type:varchar(50);not null;uniqueIndex"` // normalized
+	Kind        string    `gorm:"type:varchar(16);not null"`             // reserved matches the whole handle, profanity any part of it
+	CreatedByID uuid.UUID `gorm:"type:char(36);not null"`
+}
+
+const (
+	BlockedNameReserved  = "reserved"
+	BlockedNameProfanity = "profanity"
+)
+
+// application registered by a user, each one owns a bot user that authenticates with a bot token
+type Application struct {
+	BaseModel
+	OwnerID     uuid.UUID `gorm:"type:char(36);not null;index"`
+	Name        string    `gorm:"type:varchar(100);not null"`
+	Description string    `gorm:"type:varchar(500)"`
+	Icon        string    `gorm:"type:varchar(255)"`
+	BotUserID   uuid.UUID `gorm:"type:char(36);not null;uniqueIndex"`
+	IsPublic    bool      `gorm:"not null;default:true"` // private bots can only be added by their owner
+
+	// slash command invocations are POSTed here when set, otherwise sent over the gateway
+	InteractionsURL    string `gorm:"type:varchar(255)"`
+	InteractionsSecret string `gorm:"type:char(64)"` // hmac key for signing webhook deliveries
+
+	// oauth2 provider, the application id is the client id
+	OAuth2SecretHash string `gorm:"column:oauth2_secret_hash;type:char(64)"` // sha256 of the client secret
+	RedirectURIs     string `gorm:"type:text"`                               // newline separated, exact match
+
+	// set by instance admins, verified applications are marked as such in activities
+	ActivityVerifiedAt *time.Time
+
+	Owner   User `gorm:"foreignKey:OwnerID"`
+	BotUser User `gorm:"foreignKey:BotUserID"`
+}
+
+// image an application can reference by key in activities
+type ApplicationAsset struct {
+	BaseModel
+	ApplicationID uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_app_asset_key"`
+	Key           string    `gorm:"type:varchar(32);not null;uniqueIndex:idx_app_asset_key"`
+	URL           string    `gorm:"type:varchar(255);not null"`
+}
+
+// slash command registered by an application, global when ServerID is nil
+type ApplicationCommand struct {
+	BaseModel
+	ApplicationID uuid.UUID  `gorm:"type:char(36);not null;uniqueIndex:idx_app_server_command"`
+	ServerID      *uuid.UUID `gorm:"type:char(36);uniqueIndex:idx_app_server_command"`
+	Name          string     `gorm:"type:varchar(32);not null;uniqueIndex:idx_app_server_command"`
+	Description   string     `gorm:"type:varchar(100);not null"`
+	Options       string     `gorm:"type:json"` // JSON array of option definitions
+
+	Application Application `gorm:"foreignKey:ApplicationID"`
+}
+
+// interaction is a single command invocation waiting for the application to respond
+type Interaction struct {
+	BaseModel
+	ApplicationID uuid.UUID `gorm:"type:char(36);not null;index"`
+	CommandID     uuid.UUID `gorm:"type:char(36);not null"`
+	UserID        uuid.UUID `gorm:"type:char(36);not null;index"`
+	ServerID      uuid.UUID `gorm:"type:char(36);not null"`
+	ChannelID     uuid.UUID `gorm:"type:char(36);not null"`
+	Token         string    `gorm:"type:char(64);not null;uniqueIndex"` // lets the application respond without its bot token
+	Data          string    `gorm:"type:json"`                          // command name and resolved options
+	ExpiresAt     time.Time `gorm:"not null"`
+	RespondedAt   *time.Time
+}
+
+// oauth2 authorization code, exchanged once for a token
+type OAuth2AuthorizationCode struct {
+	BaseModel
+	CodeHash            string    `gorm:"type:char(64);not null;uniqueIndex"`
+	ApplicationID       uuid.UUID `gorm:"type:char(36);not null;index"`
+	UserID              uuid.UUID `gorm:"type:char(36);not null"`
+	Scopes              string    `gorm:"type:varchar(255);not null"` // space separated
+	RedirectURI         string    `gorm:"type:varchar(255);not null"`
+	CodeChallenge       string    `gorm:"type:varchar(128)"` // pkce, optional
+	CodeChallengeMethod string    `gorm:"type:varchar(10)"`
+	ExpiresAt           time.Time `gorm:"not null"`
+	UsedAt              *time.Time
+}
+
+// gorm would otherwise name these o_auth2_*
+func (OAuth2AuthorizationCode) TableName() string { return "oauth2_authorization_codes" }
+
+// oauth2 grant issued to a third party application, only accepted on /oauth2 endpoints
+type OAuth2Token struct {
+	BaseModel
+	ApplicationID    uuid.UUID `gorm:"type:char(36);not null;index"`
+	UserID           uuid.UUID `gorm:"type:char(36);not null;index"`
+	AccessTokenHash  string    `gorm:"type:char(64);not null;uniqueIndex"`
+	RefreshTokenHash string    `gorm:"type:char(64);not null;uniqueIndex"`
+	Scopes           string    `gorm:"type:varchar(255);not null"`
+	ExpiresAt        time.Time `gorm:"not null"`
+	RefreshExpiresAt time.Time `gorm:"not null"`
+
+	Application Application `gorm:"foreignKey:ApplicationID"`
+}
+
+func (OAuth2Token) TableName() string { return "oauth2_tokens" }
+
+// webhook types, decides how incoming payloads are parsed
+const (
+	WebhookTypeGitHub = "github"
+	WebhookTypeGitLab = "gitlab"
+)
+
+// webhook posts into a channel from outside, executed with its id and token
+type Webhook struct {
+	BaseModel
+	ServerID  uuid.UUID `gorm:"type:char(36);not null;index"`
+	ChannelID uuid.UUID `gorm:"type:char(36);not null;index"`
+	CreatorID uuid.UUID `gorm:"type:char(36);not null"`
+	Type      string    `gorm:"type:varchar(16);not null"`
+	Name      string    `gorm:"type:varchar(80);not null"`
+	Avatar    string    `gorm:"type:varchar(255)"`
+	Token     string    `gorm:"type:char(64);not null;uniqueIndex"` // part of the execute url
+	Secret    string    `gorm:"type:varchar(128)"`                  // signature / shared secret the sender is configured with
+
+	Channel Channel `gorm:"foreignKey:ChannelID"`
+}
+
+// remote user on a bridged network (matrix, irc...) that a bridge bot posts as
+type BridgePuppet struct {
+	BaseModel
+	ApplicationID uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_bridge_puppet"`
+	Protocol      string    `gorm:"type:varchar(16);not null;uniqueIndex:idx_bridge_puppet"`
+	RemoteID      string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_bridge_puppet"` // e.g @alice:matrix.org
+	DisplayName   string    `gorm:"type:varchar(80);not null"`
+	Avatar        string    `gorm:"type:varchar(255)"`
+}
+
+// per user preferences that dont belong on the user row, no row means the defaults
+type UserSettings struct {
+	BaseModel
+	UserID   uuid.UUID `gorm:"type:char(36);not null;uniqueIndex"`
+	Timezone string    `gorm:"type:varchar(64)"` // iana name, utc when empty
+
+	// JSON array of statusschedule.Entry, presence is overridden while one is active
+	StatusSchedule *string `gorm:"type:json"`
+
+	// typing indicators aren't sent anywhere, conversations can also opt out one by one
+	HideTyping bool `gorm:"not null;default:false"`
+
+	// acks still move your own read marker but aren't shown to other participants
+	HideReadReceipts bool `gorm:"not null;default:false"`
+
+	// email summary of unread dms and mentions after being offline for DigestAfterHours
+	DigestEmails     bool `gorm:"not null;default:false"`
+	DigestAfterHours int  `gorm:"not null;default:24"`
+	LastDigestAt     *time.Time
+
+	// who may send friend requests / start conversations, a privacy audience. empty means everyone
+	FriendRequestsFrom string `gorm:"type:varchar(24)"`
+	DMsFrom            string `gorm:"column:dms_from;type:varchar(24)"`
+
+	// who sees the bio, banner and mutual servers on the profile: everyone, friends or nobody. empty means everyone
+	ProfileVisibility string `gorm:"type:varchar(24)"`
+
+	// recently played is kept and shown to friends, see activityhistory. off unless the user turns it on
+	ActivityHistory bool `gorm:"not null;default:false"`
+}
+
+// kinds of messages, for rows that can point at either a channel or a direct message
+const (
+	MessageKindChannel = "channel"
+	MessageKindDM      = "dm"
+)
+
+// personal bookmark of a channel or direct message, unrelated to pins
+type SavedMessage struct {
+	BaseModel
+	UserID    uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_user_saved_message"`
+	MessageID uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_user_saved_message"`
+	Kind      string    `gorm:"type:varchar(16);not null"`
+}
+
+// public keys of a users device for end to end encrypted dms, private keys never leave the device
+type DeviceKey struct {
+	BaseModel
+	UserID       uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_user_device"`
+	DeviceID     string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_user_device"` // chosen by the client
+	Name         string    `gorm:"type:varchar(64)"`
+	IdentityKey  string    `gorm:"type:text;not null"` // base64
+	SignedPreKey string    `gorm:"type:text;not null"` // base64
+	Signature    string    `gorm:"type:text;not null"` // of the signed pre key by the identity key
+}
+
+// sender key of one device for a conversation, encrypted for one recipient device.
+// kept until the recipient device acknowledges it
+type SenderKeyDistribution struct {
+	BaseModel
+	ConversationID    uuid.UUID `gorm:"type:char(36);not null;index"`
+	SenderID          uuid.UUID `gorm:"type:char(36);not null"`
+	SenderDeviceID    string    `gorm:"type:varchar(64);not null"`
+	RecipientID       uuid.UUID `gorm:"type:char(36);not null;index:idx_sender_key_recipient"`
+	RecipientDeviceID string    `gorm:"type:varchar(64);not null;index:idx_sender_key_recipient"`
+	Ciphertext        string    `gorm:"type:text;not null"`
+}
+
+// how much a user wants to hear from a server
+const (
+	NotificationLevelAll      = "all"
+	NotificationLevelMentions = "mentions"
+	NotificationLevelNone     = "none"
+)
+
+// per user per server notification preferences, no row means the defaults
+type ServerNotificationSetting struct {
+	BaseModel
+	UserID           uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_user_server_notifications"`
+	ServerID         uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_user_server_notifications;index"`
+	Level            string    `gorm:"type:varchar(16);not null;default:'all'"`
+	SuppressEveryone bool      `gorm:"not null;default:false"` // ignore @everyone / @here
+}
+
+// device registered for push notifications
+type PushDevice struct {
+	BaseModel
+	UserID   uuid.UUID `gorm:"type:char(36);not null;index"`
+	Platform string    `gorm:"type:varchar(16);not null"` // apns, fcm or web
+	Token    string    `gorm:"type:varchar(512);not null;uniqueIndex"`
+}
+
+// why a message landed in a users mention inbox
+const (
+	InboxReasonMention     = "mention"
+	InboxReasonRoleMention = "role_mention"
+	InboxReasonReply       = "reply"
+)
+
+// a message that mentions the user or replies to them, written when the message is sent
+type InboxEntry struct {
+	BaseModel
+	UserID         uuid.UUID  `gorm:"type:char(36);not null;uniqueIndex:idx_user_inbox_message;index:idx_user_inbox_unread"`
+	MessageID      uuid.UUID  `gorm:"type:char(36);not null;uniqueIndex:idx_user_inbox_message"`
+	Kind           string     `gorm:"type:varchar(16);not null"` // MessageKindChannel or MessageKindDM
+	ServerID       *uuid.UUID `gorm:"type:char(36)"`
+	ChannelID      *uuid.UUID `gorm:"type:char(36);index"`
+	ConversationID *uuid.UUID `gorm:"type:char(36);index"`
+	AuthorID       uuid.UUID  `gorm:"type:char(36);not null"`
+	Reason         string     `gorm:"type:varchar(16);not null"`
+	ReadAt         *time.Time `gorm:"index:idx_user_inbox_unread"`
+}
+
+// legal documents a user can consent to
+const (
+	ConsentDocumentTos     = "tos"
+	ConsentDocumentPrivacy = "privacy"
+)
+
+// consent record is an append-only log of accepted document versions, kept for compliance
+type ConsentRecord struct {
+	BaseModel
+	UserID     uuid.UUID `gorm:"type:char(36);not null;index"`
+	Document   string    `gorm:"type:varchar(20);not null"`
+	Version    string    `gorm:"type:varchar(32);not null"`
+	AcceptedAt time.Time `gorm:"not null"`
+	IP         string    `gorm:"type:varchar(45)"`
+}
+
+// data access log records admins reading a users data, users can see who looked at theirs
+type DataAccessLog struct {
+	BaseModel
+	ActorID      uuid.UUID `gorm:"type:char(36);not null;index"`
+	TargetUserID uuid.UUID `gorm:"type:char(36);not null;index"`
+	Action       string    `gorm:"type:varchar(50);not null"`
+	Reason       string    `gorm:"type:varchar(500);not null"`
+	IP           string    `gorm:"type:varchar(45)"`
+
+	Actor User `gorm:"foreignKey:ActorID"`
+}
+
+// recovery codes let a user back into their account without the password, each works once.
+// only the sha256 of a code is stored, they are random enough that a slow hash isnt needed
+type RecoveryCode struct {
+	BaseModel
+	UserID   uuid.UUID  `gorm:"type:char(36);not null;uniqueIndex:idx_recovery_codes_user_hash"`
+	CodeHash string     `gorm:"type:char(64);not null;uniqueIndex:idx_recovery_codes_user_hash"`
+	UsedAt   *time.Time `gorm:"index"`
+}
+
+// security events are changes to how a user can get into their account, shown back to them
+type SecurityEvent struct {
+	BaseModel
+	UserID uuid.UUID `gorm:"type:char(36);not null;index"`
+	Action string    `gorm:"type:varchar(50);not null"`
+	IP     string    `gorm:"type:varchar(45)"`
+}
+
 var Schema = []interface{}{
 	&User{},
 	&UserToken{},
+	&UserSettings{},
+	&RecoveryCode{},
+	&SecurityEvent{},
+	&SavedMessage{},
 
 	// Servers
 	&Server{},
 	&Role{},
 	&ServerMember{},
+	&Invite{},
 	&Channel{},
+	&ChannelCategory{},
 	&ChannelMessage{},
+	&ChannelReadState{},
+	&ChannelFollow{},
+	&ScheduledEvent{},
+	&EventRSVP{},
 
 	// Direct Messages
 	&DMConversation{},
 	&DMParticipant{},
 	&DirectMessage{},
+	&ImportJob{},
+
+	// Message counts
+	&MessageCounter{},
 
 	// Friends
 	&FriendRequest{},
 	&Friendship{},
+
+	// Moderation
+	&Report{},
+	&IPBan{},
+	&BlockedHash{},
+	&QuarantinedUpload{},
+	&BlockedName{},
+
+	// Compliance
+	&ConsentRecord{},
+	&DataAccessLog{},
+
+	// Applications
+	&Application{},
+	&ApplicationCommand{},
+	&ApplicationAsset{},
+	&Interaction{},
+	&OAuth2AuthorizationCode{},
+	&OAuth2Token{},
+
+	// Integrations
+	&Webhook{},
+	&BridgePuppet{},
+
+	// Encryption
+	&DeviceKey{},
+	&SenderKeyDistribution{},
+
+	// Notifications
+	&ServerNotificationSetting{},
+	&PushDevice{},
+	&InboxEntry{},
 }
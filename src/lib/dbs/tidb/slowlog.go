@@ -0,0 +1,190 @@
+package database
+
+// slow query logging and statement timeouts. queries slower than DB_SLOW_QUERY_MS (default 200) are logged with
+// where they came from and counted per caller for the admin stats. selects running longer than
+// DB_STATEMENT_TIMEOUT_MS (default 10000, 0 turns it off) are killed by the database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+const (
+	defaultSlowQueryMS        = 200
+	defaultStatementTimeoutMS = 10000
+
+	// distinct callers kept in the stats, the slowest ones win once full
+	maxSlowQueryCallers = 100
+)
+
+type SlowQueryCaller struct {
+	Caller  string    `json:"caller"` // route pattern when the query had the request context, else file:line
+	Count   int64     `json:"count"`
+	MaxMS   int64     `json:"max_ms"`
+	LastSQL string    `json:"last_sql"`
+	LastAt  time.Time `json:"last_at"`
+}
+
+type SlowQueryStats struct {
+	ThresholdMS int64             `json:"threshold_ms"`
+	TimeoutMS   int64             `json:"timeout_ms"`
+	Slow        int64             `json:"slow"`
+	TimedOut    int64             `json:"timed_out"`
+	Callers     []SlowQueryCaller `json:"callers"`
+}
+
+var (
+	slowQueries   = SlowQueryStats{Callers: []SlowQueryCaller{}}
+	slowQueriesMu sync.Mutex
+)
+
+// GetSlowQueryStats returns a copy of the slow query counters of this instance, slowest callers first
+func GetSlowQueryStats() SlowQueryStats {
+	slowQueriesMu.Lock()
+	defer slowQueriesMu.Unlock()
+
+	result := slowQueries
+	result.Callers = append([]SlowQueryCaller{}, slowQueries.Callers...)
+	sort.Slice(result.Callers, func(i, j int) bool { return result.Callers[i].MaxMS > result.Callers[j].MaxMS })
+	return result
+}
+
+func envMS(name string, fallback int64) int64 {
+	if v, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil && v >= 0 {
+		return v
+	}
+	return fallback
+}
+
+// withStatementTimeout adds max_execution_time to the dsn unless it already sets one.
+// mysql and tidb only apply it to selects, writes are short enough already
+func withStatementTimeout(dsn string, timeoutMS int64) string {
+	if timeoutMS == 0 || strings.Contains(dsn, "max_execution_time=") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "max_execution_time=" + strconv.FormatInt(timeoutMS, 10)
+}
+
+// slowQueryLogger records slow and timed out queries, everything else goes to the default gorm logger
+type slowQueryLogger struct {
+	logger.Interface
+	threshold time.Duration
+}
+
+func newSlowQueryLogger(threshold time.Duration) *slowQueryLogger {
+	return &slowQueryLogger{
+		// slow queries are logged below, the default logger only reports errors
+		Interface: logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
+			LogLevel: logger.Warn,
+			Colorful: true,
+		}),
+		threshold: threshold,
+	}
+}
+
+func (l *slowQueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &slowQueryLogger{Interface: l.Interface.LogMode(level), threshold: l.threshold}
+}
+
+func (l *slowQueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	l.Interface.Trace(ctx, begin, fc, err)
+
+	elapsed := time.Since(begin)
+	timedOut := err != nil && isStatementTimeout(err)
+	if !timedOut && (l.threshold == 0 || elapsed < l.threshold) {
+		return
+	}
+
+	sql, rows := fc()
+	caller := queryCaller(ctx)
+
+	if timedOut {
+		log.Printf("[db] query killed after %v from %s: %s", elapsed, caller, sql)
+	} else {
+		log.Printf("[db] slow query %v (%d rows) from %s: %s", elapsed, rows, caller, sql)
+	}
+
+	recordSlowQuery(caller, sql, elapsed, timedOut)
+}
+
+// queryCaller is the chi route pattern when the query carries the request context, else the calling file:line
+func queryCaller(ctx context.Context) string {
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return utils.FileWithLineNum()
+}
+
+// isStatementTimeout matches mysql 3024 and tidbs interrupted query error
+func isStatementTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Error 3024") || strings.Contains(msg, "maximum statement execution time exceeded") ||
+		strings.Contains(msg, "Query execution was interrupted")
+}
+
+func recordSlowQuery(caller, sql string, elapsed time.Duration, timedOut bool) {
+	slowQueriesMu.Lock()
+	defer slowQueriesMu.Unlock()
+
+	if timedOut {
+		slowQueries.TimedOut++
+	} else {
+		slowQueries.Slow++
+	}
+
+	// long IN lists make this huge, the start is enough to find it
+	if len(sql) > 500 {
+		sql = sql[:500] + "..."
+	}
+
+	ms := elapsed.Milliseconds()
+	now := time.Now()
+
+	for i := range slowQueries.Callers {
+		c := &slowQueries.Callers[i]
+		if c.Caller == caller {
+			c.Count++
+			c.MaxMS = max(c.MaxMS, ms)
+			c.LastSQL = sql
+			c.LastAt = now
+			return
+		}
+	}
+
+	entry := SlowQueryCaller{Caller: caller, Count: 1, MaxMS: ms, LastSQL: sql, LastAt: now}
+	if len(slowQueries.Callers) < maxSlowQueryCallers {
+		slowQueries.Callers = append(slowQueries.Callers, entry)
+		return
+	}
+
+	// full, replace the fastest one if this was slower
+	fastest := 0
+	for i, c := range slowQueries.Callers {
+		if c.MaxMS < slowQueries.Callers[fastest].MaxMS {
+			fastest = i
+		}
+	}
+	if slowQueries.Callers[fastest].MaxMS < ms {
+		slowQueries.Callers[fastest] = entry
+	}
+}
@@ -3,8 +3,9 @@ package database
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/mysql"
 
 	"gorm.io/gorm"
@@ -15,16 +16,25 @@ var DB *gorm.DB
 
 func InitDatabase() {
 
-	dsn := os.Getenv("TIDB_DATABASE_DSN")
+	slowQueryMS := envMS("DB_SLOW_QUERY_MS", defaultSlowQueryMS)
+	timeoutMS := envMS("DB_STATEMENT_TIMEOUT_MS", defaultStatementTimeoutMS)
+	slowQueries.ThresholdMS = slowQueryMS
+	slowQueries.TimeoutMS = timeoutMS
+
+	dsn := withStatementTimeout(os.Getenv("TIDB_DATABASE_DSN"), timeoutMS)
 
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
 		DisableForeignKeyConstraintWhenMigrating: true,
+		Logger:                                   newSlowQueryLogger(time.Duration(slowQueryMS) * time.Millisecond),
 	})
 
 	if err != nil {
 		panic("failed to connect database:" + err.Error())
 	}
 
+	migrateUserHandles(db)
+	migrateNormalizedHandles(db)
+
 	db.AutoMigrate(Schema...)
 
 	// print every schema that exists
@@ -32,34 +42,88 @@ func InitDatabase() {
 		fmt.Printf("Database Schema: %T\n", s)
 	}
 
-	// create base user
+	// promote configured admins (comma separated emails)
+	if adminEmails := os.Getenv("ADMIN_EMAILS"); adminEmails != "" {
+		emails := make([]string, 0)
+		for _, email := range strings.Split(adminEmails, ",") {
+			if email = strings.TrimSpace(email); email != "" {
+				emails = append(emails, email)
+			}
+		}
 
-	basePassword, err := bcrypt.GenerateFromPassword([]byte("rmfosho123"), bcrypt.DefaultCost)
-	if err != nil {
-		panic("failed to hash base user password:" + err.Error())
+		if len(emails) > 0 {
+			db.Model(&User{}).Where("email IN ?", emails).Update("is_admin", true)
+		}
 	}
 
-	user := User{
-		Username:         "rmfosho.me",
-		Password:         string(basePassword),
-		Email:            "me@rmfosho.me",
-		Domain:           "rmfosho.me",
-		IsDomainVerified: true,
+	// pending requests from before the pending key, duplicates keep a null key
+	var pending []FriendRequest
+	db.Where("status = ? AND pending_key IS NULL", FriendRequestPending).Order("created_at ASC").Find(&pending)
+	for _, request := range pending {
+		key := FriendRequestPendingKey(request.SenderID, request.ReceiverID)
+		db.Model(&request).Update("pending_key", key)
 	}
 
-	// check if user exists
-	var existingUser User
-	result := db.Where("email = ?", user.Email).First(&existingUser)
+	// counts of servers and conversations from before the count columns, empty ones are just recounted as 0
+	db.Exec("UPDATE servers SET member_count = (SELECT COUNT(*) FROM server_members m WHERE m.server_id = servers.id AND m.deleted_at IS NULL) WHERE member_count = 0")
+	db.Exec("UPDATE dm_conversations SET participant_count = (SELECT COUNT(*) FROM dm_participants p WHERE p.conversation_id = dm_conversations.id AND p.deleted_at IS NULL) WHERE participant_count = 0")
 
-	if result.Error != nil && result.Error == gorm.ErrRecordNotFound {
-		err = db.Create(&user).Error
+	// servers from before owned domains were nullable, an empty string is no domain
+	db.Exec("UPDATE servers SET owned_domain = NULL WHERE owned_domain = ''")
 
-		if err != nil {
-			panic("failed to create base user:" + err.Error()) 
-		}
-	}
+	// groups from before owners belong to whoever joined first
+	db.Exec("UPDATE dm_conversations SET owner_id = (SELECT p.user_id FROM dm_participants p WHERE p.conversation_id = dm_conversations.id ORDER BY p.joined_at ASC LIMIT 1) WHERE is_group = 1 AND owner_id IS NULL")
 
 	// setup :)
 	DB = db
 
 }
+
+// migrateUserHandles splits the handle out of usernames from before handles had their own column. the
+// unique index on username has to go first, automigrate would otherwise keep it under the same name
+func migrateUserHandles(db *gorm.DB) {
+	if !db.Migrator().HasTable(&User{}) || db.Migrator().HasColumn(&User{}, "handle") {
+		return
+	}
+
+	if err := db.Exec("ALTER TABLE users ADD COLUMN handle varchar(50) NOT NULL DEFAULT ''").Error; err != nil {
+		panic("failed to add user handles: " + err.Error())
+	}
+
+	// username is handle.domain, or just the domain for users that are their domain
+	db.Exec("UPDATE users SET handle = LEFT(username, CHAR_LENGTH(username) - CHAR_LENGTH(domain) - 1) WHERE username LIKE CONCAT('%.', domain)")
+	db.Exec("UPDATE users SET handle = username WHERE handle = '' AND username <> domain")
+
+	if db.Migrator().HasIndex(&User{}, "idx_users_username") {
+		db.Migrator().DropIndex(&User{}, "idx_users_username")
+	}
+}
+
+// migrateNormalizedHandles fills normalized_handle for users from before it existed, ahead of its unique index.
+// when older handles normalize to the same one the oldest account keeps it and the others get a placeholder,
+// they are logged so an admin can sort them out
+func migrateNormalizedHandles(db *gorm.DB) {
+	if !db.Migrator().HasTable(&User{}) || db.Migrator().HasColumn(&User{}, "normalized_handle") {
+		return
+	}
+
+	if err := db.Exec("ALTER TABLE users ADD COLUMN normalized_handle varchar(160) NOT NULL DEFAULT ''").Error; err != nil {
+		panic("failed to add normalized handles: " + err.Error())
+	}
+
+	var users []User
+	db.Unscoped().Select("id", "handle", "domain").Order("created_at ASC").Find(&users)
+
+	taken := make(map[string]bool, len(users))
+	for _, user := range users {
+		normalized := NormalizeHandle(user.Handle)
+		key := normalized + "@" + user.Domain
+		if taken[key] {
+			fmt.Printf("[database] handle %q on %s of %s looks like an older one, it can only be found by id\n", user.Handle, user.Domain, user.ID)
+			normalized = "~" + user.ID.String()
+		}
+		taken[key] = true
+
+		db.Unscoped().Model(&User{}).Where("id = ?", user.ID).UpdateColumn("normalized_handle", normalized)
+	}
+}
@@ -13,6 +13,29 @@ var rdb *redis.Client
 var (
 	USER_CACHE_PREFIX = "user_cache:"
 	PRESENCE_PREFIX    = "presence:"
+	ACTIVE_USERS_PREFIX = "active_users:" // + YYYY-MM-DD, hyperloglog of user ids
+	STATS_CACHE_PREFIX  = "stats_cache:"
+	MAINTENANCE_KEY     = "maintenance" // json maintenance state, absent when off
+	TRANSLATION_PREFIX  = "translation:" // + message id:content hash:language
+	UNLOCKED_CONVERSATION_PREFIX = "unlocked_conversation:" // + session:conversation id
+	IMAGE_PROXY_PREFIX = "image_proxy:" // + sha256 of the remote url, hash of content_type and body
+	DIGEST_LOCK_KEY = "digest_lock" // held by the instance sending digest emails this round
+	RESPONSE_CACHE_PREFIX = "response_cache:" // + route key, json response body
+	PURGE_LOCK_KEY = "purge_lock" // held by the instance running the soft delete purge this round
+	CONTENT_SIGNAL_PREFIX = "content_signal:" // + author id:category, cooldown between signals
+	INSTANCE_SETTINGS_KEY = "instance_settings" // json, branding and registration mode set through the admin api
+	DOMAIN_VERIFY_LOCK_KEY = "domain_verify_lock" // held by the instance re-checking server domains this round
+	SESSION_LAST_USED_KEY = "session_last_used" // hash of token id to unix time, flushed to user_tokens.last_used_at
+	SESSION_FLUSH_LOCK_KEY = "session_flush_lock" // held by the instance flushing session usage this round
+	TEMPORARY_MEMBER_LOCK_KEY = "temporary_member_lock" // held by the instance removing expired temporary members this round
+	TOKEN_REUSE_PREFIX = "token_reuse:" // + token id, count of attempts with a revoked token
+	TOKEN_REUSE_IPS_PREFIX = "token_reuse_ips:" // + token id, set of ips those attempts came from
+	TOKEN_REUSE_SEEN_PREFIX = "token_reuse_seen:" // + token id:ip, set while repeats from that ip count as one attempt
+	ACTIVITY_HISTORY_PREFIX = "activity_history:" // + user id, list of json entries newest first, only for users who opted in
+	ACTIVITY_CURRENT_PREFIX = "activity_current:" // + user id, json of the app being tracked right now
+	FRIEND_WATCHERS_PREFIX = "friend_watchers:" // + user id, hash of friend ids who want to hear when they come online, to "1" when they also want a push
+	FRIEND_WATCHING_PREFIX = "friend_watching:" // + user id, the same flags by watched friend id, for listing
+	RATE_LIMIT_PREFIX = "rate_limit:" // + bucket:user id or ip:window start, requests counted in that window
 )
 
 func GetValkeyClient() *redis.Client {
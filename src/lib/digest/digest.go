@@ -0,0 +1,206 @@
+package digest
+
+// digest emails for users who have been away for a while with unread dms or mentions.
+// one email per absence, users opt in through their settings
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/inbox"
+	"github.com/hindsightchat/backend/src/lib/mail"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	interval = 15 * time.Minute
+
+	// hours offline before a digest goes out, when the user didnt pick
+	DefaultAfterHours = 24
+	MinAfterHours     = 1
+	MaxAfterHours     = 168
+
+	// conversations listed by name, the rest are summed up
+	maxListed = 10
+)
+
+type conversationSummary struct {
+	Name   string
+	Unread int64
+}
+
+// Run sends due digests every interval, start it once per instance
+func Run() {
+	if !mail.Enabled() {
+		log.Println("[digest] SMTP_HOST not set, digest emails disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sendDue()
+	}
+}
+
+func sendDue() {
+	// one instance per round
+	ctx := context.Background()
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return
+	}
+	ok, err := rdb.SetNX(ctx, valkeydb.DIGEST_LOCK_KEY, "1", interval/2).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	var settings []database.UserSettings
+	if err := database.DB.Where("digest_emails = ?", true).Find(&settings).Error; err != nil {
+		log.Printf("[digest] failed to load settings: %v", err)
+		return
+	}
+	if len(settings) == 0 {
+		return
+	}
+
+	byUser := make(map[uuid.UUID]*database.UserSettings, len(settings))
+	userIDs := make([]uuid.UUID, 0, len(settings))
+	for i := range settings {
+		byUser[settings[i].UserID] = &settings[i]
+		userIDs = append(userIDs, settings[i].UserID)
+	}
+
+	var users []database.User
+	database.DB.
+		Where("id IN ? AND last_seen_at IS NOT NULL AND disabled_at IS NULL AND is_bot = ?", userIDs, false).
+		Find(&users)
+
+	now := time.Now()
+	for i := range users {
+		user := &users[i]
+		s := byUser[user.ID]
+
+		hours := s.DigestAfterHours
+		if hours < MinAfterHours || hours > MaxAfterHours {
+			hours = DefaultAfterHours
+		}
+		if now.Sub(*user.LastSeenAt) < time.Duration(hours)*time.Hour {
+			continue
+		}
+
+		// already sent one for this absence
+		if s.LastDigestAt != nil && s.LastDigestAt.After(*user.LastSeenAt) {
+			continue
+		}
+
+		// back online on some instance
+		if n, _ := rdb.Exists(ctx, valkeydb.PRESENCE_PREFIX+user.ID.String()).Result(); n > 0 {
+			continue
+		}
+
+		if err := sendDigest(user); err != nil {
+			log.Printf("[digest] failed to send to %s: %v", user.ID, err)
+			continue
+		}
+
+		database.DB.Model(s).Update("last_digest_at", now)
+	}
+}
+
+// sendDigest mails the unread summary, nothing is sent when there is nothing unread
+func sendDigest(user *database.User) error {
+	conversations := unreadConversations(user.ID)
+	mentions := inbox.UnreadCount(user.ID)
+	if len(conversations) == 0 && mentions == 0 {
+		return nil
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nhere is what you missed while you were away.\n\n", user.Username)
+
+	if mentions > 0 {
+		fmt.Fprintf(&body, "%d unread mentions and replies\n\n", mentions)
+	}
+
+	var rest int64
+	for i, c := range conversations {
+		if i >= maxListed {
+			rest += c.Unread
+			continue
+		}
+		fmt.Fprintf(&body, "%s: %d unread\n", c.Name, c.Unread)
+	}
+	if rest > 0 {
+		fmt.Fprintf(&body, "and %d more in other conversations\n", rest)
+	}
+
+	body.WriteString("\nYou get this email because digest emails are turned on in your settings.\n")
+
+	return mail.Send(user.Email, "Unread messages on Hindsight", body.String())
+}
+
+// unreadConversations counts messages from others since the users read marker, busiest first.
+// hidden conversations are left out
+func unreadConversations(userID uuid.UUID) []conversationSummary {
+	var counts []struct {
+		ConversationID uuid.UUID
+		Unread         int64
+	}
+	database.DB.Model(&database.DirectMessage{}).
+		Select("direct_messages.conversation_id, COUNT(*) AS unread").
+		Joins("JOIN dm_participants p ON p.conversation_id = direct_messages.conversation_id AND p.user_id = ? AND p.deleted_at IS NULL AND p.hidden = ?", userID, false).
+		Where("direct_messages.author_id <> ?", userID).
+		Where("direct_messages.created_at > COALESCE(p.last_read_at, p.joined_at)").
+		Scopes(restriction.VisibleDirectMessages(userID)).
+		Group("direct_messages.conversation_id").
+		Order("unread DESC").
+		Scan(&counts)
+	if len(counts) == 0 {
+		return nil
+	}
+
+	convIDs := make([]uuid.UUID, len(counts))
+	for i, c := range counts {
+		convIDs[i] = c.ConversationID
+	}
+
+	var conversations []database.DMConversation
+	database.DB.Preload("Participants.User").Where("id IN ?", convIDs).Find(&conversations)
+
+	names := make(map[uuid.UUID]string, len(conversations))
+	for _, conv := range conversations {
+		names[conv.ID] = conversationName(&conv, userID)
+	}
+
+	result := make([]conversationSummary, 0, len(counts))
+	for _, c := range counts {
+		result = append(result, conversationSummary{Name: names[c.ConversationID], Unread: c.Unread})
+	}
+	return result
+}
+
+// conversationName is the group name, or the other participants for 1:1 and unnamed groups
+func conversationName(conv *database.DMConversation, userID uuid.UUID) string {
+	if conv.IsGroup && conv.Name != "" {
+		return conv.Name
+	}
+
+	others := make([]string, 0, len(conv.Participants))
+	for _, p := range conv.Participants {
+		if p.UserID != userID {
+			others = append(others, p.User.Username)
+		}
+	}
+	if len(others) == 0 {
+		return "a conversation"
+	}
+	return strings.Join(others, ", ")
+}
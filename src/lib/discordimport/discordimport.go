@@ -0,0 +1,387 @@
+package discordimport
+
+// recreates the dms of a discord data export (the "request all of my data" package) as conversations of
+// the importing user. the export only holds the users own messages, so every dm becomes a conversation
+// with just the user in it, named like discord names it and marked as imported
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/membercount"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	Source = "discord"
+
+	// rows per insert statement
+	batchSize = 500
+
+	// a single messages file bigger than this isnt a dm history anyone wrote by hand
+	maxMessagesFileSize = 256 << 20
+
+	// across all dms of an archive, checked before anything is stored
+	maxTotalMessagesSize = 2 << 30
+	maxMessages          = 1_000_000
+
+	// running jobs record progress after every conversation, one quiet for this long died with its instance
+	staleAfter = 30 * time.Minute
+)
+
+var ErrNoMessages = errors.New("archive has no messages folder, is it a discord data package?")
+
+// discord writes timestamps a few different ways depending on the export version
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999-07:00",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+}
+
+type channelFile struct {
+	ID   string          `json:"id"`
+	Type json.RawMessage `json:"type"` // 1 / 3 in older exports, "DM" / "GROUP_DM" in newer ones
+}
+
+type message struct {
+	timestamp time.Time
+	content   string
+}
+
+type dm struct {
+	name     string
+	group    bool
+	messages []message
+
+	file *zip.File // messages.json or messages.csv, nil when the dm has none
+	csv  bool
+}
+
+// Run imports the archive at archivePath for the job, recording progress and the outcome on it
+func Run(job *database.ImportJob, archivePath string) {
+	database.DB.Model(job).Update("status", database.ImportStatusRunning)
+
+	conversations, messages, err := run(job, archivePath)
+
+	now := time.Now()
+	updates := map[string]any{
+		"status":        database.ImportStatusDone,
+		"conversations": conversations,
+		"messages":      messages,
+		"finished_at":   now,
+	}
+	if err != nil {
+		log.Printf("[discordimport] job %s failed: %v", job.ID, err)
+		updates["status"] = database.ImportStatusFailed
+		updates["error"] = truncate(err.Error(), 500)
+	}
+	database.DB.Model(job).Updates(updates)
+}
+
+// FailStale fails pending and running jobs that stopped making progress, their instance restarted or
+// crashed mid import and they would otherwise block the user from importing again
+func FailStale() {
+	err := database.DB.Model(&database.ImportJob{}).
+		Where("status IN ? AND updated_at < ?", []string{database.ImportStatusPending, database.ImportStatusRunning}, time.Now().Add(-staleAfter)).
+		Updates(map[string]any{
+			"status":      database.ImportStatusFailed,
+			"error":       "import was interrupted, try again",
+			"finished_at": time.Now(),
+		}).Error
+	if err != nil {
+		log.Printf("[discordimport] failing stale jobs: %v", err)
+	}
+}
+
+func run(job *database.ImportJob, archivePath string) (int, int64, error) {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("not a zip archive: %w", err)
+	}
+	defer archive.Close()
+
+	dms, err := findDMs(&archive.Reader)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// one dm in memory at a time, it is stored before the next one is read
+	var conversations int
+	var messages int64
+	for _, d := range dms {
+		if err := readMessages(d); err != nil {
+			return conversations, messages, err
+		}
+		if len(d.messages) == 0 {
+			continue
+		}
+		if messages+int64(len(d.messages)) > maxMessages {
+			return conversations, messages, fmt.Errorf("archive has more than %d messages, stopped after %d", maxMessages, messages)
+		}
+		if err := store(job.UserID, d); err != nil {
+			return conversations, messages, err
+		}
+		conversations++
+		messages += int64(len(d.messages))
+		d.messages = nil
+
+		// also keeps updated_at fresh so FailStale leaves the job alone
+		database.DB.Model(job).Updates(map[string]any{"conversations": conversations, "messages": messages})
+	}
+
+	return conversations, messages, nil
+}
+
+// findDMs collects the dm channels of the archive without their messages, paths are matched case
+// insensitively since newer exports capitalize the folders
+func findDMs(archive *zip.Reader) ([]*dm, error) {
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[strings.ToLower(f.Name)] = f
+	}
+
+	names := map[string]string{}
+	if f, ok := files["messages/index.json"]; ok {
+		var index map[string]*string
+		if err := readJSON(f, &index); err != nil {
+			return nil, fmt.Errorf("messages/index.json: %w", err)
+		}
+		for id, name := range index {
+			if name != nil {
+				names[id] = *name
+			}
+		}
+	}
+
+	found := false
+	var total uint64
+	dms := make([]*dm, 0)
+	for name, f := range files {
+		if !strings.HasPrefix(name, "messages/") || path.Base(name) != "channel.json" {
+			continue
+		}
+		found = true
+
+		var channel channelFile
+		if err := readJSON(f, &channel); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+
+		group, ok := dmType(channel.Type)
+		if !ok {
+			continue
+		}
+
+		d := &dm{name: names[channel.ID], group: group}
+		dir := path.Dir(name)
+		if mf, ok := files[dir+"/messages.json"]; ok {
+			d.file = mf
+		} else if mf, ok := files[dir+"/messages.csv"]; ok {
+			d.file, d.csv = mf, true
+		}
+		if d.file == nil {
+			continue
+		}
+
+		// zip refuses to read past the size in the header, so the sizes can be trusted
+		total += d.file.UncompressedSize64
+		if total > maxTotalMessagesSize {
+			return nil, fmt.Errorf("messages in the archive are larger than %dGB", maxTotalMessagesSize>>30)
+		}
+		dms = append(dms, d)
+	}
+
+	if !found {
+		return nil, ErrNoMessages
+	}
+	return dms, nil
+}
+
+// readMessages loads the messages of d, oldest first
+func readMessages(d *dm) error {
+	var err error
+	if d.csv {
+		d.messages, err = readMessagesCSV(d.file)
+	} else {
+		d.messages, err = readMessagesJSON(d.file)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path.Dir(d.file.Name), err)
+	}
+
+	sort.SliceStable(d.messages, func(i, j int) bool { return d.messages[i].timestamp.Before(d.messages[j].timestamp) })
+	return nil
+}
+
+func dmType(raw json.RawMessage) (group bool, ok bool) {
+	switch strings.Trim(string(raw), `"`) {
+	case "1", "DM":
+		return false, true
+	case "3", "GROUP_DM":
+		return true, true
+	}
+	return false, false
+}
+
+func readMessagesJSON(f *zip.File) ([]message, error) {
+	var rows []struct {
+		Timestamp   string `json:"Timestamp"`
+		Contents    string `json:"Contents"`
+		Attachments string `json:"Attachments"`
+	}
+	if err := readJSON(f, &rows); err != nil {
+		return nil, err
+	}
+
+	messages := make([]message, 0, len(rows))
+	for _, row := range rows {
+		if msg, ok := toMessage(row.Timestamp, row.Contents, row.Attachments); ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// older exports, columns are ID,Timestamp,Contents,Attachments
+func readMessagesCSV(f *zip.File) ([]message, error) {
+	rc, err := open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	reader := csv.NewReader(rc)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]message, 0, len(rows))
+	for i, row := range rows {
+		if i == 0 || len(row) < 3 {
+			continue // header
+		}
+		attachments := ""
+		if len(row) > 3 {
+			attachments = row[3]
+		}
+		if msg, ok := toMessage(row[1], row[2], attachments); ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// toMessage parses a row, attachment urls are appended to the content since the files stay on discord
+func toMessage(timestamp, content, attachments string) (message, bool) {
+	var at time.Time
+	var err error
+	for _, layout := range timestampLayouts {
+		if at, err = time.Parse(layout, timestamp); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return message{}, false
+	}
+
+	for _, url := range strings.Fields(attachments) {
+		if content != "" {
+			content += "\n"
+		}
+		content += url
+	}
+	if content == "" {
+		return message{}, false
+	}
+
+	return message{timestamp: at, content: content}, true
+}
+
+// store creates the conversation with its messages, counted like any other conversation
+func store(userID uuid.UUID, d *dm) error {
+	name := d.name
+	if name == "" {
+		name = "Imported conversation"
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		conv := database.DMConversation{
+			Name:         truncate(name, 100),
+			IsGroup:      d.group,
+			ImportedFrom: Source,
+		}
+		if err := tx.Create(&conv).Error; err != nil {
+			return err
+		}
+
+		participant := database.DMParticipant{ConversationID: conv.ID, UserID: userID, JoinedAt: time.Now()}
+		if err := tx.Create(&participant).Error; err != nil {
+			return err
+		}
+		if err := membercount.Participants(tx, conv.ID, 1); err != nil {
+			return err
+		}
+
+		rows := make([]database.DirectMessage, 0, len(d.messages))
+		for i, msg := range d.messages {
+			row := database.DirectMessage{
+				ConversationID: conv.ID,
+				AuthorID:       userID,
+				Content:        msg.content,
+				Attachments:    "[]",
+				Seq:            int64(i + 1),
+			}
+			row.CreatedAt = msg.timestamp
+			rows = append(rows, row)
+		}
+		if err := tx.CreateInBatches(&rows, batchSize).Error; err != nil {
+			return err
+		}
+
+		// the conversation is new, its counter starts where the import ends
+		count := int64(len(rows))
+		return tx.Create(&database.MessageCounter{TargetID: conv.ID, Total: count, Sequence: count}).Error
+	})
+}
+
+func open(f *zip.File) (io.ReadCloser, error) {
+	if f.UncompressedSize64 > maxMessagesFileSize {
+		return nil, fmt.Errorf("%s is too large", f.Name)
+	}
+	return f.Open()
+}
+
+func readJSON(f *zip.File, v any) error {
+	rc, err := open(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(io.LimitReader(rc, maxMessagesFileSize)).Decode(v)
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
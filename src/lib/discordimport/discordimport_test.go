@@ -0,0 +1,27 @@
+package discordimport
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateKeepsRunesWhole(t *testing.T) {
+	cases := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 100, "short"},
+		{"abcdef", 3, "abc"},
+		{"ab日本", 4, "ab"}, // 日 is 3 bytes, cutting at 4 would split it
+		{"ab日本", 5, "ab日"},
+		{"日本", 1, ""},
+	}
+
+	for _, c := range cases {
+		got := truncate(c.s, c.n)
+		if got != c.want || !utf8.ValidString(got) {
+			t.Fatalf("truncate(%q, %d) = %q, want %q", c.s, c.n, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package domainverify
+
+// proof that a server owns the domain it claims. the owner publishes the servers token either as a dns
+// TXT record on _hindsight.<domain> or at https://<domain>/.well-known/hindsight-verification, either one
+// is enough. verified domains are re-checked daily and lose the badge when the proof disappears
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/publicnet"
+)
+
+const (
+	interval = time.Hour
+
+	// how often a claimed domain is looked at again
+	recheckAfter = 24 * time.Hour
+
+	// servers checked per round, the rest wait for the next one
+	batchSize = 100
+
+	lookupTimeout = 10 * time.Second
+
+	RecordPrefix  = "_hindsight."
+	WellKnownPath = "/.well-known/hindsight-verification"
+	valuePrefix   = "hindsight-verify="
+)
+
+// only public addresses are dialed so a claimed domain can't point us at internal services
+var httpClient = &http.Client{
+	Timeout: lookupTimeout,
+	Transport: &http.Transport{
+		DialContext:         publicnet.Dialer(5 * time.Second).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	// a redirect to another host would let that host vouch for the domain
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// NewToken returns a fresh verification token
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Record returns the TXT record name and value that prove the token for domain
+func Record(domain, token string) (name string, value string) {
+	return RecordPrefix + domain, valuePrefix + token
+}
+
+// WellKnownURL is where the token can be served instead of the TXT record
+func WellKnownURL(domain string) string {
+	return "https://" + domain + WellKnownPath
+}
+
+// Normalize lowercases a domain and strips a trailing dot, false when it doesnt look like a hostname
+func Normalize(domain string) (string, bool) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if len(domain) < 4 || len(domain) > 100 || !strings.Contains(domain, ".") {
+		return "", false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+				return "", false
+			}
+		}
+	}
+	return domain, true
+}
+
+// Check reports whether the token is published for domain in dns or under /.well-known
+func Check(domain, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	name, value := Record(domain, token)
+	if records, err := net.DefaultResolver.LookupTXT(ctx, name); err == nil {
+		for _, record := range records {
+			if strings.TrimSpace(record) == value {
+				return true
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, WellKnownURL(domain), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return false
+	}
+	served := strings.TrimSpace(string(body))
+	return served == token || served == value
+}
+
+// Verify checks the servers domain now and stores the outcome. a domain that was never verified stays
+// pending when the check fails, a verified one becomes failed
+func Verify(server *database.Server) bool {
+	if server.OwnedDomain == nil {
+		return false
+	}
+
+	now := time.Now()
+	updates := map[string]any{"domain_checked_at": now}
+
+	ok := Check(*server.OwnedDomain, server.DomainVerificationToken)
+	switch {
+	case ok:
+		updates["domain_status"] = database.DomainStatusVerified
+		if server.DomainStatus != database.DomainStatusVerified {
+			updates["domain_verified_at"] = now
+		}
+	case server.DomainStatus == database.DomainStatusVerified:
+		updates["domain_status"] = database.DomainStatusFailed
+	}
+
+	// only if the domain wasnt changed while we were looking it up
+	result := database.DB.Model(&database.Server{}).
+		Where("id = ? AND owned_domain = ? AND domain_verification_token = ?", server.ID, *server.OwnedDomain, server.DomainVerificationToken).
+		Updates(updates)
+	if result.Error != nil {
+		log.Printf("[domainverify] failed to store result for %s: %v", server.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+
+	database.DB.Where("id = ?", server.ID).First(server)
+	return ok
+}
+
+// Run re-checks claimed domains every interval, start it once per instance
+func Run() {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		recheckDue()
+	}
+}
+
+func recheckDue() {
+	// one instance per round
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return
+	}
+	ok, err := rdb.SetNX(context.Background(), valkeydb.DOMAIN_VERIFY_LOCK_KEY, "1", interval/2).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	var servers []database.Server
+	err = database.DB.
+		Where("owned_domain IS NOT NULL AND (domain_checked_at IS NULL OR domain_checked_at < ?)", time.Now().Add(-recheckAfter)).
+		Order("domain_checked_at ASC").
+		Limit(batchSize).
+		Find(&servers).Error
+	if err != nil {
+		log.Printf("[domainverify] failed to load servers: %v", err)
+		return
+	}
+
+	for i := range servers {
+		before := servers[i].DomainStatus
+		Verify(&servers[i])
+		if before != servers[i].DomainStatus {
+			log.Printf("[domainverify] %s of server %s is now %s", *servers[i].OwnedDomain, servers[i].ID, servers[i].DomainStatus)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package friendwatch
+
+// per friend opt in to hear when they come online, kept in valkey both ways: by the friend for the
+// presence path and by the watcher for listing
+
+import (
+	"context"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Watch makes watcherID hear when friendID comes online, with a push too when push is set
+func Watch(watcherID, friendID uuid.UUID, push bool) error {
+	ctx := context.Background()
+	flag := "0"
+	if push {
+		flag = "1"
+	}
+
+	pipe := valkeydb.GetValkeyClient().TxPipeline()
+	pipe.HSet(ctx, valkeydb.FRIEND_WATCHERS_PREFIX+friendID.String(), watcherID.String(), flag)
+	pipe.HSet(ctx, valkeydb.FRIEND_WATCHING_PREFIX+watcherID.String(), friendID.String(), flag)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Unwatch stops it again, nothing happens when it wasnt on
+func Unwatch(watcherID, friendID uuid.UUID) error {
+	ctx := context.Background()
+
+	pipe := valkeydb.GetValkeyClient().TxPipeline()
+	pipe.HDel(ctx, valkeydb.FRIEND_WATCHERS_PREFIX+friendID.String(), watcherID.String())
+	pipe.HDel(ctx, valkeydb.FRIEND_WATCHING_PREFIX+watcherID.String(), friendID.String())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Forget drops the toggles both of them had on each other, e.g once they stop being friends
+func Forget(a, b uuid.UUID) error {
+	if err := Unwatch(a, b); err != nil {
+		return err
+	}
+	return Unwatch(b, a)
+}
+
+// Watchers returns who wants to hear when the user comes online, and whether each wants a push
+func Watchers(userID uuid.UUID) map[uuid.UUID]bool {
+	return load(valkeydb.FRIEND_WATCHERS_PREFIX + userID.String())
+}
+
+// Watching returns the friends the user wants to hear about, and whether with a push
+func Watching(userID uuid.UUID) map[uuid.UUID]bool {
+	return load(valkeydb.FRIEND_WATCHING_PREFIX + userID.String())
+}
+
+func load(key string) map[uuid.UUID]bool {
+	values, err := valkeydb.GetValkeyClient().HGetAll(context.Background(), key).Result()
+	if err != nil {
+		return map[uuid.UUID]bool{}
+	}
+
+	result := make(map[uuid.UUID]bool, len(values))
+	for raw, flag := range values {
+		id, err := uuid.FromString(raw)
+		if err != nil {
+			continue
+		}
+		result[id] = flag == "1"
+	}
+	return result
+}
@@ -0,0 +1,144 @@
+package hashblock
+
+// blocklist of upload content hashes, checked before anything is stored. matches are rejected, with
+// HASH_BLOCK_ACTION=quarantine a copy is also kept under QUARANTINE_DIR (./quarantine when unset) for
+// review. the quarantine directory is never served, keep it out of STORAGE_DIR
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+)
+
+// hashes are reloaded from the database at most this often
+const refreshInterval = 30 * time.Second
+
+var ErrBlocked = errors.New("file is blocked")
+
+var (
+	hashes   = map[string]bool{}
+	loadedAt time.Time
+	mu       sync.RWMutex
+)
+
+// Hash returns the sha256 of data as stored in the blocklist
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeHash lowercases a sha256 hex digest, false when it isnt one
+func NormalizeHash(value string) (string, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// QuarantineEnabled reports whether blocked uploads are kept for review
+func QuarantineEnabled() bool {
+	return os.Getenv("HASH_BLOCK_ACTION") == "quarantine"
+}
+
+// QuarantineDir is the directory quarantined uploads are kept in
+func QuarantineDir() string {
+	if dir := os.Getenv("QUARANTINE_DIR"); dir != "" {
+		return dir
+	}
+	return "quarantine"
+}
+
+// Check returns ErrBlocked when data is on the blocklist, call it before storing an upload
+func Check(uploaderID uuid.UUID, data []byte) error {
+	hash := Hash(data)
+
+	refreshIfStale()
+
+	mu.RLock()
+	blocked := hashes[hash]
+	mu.RUnlock()
+
+	if !blocked {
+		return nil
+	}
+
+	log.Printf("[hashblock] rejected upload %s by %s", hash, uploaderID)
+	if QuarantineEnabled() {
+		quarantine(uploaderID, hash, data)
+	}
+	return ErrBlocked
+}
+
+func quarantine(uploaderID uuid.UUID, hash string, data []byte) {
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(QuarantineDir(), 0o700); err != nil {
+		log.Printf("[hashblock] failed to create quarantine dir: %v", err)
+		return
+	}
+
+	file := hex.EncodeToString(name)
+	if err := os.WriteFile(filepath.Join(QuarantineDir(), file), data, 0o600); err != nil {
+		log.Printf("[hashblock] failed to quarantine %s: %v", hash, err)
+		return
+	}
+
+	database.DB.Create(&database.QuarantinedUpload{Hash: hash, UploaderID: uploaderID, Path: file})
+}
+
+// RemoveQuarantined deletes the kept copy of a quarantined upload
+func RemoveQuarantined(path string) {
+	// filepath.Base drops any directories smuggled into the path
+	os.Remove(filepath.Join(QuarantineDir(), filepath.Base(path)))
+}
+
+// Invalidate forces the next check to reload the blocklist, call after changing it
+func Invalidate() {
+	mu.Lock()
+	loadedAt = time.Time{}
+	mu.Unlock()
+}
+
+func refreshIfStale() {
+	mu.RLock()
+	fresh := time.Since(loadedAt) < refreshInterval
+	mu.RUnlock()
+
+	if fresh {
+		return
+	}
+
+	var rows []string
+	if err := database.DB.Model(&database.BlockedHash{}).Pluck("hash", &rows).Error; err != nil {
+		// keep the old list rather than failing open on a db hiccup
+		log.Printf("[hashblock] failed to load blocklist: %v", err)
+		return
+	}
+
+	loaded := make(map[string]bool, len(rows))
+	for _, hash := range rows {
+		loaded[hash] = true
+	}
+
+	mu.Lock()
+	hashes = loaded
+	loadedAt = time.Now()
+	mu.Unlock()
+}
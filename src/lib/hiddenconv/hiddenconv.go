@@ -0,0 +1,63 @@
+package hiddenconv
+
+// hidden conversations, unlocked with a passcode once per session (auth token)
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	uuid "github.com/satori/go.uuid"
+)
+
+// an unlock lasts this long unless the conversation is locked again
+const unlockTTL = 12 * time.Hour
+
+// Session identifies the session of a request without keeping the token itself around
+func Session(r *http.Request) string {
+	token, _ := r.Context().Value("authToken").(string)
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}
+
+func key(session string, convID uuid.UUID) string {
+	return valkeydb.UNLOCKED_CONVERSATION_PREFIX + session + ":" + convID.String()
+}
+
+// Unlock makes the conversation readable for the session
+func Unlock(ctx context.Context, session string, convID uuid.UUID) error {
+	return valkeydb.GetValkeyClient().Set(ctx, key(session, convID), 1, unlockTTL).Err()
+}
+
+// Lock undoes Unlock
+func Lock(ctx context.Context, session string, convID uuid.UUID) error {
+	return valkeydb.GetValkeyClient().Del(ctx, key(session, convID)).Err()
+}
+
+// IsUnlocked reports whether the session unlocked the conversation
+func IsUnlocked(ctx context.Context, session string, convID uuid.UUID) bool {
+	if session == "" {
+		return false
+	}
+	n, err := valkeydb.GetValkeyClient().Exists(ctx, key(session, convID)).Result()
+	return err == nil && n > 0
+}
+
+// HiddenBy returns the participants that hid the conversation
+func HiddenBy(convID uuid.UUID) map[uuid.UUID]bool {
+	var userIDs []uuid.UUID
+	database.DB.Model(&database.DMParticipant{}).Where("conversation_id = ? AND hidden = ?", convID, true).Pluck("user_id", &userIDs)
+
+	hidden := make(map[uuid.UUID]bool, len(userIDs))
+	for _, id := range userIDs {
+		hidden[id] = true
+	}
+	return hidden
+}
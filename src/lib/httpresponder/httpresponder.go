@@ -7,8 +7,9 @@ import (
 )
 
 type ErrorResponse struct {
-	Error string `json:"error"`
-	Code  int    `json:"code,omitempty"`
+	Error   string `json:"error"`
+	Code    int    `json:"code,omitempty"`
+	Details any    `json:"details,omitempty"` // machine readable context, e.g the limit that was exceeded
 }
 
 // ReadDataToString reads all data from an io.ReadCloser and returns it as a byte slice.
@@ -44,3 +45,35 @@ func SendErrorResponse(httpWriter http.ResponseWriter, httpRequest *http.Request
 	errorJSON, _ := json.Marshal(ErrorResponse{Error: message, Code: code})
 	httpWriter.Write(errorJSON)
 }
+
+// SendErrorResponseWithDetails is SendErrorResponse with machine readable details attached
+func SendErrorResponseWithDetails(httpWriter http.ResponseWriter, httpRequest *http.Request, message string, code int, details any) {
+	httpWriter.Header().Set("Content-Type", "application/json")
+	httpWriter.WriteHeader(code)
+	errorJSON, _ := json.Marshal(ErrorResponse{Error: message, Code: code, Details: details})
+	httpWriter.Write(errorJSON)
+}
+
+// List is the envelope of paginated lists. data stays the items so clients of unpaginated lists keep working,
+// the next page is asked for with ?cursor=<next_cursor> until has_more is false
+type List struct {
+	Success    bool   `json:"success"`
+	Data       any    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	Total      *int64 `json:"total,omitempty"` // only on lists where counting is cheap
+}
+
+// SendListResponse sends one page of a list, pass total as nil when it isnt counted
+func SendListResponse(httpWriter http.ResponseWriter, httpRequest *http.Request, data any, nextCursor string, hasMore bool, total *int64) {
+	if !hasMore {
+		nextCursor = ""
+	}
+	SendNormalResponse(httpWriter, httpRequest, List{
+		Success:    true,
+		Data:       data,
+		NextCursor: nextCursor,
+		HasMore:    hasMore,
+		Total:      total,
+	})
+}
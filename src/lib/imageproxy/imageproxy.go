@@ -0,0 +1,199 @@
+package imageproxy
+
+// camo style image proxy: remote images in embeds and avatars are rewritten to signed urls served by
+// this backend, so clients never contact third party hosts. needs IMAGE_PROXY_KEY, urls are left as is without it.
+// IMAGE_PROXY_BASE_URL optionally makes the rewritten urls absolute, e.g https://api.example.com
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/publicnet"
+	"github.com/hindsightchat/backend/src/types"
+)
+
+const (
+	// images larger than this are refused
+	MaxSize = 8 << 20
+
+	// fetched images are cached this long
+	cacheTTL = 24 * time.Hour
+
+	// path the proxy routes are mounted at
+	PathPrefix = "/proxy/images/"
+)
+
+var (
+	ErrInvalidURL = errors.New("invalid url")
+	ErrForbidden  = errors.New("host not allowed")
+	ErrTooLarge   = errors.New("image too large")
+	ErrNotAnImage = errors.New("not an image")
+	ErrUpstream   = errors.New("upstream error")
+)
+
+// only public addresses are dialed, see publicnet
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         publicnet.Dialer(5 * time.Second).DialContext,
+		MaxIdleConns:        20,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	},
+}
+
+type Image struct {
+	ContentType string
+	Body        []byte
+}
+
+// Enabled reports whether a proxy key is configured
+func Enabled() bool {
+	return os.Getenv("IMAGE_PROXY_KEY") != ""
+}
+
+func sign(raw string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("IMAGE_PROXY_KEY")))
+	mac.Write([]byte(raw))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// URL rewrites a remote image url to its proxied form.
+// empty, relative and non http(s) urls come back unchanged, as does everything while the proxy is off
+func URL(raw string) string {
+	if !Enabled() {
+		return raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return raw
+	}
+
+	return strings.TrimSuffix(os.Getenv("IMAGE_PROXY_BASE_URL"), "/") + PathPrefix + sign(raw) + "/" + hex.EncodeToString([]byte(raw))
+}
+
+// ProxyEmbeds rewrites the image urls of the embeds in place
+func ProxyEmbeds(embeds []types.Embed) {
+	for i := range embeds {
+		if embeds[i].Author != nil {
+			embeds[i].Author.IconURL = URL(embeds[i].Author.IconURL)
+		}
+	}
+}
+
+// Verify checks the signature of a proxied url and returns the remote url
+func Verify(signature, encoded string) (string, bool) {
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil || !Enabled() {
+		return "", false
+	}
+
+	raw := string(decoded)
+	if !hmac.Equal([]byte(sign(raw)), []byte(strings.ToLower(signature))) {
+		return "", false
+	}
+	return raw, true
+}
+
+// Fetch returns the image at the remote url, from the cache when possible
+func Fetch(ctx context.Context, raw string) (*Image, error) {
+	sum := sha256.Sum256([]byte(raw))
+	key := valkeydb.IMAGE_PROXY_PREFIX + hex.EncodeToString(sum[:])
+	rdb := valkeydb.GetValkeyClient()
+
+	// without valkey every request goes upstream
+	if rdb != nil {
+		if cached, err := rdb.HGetAll(ctx, key).Result(); err == nil && cached["content_type"] != "" {
+			return &Image{ContentType: cached["content_type"], Body: []byte(cached["body"])}, nil
+		}
+	}
+
+	img, err := download(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if rdb != nil {
+		pipe := rdb.TxPipeline()
+		pipe.HSet(ctx, key, "content_type", img.ContentType, "body", img.Body)
+		pipe.Expire(ctx, key, cacheTTL)
+		pipe.Exec(ctx)
+	}
+
+	return img, nil
+}
+
+func download(ctx context.Context, raw string) (*Image, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("Accept", "image/*")
+	req.Header.Set("User-Agent", "hindsight-image-proxy")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, publicnet.ErrPrivateRange) {
+			return nil, ErrForbidden
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrUpstream, resp.StatusCode)
+	}
+	if resp.ContentLength > MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	// the declared type has to be an image, and so does the content itself.
+	// svg can carry scripts so it's refused
+	declared, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !isAllowedType(declared) {
+		return nil, ErrNotAnImage
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	if len(body) > MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(body))
+	if !isAllowedType(sniffed) {
+		return nil, ErrNotAnImage
+	}
+
+	return &Image{ContentType: sniffed, Body: body}, nil
+}
+
+func isAllowedType(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml"
+}
@@ -0,0 +1,158 @@
+package inbox
+
+// mention inbox: messages that mention a user or reply to one of their messages, across servers and dms.
+// entries are written when the message is sent, mass mentions are left out
+
+import (
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/mentions"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RecordChannelMessage adds the message to the inbox of the server members it mentions or replies to
+func RecordChannelMessage(serverID, channelID, messageID, authorID uuid.UUID, replyToID *uuid.UUID, mentioned mentions.Mentions) {
+	var replyAuthor *uuid.UUID
+	if replyToID != nil {
+		var authorIDs []uuid.UUID
+		database.DB.Model(&database.ChannelMessage{}).Where("id = ? AND channel_id = ?", replyToID, channelID).Pluck("author_id", &authorIDs)
+		if len(authorIDs) > 0 {
+			replyAuthor = &authorIDs[0]
+		}
+	}
+
+	reasons := targets(authorID, replyAuthor, mentioned)
+	if len(reasons) == 0 {
+		return
+	}
+
+	var members []uuid.UUID
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id IN ?", serverID, keys(reasons)).Pluck("user_id", &members)
+
+	entries := make([]database.InboxEntry, 0, len(members))
+	for _, userID := range members {
+		entries = append(entries, database.InboxEntry{
+			UserID:    userID,
+			MessageID: messageID,
+			Kind:      database.MessageKindChannel,
+			ServerID:  &serverID,
+			ChannelID: &channelID,
+			AuthorID:  authorID,
+			Reason:    reasons[userID],
+		})
+	}
+	create(entries)
+}
+
+// RecordDirectMessage adds the message to the inbox of the participants it mentions or replies to,
+// recipients limits it to those users (shadowed messages), nil means everyone in the conversation
+func RecordDirectMessage(convID, messageID, authorID uuid.UUID, replyToID *uuid.UUID, mentioned mentions.Mentions, recipients map[uuid.UUID]bool) {
+	var replyAuthor *uuid.UUID
+	if replyToID != nil {
+		var authorIDs []uuid.UUID
+		database.DB.Model(&database.DirectMessage{}).Where("id = ? AND conversation_id = ?", replyToID, convID).Pluck("author_id", &authorIDs)
+		if len(authorIDs) > 0 {
+			replyAuthor = &authorIDs[0]
+		}
+	}
+
+	reasons := targets(authorID, replyAuthor, mentioned)
+	if len(reasons) == 0 {
+		return
+	}
+
+	var participants []uuid.UUID
+	database.DB.Model(&database.DMParticipant{}).Where("conversation_id = ? AND user_id IN ?", convID, keys(reasons)).Pluck("user_id", &participants)
+
+	entries := make([]database.InboxEntry, 0, len(participants))
+	for _, userID := range participants {
+		if recipients != nil && !recipients[userID] {
+			continue
+		}
+		entries = append(entries, database.InboxEntry{
+			UserID:         userID,
+			MessageID:      messageID,
+			Kind:           database.MessageKindDM,
+			ConversationID: &convID,
+			AuthorID:       authorID,
+			Reason:         reasons[userID],
+		})
+	}
+	create(entries)
+}
+
+// MarkRead marks the given entries as read, all of the users unread entries when messageIDs is empty
+func MarkRead(userID uuid.UUID, messageIDs []uuid.UUID) error {
+	query := database.DB.Model(&database.InboxEntry{}).Where("user_id = ? AND read_at IS NULL", userID)
+	if len(messageIDs) > 0 {
+		query = query.Where("message_id IN ?", messageIDs)
+	}
+	return query.Update("read_at", time.Now()).Error
+}
+
+// MarkConversationRead marks the users entries in a conversation as read, e.g when they ack it
+func MarkConversationRead(userID, convID uuid.UUID) error {
+	return database.DB.Model(&database.InboxEntry{}).
+		Where("user_id = ? AND conversation_id = ? AND read_at IS NULL", userID, convID).
+		Update("read_at", time.Now()).Error
+}
+
+// MarkTargetsRead marks the users entries in the conversations and channels as read, inside the
+// callers transaction so bulk acks land together
+func MarkTargetsRead(tx *gorm.DB, userID uuid.UUID, convIDs, channelIDs []uuid.UUID, at time.Time) error {
+	if len(convIDs) == 0 && len(channelIDs) == 0 {
+		return nil
+	}
+
+	query := tx.Model(&database.InboxEntry{}).Where("user_id = ? AND read_at IS NULL", userID)
+	switch {
+	case len(convIDs) > 0 && len(channelIDs) > 0:
+		query = query.Where("conversation_id IN ? OR channel_id IN ?", convIDs, channelIDs)
+	case len(convIDs) > 0:
+		query = query.Where("conversation_id IN ?", convIDs)
+	default:
+		query = query.Where("channel_id IN ?", channelIDs)
+	}
+	return query.Update("read_at", at).Error
+}
+
+// UnreadCount returns how many of the users entries are unread
+func UnreadCount(userID uuid.UUID) int64 {
+	var count int64
+	database.DB.Model(&database.InboxEntry{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count)
+	return count
+}
+
+// targets maps each user the message is for to the reason, a direct mention wins over a role mention and a reply
+func targets(authorID uuid.UUID, replyAuthor *uuid.UUID, mentioned mentions.Mentions) map[uuid.UUID]string {
+	reasons := make(map[uuid.UUID]string, len(mentioned.Users)+len(mentioned.RoleUsers)+1)
+	if replyAuthor != nil {
+		reasons[*replyAuthor] = database.InboxReasonReply
+	}
+	for userID := range mentioned.RoleUsers {
+		reasons[userID] = database.InboxReasonRoleMention
+	}
+	for userID := range mentioned.Users {
+		reasons[userID] = database.InboxReasonMention
+	}
+	delete(reasons, authorID)
+	return reasons
+}
+
+func keys(m map[uuid.UUID]string) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func create(entries []database.InboxEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&entries)
+}
@@ -0,0 +1,185 @@
+package instance
+
+// what a client needs to know about this deployment. branding and the registration mode are set through
+// the admin api and live in valkey so every instance agrees on them, limits come from the environment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+)
+
+// Domain new accounts are created under
+const Domain = "hindsight.chat"
+
+// Limits are the size limits of this deployment, each can be overridden with the env var next to it
+type Limits struct {
+	MaxUploadSize        int64 `json:"max_upload_size"`         // MAX_UPLOAD_SIZE, bytes
+	MaxMessageLength     int   `json:"max_message_length"`      // MAX_MESSAGE_LENGTH, characters
+	MaxGroupSize         int   `json:"max_group_size"`          // MAX_GROUP_SIZE, group dm participants including the creator
+	MaxServersPerUser    int   `json:"max_servers_per_user"`    // MAX_SERVERS_PER_USER
+	MaxChannelsPerServer int   `json:"max_channels_per_server"` // MAX_CHANNELS_PER_SERVER
+}
+
+var DefaultLimits = Limits{
+	MaxUploadSize:        8 << 20,
+	MaxMessageLength:     4000,
+	MaxGroupSize:         25,
+	MaxServersPerUser:    100,
+	MaxChannelsPerServer: 500,
+}
+
+// QuotaError is a limit that would be exceeded, sent to clients as the details of the error
+type QuotaError struct {
+	Limit string `json:"limit"` // json name of the limit, e.g max_message_length
+	Max   int64  `json:"max"`
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s of %d exceeded", e.Limit, e.Max)
+}
+
+// registration modes
+const (
+	RegistrationOpen   = "open"
+	RegistrationClosed = "closed"
+)
+
+// Version is the build, set with -ldflags "-X github.com/hindsightchat/backend/src/lib/instance.Version=..."
+var Version = "dev"
+
+// GetLimits returns the limits, env vars that are unset or not a positive number keep the default
+func GetLimits() Limits {
+	limits := DefaultLimits
+	limits.MaxUploadSize = int64(envLimit("MAX_UPLOAD_SIZE", int(limits.MaxUploadSize)))
+	limits.MaxMessageLength = envLimit("MAX_MESSAGE_LENGTH", limits.MaxMessageLength)
+	limits.MaxGroupSize = envLimit("MAX_GROUP_SIZE", limits.MaxGroupSize)
+	limits.MaxServersPerUser = envLimit("MAX_SERVERS_PER_USER", limits.MaxServersPerUser)
+	limits.MaxChannelsPerServer = envLimit("MAX_CHANNELS_PER_SERVER", limits.MaxChannelsPerServer)
+	return limits
+}
+
+func envLimit(name string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
+// CheckMessageLength returns a QuotaError when content is longer than messages may be.
+// encrypted content is base64 ciphertext, it gets room for the encoding and the envelope
+func CheckMessageLength(content string, encrypted bool) *QuotaError {
+	limit := GetLimits().MaxMessageLength
+	length := utf8.RuneCountInString(content)
+	if encrypted {
+		length = len(content) / 2
+	}
+	if length > limit {
+		return &QuotaError{Limit: "max_message_length", Max: int64(limit)}
+	}
+	return nil
+}
+
+// CheckGroupSize returns a QuotaError when a group dm would get more than participants
+func CheckGroupSize(participants int) *QuotaError {
+	limit := GetLimits().MaxGroupSize
+	if participants > limit {
+		return &QuotaError{Limit: "max_group_size", Max: int64(limit)}
+	}
+	return nil
+}
+
+// SessionLifetime is how long a login lasts no matter how much it is used
+const SessionLifetime = 7 * 24 * time.Hour
+
+// SessionPolicy is how long logins stay valid, in seconds. an idle timeout of 0 means only the lifetime applies
+type SessionPolicy struct {
+	Lifetime    int64 `json:"lifetime"`
+	IdleTimeout int64 `json:"idle_timeout"` // SESSION_IDLE_DAYS, logins unused this long expire early
+}
+
+// GetSessionPolicy returns the session policy, SESSION_IDLE_DAYS unset or not a positive number disables the idle timeout
+func GetSessionPolicy() SessionPolicy {
+	return SessionPolicy{
+		Lifetime:    int64(SessionLifetime / time.Second),
+		IdleTimeout: int64(envLimit("SESSION_IDLE_DAYS", 0)) * int64(24*time.Hour/time.Second),
+	}
+}
+
+// settings are re-read from valkey at most this often
+const refreshInterval = 30 * time.Second
+
+type Settings struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Registration string `json:"registration"` // open or closed
+}
+
+// Default applies until an admin changes anything
+var Default = Settings{Name: "Hindsight", Registration: RegistrationOpen}
+
+var (
+	current  Settings
+	loadedAt time.Time
+	mu       sync.RWMutex
+)
+
+// Current returns the settings, cached briefly so registration can check them on every request
+func Current() Settings {
+	mu.RLock()
+	settings, fresh := current, time.Since(loadedAt) < refreshInterval
+	mu.RUnlock()
+
+	if fresh {
+		return settings
+	}
+
+	settings = Default
+	data, err := valkeydb.GetValkeyClient().Get(context.Background(), valkeydb.INSTANCE_SETTINGS_KEY).Bytes()
+	if err == nil {
+		json.Unmarshal(data, &settings)
+	}
+
+	mu.Lock()
+	current = settings
+	loadedAt = time.Now()
+	mu.Unlock()
+
+	return settings
+}
+
+// RegistrationAllowed reports whether new accounts can sign up
+func RegistrationAllowed() bool {
+	return Current().Registration != RegistrationClosed
+}
+
+// ValidRegistration reports whether mode is a known registration mode
+func ValidRegistration(mode string) bool {
+	return mode == RegistrationOpen || mode == RegistrationClosed
+}
+
+// Set stores the settings for all instances
+func Set(ctx context.Context, settings Settings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if err := valkeydb.GetValkeyClient().Set(ctx, valkeydb.INSTANCE_SETTINGS_KEY, data, 0).Err(); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	current = settings
+	loadedAt = time.Now()
+	mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package integrations
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/hindsightchat/backend/src/types"
+)
+
+type githubUser struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatar_url"`
+	HTMLURL   string `json:"html_url"`
+}
+
+type githubRepository struct {
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+}
+
+type githubPushEvent struct {
+	Ref        string           `json:"ref"`
+	Compare    string           `json:"compare"`
+	Deleted    bool             `json:"deleted"`
+	Repository githubRepository `json:"repository"`
+	Sender     githubUser       `json:"sender"`
+	Commits    []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+}
+
+type githubIssue struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url"`
+	Merged  bool   `json:"merged"`
+}
+
+type githubIssueEvent struct {
+	Action      string           `json:"action"`
+	Issue       *githubIssue     `json:"issue"`
+	PullRequest *githubIssue     `json:"pull_request"`
+	Repository  githubRepository `json:"repository"`
+	Sender      githubUser       `json:"sender"`
+}
+
+// only these actions are worth a message, the rest (labeled, assigned...) is noise
+var githubActions = map[string]bool{
+	"opened":   true,
+	"closed":   true,
+	"reopened": true,
+}
+
+// FormatGitHub turns a github webhook delivery into an embed, event is the X-GitHub-Event header
+func FormatGitHub(event string, body []byte) (*types.Embed, error) {
+	switch event {
+	case "push":
+		var payload githubPushEvent
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		if payload.Deleted || len(payload.Commits) == 0 {
+			return nil, ErrIgnored
+		}
+
+		commits := make([]commitLine, 0, len(payload.Commits))
+		for _, c := range payload.Commits {
+			commits = append(commits, commitLine{id: c.ID, url: c.URL, message: c.Message, author: c.Author.Name})
+		}
+
+		branch := strings.TrimPrefix(payload.Ref, "refs/heads/")
+		return &types.Embed{
+			Title:       "[" + payload.Repository.FullName + ":" + branch + "] " + pluralize(len(commits), "new commit"),
+			Description: commitList(commits),
+			URL:         payload.Compare,
+			Color:       colorPush,
+			Author:      githubAuthor(payload.Sender),
+			Footer:      "GitHub",
+		}, nil
+
+	case "pull_request", "issues":
+		var payload githubIssueEvent
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		if !githubActions[payload.Action] {
+			return nil, ErrIgnored
+		}
+
+		item, kind := payload.Issue, "Issue"
+		if event == "pull_request" {
+			item, kind = payload.PullRequest, "Pull request"
+		}
+		if item == nil {
+			return nil, ErrIgnored
+		}
+
+		action := payload.Action
+		if item.Merged && action == "closed" {
+			action = "merged"
+		}
+
+		embed := &types.Embed{
+			Title:  "[" + payload.Repository.FullName + "] " + kind + " " + action + ": #" + strconv.Itoa(item.Number) + " " + item.Title,
+			URL:    item.HTMLURL,
+			Color:  actionColor(payload.Action, item.Merged),
+			Author: githubAuthor(payload.Sender),
+			Footer: "GitHub",
+		}
+		if payload.Action == "opened" {
+			embed.Description = truncate(item.Body, maxDescription)
+		}
+		return embed, nil
+	}
+
+	// ping and everything we dont format
+	return nil, ErrIgnored
+}
+
+func githubAuthor(user githubUser) *types.EmbedAuthor {
+	if user.Login == "" {
+		return nil
+	}
+	return &types.EmbedAuthor{Name: user.Login, URL: user.HTMLURL, IconURL: user.AvatarURL}
+}
@@ -0,0 +1,130 @@
+package integrations
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/hindsightchat/backend/src/types"
+)
+
+type gitlabUser struct {
+	Name      string `json:"name"`
+	Username  string `json:"username"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type gitlabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+}
+
+type gitlabPushEvent struct {
+	Ref         string        `json:"ref"`
+	After       string        `json:"after"`
+	UserName    string        `json:"user_name"`
+	UserAvatar  string        `json:"user_avatar"`
+	Project     gitlabProject `json:"project"`
+	TotalCommit int           `json:"total_commits_count"`
+	Commits     []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+}
+
+type gitlabIssueEvent struct {
+	User             gitlabUser    `json:"user"`
+	Project          gitlabProject `json:"project"`
+	ObjectAttributes struct {
+		IID         int    `json:"iid"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+		Action      string `json:"action"`
+	} `json:"object_attributes"`
+}
+
+// gitlab uses present tense actions, "merge" only exists for merge requests
+var gitlabActions = map[string]string{
+	"open":   "opened",
+	"close":  "closed",
+	"reopen": "reopened",
+	"merge":  "merged",
+}
+
+// a push deleting a branch has an all zero after sha
+const gitlabNullSHA = "0000000000000000000000000000000000000000"
+
+// FormatGitLab turns a gitlab webhook delivery into an embed, event is the X-Gitlab-Event header
+func FormatGitLab(event string, body []byte) (*types.Embed, error) {
+	switch event {
+	case "Push Hook":
+		var payload gitlabPushEvent
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		if payload.After == gitlabNullSHA || len(payload.Commits) == 0 {
+			return nil, ErrIgnored
+		}
+
+		commits := make([]commitLine, 0, len(payload.Commits))
+		for _, c := range payload.Commits {
+			commits = append(commits, commitLine{id: c.ID, url: c.URL, message: c.Message, author: c.Author.Name})
+		}
+
+		total := payload.TotalCommit
+		if total < len(commits) {
+			total = len(commits)
+		}
+
+		branch := strings.TrimPrefix(payload.Ref, "refs/heads/")
+		embed := &types.Embed{
+			Title:       "[" + payload.Project.PathWithNamespace + ":" + branch + "] " + pluralize(total, "new commit"),
+			Description: commitList(commits),
+			URL:         payload.Project.WebURL + "/-/commits/" + branch,
+			Color:       colorPush,
+			Footer:      "GitLab",
+		}
+		if payload.UserName != "" {
+			embed.Author = &types.EmbedAuthor{Name: payload.UserName, IconURL: payload.UserAvatar}
+		}
+		return embed, nil
+
+	case "Merge Request Hook", "Issue Hook":
+		var payload gitlabIssueEvent
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+
+		attrs := payload.ObjectAttributes
+		action, ok := gitlabActions[attrs.Action]
+		if !ok {
+			return nil, ErrIgnored
+		}
+
+		kind, ref := "Issue", "#"
+		if event == "Merge Request Hook" {
+			kind, ref = "Merge request", "!"
+		}
+
+		embed := &types.Embed{
+			Title:  "[" + payload.Project.PathWithNamespace + "] " + kind + " " + action + ": " + ref + strconv.Itoa(attrs.IID) + " " + attrs.Title,
+			URL:    attrs.URL,
+			Color:  actionColor(attrs.Action, attrs.Action == "merge"),
+			Footer: "GitLab",
+		}
+		if payload.User.Username != "" {
+			embed.Author = &types.EmbedAuthor{Name: payload.User.Username, IconURL: payload.User.AvatarURL}
+		}
+		if attrs.Action == "open" {
+			embed.Description = truncate(attrs.Description, maxDescription)
+		}
+		return embed, nil
+	}
+
+	return nil, ErrIgnored
+}
@@ -0,0 +1,114 @@
+package integrations
+
+// first party integrations turning third party webhook payloads into message embeds
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrIgnored is returned for events we dont post anything for
+var ErrIgnored = errors.New("event ignored")
+
+const (
+	colorPush   = 0x7289da
+	colorOpened = 0x2ecc71
+	colorClosed = 0xe74c3c
+	colorMerged = 0x9b59b6
+	colorOther  = 0x95a5a6
+)
+
+// embed descriptions are cut to this
+const maxDescription = 1000
+
+// VerifyGitHubSignature checks X-Hub-Signature-256 against the raw body
+func VerifyGitHubSignature(secret string, body []byte, header string) bool {
+	if secret == "" {
+		return false
+	}
+
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// VerifyGitLabToken checks X-Gitlab-Token, gitlab sends the shared secret as is
+func VerifyGitLabToken(secret string, header string) bool {
+	if secret == "" || header == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(header)) == 1
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// commitList renders up to five commits as markdown lines
+func commitList(commits []commitLine) string {
+	var b strings.Builder
+	for i, c := range commits {
+		if i == 5 {
+			b.WriteString("…and more")
+			break
+		}
+		b.WriteString("[`" + shortSHA(c.id) + "`](" + c.url + ") " + truncate(firstLine(c.message), 80) + " - " + c.author + "\n")
+	}
+	return truncate(strings.TrimSuffix(b.String(), "\n"), maxDescription)
+}
+
+type commitLine struct {
+	id      string
+	url     string
+	message string
+	author  string
+}
+
+func actionColor(action string, merged bool) int {
+	switch {
+	case merged:
+		return colorMerged
+	case action == "opened" || action == "open" || action == "reopened" || action == "reopen":
+		return colorOpened
+	case action == "closed" || action == "close":
+		return colorClosed
+	}
+	return colorOther
+}
+
+func pluralize(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return strconv.Itoa(n) + " " + word + "s"
+}
@@ -0,0 +1,200 @@
+package ipban
+
+// ip range bans, enforced at register, login and websocket upgrade
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+)
+
+// bans are reloaded from the database at most this often
+const refreshInterval = 30 * time.Second
+
+type cachedBan struct {
+	network   *net.IPNet
+	reason    string
+	expiresAt *time.Time
+}
+
+var (
+	bans     []cachedBan
+	loadedAt time.Time
+	mu       sync.RWMutex
+)
+
+// ClientIP returns the ip of the caller. forwarding headers are only read when the connection comes from a
+// proxy listed in TRUSTED_PROXIES (comma separated ips or ranges, e.g 127.0.0.1 for a local tunnel),
+// anyone else could put any address in them
+func ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !trustedProxy(remote) {
+		return remote
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("CF-Connecting-IP")); ip != "" {
+		return ip
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		// each proxy appends the address it got the request from, so the client can only write the left side.
+		// the rightmost hop that isnt one of our proxies is the one to believe
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop != "" && (i == 0 || !trustedProxy(hop)) {
+				return hop
+			}
+		}
+	}
+
+	return remote
+}
+
+var (
+	trustedProxies     []*net.IPNet
+	trustedProxiesOnce sync.Once
+)
+
+func trustedProxy(ip string) bool {
+	trustedProxiesOnce.Do(func() {
+		for _, value := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+			cidr, ok := NormalizeCIDR(value)
+			if !ok {
+				continue
+			}
+			_, network, _ := net.ParseCIDR(cidr)
+			trustedProxies = append(trustedProxies, network)
+		}
+	})
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeCIDR turns a single address or a range into canonical cidr notation
+func NormalizeCIDR(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return "", false
+		}
+		if ip.To4() != nil {
+			return ip.String() + "/32", true
+		}
+		return ip.String() + "/128", true
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", false
+	}
+	return network.String(), true
+}
+
+// Contains reports whether ip falls inside cidr
+func Contains(cidr string, ip string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	return parsed != nil && network.Contains(parsed)
+}
+
+// Check returns whether the ip is covered by an active ban and the reason for it
+func Check(ip string) (bool, string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false, ""
+	}
+
+	refreshIfStale()
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	now := time.Now()
+	for _, ban := range bans {
+		if ban.expiresAt != nil && ban.expiresAt.Before(now) {
+			continue
+		}
+		if ban.network.Contains(parsed) {
+			return true, ban.reason
+		}
+	}
+
+	return false, ""
+}
+
+// IsRequestBanned is Check for the caller of a request
+func IsRequestBanned(r *http.Request) (bool, string) {
+	return Check(ClientIP(r))
+}
+
+// RejectionMessage is what banned clients are told
+func RejectionMessage(reason string) string {
+	if reason == "" {
+		return "Your network has been banned from this instance"
+	}
+	return "Your network has been banned from this instance: " + reason
+}
+
+// Invalidate forces the next check to reload bans, call after changing them
+func Invalidate() {
+	mu.Lock()
+	loadedAt = time.Time{}
+	mu.Unlock()
+}
+
+func refreshIfStale() {
+	mu.RLock()
+	fresh := time.Since(loadedAt) < refreshInterval
+	mu.RUnlock()
+
+	if fresh {
+		return
+	}
+
+	var rows []database.IPBan
+	if err := database.DB.Where("expires_at IS NULL OR expires_at > ?", time.Now()).Find(&rows).Error; err != nil {
+		// keep the old list rather than failing open on a db hiccup
+		return
+	}
+
+	loaded := make([]cachedBan, 0, len(rows))
+	for _, row := range rows {
+		_, network, err := net.ParseCIDR(row.CIDR)
+		if err != nil {
+			continue
+		}
+		loaded = append(loaded, cachedBan{network: network, reason: row.Reason, expiresAt: row.ExpiresAt})
+	}
+
+	mu.Lock()
+	bans = loaded
+	loadedAt = time.Now()
+	mu.Unlock()
+}
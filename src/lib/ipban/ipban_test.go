@@ -0,0 +1,37 @@
+package ipban
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "127.0.0.1, 10.0.0.0/8")
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"headers from an untrusted peer are ignored", "203.0.113.7:5000", map[string]string{"X-Real-IP": "1.2.3.4", "X-Forwarded-For": "1.2.3.4"}, "203.0.113.7"},
+		{"tunnel header from a trusted proxy", "127.0.0.1:5000", map[string]string{"CF-Connecting-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"rightmost untrusted forwarded hop", "127.0.0.1:5000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.2, 10.0.0.5"}, "198.51.100.2"},
+		{"only proxies forwarded", "127.0.0.1:5000", map[string]string{"X-Forwarded-For": "10.0.0.9, 10.0.0.5"}, "10.0.0.9"},
+		{"trusted proxy without headers", "127.0.0.1:5000", nil, "127.0.0.1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = c.remote
+			for k, v := range c.headers {
+				r.Header.Set(k, v)
+			}
+			if got := ClientIP(r); got != c.want {
+				t.Fatalf("ClientIP = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package mail
+
+// plain text email over smtp, configured with SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM.
+// nothing is sent when SMTP_HOST isnt set
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Enabled reports whether an smtp server is configured
+func Enabled() bool {
+	return os.Getenv("SMTP_HOST") != ""
+}
+
+// Send delivers a plain text email to one recipient
+func Send(to, subject, body string) error {
+	if !Enabled() {
+		return nil
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@" + host
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	// keep header injection out of user controlled values
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+
+	message := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		from, to, subject, time.Now().Format(time.RFC1123Z), strings.ReplaceAll(body, "\n", "\r\n"),
+	)
+
+	return smtp.SendMail(net.JoinHostPort(host, port), auth, from, []string{to}, []byte(message))
+}
@@ -0,0 +1,89 @@
+package maintenance
+
+// read-only mode, state lives in valkey so every instance agrees on it
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+)
+
+const DefaultMessage = "Hindsight is undergoing maintenance, please try again shortly"
+
+// state is re-read from valkey at most this often
+const refreshInterval = 5 * time.Second
+
+type State struct {
+	Enabled bool      `json:"enabled"`
+	Message string    `json:"message"`
+	Since   time.Time `json:"since,omitempty"`
+}
+
+var (
+	current  State
+	loadedAt time.Time
+	mu       sync.RWMutex
+)
+
+// Current returns the maintenance state, cached briefly so it can be checked on every request
+func Current() State {
+	mu.RLock()
+	state, fresh := current, time.Since(loadedAt) < refreshInterval
+	mu.RUnlock()
+
+	if fresh {
+		return state
+	}
+
+	state = State{}
+	data, err := valkeydb.GetValkeyClient().Get(context.Background(), valkeydb.MAINTENANCE_KEY).Bytes()
+	if err == nil {
+		json.Unmarshal(data, &state)
+	}
+
+	mu.Lock()
+	current = state
+	loadedAt = time.Now()
+	mu.Unlock()
+
+	return state
+}
+
+// Enabled is shorthand for Current().Enabled
+func Enabled() bool {
+	return Current().Enabled
+}
+
+// Set turns maintenance mode on or off for all instances
+func Set(ctx context.Context, enabled bool, message string) (State, error) {
+	state := State{Enabled: enabled}
+	rdb := valkeydb.GetValkeyClient()
+
+	if enabled {
+		if message == "" {
+			message = DefaultMessage
+		}
+		state.Message = message
+		state.Since = time.Now()
+
+		data, err := json.Marshal(state)
+		if err != nil {
+			return state, err
+		}
+		if err := rdb.Set(ctx, valkeydb.MAINTENANCE_KEY, data, 0).Err(); err != nil {
+			return state, err
+		}
+	} else if err := rdb.Del(ctx, valkeydb.MAINTENANCE_KEY).Err(); err != nil {
+		return state, err
+	}
+
+	mu.Lock()
+	current = state
+	loadedAt = time.Now()
+	mu.Unlock()
+
+	return state, nil
+}
@@ -0,0 +1,30 @@
+package membercount
+
+// member counts of servers and participant counts of conversations, kept on the rows themselves so listings
+// never count. call these in the transaction that adds or removes the members
+
+import (
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Members adds delta (negative when members left) to the member count of the server
+func Members(tx *gorm.DB, serverID uuid.UUID, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	return tx.Model(&database.Server{}).
+		Where("id = ?", serverID).
+		UpdateColumn("member_count", gorm.Expr("GREATEST(member_count + ?, 0)", delta)).Error
+}
+
+// Participants adds delta (negative when participants left) to the participant count of the conversation
+func Participants(tx *gorm.DB, conversationID uuid.UUID, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	return tx.Model(&database.DMConversation{}).
+		Where("id = ?", conversationID).
+		UpdateColumn("participant_count", gorm.Expr("GREATEST(participant_count + ?, 0)", delta)).Error
+}
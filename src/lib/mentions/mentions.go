@@ -0,0 +1,61 @@
+package mentions
+
+// mention parsing for message content, users are mentioned as <@user_id> and roles as <@&role_id>
+
+import (
+	"regexp"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	userMentionPattern = regexp.MustCompile(`<@([0-9a-fA-F-]{36})>`)
+	roleMentionPattern = regexp.MustCompile(`<@&([0-9a-fA-F-]{36})>`)
+)
+
+// mass mentions only count as whole words
+var (
+	everyonePattern = regexp.MustCompile(`(^|\s)@everyone\b`)
+	herePattern     = regexp.MustCompile(`(^|\s)@here\b`)
+)
+
+type Mentions struct {
+	Users    map[uuid.UUID]bool
+	Roles    map[uuid.UUID]bool
+	Everyone bool
+	Here     bool
+
+	// members of the mentioned roles, only set by ResolveRoles
+	RoleUsers map[uuid.UUID]bool
+}
+
+// Parse extracts the mentions from message content
+func Parse(content string) Mentions {
+	m := Mentions{Users: make(map[uuid.UUID]bool), Roles: make(map[uuid.UUID]bool)}
+
+	for _, match := range userMentionPattern.FindAllStringSubmatch(content, -1) {
+		if id, err := uuid.FromString(match[1]); err == nil {
+			m.Users[id] = true
+		}
+	}
+	for _, match := range roleMentionPattern.FindAllStringSubmatch(content, -1) {
+		if id, err := uuid.FromString(match[1]); err == nil {
+			m.Roles[id] = true
+		}
+	}
+
+	m.Everyone = everyonePattern.MatchString(content)
+	m.Here = herePattern.MatchString(content)
+
+	return m
+}
+
+// Mass reports whether the message mentions @everyone or @here
+func (m Mentions) Mass() bool {
+	return m.Everyone || m.Here
+}
+
+// Mentioned reports whether the user is mentioned directly or through a resolved role
+func (m Mentions) Mentioned(userID uuid.UUID) bool {
+	return m.Users[userID] || m.RoleUsers[userID]
+}
@@ -0,0 +1,45 @@
+package mentions
+
+import (
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ResolveRoles keeps the mentioned roles of the server that are mentionable and fills RoleUsers with their members.
+// default roles are @everyone and never resolve. returns the roles that stayed
+func ResolveRoles(serverID uuid.UUID, m *Mentions) []uuid.UUID {
+	m.RoleUsers = make(map[uuid.UUID]bool)
+	if len(m.Roles) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(m.Roles))
+	for id := range m.Roles {
+		ids = append(ids, id)
+	}
+
+	var roleIDs []uuid.UUID
+	database.DB.Model(&database.Role{}).
+		Where("id IN ? AND server_id = ? AND mentionable = ? AND is_default = ?", ids, serverID, true, false).
+		Pluck("id", &roleIDs)
+
+	m.Roles = make(map[uuid.UUID]bool, len(roleIDs))
+	for _, id := range roleIDs {
+		m.Roles[id] = true
+	}
+	if len(roleIDs) == 0 {
+		return nil
+	}
+
+	var userIDs []uuid.UUID
+	database.DB.Model(&database.ServerMember{}).
+		Distinct("server_members.user_id").
+		Joins("JOIN server_member_roles smr ON smr.server_member_id = server_members.id").
+		Where("server_members.server_id = ? AND smr.role_id IN ?", serverID, roleIDs).
+		Pluck("server_members.user_id", &userIDs)
+
+	for _, id := range userIDs {
+		m.RoleUsers[id] = true
+	}
+	return roleIDs
+}
@@ -0,0 +1,163 @@
+package msgcount
+
+// message counts per channel and conversation, maintained on create / delete instead of counted
+
+import (
+	"errors"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUnknownMessage = errors.New("unknown message")
+
+type Kind int
+
+const (
+	Channel Kind = iota
+	Conversation
+)
+
+func (k Kind) model() any {
+	if k == Channel {
+		return &database.ChannelMessage{}
+	}
+	return &database.DirectMessage{}
+}
+
+func (k Kind) column() string {
+	if k == Channel {
+		return "channel_id"
+	}
+	return "conversation_id"
+}
+
+// load returns the counter of the target, creating it from the existing messages the first time
+func load(tx *gorm.DB, kind Kind, targetID uuid.UUID) (*database.MessageCounter, error) {
+	var counter database.MessageCounter
+	err := tx.Where("target_id = ?", targetID).First(&counter).Error
+	if err == nil {
+		return &counter, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// one off count for channels and conversations that predate the counters
+	var total int64
+	if err := tx.Model(kind.model()).Where(kind.column()+" = ?", targetID).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	counter = database.MessageCounter{TargetID: targetID, Total: total, Sequence: total}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+		return nil, err
+	}
+
+	// someone else may have created it first
+	err = tx.Where("target_id = ?", targetID).First(&counter).Error
+	return &counter, err
+}
+
+// Next counts a new message and returns its sequence number.
+// call it inside the transaction that creates the message so the counter row stays locked until then
+func Next(tx *gorm.DB, kind Kind, targetID uuid.UUID) (int64, error) {
+	if _, err := load(tx, kind, targetID); err != nil {
+		return 0, err
+	}
+
+	err := tx.Model(&database.MessageCounter{}).Where("target_id = ?", targetID).Updates(map[string]any{
+		"total":    gorm.Expr("total + 1"),
+		"sequence": gorm.Expr("sequence + 1"),
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var counter database.MessageCounter
+	if err := tx.Where("target_id = ?", targetID).First(&counter).Error; err != nil {
+		return 0, err
+	}
+	return counter.Sequence, nil
+}
+
+// Deleted takes n deleted messages off the total
+func Deleted(targetID uuid.UUID, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	return database.DB.Model(&database.MessageCounter{}).
+		Where("target_id = ?", targetID).
+		Update("total", gorm.Expr("GREATEST(total - ?, 0)", n)).Error
+}
+
+// Total returns the number of messages in the target
+func Total(kind Kind, targetID uuid.UUID) (int64, error) {
+	counter, err := load(database.DB, kind, targetID)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Total, nil
+}
+
+// Since returns the number of messages sent after the given one.
+// deletions after it aren't tracked per message, so it can overcount until capped at the total
+func Since(kind Kind, targetID, messageID uuid.UUID) (int64, error) {
+	counter, err := load(database.DB, kind, targetID)
+	if err != nil {
+		return 0, err
+	}
+
+	var ref struct {
+		Seq       int64
+		CreatedAt time.Time
+	}
+	err = database.DB.Model(kind.model()).
+		Select("seq, created_at").
+		Where("id = ? AND "+kind.column()+" = ?", messageID, targetID).
+		Take(&ref).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrUnknownMessage
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if ref.Seq == 0 {
+		// the message predates the counters, count what came after it instead
+		var since int64
+		err := database.DB.Model(kind.model()).
+			Where(kind.column()+" = ? AND created_at > ?", targetID, ref.CreatedAt).
+			Count(&since).Error
+		return since, err
+	}
+
+	return min(counter.Sequence-ref.Seq, counter.Total), nil
+}
+
+// CreateChannelMessage inserts the message and counts it in one transaction
+func CreateChannelMessage(msg *database.ChannelMessage) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		seq, err := Next(tx, Channel, msg.ChannelID)
+		if err != nil {
+			return err
+		}
+		msg.Seq = seq
+		return tx.Create(msg).Error
+	})
+}
+
+// CreateDirectMessage inserts the message and counts it in one transaction
+func CreateDirectMessage(msg *database.DirectMessage) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		seq, err := Next(tx, Conversation, msg.ConversationID)
+		if err != nil {
+			return err
+		}
+		msg.Seq = seq
+		return tx.Create(msg).Error
+	})
+}
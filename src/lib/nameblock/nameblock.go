@@ -0,0 +1,132 @@
+package nameblock
+
+// handles that can't be registered. reserved names are refused when the whole handle matches, profanity
+// when it appears anywhere in it. handles are normalized first so Adm1n and s.u.p.p.o.r.t match too.
+// admins add entries through the admin api, a few reserved names are built in
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+)
+
+// entries are reloaded from the database at most this often
+const refreshInterval = 30 * time.Second
+
+var (
+	ErrReserved = errors.New("this username is reserved")
+	ErrProfane  = errors.New("this username is not allowed")
+)
+
+// always reserved, they would pass for the instance speaking
+var builtinReserved = []string{
+	"admin", "administrator", "root", "system", "support", "help", "staff", "official",
+	"moderator", "mod", "security", "abuse", "postmaster", "webmaster", "noreply",
+	"hindsight", "everyone", "here",
+}
+
+// look-alikes folded into the letter they stand for
+var leet = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+var (
+	reserved  = withBuiltin(0)
+	profanity []string
+	loadedAt  time.Time
+	mu        sync.RWMutex
+)
+
+// Normalize lowercases the handle, folds look-alike digits into letters and drops everything that isnt a letter
+func Normalize(handle string) string {
+	folded := leet.Replace(strings.ToLower(handle))
+
+	var b strings.Builder
+	for _, c := range folded {
+		if c >= 'a' && c <= 'z' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// Check returns ErrReserved or ErrProfane when the handle can't be used
+func Check(handle string) error {
+	name := Normalize(handle)
+	if name == "" {
+		return nil
+	}
+
+	refreshIfStale()
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if reserved[name] {
+		return ErrReserved
+	}
+	for _, word := range profanity {
+		if strings.Contains(name, word) {
+			return ErrProfane
+		}
+	}
+	return nil
+}
+
+// Reason is the reason sent to clients for an error of Check
+func Reason(err error) string {
+	if errors.Is(err, ErrProfane) {
+		return database.BlockedNameProfanity
+	}
+	return database.BlockedNameReserved
+}
+
+// Invalidate forces the next check to reload the entries, call after changing them
+func Invalidate() {
+	mu.Lock()
+	loadedAt = time.Time{}
+	mu.Unlock()
+}
+
+func refreshIfStale() {
+	mu.RLock()
+	fresh := time.Since(loadedAt) < refreshInterval
+	mu.RUnlock()
+
+	if fresh {
+		return
+	}
+
+	var rows []database.BlockedName
+	if err := database.DB.Find(&rows).Error; err != nil {
+		// keep the old list rather than failing open on a db hiccup
+		log.Printf("[nameblock] failed to load blocklist: %v", err)
+		return
+	}
+
+	loadedReserved := withBuiltin(len(rows))
+	loadedProfanity := make([]string, 0)
+	for _, row := range rows {
+		if row.Kind == database.BlockedNameProfanity {
+			loadedProfanity = append(loadedProfanity, row.Name)
+		} else {
+			loadedReserved[row.Name] = true
+		}
+	}
+
+	mu.Lock()
+	reserved = loadedReserved
+	profanity = loadedProfanity
+	loadedAt = time.Now()
+	mu.Unlock()
+}
+
+func withBuiltin(extra int) map[string]bool {
+	set := make(map[string]bool, len(builtinReserved)+extra)
+	for _, name := range builtinReserved {
+		set[name] = true
+	}
+	return set
+}
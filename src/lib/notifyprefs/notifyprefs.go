@@ -0,0 +1,139 @@
+package notifyprefs
+
+// per server notification preferences, checked before sending notify dispatches and push notifications
+
+import (
+	"sync"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/mentions"
+	uuid "github.com/satori/go.uuid"
+)
+
+// settings are cached per user per server for this long
+const cacheTTL = time.Minute
+
+type Setting struct {
+	Level            string `json:"level"`
+	SuppressEveryone bool   `json:"suppress_everyone"`
+}
+
+// Default applies when the user never changed anything for a server
+var Default = Setting{Level: database.NotificationLevelAll}
+
+type cacheKey struct {
+	userID   uuid.UUID
+	serverID uuid.UUID
+}
+
+type cachedSetting struct {
+	setting  Setting
+	loadedAt time.Time
+}
+
+var (
+	cache = make(map[cacheKey]cachedSetting)
+	mu    sync.RWMutex
+)
+
+// ValidLevel reports whether level is a known notification level
+func ValidLevel(level string) bool {
+	switch level {
+	case database.NotificationLevelAll, database.NotificationLevelMentions, database.NotificationLevelNone:
+		return true
+	}
+	return false
+}
+
+// Get returns the users setting for a server
+func Get(userID, serverID uuid.UUID) Setting {
+	return ForServer(serverID, []uuid.UUID{userID})[userID]
+}
+
+// ForServer returns the settings of several users in one server, loading what isnt cached in one query
+func ForServer(serverID uuid.UUID, userIDs []uuid.UUID) map[uuid.UUID]Setting {
+	result := make(map[uuid.UUID]Setting, len(userIDs))
+	missing := make([]uuid.UUID, 0)
+
+	mu.RLock()
+	for _, userID := range userIDs {
+		cached, ok := cache[cacheKey{userID, serverID}]
+		if ok && time.Since(cached.loadedAt) < cacheTTL {
+			result[userID] = cached.setting
+		} else {
+			missing = append(missing, userID)
+		}
+	}
+	mu.RUnlock()
+
+	if len(missing) == 0 {
+		return result
+	}
+
+	for _, userID := range missing {
+		result[userID] = Default
+	}
+
+	var rows []database.ServerNotificationSetting
+	if err := database.DB.Where("server_id = ? AND user_id IN ?", serverID, missing).Find(&rows).Error; err != nil {
+		// dont cache defaults we arent sure about
+		return result
+	}
+
+	for _, row := range rows {
+		result[row.UserID] = Setting{Level: row.Level, SuppressEveryone: row.SuppressEveryone}
+	}
+
+	now := time.Now()
+	mu.Lock()
+	for _, userID := range missing {
+		cache[cacheKey{userID, serverID}] = cachedSetting{setting: result[userID], loadedAt: now}
+	}
+	mu.Unlock()
+
+	return result
+}
+
+// Set stores the users setting for a server
+func Set(userID, serverID uuid.UUID, setting Setting) error {
+	row := database.ServerNotificationSetting{UserID: userID, ServerID: serverID}
+	err := database.DB.Where("user_id = ? AND server_id = ?", userID, serverID).
+		Assign(map[string]any{"level": setting.Level, "suppress_everyone": setting.SuppressEveryone}).
+		FirstOrCreate(&row).Error
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	cache[cacheKey{userID, serverID}] = cachedSetting{setting: setting, loadedAt: time.Now()}
+	mu.Unlock()
+
+	return nil
+}
+
+// Forget drops the cached setting, e.g when the user leaves the server
+func Forget(userID, serverID uuid.UUID) {
+	mu.Lock()
+	delete(cache, cacheKey{userID, serverID})
+	mu.Unlock()
+}
+
+// Allows reports whether a message with the given mentions should notify a user with this setting
+func (s Setting) Allows(userID uuid.UUID, m mentions.Mentions) bool {
+	switch s.Level {
+	case database.NotificationLevelNone:
+		return false
+	case database.NotificationLevelMentions:
+		if m.Mentioned(userID) {
+			return true
+		}
+		return m.Mass() && !s.SuppressEveryone
+	}
+	return true
+}
+
+// AcceptsMass reports whether @everyone / @here should reach a user with this setting
+func (s Setting) AcceptsMass() bool {
+	return s.Level != database.NotificationLevelNone && !s.SuppressEveryone
+}
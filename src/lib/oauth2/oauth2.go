@@ -0,0 +1,150 @@
+package oauth2
+
+// hindsight as an oauth2 provider: scopes, token hashing and bearer lookup
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"gorm.io/gorm"
+)
+
+const (
+	ScopeIdentify     = "identify"      // basic profile of the user
+	ScopeGuilds       = "guilds"        // servers the user is in
+	ScopeMessagesRead = "messages.read" // read channel messages the user can see
+)
+
+var knownScopes = map[string]bool{
+	ScopeIdentify:     true,
+	ScopeGuilds:       true,
+	ScopeMessagesRead: true,
+}
+
+const (
+	CodeLifetime         = 10 * time.Minute
+	AccessTokenLifetime  = time.Hour
+	RefreshTokenLifetime = 30 * 24 * time.Hour
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// ParseScopes splits a space separated scope string, rejecting unknown scopes and duplicates
+func ParseScopes(raw string) ([]string, error) {
+	fields := strings.Fields(raw)
+	if len(fields) == 0 {
+		return nil, errors.New("no scopes requested")
+	}
+
+	seen := make(map[string]bool, len(fields))
+	scopes := make([]string, 0, len(fields))
+	for _, scope := range fields {
+		if !knownScopes[scope] {
+			return nil, errors.New("unknown scope " + scope)
+		}
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, nil
+}
+
+// HasScope reports whether a stored scope string grants scope
+func HasScope(granted string, scope string) bool {
+	for _, s := range strings.Fields(granted) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateSecret returns a random url-safe secret
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Hash is how secrets, codes and tokens are stored, we never keep the raw value
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// SecretMatches compares a presented client secret against the stored hash
+func SecretMatches(storedHash, presented string) bool {
+	if storedHash == "" || presented == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(storedHash), []byte(Hash(presented))) == 1
+}
+
+// VerifyPKCE checks a code verifier against the challenge stored with the code. only S256 is accepted,
+// plain would hand the verifier to anyone who sees the authorize url
+func VerifyPKCE(challenge, method, verifier string) bool {
+	if challenge == "" {
+		return true
+	}
+	if verifier == "" || method != "S256" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// RedirectURIAllowed checks for an exact match against the application's registered uris
+func RedirectURIAllowed(app *database.Application, uri string) bool {
+	for _, allowed := range strings.Split(app.RedirectURIs, "\n") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveUser limits a query on table to rows whose user_id is neither disabled nor deleted, a grant
+// shouldnt outlive the account it was given for
+func ActiveUser(table string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("EXISTS (SELECT 1 FROM users u WHERE u.id = " + table + ".user_id AND u.disabled_at IS NULL AND u.deleted_at IS NULL)")
+	}
+}
+
+// Authenticate looks up the bearer access token of a request, tokens of disabled users are refused
+func Authenticate(r *http.Request) (*database.OAuth2Token, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, ErrInvalidToken
+	}
+
+	accessToken := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	if accessToken == "" {
+		return nil, ErrInvalidToken
+	}
+
+	var token database.OAuth2Token
+	err := database.DB.
+		Where("access_token_hash = ? AND expires_at > ?", Hash(accessToken), time.Now()).
+		Scopes(ActiveUser("oauth2_tokens")).
+		First(&token).Error
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,41 @@
+package pagination
+
+// query params shared by every paginated list, the response side is httpresponder.List
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 100
+)
+
+// LimitError is sent back when ?limit= is out of range
+const LimitError = "limit must be between 1 and 100"
+
+// Limit reads ?limit=, DefaultLimit when missing. ok is false when it isnt a number between 1 and MaxLimit
+func Limit(r *http.Request) (int, bool) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return DefaultLimit, true
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 || limit > MaxLimit {
+		return 0, false
+	}
+	return limit, true
+}
+
+// Cursor reads ?cursor=, the next_cursor of the previous page. lists that were paginated before cursors
+// existed still take their own param, e.g before or after
+func Cursor(r *http.Request, legacy string) string {
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		return cursor
+	}
+	if legacy != "" {
+		return r.URL.Query().Get(legacy)
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package permissions
+
+// server permission bits, stored on roles. a member has the union of the server's default
+// roles and their own roles, the server owner and administrators have everything
+
+import (
+	"math"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	Administrator uint64 = 1 << iota
+	ManageServer
+	ManageChannels
+	ManageRoles
+	ManageMessages
+	ManageWebhooks
+	KickMembers
+	BanMembers
+	CreateInvites
+	SendMessages
+	MentionEveryone
+	ManageEvents
+)
+
+// All is every permission bit, given to owners and administrators
+const All = ^uint64(0)
+
+// ForMember returns the effective permissions of a user in a server, 0 when they arent a member
+func ForMember(serverID, userID uuid.UUID) (uint64, error) {
+	var server database.Server
+	if err := database.DB.Select("id", "owner_id").Where("id = ?", serverID).First(&server).Error; err != nil {
+		return 0, err
+	}
+	if server.OwnerID == userID {
+		return All, nil
+	}
+
+	var member database.ServerMember
+	if err := database.DB.Preload("Roles").Where("server_id = ? AND user_id = ?", serverID, userID).First(&member).Error; err != nil {
+		return 0, err
+	}
+
+	var defaults []database.Role
+	if err := database.DB.Where("server_id = ? AND is_default = ?", serverID, true).Find(&defaults).Error; err != nil {
+		return 0, err
+	}
+
+	var perms uint64
+	for _, role := range append(defaults, member.Roles...) {
+		perms |= role.Permissions
+	}
+
+	if perms&Administrator != 0 {
+		return All, nil
+	}
+	return perms, nil
+}
+
+// Has reports whether perms include every bit of permission
+func Has(perms, permission uint64) bool {
+	return perms&permission == permission
+}
+
+// MemberHas is ForMember + Has, errors count as not having it
+func MemberHas(serverID, userID uuid.UUID, permission uint64) bool {
+	perms, err := ForMember(serverID, userID)
+	return err == nil && Has(perms, permission)
+}
+
+// TopRolePosition is the highest position among the members own roles, roles at or above it are out of
+// their reach when managing roles. the owner is above every role
+func TopRolePosition(serverID, userID uuid.UUID) (int, error) {
+	var server database.Server
+	if err := database.DB.Select("id", "owner_id").Where("id = ?", serverID).First(&server).Error; err != nil {
+		return 0, err
+	}
+	if server.OwnerID == userID {
+		return math.MaxInt, nil
+	}
+
+	var member database.ServerMember
+	if err := database.DB.Preload("Roles").Where("server_id = ? AND user_id = ?", serverID, userID).First(&member).Error; err != nil {
+		return 0, err
+	}
+
+	top := 0
+	for _, role := range member.Roles {
+		top = max(top, role.Position)
+	}
+	return top, nil
+}
@@ -0,0 +1,38 @@
+package precondition
+
+// optimistic concurrency for updates. responses carry the rows version as ETag, clients send it back as
+// If-Match and the update only goes through when nobody changed the row in between.
+// requests without If-Match update unconditionally
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+)
+
+// IfMatch returns the version from the If-Match header, ok is false when there is none
+func IfMatch(r *http.Request) (version int64, ok bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+
+	header = strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err = strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
+
+// SetETag tags the response with the version
+func SetETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// Failed tells the client someone else changed the resource first
+func Failed(w http.ResponseWriter, r *http.Request, what string) {
+	httpresponder.SendErrorResponse(w, r, what+" was changed by someone else, reload it and try again", http.StatusPreconditionFailed)
+}
@@ -0,0 +1,155 @@
+package privacy
+
+// who may start contact with a user and who sees their profile. friend requests and new conversations
+// each have their own setting, friends always pass the dm setting unless it is nobody
+
+import (
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	"github.com/hindsightchat/backend/src/lib/usersettings"
+	uuid "github.com/satori/go.uuid"
+)
+
+// audiences a setting can allow
+const (
+	Everyone         = "everyone"
+	FriendsOfFriends = "friends_of_friends"
+	ServerMembers    = "server_members" // people sharing a server with the user
+	Nobody           = "nobody"
+
+	Friends = "friends" // profile visibility only
+)
+
+// reasons sent to clients so they can explain why contact failed
+const (
+	ReasonFriendRequestsNobody           = "friend_requests_disabled"
+	ReasonFriendRequestsFriendsOfFriends = "friend_requests_friends_of_friends"
+	ReasonFriendRequestsServerMembers    = "friend_requests_server_members"
+	ReasonDMsNobody                      = "dms_disabled"
+)
+
+// DeniedError is contact the target doesnt allow, sent to clients as the details of the error
+type DeniedError struct {
+	Reason string `json:"reason"`
+	UserID string `json:"user_id"`
+}
+
+func (e *DeniedError) Error() string {
+	return "user does not accept this from you"
+}
+
+// Valid reports whether audience is a known setting
+func Valid(audience string) bool {
+	switch audience {
+	case Everyone, FriendsOfFriends, ServerMembers, Nobody:
+		return true
+	}
+	return false
+}
+
+// ValidProfileVisibility reports whether visibility is a known profile visibility
+func ValidProfileVisibility(visibility string) bool {
+	return visibility == Everyone || visibility == Friends || visibility == Nobody
+}
+
+// OrDefault returns the audience, everyone when the user never set it
+func OrDefault(audience string) string {
+	if audience == "" {
+		return Everyone
+	}
+	return audience
+}
+
+// CheckFriendRequest returns a DeniedError when the target doesnt take friend requests from the sender
+func CheckFriendRequest(senderID, targetID uuid.UUID) (*DeniedError, error) {
+	settings, err := usersettings.Get(targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch OrDefault(settings.FriendRequestsFrom) {
+	case Nobody:
+		return denied(ReasonFriendRequestsNobody, targetID), nil
+	case FriendsOfFriends:
+		ok, err := shareFriend(senderID, targetID)
+		if err != nil || ok {
+			return nil, err
+		}
+		return denied(ReasonFriendRequestsFriendsOfFriends, targetID), nil
+	case ServerMembers:
+		ok, err := shareServer(senderID, targetID)
+		if err != nil || ok {
+			return nil, err
+		}
+		return denied(ReasonFriendRequestsServerMembers, targetID), nil
+	}
+	return nil, nil
+}
+
+// CheckNewConversation returns a DeniedError for the first of the targets that doesnt want to be put
+// into a new conversation by the sender. callers only create conversations between friends so far,
+// which every setting except nobody lets through
+func CheckNewConversation(senderID uuid.UUID, targetIDs []uuid.UUID) (*DeniedError, error) {
+	settings, err := usersettings.ForUsers(targetIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, targetID := range targetIDs {
+		if s, ok := settings[targetID]; ok && s.DMsFrom == Nobody {
+			return denied(ReasonDMsNobody, targetID), nil
+		}
+	}
+	return nil, nil
+}
+
+// ProfileVisible reports whether the viewer may see the restricted fields of the targets profile,
+// people always see their own
+func ProfileVisible(viewerID, targetID uuid.UUID, settings *database.UserSettings) (bool, error) {
+	if viewerID == targetID {
+		return true, nil
+	}
+
+	switch OrDefault(settings.ProfileVisibility) {
+	case Nobody:
+		return false, nil
+	case Friends:
+		var count int64
+		err := database.DB.Model(&database.Friendship{}).
+			Where("(user1_id = ? AND user2_id = ?) OR (user1_id = ? AND user2_id = ?)", viewerID, targetID, targetID, viewerID).
+			Count(&count).Error
+		return count > 0, err
+	}
+	return true, nil
+}
+
+func denied(reason string, targetID uuid.UUID) *DeniedError {
+	return &DeniedError{Reason: reason, UserID: targetID.String()}
+}
+
+func shareFriend(a, b uuid.UUID) (bool, error) {
+	friendsA, err := restriction.FriendIDs(a)
+	if err != nil {
+		return false, err
+	}
+	friendsB, err := restriction.FriendIDs(b)
+	if err != nil {
+		return false, err
+	}
+
+	for id := range friendsA {
+		if friendsB[id] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func shareServer(a, b uuid.UUID) (bool, error) {
+	var count int64
+	err := database.DB.Model(&database.ServerMember{}).
+		Where("user_id = ?", a).
+		Where("server_id IN (?)", database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", b)).
+		Count(&count).Error
+	return count > 0, err
+}
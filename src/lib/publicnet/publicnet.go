@@ -0,0 +1,37 @@
+package publicnet
+
+// dialing urls that users control (embeds, claimed domains, application webhooks). only public addresses
+// are dialed, checked after resolving so dns can't point us at internal services
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateRange = errors.New("address in a private range")
+
+// Dialer refuses connections to loopback, private, link local and multicast addresses, use its DialContext
+// in the transport of the client
+func Dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+				return ErrPrivateRange
+			}
+			return nil
+		},
+	}
+}
+
+// IsPublic reports whether ip is reachable from the internet, the cloud metadata address is link local
+func IsPublic(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
@@ -0,0 +1,204 @@
+package purge
+
+// hard deletes rows that were soft deleted longer than PURGE_RETENTION_DAYS (default 30) ago and cleans up
+// rows left behind by deletes: participants of deleted conversations, role assignments of removed members,
+// messages of purged conversations and conversations nobody is in anymore
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"gorm.io/gorm"
+)
+
+const (
+	interval = time.Hour
+
+	// sync tokens are valid for 30 days and see deletes through deleted_at, a shorter retention would hide them
+	DefaultRetentionDays = 30
+	MinRetentionDays     = 30
+
+	// rows per delete statement, keeps locks short on big tables
+	batchSize = 1000
+
+	// conversations are created before their participants, give them a moment before calling them empty
+	emptyConversationGrace = time.Hour
+)
+
+// soft deleted rows of these tables are purged. users, servers, channels, roles and applications are kept
+// since other rows still point at them, compliance and moderation records are never purged
+var purged = []struct {
+	name  string
+	model any
+}{
+	{"channel_messages", &database.ChannelMessage{}},
+	{"direct_messages", &database.DirectMessage{}},
+	{"dm_participants", &database.DMParticipant{}},
+	{"dm_conversations", &database.DMConversation{}},
+	{"server_members", &database.ServerMember{}},
+	{"friend_requests", &database.FriendRequest{}},
+	{"friendships", &database.Friendship{}},
+	{"user_tokens", &database.UserToken{}},
+	{"saved_messages", &database.SavedMessage{}},
+	{"inbox_entries", &database.InboxEntry{}},
+	{"webhooks", &database.Webhook{}},
+	{"interactions", &database.Interaction{}},
+	{"oauth2_authorization_codes", &database.OAuth2AuthorizationCode{}},
+	{"oauth2_tokens", &database.OAuth2Token{}},
+	{"device_keys", &database.DeviceKey{}},
+	{"sender_key_distributions", &database.SenderKeyDistribution{}},
+	{"push_devices", &database.PushDevice{}},
+	{"invites", &database.Invite{}},
+	{"channel_categories", &database.ChannelCategory{}},
+}
+
+// Stats are the purge counters of this instance
+type Stats struct {
+	RetentionDays int              `json:"retention_days"`
+	LastRunAt     *time.Time       `json:"last_run_at,omitempty"`
+	LastRunMS     int64            `json:"last_run_ms"`
+	LastRun       map[string]int64 `json:"last_run"` // rows per table or orphan kind in the last run
+	Total         map[string]int64 `json:"total"`    // since the instance started
+	Errors        int64            `json:"errors"`
+}
+
+var (
+	stats   = Stats{LastRun: map[string]int64{}, Total: map[string]int64{}}
+	statsMu sync.Mutex
+)
+
+// GetStats returns a copy of the purge counters
+func GetStats() Stats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	result := stats
+	result.RetentionDays = retentionDays()
+	result.LastRun = make(map[string]int64, len(stats.LastRun))
+	for k, v := range stats.LastRun {
+		result.LastRun[k] = v
+	}
+	result.Total = make(map[string]int64, len(stats.Total))
+	for k, v := range stats.Total {
+		result.Total[k] = v
+	}
+	return result
+}
+
+func retentionDays() int {
+	days, err := strconv.Atoi(os.Getenv("PURGE_RETENTION_DAYS"))
+	if err != nil {
+		return DefaultRetentionDays
+	}
+	return max(days, MinRetentionDays)
+}
+
+// Run purges every interval, start it once per instance
+func Run() {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		runOnce()
+	}
+}
+
+func runOnce() {
+	// one instance per round
+	ctx := context.Background()
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return
+	}
+	ok, err := rdb.SetNX(ctx, valkeydb.PURGE_LOCK_KEY, "1", interval/2).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	started := time.Now()
+	cutoff := started.AddDate(0, 0, -retentionDays())
+	counts := map[string]int64{}
+	var failed int64
+
+	count := func(name string, n int64, err error) {
+		counts[name] += n
+		if err != nil {
+			failed++
+			log.Printf("[purge] %s: %v", name, err)
+		}
+	}
+
+	// orphans first, rows they soft delete are purged once they are past the retention
+	n, err := inBatches(func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("created_at < ? AND NOT EXISTS (SELECT 1 FROM dm_participants p WHERE p.conversation_id = dm_conversations.id AND p.deleted_at IS NULL)",
+			started.Add(-emptyConversationGrace)).
+			Delete(&database.DMConversation{})
+	})
+	count("orphan_empty_conversations", n, err)
+
+	n, err = inBatches(func(tx *gorm.DB) *gorm.DB {
+		return tx.Unscoped().
+			Where("NOT EXISTS (SELECT 1 FROM dm_conversations c WHERE c.id = dm_participants.conversation_id AND c.deleted_at IS NULL)").
+			Delete(&database.DMParticipant{})
+	})
+	count("orphan_participants", n, err)
+
+	// messages age out with their conversation, they go once it was purged
+	n, err = inBatches(func(tx *gorm.DB) *gorm.DB {
+		return tx.Unscoped().
+			Where("NOT EXISTS (SELECT 1 FROM dm_conversations c WHERE c.id = direct_messages.conversation_id)").
+			Delete(&database.DirectMessage{})
+	})
+	count("orphan_direct_messages", n, err)
+
+	n, err = inBatches(func(tx *gorm.DB) *gorm.DB {
+		return tx.Exec("DELETE FROM server_member_roles WHERE NOT EXISTS (SELECT 1 FROM server_members m WHERE m.id = server_member_roles.server_member_id AND m.deleted_at IS NULL) LIMIT ?", batchSize)
+	})
+	count("orphan_member_roles", n, err)
+
+	for _, table := range purged {
+		n, err := inBatches(func(tx *gorm.DB) *gorm.DB {
+			return tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Delete(table.model)
+		})
+		count(table.name, n, err)
+	}
+
+	statsMu.Lock()
+	stats.LastRunAt = &started
+	stats.LastRunMS = time.Since(started).Milliseconds()
+	stats.LastRun = counts
+	for name, n := range counts {
+		stats.Total[name] += n
+	}
+	stats.Errors += failed
+	statsMu.Unlock()
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	if total > 0 || failed > 0 {
+		log.Printf("[purge] removed %d rows in %v, %d errors: %v", total, time.Since(started).Round(time.Millisecond), failed, counts)
+	}
+}
+
+// inBatches repeats the delete until a batch comes back short, returns the rows removed
+func inBatches(del func(tx *gorm.DB) *gorm.DB) (int64, error) {
+	var total int64
+	for {
+		result := del(database.DB.Limit(batchSize))
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < batchSize {
+			return total, nil
+		}
+	}
+}
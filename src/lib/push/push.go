@@ -0,0 +1,89 @@
+package push
+
+// push notifications for users without a connected client. delivery to apns / fcm / web push is
+// left to a push gateway configured with PUSH_GATEWAY_URL, nothing is sent when it isnt set
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	PlatformAPNS = "apns"
+	PlatformFCM  = "fcm"
+	PlatformWeb  = "web"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+type Notification struct {
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+type device struct {
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+}
+
+type gatewayRequest struct {
+	Notification Notification `json:"notification"`
+	Devices      []device     `json:"devices"`
+}
+
+// ValidPlatform reports whether devices of this platform can be registered
+func ValidPlatform(platform string) bool {
+	return platform == PlatformAPNS || platform == PlatformFCM || platform == PlatformWeb
+}
+
+// Enabled reports whether a push gateway is configured
+func Enabled() bool {
+	return os.Getenv("PUSH_GATEWAY_URL") != ""
+}
+
+// Send pushes the notification to every device of the given users, in the background
+func Send(userIDs []uuid.UUID, notification Notification) {
+	if !Enabled() || len(userIDs) == 0 {
+		return
+	}
+
+	go func() {
+		var devices []database.PushDevice
+		if err := database.DB.Where("user_id IN ?", userIDs).Find(&devices).Error; err != nil {
+			log.Printf("[push] failed to load devices: %v", err)
+			return
+		}
+		if len(devices) == 0 {
+			return
+		}
+
+		payload := gatewayRequest{Notification: notification, Devices: make([]device, 0, len(devices))}
+		for _, d := range devices {
+			payload.Devices = append(payload.Devices, device{Platform: d.Platform, Token: d.Token})
+		}
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return
+		}
+
+		resp, err := client.Post(os.Getenv("PUSH_GATEWAY_URL"), "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[push] gateway request failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			log.Printf("[push] gateway responded with %d", resp.StatusCode)
+		}
+	}()
+}
@@ -0,0 +1,28 @@
+package randcode
+
+// random codes people read, type or write down: invite codes, recovery codes
+
+import "crypto/rand"
+
+// Readable is lowercase without i, l, o, 0 or 1, so codes survive being read out loud, written on paper
+// and lowercased
+const Readable = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// String returns n letters of alphabet picked uniformly at random
+func String(alphabet string, n int) (string, error) {
+	// bytes past the last full multiple of the alphabet are skipped so every letter is equally likely
+	limit := 256 - 256%len(alphabet)
+
+	code := make([]byte, 0, n)
+	b := make([]byte, 1)
+	for len(code) < n {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		if int(b[0]) >= limit {
+			continue
+		}
+		code = append(code, alphabet[int(b[0])%len(alphabet)])
+	}
+	return string(code), nil
+}
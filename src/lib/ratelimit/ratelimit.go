@@ -0,0 +1,83 @@
+package ratelimit
+
+// fixed window rate limits for the rest api, counted in valkey so every instance shares them.
+// each bucket is counted per user, or per ip for requests without a session
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+)
+
+// Bucket is a named limit, the name is sent to clients so they can throttle before hitting it
+type Bucket struct {
+	Name   string        `json:"name"`
+	Limit  int64         `json:"limit"`
+	Window time.Duration `json:"-"`
+
+	WindowSeconds int64 `json:"window_seconds"`
+}
+
+func bucket(name string, limit int64, window time.Duration) Bucket {
+	return Bucket{Name: name, Limit: limit, Window: window, WindowSeconds: int64(window / time.Second)}
+}
+
+var (
+	Auth           = bucket("auth", 10, time.Minute) // login and register, always per ip
+	FriendRequests = bucket("friend_requests", 30, time.Hour)
+	InviteJoin     = bucket("invite_join", 10, 10*time.Minute)
+	Translate      = bucket("translate", 30, time.Minute)
+	Reports        = bucket("reports", 10, time.Hour)
+)
+
+// All lists every bucket, for the instance document
+var All = []Bucket{Auth, FriendRequests, InviteJoin, Translate, Reports}
+
+// scopes a bucket is counted in
+const (
+	ScopeUser = "user"
+	ScopeIP   = "ip"
+)
+
+// Result is where the subject stands in the bucket after a request
+type Result struct {
+	Bucket     string    `json:"bucket"`
+	Scope      string    `json:"scope"`
+	Limit      int64     `json:"limit"`
+	Remaining  int64     `json:"remaining"`
+	ResetAt    time.Time `json:"reset_at"`
+	RetryAfter float64   `json:"retry_after,omitempty"` // seconds, only when the request was refused
+	Allowed    bool      `json:"-"`
+}
+
+// Take counts a request of the subject against the bucket. when valkey is unreachable the request is let
+// through, a limiter outage shouldnt take the api down with it
+func Take(ctx context.Context, b Bucket, scope, subject string) (*Result, error) {
+	now := time.Now()
+	windowStart := now.Truncate(b.Window)
+	resetAt := windowStart.Add(b.Window)
+
+	key := fmt.Sprintf("%s%s:%s:%s:%d", valkeydb.RATE_LIMIT_PREFIX, b.Name, scope, subject, windowStart.Unix())
+
+	pipe := valkeydb.GetValkeyClient().TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, b.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return &Result{Bucket: b.Name, Scope: scope, Limit: b.Limit, Remaining: b.Limit, ResetAt: resetAt, Allowed: true}, err
+	}
+
+	result := &Result{
+		Bucket:    b.Name,
+		Scope:     scope,
+		Limit:     b.Limit,
+		Remaining: max(b.Limit-count.Val(), 0),
+		ResetAt:   resetAt,
+		Allowed:   count.Val() <= b.Limit,
+	}
+	if !result.Allowed {
+		result.RetryAfter = resetAt.Sub(now).Seconds()
+	}
+	return result, nil
+}
@@ -0,0 +1,109 @@
+package recovery
+
+// one time recovery codes. a fresh set replaces the old one, each code can be used once instead of the
+// password. codes are shown to the user when generated and never again
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/randcode"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	codeCount  = 10
+	codeLength = 10 // shown as two groups of five
+)
+
+// Generate replaces the users codes with a new set and returns them in plain text
+func Generate(userID uuid.UUID) ([]string, error) {
+	codes := make([]string, 0, codeCount)
+	rows := make([]database.RecoveryCode, 0, codeCount)
+	for len(codes) < codeCount {
+		code, err := newCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		rows = append(rows, database.RecoveryCode{UserID: userID, CodeHash: hash(code)})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// hard delete, old codes must not keep working and the unique index has to allow reuse
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&database.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Consume marks the code as used, false when it doesnt exist or was already used
+func Consume(userID uuid.UUID, code string) (bool, error) {
+	if normalize(code) == "" {
+		return false, nil
+	}
+
+	// the used_at condition makes sure two logins can't both spend the same code
+	result := database.DB.Model(&database.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Valid is Consume without using the code up
+func Valid(userID uuid.UUID, code string) (bool, error) {
+	if normalize(code) == "" {
+		return false, nil
+	}
+
+	var count int64
+	err := database.DB.Model(&database.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash(code)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Remaining counts the users unused codes
+func Remaining(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := database.DB.Model(&database.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	return count, err
+}
+
+func newCode() (string, error) {
+	code, err := randcode.String(randcode.Readable, codeLength)
+	if err != nil {
+		return "", err
+	}
+	return code[:codeLength/2] + "-" + code[codeLength/2:], nil
+}
+
+// normalize drops the dash and spaces and lowercases, so codes can be typed however they were written down
+func normalize(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(c rune) rune {
+		if c == '-' || c == ' ' {
+			return -1
+		}
+		return c
+	}, code)
+}
+
+func hash(code string) string {
+	sum := sha256.Sum256([]byte(normalize(code)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,53 @@
+package restriction
+
+// shadow restrictions: restricted accounts can keep using the app but anything
+// they send to people who arent their friends is stored without being delivered
+
+import (
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// IsRestricted reports whether the user is currently shadow restricted
+func IsRestricted(userID uuid.UUID) bool {
+	var count int64
+	database.DB.Model(&database.User{}).
+		Where("id = ? AND restricted_at IS NOT NULL", userID).
+		Count(&count)
+	return count > 0
+}
+
+// FriendIDs returns the set of users the given user is friends with
+func FriendIDs(userID uuid.UUID) (map[uuid.UUID]bool, error) {
+	var friendships []database.Friendship
+	err := database.DB.
+		Where("user1_id = ? OR user2_id = ?", userID, userID).
+		Find(&friendships).Error
+	if err != nil {
+		return nil, err
+	}
+
+	friends := make(map[uuid.UUID]bool, len(friendships))
+	for _, f := range friendships {
+		if f.User1ID == userID {
+			friends[f.User2ID] = true
+		} else {
+			friends[f.User1ID] = true
+		}
+	}
+	return friends, nil
+}
+
+// VisibleDirectMessages hides shadowed dms from everyone except the author and their friends
+func VisibleDirectMessages(viewerID uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(
+			"shadowed = ? OR author_id = ? OR author_id IN (?) OR author_id IN (?)",
+			false,
+			viewerID,
+			database.DB.Model(&database.Friendship{}).Select("user2_id").Where("user1_id = ?", viewerID),
+			database.DB.Model(&database.Friendship{}).Select("user1_id").Where("user2_id = ?", viewerID),
+		)
+	}
+}
@@ -0,0 +1,35 @@
+package securitylog
+
+// records changes to how a user can get into their account, so they can spot ones they didnt make
+
+import (
+	"log"
+	"net/http"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/ipban"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	ActionRecoveryCodesGenerated = "recovery_codes.generated"
+	ActionRecoveryCodeUsed       = "recovery_code.used"
+	ActionTokenReused            = "session.reused" // a revoked token was presented again, ip is where from
+)
+
+// Record stores the event, failures are only logged since the action itself already happened
+func Record(r *http.Request, userID uuid.UUID, action string) {
+	RecordFrom(ipban.ClientIP(r), userID, action)
+}
+
+// RecordFrom is Record for events that didnt come with a request
+func RecordFrom(ip string, userID uuid.UUID, action string) {
+	err := database.DB.Create(&database.SecurityEvent{
+		UserID: userID,
+		Action: action,
+		IP:     ip,
+	}).Error
+	if err != nil {
+		log.Printf("[securitylog] failed to record %s for %s: %v", action, userID, err)
+	}
+}
@@ -0,0 +1,307 @@
+package seed
+
+// development data: a base user, generated users with friendships and dms, servers with channels and
+// message history. run with `go run . seed`, refuses to run when IS_PROD is set
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// every seeded account uses this password
+const Password = "password123"
+
+// generated accounts are seed<n>.<Domain>
+const Domain = "seed.localhost"
+
+// rows per insert
+const batchSize = 500
+
+type Options struct {
+	Users             int // generated users besides the base user
+	FriendsPerUser    int // each user befriends the next n users, the base user befriends everyone
+	Servers           int
+	ChannelsPerServer int
+	Messages          int // per channel and per friendship dm
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Users:             50,
+		FriendsPerUser:    3,
+		Servers:           3,
+		ChannelsPerServer: 4,
+		Messages:          200,
+	}
+}
+
+var words = strings.Fields(`hey hello so the a that this is was it we they you lol yeah no maybe tomorrow today
+	later meeting build deploy server channel message think going just really what when why how nice cool ok sure`)
+
+// Run fills an empty development database
+func Run(opts Options) error {
+	if os.Getenv("IS_PROD") == "true" {
+		return errors.New("refusing to seed a production database")
+	}
+
+	var seeded int64
+	database.DB.Model(&database.User{}).Where("domain = ?", Domain).Count(&seeded)
+	if seeded > 0 {
+		return errors.New("database is already seeded, drop it to seed again")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	base, err := baseUser(string(hash))
+	if err != nil {
+		return fmt.Errorf("base user: %w", err)
+	}
+
+	users, err := createUsers(opts.Users, string(hash))
+	if err != nil {
+		return fmt.Errorf("users: %w", err)
+	}
+	everyone := append([]database.User{*base}, users...)
+
+	friendships, err := createFriendships(everyone, opts.FriendsPerUser, opts.Messages)
+	if err != nil {
+		return fmt.Errorf("friendships: %w", err)
+	}
+
+	channels, err := createServers(base, everyone, opts)
+	if err != nil {
+		return fmt.Errorf("servers: %w", err)
+	}
+
+	fmt.Printf("seeded %d users, %d friendships, %d servers, %d channels\n", len(everyone), friendships, opts.Servers, channels)
+	fmt.Printf("log in as %s or seed<n>@%s with password %s\n", base.Email, Domain, Password)
+	return nil
+}
+
+// baseUser is the account contributors log in with, reused when it already exists
+func baseUser(hash string) (*database.User, error) {
+	user := database.User{
+		Username:         database.FullUsername("", "rmfosho.me"),
+		Password:         hash,
+		Email:            "me@rmfosho.me",
+		Domain:           "rmfosho.me",
+		IsDomainVerified: true,
+	}
+
+	err := database.DB.Where("email = ?", user.Email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = database.DB.Create(&user).Error
+	}
+	return &user, err
+}
+
+func createUsers(n int, hash string) ([]database.User, error) {
+	users := make([]database.User, n)
+	for i := range users {
+		users[i] = database.User{
+			Handle:           fmt.Sprintf("seed%d", i+1),
+			Username:         database.FullUsername(fmt.Sprintf("seed%d", i+1), Domain),
+			Password:         hash,
+			Email:            fmt.Sprintf("seed%d@%s", i+1, Domain),
+			Domain:           Domain,
+			IsDomainVerified: true,
+			Bio:              fmt.Sprintf("generated user %d", i+1),
+		}
+	}
+	if n == 0 {
+		return users, nil
+	}
+	return users, database.DB.CreateInBatches(&users, batchSize).Error
+}
+
+// createFriendships befriends the base user with everyone and each user with the next perUser users,
+// every friendship gets its dm with message history
+func createFriendships(users []database.User, perUser, messages int) (int, error) {
+	seen := make(map[[2]uuid.UUID]bool)
+	count := 0
+
+	befriend := func(a, b database.User) error {
+		key := [2]uuid.UUID{a.ID, b.ID}
+		if a.ID.String() > b.ID.String() {
+			key = [2]uuid.UUID{b.ID, a.ID}
+		}
+		if a.ID == b.ID || seen[key] {
+			return nil
+		}
+		seen[key] = true
+
+		return database.DB.Transaction(func(tx *gorm.DB) error {
+			conversation := database.DMConversation{IsGroup: false, ParticipantCount: 2}
+			if err := tx.Create(&conversation).Error; err != nil {
+				return err
+			}
+
+			participants := []database.DMParticipant{
+				{ConversationID: conversation.ID, UserID: a.ID},
+				{ConversationID: conversation.ID, UserID: b.ID},
+			}
+			if err := tx.Create(&participants).Error; err != nil {
+				return err
+			}
+
+			friendship := database.Friendship{User1ID: a.ID, User2ID: b.ID, ConversationID: conversation.ID}
+			if err := tx.Create(&friendship).Error; err != nil {
+				return err
+			}
+
+			count++
+			return createDirectMessages(tx, conversation.ID, []uuid.UUID{a.ID, b.ID}, messages)
+		})
+	}
+
+	for i := 1; i < len(users); i++ {
+		if err := befriend(users[0], users[i]); err != nil {
+			return count, err
+		}
+	}
+	for i := 1; i < len(users); i++ {
+		for j := 1; j <= perUser; j++ {
+			if err := befriend(users[i], users[1+(i-1+j)%(len(users)-1)]); err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, nil
+}
+
+// createServers makes servers owned by the base user that everyone joins, with a default role and
+// channels full of messages. returns the number of channels
+func createServers(owner *database.User, members []database.User, opts Options) (int, error) {
+	channels := 0
+
+	for s := 0; s < opts.Servers; s++ {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			server := database.Server{
+				Name:        fmt.Sprintf("Seed Server %d", s+1),
+				Description: "generated for local development",
+				OwnerID:     owner.ID,
+				MemberCount: int64(len(members)),
+			}
+			if err := tx.Create(&server).Error; err != nil {
+				return err
+			}
+
+			role := database.Role{
+				ServerID:    server.ID,
+				Name:        "everyone",
+				Permissions: permissions.SendMessages | permissions.CreateInvites,
+				IsDefault:   true,
+			}
+			if err := tx.Create(&role).Error; err != nil {
+				return err
+			}
+
+			now := time.Now()
+			rows := make([]database.ServerMember, len(members))
+			memberIDs := make([]uuid.UUID, len(members))
+			for i, m := range members {
+				rows[i] = database.ServerMember{ServerID: server.ID, UserID: m.ID, JoinedAt: now}
+				memberIDs[i] = m.ID
+			}
+			if err := tx.CreateInBatches(&rows, batchSize).Error; err != nil {
+				return err
+			}
+
+			for c := 0; c < opts.ChannelsPerServer; c++ {
+				name := "general"
+				if c > 0 {
+					name = fmt.Sprintf("channel-%d", c+1)
+				}
+				channel := database.Channel{ServerID: server.ID, Name: name, Position: c}
+				if err := tx.Create(&channel).Error; err != nil {
+					return err
+				}
+				if err := createChannelMessages(tx, channel.ID, memberIDs, opts.Messages); err != nil {
+					return err
+				}
+				channels++
+			}
+			return nil
+		})
+		if err != nil {
+			return channels, err
+		}
+	}
+	return channels, nil
+}
+
+// history is spread over the last week, oldest first so seq follows created_at
+func messageTimes(n int) []time.Time {
+	times := make([]time.Time, n)
+	start := time.Now().Add(-7 * 24 * time.Hour)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * 7 * 24 * time.Hour / time.Duration(max(n, 1)))
+	}
+	return times
+}
+
+func sentence() string {
+	n := 3 + rand.IntN(12)
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = words[rand.IntN(len(words))]
+	}
+	return strings.Join(parts, " ")
+}
+
+func createChannelMessages(tx *gorm.DB, channelID uuid.UUID, authors []uuid.UUID, n int) error {
+	if n == 0 || len(authors) == 0 {
+		return nil
+	}
+
+	messages := make([]database.ChannelMessage, n)
+	for i, at := range messageTimes(n) {
+		messages[i] = database.ChannelMessage{
+			ChannelID: channelID,
+			AuthorID:  authors[rand.IntN(len(authors))],
+			Content:   sentence(),
+			Seq:       int64(i + 1),
+		}
+		messages[i].CreatedAt = at
+		messages[i].UpdatedAt = at
+	}
+	if err := tx.CreateInBatches(&messages, batchSize).Error; err != nil {
+		return err
+	}
+	return tx.Create(&database.MessageCounter{TargetID: channelID, Total: int64(n), Sequence: int64(n)}).Error
+}
+
+func createDirectMessages(tx *gorm.DB, conversationID uuid.UUID, authors []uuid.UUID, n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	messages := make([]database.DirectMessage, n)
+	for i, at := range messageTimes(n) {
+		messages[i] = database.DirectMessage{
+			ConversationID: conversationID,
+			AuthorID:       authors[rand.IntN(len(authors))],
+			Content:        sentence(),
+			Seq:            int64(i + 1),
+		}
+		messages[i].CreatedAt = at
+		messages[i].UpdatedAt = at
+	}
+	if err := tx.CreateInBatches(&messages, batchSize).Error; err != nil {
+		return err
+	}
+	return tx.Create(&database.MessageCounter{TargetID: conversationID, Total: int64(n), Sequence: int64(n)}).Error
+}
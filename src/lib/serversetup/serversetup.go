@@ -0,0 +1,55 @@
+package serversetup
+
+// what every new server starts with: an everyone role, a general text channel and its first members.
+// shared by creating a server and by upgrading a group dm into one
+
+import (
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/membercount"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// DefaultPermissions are what the everyone role of a new server allows
+const DefaultPermissions = permissions.SendMessages | permissions.CreateInvites
+
+// Create stores the server with its role, channel and members, call it in a transaction.
+// memberIDs should include the owner
+func Create(tx *gorm.DB, server *database.Server, memberIDs []uuid.UUID) (*database.Channel, error) {
+	if err := tx.Create(server).Error; err != nil {
+		return nil, err
+	}
+
+	role := database.Role{
+		ServerID:    server.ID,
+		Name:        "everyone",
+		Permissions: DefaultPermissions,
+		IsDefault:   true,
+	}
+	if err := tx.Create(&role).Error; err != nil {
+		return nil, err
+	}
+
+	channel := database.Channel{ServerID: server.ID, Name: "general", Type: database.ChannelTypeText}
+	if err := tx.Create(&channel).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	members := make([]database.ServerMember, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		members = append(members, database.ServerMember{ServerID: server.ID, UserID: id, JoinedAt: now})
+	}
+	if err := tx.Create(&members).Error; err != nil {
+		return nil, err
+	}
+	if err := membercount.Members(tx, server.ID, int64(len(members))); err != nil {
+		return nil, err
+	}
+
+	server.MemberCount = int64(len(members))
+	return &channel, nil
+}
@@ -0,0 +1,139 @@
+package sessions
+
+// idle timeout for logins. every request notes when its token was used in valkey, at most once a minute
+// per token, and those times are flushed to user_tokens.last_used_at in batches. a token unused for
+// longer than the idle timeout stops working before its absolute expiry
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// a token's use is noted at most this often
+	touchEvery = time.Minute
+
+	flushInterval = 5 * time.Minute
+
+	// forget the in memory times once this many tokens were seen, they only save valkey writes
+	maxTracked = 50000
+)
+
+var (
+	touched   = make(map[uuid.UUID]time.Time)
+	touchedMu sync.Mutex
+)
+
+// Touch notes that the token was just used
+func Touch(token *database.UserToken) {
+	now := time.Now()
+
+	touchedMu.Lock()
+	if now.Sub(touched[token.ID]) < touchEvery {
+		touchedMu.Unlock()
+		return
+	}
+	if len(touched) >= maxTracked {
+		touched = make(map[uuid.UUID]time.Time)
+	}
+	touched[token.ID] = now
+	touchedMu.Unlock()
+
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return
+	}
+
+	go rdb.HSet(context.Background(), valkeydb.SESSION_LAST_USED_KEY, token.ID.String(), now.Unix())
+}
+
+// Idle reports whether the token went unused for longer than the idle timeout. bot tokens never idle out
+func Idle(token *database.UserToken) bool {
+	timeout := time.Duration(instance.GetSessionPolicy().IdleTimeout) * time.Second
+	if timeout <= 0 {
+		return false
+	}
+
+	cutoff := time.Now().Add(-timeout)
+
+	lastUsed := token.CreatedAt
+	if token.LastUsedAt != nil && token.LastUsedAt.After(lastUsed) {
+		lastUsed = *token.LastUsedAt
+	}
+	if lastUsed.After(cutoff) {
+		return false
+	}
+
+	// the last use may not be flushed yet
+	if rdb := valkeydb.GetValkeyClient(); rdb != nil {
+		pending, err := rdb.HGet(context.Background(), valkeydb.SESSION_LAST_USED_KEY, token.ID.String()).Int64()
+		if err == nil && time.Unix(pending, 0).After(cutoff) {
+			return false
+		}
+	}
+
+	var isBot bool
+	database.DB.Model(&database.User{}).Where("id = ?", token.UserID).Select("is_bot").Scan(&isBot)
+	return !isBot
+}
+
+// Run flushes token usage to the database every flushInterval, start it once per instance
+func Run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		flush()
+	}
+}
+
+func flush() {
+	ctx := context.Background()
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return
+	}
+
+	// one instance per round
+	ok, err := rdb.SetNX(ctx, valkeydb.SESSION_FLUSH_LOCK_KEY, "1", flushInterval/2).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	// moved aside so uses noted while flushing land in a fresh hash
+	flushing := valkeydb.SESSION_LAST_USED_KEY + ":flushing"
+	if err := rdb.Rename(ctx, valkeydb.SESSION_LAST_USED_KEY, flushing).Err(); err != nil {
+		return // nothing was used
+	}
+
+	used, err := rdb.HGetAll(ctx, flushing).Result()
+	if err != nil {
+		log.Printf("[sessions] failed to read token usage: %v", err)
+		return
+	}
+
+	for id, unix := range used {
+		seconds, err := strconv.ParseInt(unix, 10, 64)
+		if err != nil {
+			continue
+		}
+		at := time.Unix(seconds, 0)
+
+		err = database.DB.Model(&database.UserToken{}).
+			Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, at).
+			Update("last_used_at", at).Error
+		if err != nil {
+			log.Printf("[sessions] failed to store last use of token %s: %v", id, err)
+		}
+	}
+
+	rdb.Del(ctx, flushing)
+}
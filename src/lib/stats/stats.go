@@ -0,0 +1,80 @@
+package stats
+
+// tracks daily active users in valkey hyperloglogs, fed by token usage and gateway presence
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+)
+
+// how long a day's key is kept, enough for monthly numbers
+const activeUsersTTL = 35 * 24 * time.Hour
+
+// ErrUnavailable is returned while valkey isnt connected yet
+var ErrUnavailable = errors.New("stats: valkey is not ready")
+
+var (
+	// users already recorded today, avoids a valkey round trip per request
+	recorded    = make(map[string]bool)
+	recordedDay string
+	recordedMu  sync.Mutex
+)
+
+func dayKey(t time.Time) string {
+	return valkeydb.ACTIVE_USERS_PREFIX + t.UTC().Format("2006-01-02")
+}
+
+// RecordActive marks the user as active today
+func RecordActive(userID string) {
+	if userID == "" {
+		return
+	}
+
+	now := time.Now()
+	day := now.UTC().Format("2006-01-02")
+
+	recordedMu.Lock()
+	if recordedDay != day {
+		// new day, start over
+		recorded = make(map[string]bool)
+		recordedDay = day
+	}
+	if recorded[userID] {
+		recordedMu.Unlock()
+		return
+	}
+	recorded[userID] = true
+	recordedMu.Unlock()
+
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return
+	}
+
+	go func() {
+		ctx := context.Background()
+		key := dayKey(now)
+		rdb.PFAdd(ctx, key, userID)
+		rdb.Expire(ctx, key, activeUsersTTL)
+	}()
+}
+
+// ActiveUsers returns the number of distinct users active over the last n days (including today)
+func ActiveUsers(days int) (int64, error) {
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return 0, ErrUnavailable
+	}
+
+	now := time.Now()
+	keys := make([]string, 0, days)
+	for i := 0; i < days; i++ {
+		keys = append(keys, dayKey(now.AddDate(0, 0, -i)))
+	}
+
+	return rdb.PFCount(context.Background(), keys...).Result()
+}
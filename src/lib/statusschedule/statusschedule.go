@@ -0,0 +1,125 @@
+package statusschedule
+
+// recurring status overrides, e.g dnd from 22:00 to 07:00 in the users time zone
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	_ "time/tzdata" // containers often ship without zoneinfo
+)
+
+// users can have this many schedule entries
+const MaxEntries = 10
+
+type Entry struct {
+	Status string `json:"status"` // idle, dnd or offline
+	Start  string `json:"start"`  // HH:MM local time
+	End    string `json:"end"`    // HH:MM, before start means the window wraps past midnight
+	// weekdays the window starts on, 0 is sunday. empty means every day
+	Days []int `json:"days,omitempty"`
+}
+
+var schedulableStatuses = map[string]bool{"idle": true, "dnd": true, "offline": true}
+
+// Parse decodes a stored schedule, nil and invalid json are an empty schedule
+func Parse(raw *string) []Entry {
+	if raw == nil || *raw == "" {
+		return nil
+	}
+	var entries []Entry
+	if err := json.Unmarshal([]byte(*raw), &entries); err != nil {
+		return nil
+	}
+	return entries
+}
+
+// Validate checks entries submitted by a user
+func Validate(entries []Entry) error {
+	if len(entries) > MaxEntries {
+		return fmt.Errorf("at most %d schedule entries are allowed", MaxEntries)
+	}
+
+	for _, e := range entries {
+		if !schedulableStatuses[e.Status] {
+			return errors.New("status must be idle, dnd or offline")
+		}
+
+		start, err := minuteOfDay(e.Start)
+		if err != nil {
+			return err
+		}
+		end, err := minuteOfDay(e.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return errors.New("start and end must differ")
+		}
+
+		for _, d := range e.Days {
+			if d < 0 || d > 6 {
+				return errors.New("days must be between 0 (sunday) and 6 (saturday)")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Active returns the status of the first entry covering now in loc
+func Active(entries []Entry, loc *time.Location, now time.Time) (string, bool) {
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	today := int(local.Weekday())
+	yesterday := (today + 6) % 7
+
+	for _, e := range entries {
+		start, err := minuteOfDay(e.Start)
+		if err != nil {
+			continue
+		}
+		end, err := minuteOfDay(e.End)
+		if err != nil {
+			continue
+		}
+
+		if start < end {
+			if minute >= start && minute < end && onDay(e.Days, today) {
+				return e.Status, true
+			}
+			continue
+		}
+
+		// wraps midnight, the part after midnight belongs to the day it started on
+		if minute >= start && onDay(e.Days, today) {
+			return e.Status, true
+		}
+		if minute < end && onDay(e.Days, yesterday) {
+			return e.Status, true
+		}
+	}
+
+	return "", false
+}
+
+func onDay(days []int, day int) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+// uploaded files, kept on local disk under STORAGE_DIR (./uploads when unset) and served at /media/.
+// STORAGE_PUBLIC_URL optionally makes the returned urls absolute, e.g https://cdn.example.com
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// path the media routes are mounted at
+const PathPrefix = "/media/"
+
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+// image types accepted for uploads, by sniffed content type
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Dir is the directory uploads are stored in
+func Dir() string {
+	if dir := os.Getenv("STORAGE_DIR"); dir != "" {
+		return dir
+	}
+	return "uploads"
+}
+
+func publicPrefix() string {
+	return strings.TrimSuffix(os.Getenv("STORAGE_PUBLIC_URL"), "/") + PathPrefix
+}
+
+// ImageExtension sniffs the data and returns the file extension for supported images
+func ImageExtension(data []byte) (string, error) {
+	ext, ok := imageExtensions[http.DetectContentType(data)]
+	if !ok {
+		return "", ErrUnsupportedType
+	}
+	return ext, nil
+}
+
+// Save stores the data under folder with a random name and returns its public url
+func Save(folder string, data []byte, ext string) (string, error) {
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(Dir(), folder)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	file := hex.EncodeToString(name) + ext
+	if err := os.WriteFile(filepath.Join(dir, file), data, 0o644); err != nil {
+		return "", err
+	}
+
+	return publicPrefix() + folder + "/" + file, nil
+}
+
+// Delete removes a file previously returned by Save, other urls are ignored
+func Delete(url string) {
+	rel, ok := strings.CutPrefix(url, publicPrefix())
+	if !ok || rel == "" {
+		return
+	}
+
+	// filepath.Clean on a rooted path drops any ../
+	path := filepath.Join(Dir(), filepath.Clean("/"+rel))
+	os.Remove(path)
+}
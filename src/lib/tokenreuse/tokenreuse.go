@@ -0,0 +1,131 @@
+package tokenreuse
+
+// early warning for stolen cookies. a token that was revoked shouldnt come back, a client that logged out
+// forgets it. when one is presented again from a few places or times within the window the account is flagged,
+// every ip it came from is logged as a security event and the user gets an email unless TOKEN_REUSE_NOTIFY=false.
+// tokens that expired or went idle are left alone, clients coming back after a while still hold those
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	usercache "github.com/hindsightchat/backend/src/lib/cache/user"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/mail"
+	"github.com/hindsightchat/backend/src/lib/securitylog"
+	"github.com/hindsightchat/backend/src/lib/sessions"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// attempts within the window before the account is flagged, a client retrying once or twice is normal
+	threshold = 3
+	window    = 24 * time.Hour
+
+	// repeats from the same ip within this long are one attempt, a client fires its requests in parallel
+	dedupeWindow = 10 * time.Minute
+)
+
+// Report looks at a token that failed to authenticate, call it in the background
+func Report(token, ip string) {
+	if token == "" {
+		return
+	}
+
+	var found database.UserToken
+	err := database.DB.Unscoped().Where("token = ?", token).Limit(1).Find(&found).Error
+	if err != nil || found.UserID == uuid.Nil {
+		// never issued, nobody to warn
+		return
+	}
+
+	revoked := found.DeletedAt.Valid && found.ExpiresAt > time.Now().Unix() && !sessions.Idle(&found)
+	if !revoked {
+		return
+	}
+
+	ctx := context.Background()
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return
+	}
+
+	fresh, err := rdb.SetNX(ctx, valkeydb.TOKEN_REUSE_SEEN_PREFIX+found.ID.String()+":"+ip, 1, dedupeWindow).Result()
+	if err != nil || !fresh {
+		return
+	}
+
+	countKey := valkeydb.TOKEN_REUSE_PREFIX + found.ID.String()
+	ipsKey := valkeydb.TOKEN_REUSE_IPS_PREFIX + found.ID.String()
+
+	attempts, err := rdb.Incr(ctx, countKey).Result()
+	if err != nil {
+		return
+	}
+	newIP, _ := rdb.SAdd(ctx, ipsKey, ip).Result()
+	if attempts == 1 {
+		rdb.Expire(ctx, countKey, window)
+		rdb.Expire(ctx, ipsKey, window)
+	}
+
+	switch {
+	case attempts < threshold:
+		return
+	case attempts == threshold:
+		flag(&found)
+	case newIP == 0:
+		// already flagged and this ip was logged
+		return
+	default:
+		securitylog.RecordFrom(ip, found.UserID, securitylog.ActionTokenReused)
+	}
+}
+
+// flag marks the account and logs every ip the token came back from so far
+func flag(token *database.UserToken) {
+	ctx := context.Background()
+
+	ips, _ := valkeydb.GetValkeyClient().SMembers(ctx, valkeydb.TOKEN_REUSE_IPS_PREFIX+token.ID.String()).Result()
+	for _, ip := range ips {
+		securitylog.RecordFrom(ip, token.UserID, securitylog.ActionTokenReused)
+	}
+
+	now := time.Now()
+	err := database.DB.Model(&database.User{}).Where("id = ?", token.UserID).Update("token_reuse_at", now).Error
+	if err != nil {
+		log.Printf("[tokenreuse] failed to flag %s: %v", token.UserID, err)
+	}
+	usercache.UserCacheInstance.Delete(token.UserID.String())
+
+	log.Printf("[tokenreuse] revoked token %s of %s presented from %v", token.ID, token.UserID, ips)
+
+	if os.Getenv("TOKEN_REUSE_NOTIFY") != "false" {
+		notify(token, ips)
+	}
+}
+
+func notify(token *database.UserToken, ips []string) {
+	var user database.User
+	if err := database.DB.Select("id", "email", "is_bot").Where("id = ?", token.UserID).First(&user).Error; err != nil {
+		return
+	}
+	if user.IsBot || user.Email == "" {
+		return
+	}
+
+	body := fmt.Sprintf(
+		"A login to your Hindsight account from %s was signed out, but it is still being used from these addresses:\n\n%s\n\n"+
+			"This can mean someone copied your login cookie. The old login doesn't work anymore, but consider changing your password "+
+			"and checking your security events in the app.",
+		token.CreatedAt.Format("January 2, 2006"), strings.Join(ips, "\n"),
+	)
+
+	if err := mail.Send(user.Email, "A signed out login of your account is being used", body); err != nil {
+		log.Printf("[tokenreuse] failed to email %s: %v", user.ID, err)
+	}
+}
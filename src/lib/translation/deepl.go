@@ -0,0 +1,71 @@
+package translation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type deepL struct {
+	apiKey   string
+	endpoint string
+}
+
+func newDeepL(apiKey string) Backend {
+	if apiKey == "" {
+		return nil
+	}
+
+	// free plan keys end in :fx and use a different host
+	endpoint := "https://api.deepl.com/v2/translate"
+	if strings.HasSuffix(apiKey, ":fx") {
+		endpoint = "https://api-free.deepl.com/v2/translate"
+	}
+
+	return &deepL{apiKey: apiKey, endpoint: endpoint}
+}
+
+func (d *deepL) Translate(ctx context.Context, text, target string) (string, string, error) {
+	form := url.Values{}
+	form.Set("text", text)
+	form.Set("target_lang", strings.ToUpper(target))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+d.apiKey)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest {
+		return "", "", ErrUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("deepl responded with %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", "", err
+	}
+	if len(body.Translations) == 0 {
+		return "", "", errors.New("deepl returned no translation")
+	}
+
+	return body.Translations[0].Text, body.Translations[0].DetectedSourceLanguage, nil
+}
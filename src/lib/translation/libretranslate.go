@@ -0,0 +1,66 @@
+package translation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type libreTranslate struct {
+	baseURL string
+	apiKey  string
+}
+
+func newLibreTranslate(baseURL, apiKey string) Backend {
+	if baseURL == "" {
+		return nil
+	}
+	return &libreTranslate{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey}
+}
+
+func (l *libreTranslate) Translate(ctx context.Context, text, target string) (string, string, error) {
+	payload, err := json.Marshal(map[string]string{
+		"q":       text,
+		"source":  "auto",
+		"target":  strings.ToLower(strings.SplitN(target, "-", 2)[0]),
+		"format":  "text",
+		"api_key": l.apiKey,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.baseURL+"/translate", bytes.NewReader(payload))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest {
+		return "", "", ErrUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("libretranslate responded with %d", resp.StatusCode)
+	}
+
+	var body struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", "", err
+	}
+
+	return body.TranslatedText, body.DetectedLanguage.Language, nil
+}
@@ -0,0 +1,103 @@
+package translation
+
+// machine translation of message content. the backend is picked with TRANSLATION_BACKEND
+// (deepl or libretranslate), translation is unavailable when it isnt set
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+)
+
+var (
+	ErrUnavailable = errors.New("translation is not configured")
+	ErrUnsupported = errors.New("unsupported language")
+)
+
+// translations are cached this long per message, content and language
+const cacheTTL = 7 * 24 * time.Hour
+
+var languagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{2,4})?$`)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+type Result struct {
+	Text           string `json:"text"`
+	SourceLanguage string `json:"source_language,omitempty"` // as detected by the backend
+	TargetLanguage string `json:"target_language"`
+	Cached         bool   `json:"cached"`
+}
+
+// Backend translates text into the target language, detecting the source
+type Backend interface {
+	Translate(ctx context.Context, text, target string) (translated, source string, err error)
+}
+
+var (
+	backend     Backend
+	backendOnce sync.Once
+)
+
+func getBackend() Backend {
+	backendOnce.Do(func() {
+		switch os.Getenv("TRANSLATION_BACKEND") {
+		case "deepl":
+			backend = newDeepL(os.Getenv("DEEPL_API_KEY"))
+		case "libretranslate":
+			backend = newLibreTranslate(os.Getenv("LIBRETRANSLATE_URL"), os.Getenv("LIBRETRANSLATE_API_KEY"))
+		}
+	})
+	return backend
+}
+
+// Enabled reports whether a translation backend is configured
+func Enabled() bool {
+	return getBackend() != nil
+}
+
+// ValidLanguage reports whether target looks like a language code (en, de, pt-BR...)
+func ValidLanguage(target string) bool {
+	return languagePattern.MatchString(target)
+}
+
+// Translate translates message content, cached per message so edits get a fresh translation
+func Translate(ctx context.Context, messageID, content, target string) (*Result, error) {
+	b := getBackend()
+	if b == nil {
+		return nil, ErrUnavailable
+	}
+	if !ValidLanguage(target) {
+		return nil, ErrUnsupported
+	}
+
+	target = strings.ToLower(target)
+	sum := sha256.Sum256([]byte(content))
+	key := valkeydb.TRANSLATION_PREFIX + messageID + ":" + hex.EncodeToString(sum[:8]) + ":" + target
+
+	rdb := valkeydb.GetValkeyClient()
+	if cached, err := rdb.HGetAll(ctx, key).Result(); err == nil && cached["text"] != "" {
+		return &Result{Text: cached["text"], SourceLanguage: cached["source"], TargetLanguage: target, Cached: true}, nil
+	}
+
+	translated, source, err := b.Translate(ctx, content, target)
+	if err != nil {
+		return nil, err
+	}
+	source = strings.ToLower(source)
+
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, key, "text", translated, "source", source)
+	pipe.Expire(ctx, key, cacheTTL)
+	pipe.Exec(ctx)
+
+	return &Result{Text: translated, SourceLanguage: source, TargetLanguage: target}, nil
+}
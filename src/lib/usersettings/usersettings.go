@@ -0,0 +1,56 @@
+package usersettings
+
+import (
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Get returns the users settings, unsaved defaults when they never changed any
+func Get(userID uuid.UUID) (*database.UserSettings, error) {
+	settings := database.UserSettings{UserID: userID}
+	err := database.DB.Where("user_id = ?", userID).Limit(1).Find(&settings).Error
+	return &settings, err
+}
+
+// ForUsers loads the settings rows that exist for the given users
+func ForUsers(userIDs []uuid.UUID) (map[uuid.UUID]*database.UserSettings, error) {
+	result := make(map[uuid.UUID]*database.UserSettings, len(userIDs))
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	var rows []database.UserSettings
+	if err := database.DB.Where("user_id IN ?", userIDs).Find(&rows).Error; err != nil {
+		return result, err
+	}
+
+	for i := range rows {
+		result[rows[i].UserID] = &rows[i]
+	}
+	return result, nil
+}
+
+// Update writes the given columns, creating the settings row if needed
+func Update(userID uuid.UUID, columns map[string]any) (*database.UserSettings, error) {
+	columns["updated_at"] = time.Now()
+
+	settings := database.UserSettings{UserID: userID}
+	if err := database.DB.Where("user_id = ?", userID).Assign(columns).FirstOrCreate(&settings).Error; err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// Location returns the users time zone, utc when unset or unknown
+func Location(settings *database.UserSettings) *time.Location {
+	if settings == nil || settings.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
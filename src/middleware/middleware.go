@@ -2,10 +2,20 @@ package middleware
 
 import (
 	"context"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hindsightchat/backend/src/lib/authhelper"
+	"github.com/hindsightchat/backend/src/lib/consent"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/ipban"
+	"github.com/hindsightchat/backend/src/lib/maintenance"
+	"github.com/hindsightchat/backend/src/lib/oauth2"
+	"github.com/hindsightchat/backend/src/lib/ratelimit"
+	"github.com/hindsightchat/backend/src/lib/tokenreuse"
 )
 
 // CaseSensitiveMiddleware is a middleware that makes all URL paths lowercase to ensure case insensitivity.
@@ -31,9 +41,10 @@ func CaseSensitiveMiddleware(next http.Handler) http.Handler {
 		originalReqFrom := reqFrom
 
 		w.Header().Set("Access-Control-Allow-Origin", originalReqFrom) // as it is with http or https
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match, If-Modified-Since")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified, Retry-After, X-RateLimit-Bucket, X-RateLimit-Scope, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-RateLimit-Reset-After")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -65,6 +76,8 @@ func SaveAuthTokenMiddleware(next http.Handler) http.Handler {
 		if ctx.Value("authToken") == nil {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader != "" {
+				// users send "Bearer <token>", bots send "Bot <token>"
+				authHeader = strings.TrimPrefix(authHeader, "Bot ")
 				ctx = context.WithValue(ctx, "authToken", strings.Replace(authHeader, "Bearer ", "", 1))
 			}
 		}
@@ -88,6 +101,7 @@ func RouteRequiresAuthentication(next http.Handler) http.Handler {
 		userID, err := authhelper.GetUserIDFromToken(authToken)
 
 		if err != nil || userID == "" {
+			go tokenreuse.Report(authToken, ipban.ClientIP(r))
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -98,3 +112,162 @@ func RouteRequiresAuthentication(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RouteRequiresAdmin only lets instance admins through, must be used after RouteRequiresAuthentication.
+func RouteRequiresAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := authhelper.GetUserFromRequest(r)
+
+		if err != nil || user == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !user.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BlockDuringMaintenance rejects mutating requests with 503 while the instance is read-only.
+// admin routes and login stay writable so maintenance can be switched off again
+func BlockDuringMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/admin") || r.URL.Path == "/auth/login" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if state := maintenance.Current(); state.Enabled {
+			w.Header().Set("Retry-After", "60")
+			httpresponder.SendErrorResponse(w, r, state.Message, http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// paths reachable without having accepted the current terms
+var consentExemptPrefixes = []string{"/auth/", "/users/@me/consent", "/ws", "/gateway"}
+
+// RequiresConsent answers 428 to logged in users who havent accepted the current terms of service / privacy policy.
+// anonymous requests are passed through, the auth middleware deals with those
+func RequiresConsent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !consent.Enforced() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for _, prefix := range consentExemptPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		authToken, _ := r.Context().Value("authToken").(string)
+		if authToken == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := authhelper.GetUserFromRequest(r)
+		if err != nil || user == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !consent.UpToDate(user) {
+			httpresponder.SendErrorResponse(w, r, "You must accept the updated terms before continuing", http.StatusPreconditionRequired)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RouteRequiresOAuth2Scope authenticates third party oauth2 access tokens and checks they carry scope.
+// the token owner's id is stored as "userID" so the usual helpers work, the grant itself under "oauth2Token"
+func RouteRequiresOAuth2Scope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := oauth2.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !oauth2.HasScope(token.Scopes, scope) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), "userID", token.UserID.String())
+			ctx = context.WithValue(ctx, "oauth2Token", token)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RateLimit counts requests against the bucket, per user when there is a session and per ip otherwise.
+// every response carries where the caller stands, refused ones get a 429 with the same numbers as details
+func RateLimit(bucket ratelimit.Bucket) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope, subject := ratelimit.ScopeIP, ipban.ClientIP(r)
+			if bucket != ratelimit.Auth {
+				if userID := rateLimitUserID(r); userID != "" {
+					scope, subject = ratelimit.ScopeUser, userID
+				}
+			}
+
+			result, _ := ratelimit.Take(r.Context(), bucket, scope, subject)
+
+			h := w.Header()
+			h.Set("X-RateLimit-Bucket", result.Bucket)
+			h.Set("X-RateLimit-Scope", result.Scope)
+			h.Set("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+			h.Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+			h.Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+			h.Set("X-RateLimit-Reset-After", strconv.FormatFloat(time.Until(result.ResetAt).Seconds(), 'f', 3, 64))
+
+			if !result.Allowed {
+				h.Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter))))
+				httpresponder.SendErrorResponseWithDetails(w, r, "rate limited", http.StatusTooManyRequests, result)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitUserID is the user of the request, from RouteRequiresAuthentication when it ran first
+func rateLimitUserID(r *http.Request) string {
+	if userID, ok := r.Context().Value("userID").(string); ok && userID != "" {
+		return userID
+	}
+	token, _ := r.Context().Value("authToken").(string)
+	if token == "" {
+		return ""
+	}
+	userID, err := authhelper.GetUserIDFromToken(token)
+	if err != nil {
+		return ""
+	}
+	return userID
+}
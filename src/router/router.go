@@ -0,0 +1,64 @@
+package router
+
+// builds the http router with every route group, shared by main and the testserver
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	gomiddlewares "github.com/go-chi/chi/v5/middleware"
+	"github.com/hindsightchat/backend/src/middleware"
+	adminroutes "github.com/hindsightchat/backend/src/routes/admin"
+	applicationroutes "github.com/hindsightchat/backend/src/routes/applications"
+	authroutes "github.com/hindsightchat/backend/src/routes/auth"
+	bridgeroutes "github.com/hindsightchat/backend/src/routes/bridges"
+	conversationroutes "github.com/hindsightchat/backend/src/routes/conversations"
+	friendroutes "github.com/hindsightchat/backend/src/routes/friends"
+	instanceroutes "github.com/hindsightchat/backend/src/routes/instance"
+	interactionroutes "github.com/hindsightchat/backend/src/routes/interactions"
+	mediaroutes "github.com/hindsightchat/backend/src/routes/media"
+	messageroutes "github.com/hindsightchat/backend/src/routes/messages"
+	oauth2routes "github.com/hindsightchat/backend/src/routes/oauth2"
+	proxyroutes "github.com/hindsightchat/backend/src/routes/proxy"
+	reportroutes "github.com/hindsightchat/backend/src/routes/reports"
+	serverroutes "github.com/hindsightchat/backend/src/routes/servers"
+	usersroutes "github.com/hindsightchat/backend/src/routes/users"
+	webhookroutes "github.com/hindsightchat/backend/src/routes/webhooks"
+	websocketroutes "github.com/hindsightchat/backend/src/routes/websocket"
+)
+
+// New returns the router, the database and valkey have to be initialized first.
+// it also starts the gateway hub, call it once per process
+func New() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(middleware.CaseSensitiveMiddleware)
+	r.Use(middleware.SaveAuthTokenMiddleware)
+	r.Use(gomiddlewares.Logger)
+	r.Use(middleware.BlockDuringMaintenance)
+	r.Use(middleware.RequiresConsent)
+
+	authroutes.RegisterRoutes(r)
+	friendroutes.RegisterRoutes(r)
+	usersroutes.RegisterRoutes(r)
+	websocketroutes.RegisterRoutes(r)
+	conversationroutes.RegisterRoutes(r)
+	adminroutes.RegisterRoutes(r)
+	reportroutes.RegisterRoutes(r)
+	applicationroutes.RegisterRoutes(r)
+	interactionroutes.RegisterRoutes(r)
+	oauth2routes.RegisterRoutes(r)
+	webhookroutes.RegisterRoutes(r)
+	bridgeroutes.RegisterRoutes(r)
+	serverroutes.RegisterRoutes(r)
+	messageroutes.RegisterRoutes(r)
+	proxyroutes.RegisterRoutes(r)
+	mediaroutes.RegisterRoutes(r)
+	instanceroutes.RegisterRoutes(r)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
+	return r
+}
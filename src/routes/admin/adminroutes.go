@@ -0,0 +1,549 @@
+package adminroutes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/accesslog"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	usercache "github.com/hindsightchat/backend/src/lib/cache/user"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/middleware"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type adminUserResponse struct {
+	ID               string     `json:"id"`
+	Username         string     `json:"username"`
+	Domain           string     `json:"domain"`
+	Email            string     `json:"email"`
+	IsDomainVerified bool       `json:"is_domain_verified"`
+	IsAdmin          bool       `json:"is_admin"`
+	Status           string     `json:"status"`
+	DisabledAt       *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason   string     `json:"disabled_reason,omitempty"`
+	RestrictedAt     *time.Time `json:"restricted_at,omitempty"`
+	RestrictedReason string     `json:"restricted_reason,omitempty"`
+	RegistrationIP   string     `json:"registration_ip,omitempty"`
+	AgeVerifiedAt    *time.Time `json:"age_verified_at,omitempty"`
+	TokenReuseAt     *time.Time `json:"token_reuse_at,omitempty"` // a revoked or expired token was presented repeatedly
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type sessionResponse struct {
+	ID         string     `json:"id"`
+	IP         string     `json:"ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type disableRequest struct {
+	Reason string `json:"reason"`
+}
+
+type resetPasswordRequest struct {
+	Password string `json:"password"` // optional, a random one is generated when empty
+}
+
+func RegisterRoutes(r chi.Router) {
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(middleware.RouteRequiresAuthentication)
+		r.Use(middleware.RouteRequiresAdmin)
+
+		r.Route("/users", func(r chi.Router) {
+			// list / search users
+			r.Get("/", listUsers)
+
+			r.Route("/{id}", func(r chi.Router) {
+				r.Get("/", getUser)
+
+				// disable / re-enable account
+				r.Post("/disable", disableUser)
+				r.Post("/enable", enableUser)
+
+				// shadow restriction
+				r.Post("/restrict", restrictUser)
+				r.Post("/unrestrict", unrestrictUser)
+
+				// age verification, needed for nsfw channels
+				r.Post("/verify-age", verifyAge)
+				r.Post("/unverify-age", unverifyAge)
+
+				// reset password
+				r.Post("/reset-password", resetPassword)
+
+				// clears the stolen token flag once it was looked into
+				r.Post("/clear-token-reuse", clearTokenReuse)
+
+				// sessions
+				r.Get("/sessions", getSessions)
+				r.Delete("/sessions", revokeAllSessions)
+				r.Delete("/sessions/{sessionId}", revokeSession)
+			})
+		})
+
+		// instance statistics
+		r.Get("/stats", getStats)
+
+		// moderation queue
+		registerReportRoutes(r)
+
+		// network bans
+		registerIPBanRoutes(r)
+
+		// upload hash blocklist and quarantine
+		registerBlockedHashRoutes(r)
+
+		// reserved and profane handles
+		registerBlockedNameRoutes(r)
+
+		// read-only mode
+		registerMaintenanceRoutes(r)
+
+		// branding and registration mode shown at /instance
+		registerInstanceRoutes(r)
+
+		// application verification
+		registerApplicationRoutes(r)
+	})
+}
+
+func listUsers(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limitInt, err := strconv.Atoi(limitStr)
+		if err != nil || limitInt <= 0 || limitInt > 100 {
+			httpresponder.SendErrorResponse(w, r, "invalid limit, must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = limitInt
+	}
+
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offsetInt, err := strconv.Atoi(offsetStr)
+		if err != nil || offsetInt < 0 {
+			httpresponder.SendErrorResponse(w, r, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = offsetInt
+	}
+
+	dbQuery := database.DB.Model(&database.User{})
+	if query != "" {
+		like := "%" + query + "%"
+		search := database.DB.Where("username LIKE ? OR email LIKE ? OR id = ?", like, like, query)
+		if handle, domain, ok := database.SplitHandle(query); ok {
+			search = search.Or("normalized_handle = ? AND domain = ?", database.NormalizeHandle(handle), strings.ToLower(domain))
+		} else {
+			search = search.Or("normalized_handle = ?", database.NormalizeHandle(query))
+		}
+		dbQuery = dbQuery.Where(search)
+	}
+
+	var total int64
+	if err := dbQuery.Count(&total).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to count users", http.StatusInternalServerError)
+		return
+	}
+
+	var users []database.User
+	err := dbQuery.
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&users).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]adminUserResponse, 0, len(users))
+	for _, u := range users {
+		response = append(response, toAdminUser(&u))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"users": response,
+		"total": total,
+	})
+}
+
+func getUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok || !recordAccess(w, r, user.ID, accesslog.ActionViewUser) {
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toAdminUser(user))
+}
+
+func disableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.IsAdmin {
+		httpresponder.SendErrorResponse(w, r, "cannot disable an admin account", http.StatusBadRequest)
+		return
+	}
+
+	var body disableRequest
+	// body is optional
+	json.NewDecoder(r.Body).Decode(&body)
+
+	now := time.Now()
+	err := database.DB.Model(&database.User{}).
+		Where("id = ?", user.ID).
+		Updates(map[string]any{"disabled_at": now, "disabled_reason": body.Reason}).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to disable user", http.StatusInternalServerError)
+		return
+	}
+
+	// kick them out everywhere
+	if err := logoutEverywhere(user.ID); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"disabled": true})
+}
+
+func enableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Model(&database.User{}).
+		Where("id = ?", user.ID).
+		Updates(map[string]any{"disabled_at": nil, "disabled_reason": ""}).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to enable user", http.StatusInternalServerError)
+		return
+	}
+
+	usercache.UserCacheInstance.Delete(user.ID.String())
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"enabled": true})
+}
+
+func restrictUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.IsAdmin {
+		httpresponder.SendErrorResponse(w, r, "cannot restrict an admin account", http.StatusBadRequest)
+		return
+	}
+
+	var body disableRequest
+	json.NewDecoder(r.Body).Decode(&body)
+
+	now := time.Now()
+	err := database.DB.Model(&database.User{}).
+		Where("id = ?", user.ID).
+		Updates(map[string]any{"restricted_at": now, "restricted_reason": body.Reason}).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to restrict user", http.StatusInternalServerError)
+		return
+	}
+
+	// the user is not notified, that is the point
+	usercache.UserCacheInstance.Delete(user.ID.String())
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"restricted": true})
+}
+
+func unrestrictUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Model(&database.User{}).
+		Where("id = ?", user.ID).
+		Updates(map[string]any{"restricted_at": nil, "restricted_reason": ""}).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to unrestrict user", http.StatusInternalServerError)
+		return
+	}
+
+	usercache.UserCacheInstance.Delete(user.ID.String())
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"restricted": false})
+}
+
+func verifyAge(w http.ResponseWriter, r *http.Request) {
+	setAgeVerified(w, r, true)
+}
+
+func unverifyAge(w http.ResponseWriter, r *http.Request) {
+	setAgeVerified(w, r, false)
+}
+
+func setAgeVerified(w http.ResponseWriter, r *http.Request, verified bool) {
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var verifiedAt *time.Time
+	if verified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+
+	err := database.DB.Model(&database.User{}).
+		Where("id = ?", user.ID).
+		Update("age_verified_at", verifiedAt).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update age verification", http.StatusInternalServerError)
+		return
+	}
+
+	usercache.UserCacheInstance.Delete(user.ID.String())
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"age_verified": verified})
+}
+
+func clearTokenReuse(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Model(&database.User{}).
+		Where("id = ?", user.ID).
+		Update("token_reuse_at", nil).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to clear token reuse flag", http.StatusInternalServerError)
+		return
+	}
+
+	usercache.UserCacheInstance.Delete(user.ID.String())
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"cleared": true})
+}
+
+func resetPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body resetPasswordRequest
+	json.NewDecoder(r.Body).Decode(&body)
+
+	password := body.Password
+	generated := false
+	if password == "" {
+		buf := make([]byte, 12)
+		if _, err := rand.Read(buf); err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to generate password", http.StatusInternalServerError)
+			return
+		}
+		password = hex.EncodeToString(buf)
+		generated = true
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	err = database.DB.Model(&database.User{}).
+		Where("id = ?", user.ID).
+		Update("password", string(hashedPassword)).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	// old sessions shouldnt survive a password reset
+	if err := logoutEverywhere(user.ID); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]any{"reset": true}
+	if generated {
+		// only returned once, admin has to pass it on
+		response["password"] = password
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func getSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok || !recordAccess(w, r, user.ID, accesslog.ActionViewSessions) {
+		return
+	}
+
+	var tokens []database.UserToken
+	err := database.DB.
+		Where("user_id = ? AND expires_at > ?", user.ID, time.Now().Unix()).
+		Order("created_at DESC").
+		Find(&tokens).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]sessionResponse, 0, len(tokens))
+	for _, t := range tokens {
+		response = append(response, sessionResponse{
+			ID:         t.ID.String(),
+			IP:         t.IP,
+			CreatedAt:  t.CreatedAt,
+			ExpiresAt:  time.Unix(t.ExpiresAt, 0),
+			LastUsedAt: t.LastUsedAt,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := logoutEverywhere(user.ID); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"revoked": true})
+}
+
+func revokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.FromString(chi.URLParam(r, "sessionId"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	result := database.DB.Where("id = ? AND user_id = ?", sessionID, user.ID).Delete(&database.UserToken{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "session not found", http.StatusNotFound)
+		return
+	}
+
+	// gateway connections arent tied to a token, drop them all so the client re-identifies
+	websocket.DisconnectUser(user.ID, "session revoked")
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"revoked": true})
+}
+
+// helpers
+
+func loadTargetUser(w http.ResponseWriter, r *http.Request) (*database.User, bool) {
+	userID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid user id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var user database.User
+	if err := database.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "user not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &user, true
+}
+
+// recordAccess logs the admin reading the users data, refusing requests without a reason
+func recordAccess(w http.ResponseWriter, r *http.Request, targetID uuid.UUID, action string) bool {
+	admin, err := authhelper.GetUserFromRequest(r)
+	if err != nil || admin == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	reason := accesslog.Reason(r)
+	if reason == "" {
+		httpresponder.SendErrorResponse(w, r, "X-Access-Reason header is required to read user data", http.StatusBadRequest)
+		return false
+	}
+
+	if err := accesslog.Record(r, admin.ID, targetID, action, reason); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to record access", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// logoutEverywhere deletes all tokens of a user, oauth2 grants included, and closes their gateway connections
+func logoutEverywhere(userID uuid.UUID) error {
+	if err := database.DB.Where("user_id = ?", userID).Delete(&database.UserToken{}).Error; err != nil {
+		return err
+	}
+	// access and refresh tokens live in the same row, a revoked grant is never used again
+	if err := database.DB.Unscoped().Where("user_id = ?", userID).Delete(&database.OAuth2Token{}).Error; err != nil {
+		return err
+	}
+
+	usercache.UserCacheInstance.Delete(userID.String())
+	websocket.DisconnectUser(userID, "logged out by an administrator")
+
+	return nil
+}
+
+func toAdminUser(u *database.User) adminUserResponse {
+	return adminUserResponse{
+		ID:               u.ID.String(),
+		Username:         u.Username,
+		Domain:           u.Domain,
+		Email:            u.Email,
+		IsDomainVerified: u.IsDomainVerified,
+		IsAdmin:          u.IsAdmin,
+		Status:           u.Status,
+		DisabledAt:       u.DisabledAt,
+		DisabledReason:   u.DisabledReason,
+		RestrictedAt:     u.RestrictedAt,
+		RestrictedReason: u.RestrictedReason,
+		RegistrationIP:   u.RegistrationIP,
+		AgeVerifiedAt:    u.AgeVerifiedAt,
+		TokenReuseAt:     u.TokenReuseAt,
+		CreatedAt:        u.CreatedAt,
+	}
+}
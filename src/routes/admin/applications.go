@@ -0,0 +1,56 @@
+package adminroutes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/activity"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	uuid "github.com/satori/go.uuid"
+)
+
+func registerApplicationRoutes(r chi.Router) {
+	r.Route("/applications/{id}", func(r chi.Router) {
+		// verified applications are marked as such in rich presence
+		r.Post("/verify", verifyApplication)
+		r.Post("/unverify", unverifyApplication)
+	})
+}
+
+func verifyApplication(w http.ResponseWriter, r *http.Request) {
+	setApplicationVerified(w, r, true)
+}
+
+func unverifyApplication(w http.ResponseWriter, r *http.Request) {
+	setApplicationVerified(w, r, false)
+}
+
+func setApplicationVerified(w http.ResponseWriter, r *http.Request, verified bool) {
+	appID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid application id", http.StatusBadRequest)
+		return
+	}
+
+	var verifiedAt *time.Time
+	if verified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+
+	result := database.DB.Model(&database.Application{}).Where("id = ?", appID).Update("activity_verified_at", verifiedAt)
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update application", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "application not found", http.StatusNotFound)
+		return
+	}
+
+	activity.Invalidate(appID)
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{"id": appID, "activity_verified": verified})
+}
@@ -0,0 +1,208 @@
+package adminroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hashblock"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	uuid "github.com/satori/go.uuid"
+)
+
+type blockedHashResponse struct {
+	ID          string    `json:"id"`
+	Hash        string    `json:"hash"`
+	Reason      string    `json:"reason,omitempty"`
+	CreatedByID string    `json:"created_by_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type createBlockedHashRequest struct {
+	Hash   string `json:"hash"` // sha256 of the file, hex
+	Reason string `json:"reason"`
+}
+
+type quarantinedUploadResponse struct {
+	ID         string    `json:"id"`
+	Hash       string    `json:"hash"`
+	UploaderID string    `json:"uploader_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func registerBlockedHashRoutes(r chi.Router) {
+	r.Route("/blocked-hashes", func(r chi.Router) {
+		r.Get("/", listBlockedHashes)
+		r.Post("/", createBlockedHash)
+		r.Delete("/{id}", deleteBlockedHash)
+	})
+
+	r.Route("/quarantine", func(r chi.Router) {
+		r.Get("/", listQuarantinedUploads)
+		r.Delete("/{id}", deleteQuarantinedUpload)
+	})
+}
+
+func listBlockedHashes(w http.ResponseWriter, r *http.Request) {
+	var rows []database.BlockedHash
+	if err := database.DB.Order("created_at DESC").Find(&rows).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch blocked hashes", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]blockedHashResponse, 0, len(rows))
+	for _, row := range rows {
+		response = append(response, toBlockedHashResponse(&row))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func createBlockedHash(w http.ResponseWriter, r *http.Request) {
+	admin, err := authhelper.GetUserFromRequest(r)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body createBlockedHashRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	hash, ok := hashblock.NormalizeHash(body.Hash)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, "hash must be a hex sha256", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Reason) > 500 {
+		httpresponder.SendErrorResponse(w, r, "reason too long", http.StatusBadRequest)
+		return
+	}
+
+	var existing int64
+	database.DB.Model(&database.BlockedHash{}).Where("hash = ?", hash).Count(&existing)
+	if existing > 0 {
+		httpresponder.SendErrorResponse(w, r, "hash is already blocked", http.StatusConflict)
+		return
+	}
+
+	row := database.BlockedHash{
+		Hash:        hash,
+		Reason:      body.Reason,
+		CreatedByID: admin.ID,
+	}
+
+	if err := database.DB.Create(&row).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to block hash", http.StatusInternalServerError)
+		return
+	}
+
+	hashblock.Invalidate()
+
+	httpresponder.SendSuccessResponse(w, r, toBlockedHashResponse(&row))
+}
+
+func deleteBlockedHash(w http.ResponseWriter, r *http.Request) {
+	rowID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid blocked hash id", http.StatusBadRequest)
+		return
+	}
+
+	// hard delete so the same hash can be blocked again later
+	result := database.DB.Unscoped().Where("id = ?", rowID).Delete(&database.BlockedHash{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete blocked hash", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "blocked hash not found", http.StatusNotFound)
+		return
+	}
+
+	hashblock.Invalidate()
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// listQuarantinedUploads returns the newest quarantined uploads first.
+// query params: limit (default 50, max 100), before (id of the last upload of the previous page)
+func listQuarantinedUploads(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limitInt, err := strconv.Atoi(limitStr)
+		if err != nil || limitInt <= 0 || limitInt > 100 {
+			httpresponder.SendErrorResponse(w, r, "invalid limit, must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = limitInt
+	}
+
+	query := database.DB.Order("created_at DESC").Limit(limit)
+	if before := r.URL.Query().Get("before"); before != "" {
+		var cursor database.QuarantinedUpload
+		if err := database.DB.Where("id = ?", before).First(&cursor).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid before id", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("created_at < ?", cursor.CreatedAt)
+	}
+
+	var uploads []database.QuarantinedUpload
+	if err := query.Find(&uploads).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch quarantined uploads", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]quarantinedUploadResponse, 0, len(uploads))
+	for _, upload := range uploads {
+		response = append(response, quarantinedUploadResponse{
+			ID:         upload.ID.String(),
+			Hash:       upload.Hash,
+			UploaderID: upload.UploaderID.String(),
+			CreatedAt:  upload.CreatedAt,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// deleteQuarantinedUpload removes a reviewed upload and its kept copy
+func deleteQuarantinedUpload(w http.ResponseWriter, r *http.Request) {
+	uploadID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid quarantined upload id", http.StatusBadRequest)
+		return
+	}
+
+	var upload database.QuarantinedUpload
+	if err := database.DB.Where("id = ?", uploadID).First(&upload).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "quarantined upload not found", http.StatusNotFound)
+		return
+	}
+
+	if err := database.DB.Unscoped().Delete(&upload).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete quarantined upload", http.StatusInternalServerError)
+		return
+	}
+	hashblock.RemoveQuarantined(upload.Path)
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+func toBlockedHashResponse(row *database.BlockedHash) blockedHashResponse {
+	return blockedHashResponse{
+		ID:          row.ID.String(),
+		Hash:        row.Hash,
+		Reason:      row.Reason,
+		CreatedByID: row.CreatedByID.String(),
+		CreatedAt:   row.CreatedAt,
+	}
+}
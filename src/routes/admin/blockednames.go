@@ -0,0 +1,131 @@
+package adminroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/nameblock"
+	uuid "github.com/satori/go.uuid"
+)
+
+type blockedNameResponse struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"`
+	CreatedByID string    `json:"created_by_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type createBlockedNameRequest struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"` // reserved (default) or profanity
+}
+
+func registerBlockedNameRoutes(r chi.Router) {
+	r.Route("/blocked-names", func(r chi.Router) {
+		r.Get("/", listBlockedNames)
+		r.Post("/", createBlockedName)
+		r.Delete("/{id}", deleteBlockedName)
+	})
+}
+
+func listBlockedNames(w http.ResponseWriter, r *http.Request) {
+	var rows []database.BlockedName
+	if err := database.DB.Order("name ASC").Find(&rows).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch blocked names", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]blockedNameResponse, 0, len(rows))
+	for _, row := range rows {
+		response = append(response, toBlockedNameResponse(&row))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func createBlockedName(w http.ResponseWriter, r *http.Request) {
+	admin, err := authhelper.GetUserFromRequest(r)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body createBlockedNameRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.Kind == "" {
+		body.Kind = database.BlockedNameReserved
+	}
+	if body.Kind != database.BlockedNameReserved && body.Kind != database.BlockedNameProfanity {
+		httpresponder.SendErrorResponse(w, r, "kind must be reserved or profanity", http.StatusBadRequest)
+		return
+	}
+
+	// stored the way handles are compared
+	name := nameblock.Normalize(body.Name)
+	if name == "" || len(name) > 50 {
+		httpresponder.SendErrorResponse(w, r, "name must have between 1 and 50 letters", http.StatusBadRequest)
+		return
+	}
+
+	row := database.BlockedName{
+		Name:        name,
+		Kind:        body.Kind,
+		CreatedByID: admin.ID,
+	}
+
+	if err := database.DB.Create(&row).Error; err != nil {
+		if _, duplicate := database.DuplicateKey(err); duplicate {
+			httpresponder.SendErrorResponse(w, r, "name is already blocked", http.StatusConflict)
+			return
+		}
+		httpresponder.SendErrorResponse(w, r, "failed to block name", http.StatusInternalServerError)
+		return
+	}
+
+	nameblock.Invalidate()
+
+	httpresponder.SendSuccessResponse(w, r, toBlockedNameResponse(&row))
+}
+
+func deleteBlockedName(w http.ResponseWriter, r *http.Request) {
+	rowID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid blocked name id", http.StatusBadRequest)
+		return
+	}
+
+	// hard delete so the same name can be blocked again later
+	result := database.DB.Unscoped().Where("id = ?", rowID).Delete(&database.BlockedName{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete blocked name", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "blocked name not found", http.StatusNotFound)
+		return
+	}
+
+	nameblock.Invalidate()
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+func toBlockedNameResponse(row *database.BlockedName) blockedNameResponse {
+	return blockedNameResponse{
+		ID:          row.ID.String(),
+		Name:        row.Name,
+		Kind:        row.Kind,
+		CreatedByID: row.CreatedByID.String(),
+		CreatedAt:   row.CreatedAt,
+	}
+}
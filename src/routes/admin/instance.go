@@ -0,0 +1,65 @@
+package adminroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/instance"
+)
+
+type instanceRequest struct {
+	Name         *string `json:"name"`
+	Description  *string `json:"description"`
+	Registration *string `json:"registration"` // open or closed
+}
+
+func registerInstanceRoutes(r chi.Router) {
+	r.Get("/instance", getInstanceSettings)
+	r.Patch("/instance", updateInstanceSettings)
+}
+
+func getInstanceSettings(w http.ResponseWriter, r *http.Request) {
+	httpresponder.SendSuccessResponse(w, r, instance.Current())
+}
+
+func updateInstanceSettings(w http.ResponseWriter, r *http.Request) {
+	var body instanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	settings := instance.Current()
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" || len(name) > 100 {
+			httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+			return
+		}
+		settings.Name = name
+	}
+	if body.Description != nil {
+		if len(*body.Description) > 1000 {
+			httpresponder.SendErrorResponse(w, r, "description must be at most 1000 characters", http.StatusBadRequest)
+			return
+		}
+		settings.Description = *body.Description
+	}
+	if body.Registration != nil {
+		if !instance.ValidRegistration(*body.Registration) {
+			httpresponder.SendErrorResponse(w, r, "registration must be open or closed", http.StatusBadRequest)
+			return
+		}
+		settings.Registration = *body.Registration
+	}
+
+	if err := instance.Set(r.Context(), settings); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update instance settings", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, settings)
+}
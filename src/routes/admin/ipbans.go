@@ -0,0 +1,152 @@
+package adminroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/ipban"
+	uuid "github.com/satori/go.uuid"
+)
+
+type ipBanResponse struct {
+	ID          string     `json:"id"`
+	CIDR        string     `json:"cidr"`
+	Reason      string     `json:"reason,omitempty"`
+	CreatedByID string     `json:"created_by_id"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type createIPBanRequest struct {
+	CIDR   string `json:"cidr"` // single address or range
+	Reason string `json:"reason"`
+	// optional, ban is permanent when neither is set
+	ExpiresAt       *time.Time `json:"expires_at"`
+	DurationSeconds int64      `json:"duration_seconds"`
+}
+
+func registerIPBanRoutes(r chi.Router) {
+	r.Route("/ip-bans", func(r chi.Router) {
+		r.Get("/", listIPBans)
+		r.Post("/", createIPBan)
+		r.Delete("/{id}", deleteIPBan)
+	})
+}
+
+func listIPBans(w http.ResponseWriter, r *http.Request) {
+	var bans []database.IPBan
+	if err := database.DB.Order("created_at DESC").Find(&bans).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch ip bans", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]ipBanResponse, 0, len(bans))
+	for _, ban := range bans {
+		response = append(response, toIPBanResponse(&ban))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func createIPBan(w http.ResponseWriter, r *http.Request) {
+	admin, err := authhelper.GetUserFromRequest(r)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body createIPBanRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	cidr, ok := ipban.NormalizeCIDR(body.CIDR)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, "invalid ip address or range", http.StatusBadRequest)
+		return
+	}
+
+	// refuse to lock the caller out of their own instance
+	if ipban.Contains(cidr, ipban.ClientIP(r)) {
+		httpresponder.SendErrorResponse(w, r, "this range includes your own address", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Reason) > 500 {
+		httpresponder.SendErrorResponse(w, r, "reason too long", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := body.ExpiresAt
+	if expiresAt == nil && body.DurationSeconds > 0 {
+		t := time.Now().Add(time.Duration(body.DurationSeconds) * time.Second)
+		expiresAt = &t
+	}
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		httpresponder.SendErrorResponse(w, r, "expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	var existing int64
+	database.DB.Model(&database.IPBan{}).Where("cidr = ?", cidr).Count(&existing)
+	if existing > 0 {
+		httpresponder.SendErrorResponse(w, r, "range is already banned", http.StatusConflict)
+		return
+	}
+
+	ban := database.IPBan{
+		CIDR:        cidr,
+		Reason:      body.Reason,
+		CreatedByID: admin.ID,
+		ExpiresAt:   expiresAt,
+	}
+
+	if err := database.DB.Create(&ban).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create ip ban", http.StatusInternalServerError)
+		return
+	}
+
+	ipban.Invalidate()
+
+	httpresponder.SendSuccessResponse(w, r, toIPBanResponse(&ban))
+}
+
+func deleteIPBan(w http.ResponseWriter, r *http.Request) {
+	banID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid ip ban id", http.StatusBadRequest)
+		return
+	}
+
+	// hard delete so the same range can be banned again later
+	result := database.DB.Unscoped().Where("id = ?", banID).Delete(&database.IPBan{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete ip ban", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "ip ban not found", http.StatusNotFound)
+		return
+	}
+
+	ipban.Invalidate()
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+func toIPBanResponse(ban *database.IPBan) ipBanResponse {
+	return ipBanResponse{
+		ID:          ban.ID.String(),
+		CIDR:        ban.CIDR,
+		Reason:      ban.Reason,
+		CreatedByID: ban.CreatedByID.String(),
+		ExpiresAt:   ban.ExpiresAt,
+		CreatedAt:   ban.CreatedAt,
+	}
+}
@@ -0,0 +1,48 @@
+package adminroutes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/maintenance"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+)
+
+type maintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"` // optional, a default is used when empty
+}
+
+func registerMaintenanceRoutes(r chi.Router) {
+	r.Get("/maintenance", getMaintenance)
+	r.Put("/maintenance", setMaintenance)
+}
+
+func getMaintenance(w http.ResponseWriter, r *http.Request) {
+	httpresponder.SendSuccessResponse(w, r, maintenance.Current())
+}
+
+func setMaintenance(w http.ResponseWriter, r *http.Request) {
+	var body maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Message) > 500 {
+		httpresponder.SendErrorResponse(w, r, "message too long", http.StatusBadRequest)
+		return
+	}
+
+	state, err := maintenance.Set(r.Context(), body.Enabled, body.Message)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update maintenance mode", http.StatusInternalServerError)
+		return
+	}
+
+	websocket.NotifyMaintenance(state.Enabled, state.Message)
+
+	httpresponder.SendSuccessResponse(w, r, state)
+}
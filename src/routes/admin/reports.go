@@ -0,0 +1,246 @@
+package adminroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/accesslog"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+)
+
+type adminReportResponse struct {
+	ID             string     `json:"id"`
+	ReporterID     string     `json:"reporter_id"`
+	TargetType     string     `json:"target_type"`
+	TargetID       string     `json:"target_id"`
+	Reason         string     `json:"reason"`
+	Details        string     `json:"details,omitempty"`
+	Status         int        `json:"status"`
+	ReportedUserID *string    `json:"reported_user_id,omitempty"`
+	ServerID       *string    `json:"server_id,omitempty"`
+	ChannelID      *string    `json:"channel_id,omitempty"`
+	ConversationID *string    `json:"conversation_id,omitempty"`
+	MessageContent string     `json:"message_content,omitempty"`
+	ResolvedByID   *string    `json:"resolved_by_id,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type updateReportRequest struct {
+	Status         *int   `json:"status"`
+	ResolutionNote string `json:"resolution_note"`
+}
+
+// allowed status transitions, resolved and dismissed are final
+var reportTransitions = map[database.ReportStatus][]database.ReportStatus{
+	database.ReportOpen:      {database.ReportReviewing, database.ReportResolved, database.ReportDismissed},
+	database.ReportReviewing: {database.ReportOpen, database.ReportResolved, database.ReportDismissed},
+}
+
+func registerReportRoutes(r chi.Router) {
+	r.Route("/reports", func(r chi.Router) {
+		// moderation queue, defaults to open reports
+		r.Get("/", listReports)
+		r.Get("/{id}", getReport)
+		r.Patch("/{id}", updateReport)
+	})
+}
+
+func listReports(w http.ResponseWriter, r *http.Request) {
+	status := database.ReportOpen
+	if statusStr := r.URL.Query().Get("status"); statusStr != "" {
+		statusInt, err := strconv.Atoi(statusStr)
+		if err != nil || statusInt < 0 || statusInt > int(database.ReportDismissed) {
+			httpresponder.SendErrorResponse(w, r, "invalid status", http.StatusBadRequest)
+			return
+		}
+		status = database.ReportStatus(statusInt)
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limitInt, err := strconv.Atoi(limitStr)
+		if err != nil || limitInt <= 0 || limitInt > 100 {
+			httpresponder.SendErrorResponse(w, r, "invalid limit, must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = limitInt
+	}
+
+	query := database.DB.Where("status = ?", status)
+
+	if targetType := r.URL.Query().Get("target_type"); targetType != "" {
+		query = query.Where("target_type = ?", targetType)
+	}
+
+	var reports []database.Report
+	// oldest first so the queue is worked in order
+	err := query.
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&reports).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch reports", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]adminReportResponse, 0, len(reports))
+	for _, report := range reports {
+		response = append(response, toAdminReport(&report))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func getReport(w http.ResponseWriter, r *http.Request) {
+	report, ok := loadReport(w, r)
+	if !ok {
+		return
+	}
+
+	// the report carries the reported users message
+	if report.ReportedUserID != nil && !recordAccess(w, r, *report.ReportedUserID, accesslog.ActionViewReport) {
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toAdminReport(report))
+}
+
+func updateReport(w http.ResponseWriter, r *http.Request) {
+	moderator, err := authhelper.GetUserFromRequest(r)
+	if err != nil || moderator == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	report, ok := loadReport(w, r)
+	if !ok {
+		return
+	}
+
+	var body updateReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Status == nil {
+		httpresponder.SendErrorResponse(w, r, "status is required", http.StatusBadRequest)
+		return
+	}
+
+	newStatus := database.ReportStatus(*body.Status)
+
+	allowed := false
+	for _, s := range reportTransitions[report.Status] {
+		if s == newStatus {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		httpresponder.SendErrorResponse(w, r, "invalid status transition", http.StatusConflict)
+		return
+	}
+
+	updates := map[string]any{"status": newStatus}
+
+	closed := newStatus == database.ReportResolved || newStatus == database.ReportDismissed
+	if closed {
+		now := time.Now()
+		updates["resolved_by_id"] = moderator.ID
+		updates["resolved_at"] = now
+		updates["resolution_note"] = body.ResolutionNote
+	}
+
+	// guard on the old status so two moderators cant both close it
+	result := database.DB.Model(&database.Report{}).
+		Where("id = ? AND status = ?", report.ID, report.Status).
+		Updates(updates)
+
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update report", http.StatusInternalServerError)
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "report was updated by someone else", http.StatusConflict)
+		return
+	}
+
+	database.DB.Where("id = ?", report.ID).First(report)
+
+	if closed {
+		notifyReportResolved(report)
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toAdminReport(report))
+}
+
+// helpers
+
+func loadReport(w http.ResponseWriter, r *http.Request) (*database.Report, bool) {
+	reportID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid report id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var report database.Report
+	if err := database.DB.Where("id = ?", reportID).First(&report).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "report not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &report, true
+}
+
+// notifyReportResolved lets the reporter know their report was handled, without moderator details
+func notifyReportResolved(report *database.Report) {
+	hub := websocket.GetHub()
+	if hub == nil {
+		return
+	}
+
+	hub.DispatchToUser(report.ReporterID, websocket.EventReportResolved, map[string]any{
+		"report_id":   report.ID,
+		"target_type": report.TargetType,
+		"target_id":   report.TargetID,
+		"status":      report.Status,
+		"resolved_at": report.ResolvedAt,
+	})
+}
+
+func optionalID(id *uuid.UUID) *string {
+	if id == nil {
+		return nil
+	}
+	s := id.String()
+	return &s
+}
+
+func toAdminReport(report *database.Report) adminReportResponse {
+	return adminReportResponse{
+		ID:             report.ID.String(),
+		ReporterID:     report.ReporterID.String(),
+		TargetType:     report.TargetType,
+		TargetID:       report.TargetID.String(),
+		Reason:         report.Reason,
+		Details:        report.Details,
+		Status:         int(report.Status),
+		ReportedUserID: optionalID(report.ReportedUserID),
+		ServerID:       optionalID(report.ServerID),
+		ChannelID:      optionalID(report.ChannelID),
+		ConversationID: optionalID(report.ConversationID),
+		MessageContent: report.MessageContent,
+		ResolvedByID:   optionalID(report.ResolvedByID),
+		ResolvedAt:     report.ResolvedAt,
+		ResolutionNote: report.ResolutionNote,
+		CreatedAt:      report.CreatedAt,
+	}
+}
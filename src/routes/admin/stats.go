@@ -0,0 +1,188 @@
+package adminroutes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/purge"
+	"github.com/hindsightchat/backend/src/lib/stats"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+)
+
+// aggregates are cached for this long, gateway numbers are always live
+const statsCacheTTL = 60 * time.Second
+
+type dailyMessageCount struct {
+	Day      string `json:"day"`
+	Direct   int64  `json:"direct"`
+	Channel  int64  `json:"channel"`
+	Combined int64  `json:"total"`
+}
+
+type instanceStats struct {
+	RegisteredUsers    int64               `json:"registered_users"`
+	DailyActiveUsers   int64               `json:"daily_active_users"`
+	MonthlyActiveUsers int64               `json:"monthly_active_users"`
+	Servers            int64               `json:"servers"`
+	Conversations      int64               `json:"conversations"`
+	MessagesPerDay     []dailyMessageCount `json:"messages_per_day"`
+	GeneratedAt        time.Time           `json:"generated_at"`
+}
+
+type gatewayStats struct {
+	Connections int                    `json:"connections"`
+	OnlineUsers int                    `json:"online_users"`
+	Fanout      *websocket.FanoutStats `json:"fanout,omitempty"`
+}
+
+type databaseStats struct {
+	SlowQueries database.SlowQueryStats `json:"slow_queries"`
+	Purge       purge.Stats             `json:"purge"`
+}
+
+type dayCountRow struct {
+	Day   string
+	Count int64
+}
+
+func getStats(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		daysInt, err := strconv.Atoi(daysStr)
+		if err != nil || daysInt <= 0 || daysInt > 90 {
+			httpresponder.SendErrorResponse(w, r, "invalid days, must be between 1 and 90", http.StatusBadRequest)
+			return
+		}
+		days = daysInt
+	}
+
+	result, err := loadInstanceStats(r.Context(), days)
+	if errors.Is(err, stats.ErrUnavailable) {
+		httpresponder.SendErrorResponse(w, r, "stats are unavailable right now", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to compute stats", http.StatusInternalServerError)
+		return
+	}
+
+	gateway := gatewayStats{}
+	if hub := websocket.GetHub(); hub != nil {
+		gateway.Connections, gateway.OnlineUsers = hub.ConnectionCount()
+		fanout := hub.FanoutStats()
+		gateway.Fanout = &fanout
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"stats":    result,
+		"gateway":  gateway,
+		"database": databaseStats{SlowQueries: database.GetSlowQueryStats(), Purge: purge.GetStats()},
+	})
+}
+
+// loadInstanceStats returns cached aggregates or computes and caches them
+func loadInstanceStats(ctx context.Context, days int) (*instanceStats, error) {
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return nil, stats.ErrUnavailable
+	}
+	cacheKey := valkeydb.STATS_CACHE_PREFIX + "instance:" + strconv.Itoa(days)
+
+	if cached, err := rdb.Get(ctx, cacheKey).Bytes(); err == nil {
+		var result instanceStats
+		if err := json.Unmarshal(cached, &result); err == nil {
+			return &result, nil
+		}
+	}
+
+	result := &instanceStats{GeneratedAt: time.Now()}
+
+	if err := database.DB.Model(&database.User{}).Count(&result.RegisteredUsers).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Model(&database.Server{}).Count(&result.Servers).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Model(&database.DMConversation{}).Count(&result.Conversations).Error; err != nil {
+		return nil, err
+	}
+
+	// active users come from the hyperloglogs, these are approximate by design
+	dau, err := stats.ActiveUsers(1)
+	if err != nil {
+		return nil, err
+	}
+	mau, err := stats.ActiveUsers(30)
+	if err != nil {
+		return nil, err
+	}
+	result.DailyActiveUsers = dau
+	result.MonthlyActiveUsers = mau
+
+	// message counts grouped per day, one aggregate query per table
+	since := time.Now().AddDate(0, 0, -(days - 1)).Truncate(24 * time.Hour)
+
+	var directRows []dayCountRow
+	err = database.DB.Model(&database.DirectMessage{}).
+		Select("DATE(created_at) AS day, COUNT(*) AS count").
+		Where("created_at >= ?", since).
+		Group("day").
+		Scan(&directRows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var channelRows []dayCountRow
+	err = database.DB.Model(&database.ChannelMessage{}).
+		Select("DATE(created_at) AS day, COUNT(*) AS count").
+		Where("created_at >= ?", since).
+		Group("day").
+		Scan(&channelRows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	perDay := make(map[string]*dailyMessageCount)
+	result.MessagesPerDay = make([]dailyMessageCount, 0, days)
+	for i := 0; i < days; i++ {
+		day := since.AddDate(0, 0, i).Format("2006-01-02")
+		result.MessagesPerDay = append(result.MessagesPerDay, dailyMessageCount{Day: day})
+	}
+	for i := range result.MessagesPerDay {
+		perDay[result.MessagesPerDay[i].Day] = &result.MessagesPerDay[i]
+	}
+
+	for _, row := range directRows {
+		if entry, ok := perDay[normalizeDay(row.Day)]; ok {
+			entry.Direct = row.Count
+			entry.Combined += row.Count
+		}
+	}
+	for _, row := range channelRows {
+		if entry, ok := perDay[normalizeDay(row.Day)]; ok {
+			entry.Channel = row.Count
+			entry.Combined += row.Count
+		}
+	}
+
+	if data, err := json.Marshal(result); err == nil {
+		rdb.Set(ctx, cacheKey, data, statsCacheTTL)
+	}
+
+	return result, nil
+}
+
+// DATE() comes back as "2006-01-02" or a full timestamp depending on the driver settings
+func normalizeDay(day string) string {
+	if len(day) > 10 {
+		return day[:10]
+	}
+	return day
+}
@@ -0,0 +1,734 @@
+package applicationroutes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/activity"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	usercache "github.com/hindsightchat/backend/src/lib/cache/user"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/membercount"
+	"github.com/hindsightchat/backend/src/lib/oauth2"
+	"github.com/hindsightchat/backend/src/lib/publicnet"
+	"github.com/hindsightchat/backend/src/middleware"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// bot users live on their own pseudo domain so they can never clash with real accounts
+const botDomain = "bot.hindsight.chat"
+
+// bot tokens dont expire, they are rotated with the reset endpoint instead
+const botTokenLifetime = 100 * 365 * 24 * time.Hour
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+type applicationResponse struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Icon        string    `json:"icon,omitempty"`
+	IsPublic    bool      `json:"is_public"`
+	OwnerID     string    `json:"owner_id"`
+	Bot         botBrief  `json:"bot"`
+	BotToken    string    `json:"bot_token,omitempty"` // only returned on create and token reset
+	CreatedAt   time.Time `json:"created_at"`
+
+	ActivityVerified bool `json:"activity_verified"` // verified by instance admins, shown on activities
+
+	// owner only
+	InteractionsURL    string   `json:"interactions_url,omitempty"`
+	InteractionsSecret string   `json:"interactions_secret,omitempty"`
+	RedirectURIs       []string `json:"redirect_uris,omitempty"`
+	OAuth2Enabled      bool     `json:"oauth2_enabled,omitempty"`
+	ClientSecret       string   `json:"client_secret,omitempty"` // only returned when rotated
+}
+
+type botBrief struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Domain   string `json:"domain"`
+}
+
+type serverBrief struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Icon string `json:"icon,omitempty"`
+}
+
+type createApplicationRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	IsPublic    *bool  `json:"is_public"`
+}
+
+type updateApplicationRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Icon        *string `json:"icon"`
+	IsPublic    *bool   `json:"is_public"`
+
+	InteractionsURL *string   `json:"interactions_url"` // empty string switches back to gateway delivery
+	RedirectURIs    *[]string `json:"redirect_uris"`
+}
+
+type authorizeRequest struct {
+	ServerID string `json:"server_id"`
+}
+
+func RegisterRoutes(r chi.Router) {
+	r.Route("/applications", func(r chi.Router) {
+		r.Use(middleware.RouteRequiresAuthentication)
+
+		// applications owned by the current user
+		r.Get("/", listApplications)
+		r.Post("/", createApplication)
+
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", getApplication)
+			r.Patch("/", updateApplication)
+			r.Delete("/", deleteApplication)
+
+			// rotate the bot token, old one stops working immediately
+			r.Post("/bot/token", resetBotToken)
+
+			// oauth2 client secret, generating one enables the oauth2 flow
+			r.Post("/oauth2/secret", resetClientSecret)
+
+			// add the bot to a server
+			r.Get("/authorize", getAuthorization)
+			r.Post("/authorize", authorizeApplication)
+
+			// slash commands
+			registerCommandRoutes(r)
+
+			// images for rich presence
+			registerAssetRoutes(r)
+		})
+	})
+}
+
+func listApplications(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireHuman(w, r)
+	if !ok {
+		return
+	}
+
+	var apps []database.Application
+	err := database.DB.
+		Preload("BotUser").
+		Where("owner_id = ?", user.ID).
+		Order("created_at ASC").
+		Find(&apps).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch applications", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]applicationResponse, 0, len(apps))
+	for _, app := range apps {
+		response = append(response, toOwnerApplicationResponse(&app))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func createApplication(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireHuman(w, r)
+	if !ok {
+		return
+	}
+
+	var body createApplicationRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || len(body.Name) > 100 {
+		httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(body.Description) > 500 {
+		httpresponder.SendErrorResponse(w, r, "description too long", http.StatusBadRequest)
+		return
+	}
+
+	suffix, err := randomHex(3)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create application", http.StatusInternalServerError)
+		return
+	}
+
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(body.Name), "-"), "-")
+	if slug == "" {
+		slug = "bot"
+	}
+	if len(slug) > 24 {
+		slug = slug[:24]
+	}
+
+	// bots cant log in with a password, this one is never handed out
+	unusable, err := randomHex(32)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create application", http.StatusInternalServerError)
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(unusable), bcrypt.DefaultCost)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create application", http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create application", http.StatusInternalServerError)
+		return
+	}
+
+	var app database.Application
+	var token string
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		botUser := database.User{
+			Handle:           slug + "-" + suffix,
+			Username:         database.FullUsername(slug+"-"+suffix, botDomain),
+			Domain:           botDomain,
+			Email:            slug + "-" + suffix + "@" + botDomain,
+			Password:         string(hashedPassword),
+			IsDomainVerified: true,
+			IsBot:            true,
+		}
+		if err := tx.Create(&botUser).Error; err != nil {
+			return err
+		}
+
+		app = database.Application{
+			OwnerID:            user.ID,
+			Name:               body.Name,
+			Description:        body.Description,
+			BotUserID:          botUser.ID,
+			IsPublic:           body.IsPublic == nil || *body.IsPublic,
+			InteractionsSecret: secret,
+		}
+		if err := tx.Create(&app).Error; err != nil {
+			return err
+		}
+		app.BotUser = botUser
+
+		var err error
+		token, err = issueBotToken(tx, botUser.ID)
+		return err
+	})
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create application", http.StatusInternalServerError)
+		return
+	}
+
+	response := toOwnerApplicationResponse(&app)
+	response.BotToken = token
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func getApplication(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireHuman(w, r)
+	if !ok {
+		return
+	}
+
+	app, ok := loadOwnedApplication(w, r, user)
+	if !ok {
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toOwnerApplicationResponse(app))
+}
+
+func updateApplication(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireHuman(w, r)
+	if !ok {
+		return
+	}
+
+	app, ok := loadOwnedApplication(w, r, user)
+	if !ok {
+		return
+	}
+
+	var body updateApplicationRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]any{}
+
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" || len(name) > 100 {
+			httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+			return
+		}
+		updates["name"] = name
+		app.Name = name
+	}
+	if body.Description != nil {
+		if len(*body.Description) > 500 {
+			httpresponder.SendErrorResponse(w, r, "description too long", http.StatusBadRequest)
+			return
+		}
+		updates["description"] = *body.Description
+		app.Description = *body.Description
+	}
+	if body.Icon != nil {
+		if len(*body.Icon) > 255 {
+			httpresponder.SendErrorResponse(w, r, "icon url too long", http.StatusBadRequest)
+			return
+		}
+		updates["icon"] = *body.Icon
+		app.Icon = *body.Icon
+	}
+	if body.IsPublic != nil {
+		updates["is_public"] = *body.IsPublic
+		app.IsPublic = *body.IsPublic
+	}
+	if body.InteractionsURL != nil {
+		interactionsURL := strings.TrimSpace(*body.InteractionsURL)
+		if interactionsURL != "" && !strings.HasPrefix(interactionsURL, "https://") && !strings.HasPrefix(interactionsURL, "http://") {
+			httpresponder.SendErrorResponse(w, r, "interactions url must be http(s)", http.StatusBadRequest)
+			return
+		}
+		// names are checked again when dialing, this only catches the obvious ones early
+		if interactionsURL != "" && !publicHost(interactionsURL) {
+			httpresponder.SendErrorResponse(w, r, "interactions url must point to a public address", http.StatusBadRequest)
+			return
+		}
+		if len(interactionsURL) > 255 {
+			httpresponder.SendErrorResponse(w, r, "interactions url too long", http.StatusBadRequest)
+			return
+		}
+		updates["interactions_url"] = interactionsURL
+		app.InteractionsURL = interactionsURL
+	}
+	if body.RedirectURIs != nil {
+		if len(*body.RedirectURIs) > 10 {
+			httpresponder.SendErrorResponse(w, r, "at most 10 redirect uris", http.StatusBadRequest)
+			return
+		}
+		for _, uri := range *body.RedirectURIs {
+			parsed, err := url.Parse(uri)
+			if err != nil || parsed.Scheme == "" || parsed.Fragment != "" || len(uri) > 255 ||
+				parsed.Scheme == "javascript" || parsed.Scheme == "data" {
+				httpresponder.SendErrorResponse(w, r, "invalid redirect uri: "+uri, http.StatusBadRequest)
+				return
+			}
+		}
+		joined := strings.Join(*body.RedirectURIs, "\n")
+		updates["redirect_uris"] = joined
+		app.RedirectURIs = joined
+	}
+
+	if len(updates) > 0 {
+		if err := database.DB.Model(&database.Application{}).Where("id = ?", app.ID).Updates(updates).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update application", http.StatusInternalServerError)
+			return
+		}
+
+		// keep the bot avatar in sync with the application icon
+		if body.Icon != nil {
+			database.DB.Model(&database.User{}).Where("id = ?", app.BotUserID).Update("profile_pic_url", *body.Icon)
+			usercache.UserCacheInstance.Delete(app.BotUserID.String())
+		}
+
+		activity.Invalidate(app.ID)
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toOwnerApplicationResponse(app))
+}
+
+func deleteApplication(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireHuman(w, r)
+	if !ok {
+		return
+	}
+
+	app, ok := loadOwnedApplication(w, r, user)
+	if !ok {
+		return
+	}
+
+	var serverIDs []uuid.UUID
+	database.DB.Model(&database.ServerMember{}).Where("user_id = ?", app.BotUserID).Pluck("server_id", &serverIDs)
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", app.BotUserID).Delete(&database.UserToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", app.BotUserID).Delete(&database.ServerMember{}).Error; err != nil {
+			return err
+		}
+		for _, serverID := range serverIDs {
+			if err := membercount.Members(tx, serverID, -1); err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Where("application_id = ?", app.ID).Delete(&database.OAuth2Token{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", app.BotUserID).Delete(&database.User{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", app.ID).Delete(&database.Application{}).Error
+	})
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete application", http.StatusInternalServerError)
+		return
+	}
+
+	usercache.UserCacheInstance.Delete(app.BotUserID.String())
+	activity.Invalidate(app.ID)
+	websocket.DisconnectUser(app.BotUserID, "application deleted")
+	for _, serverID := range serverIDs {
+		websocket.NotifyServerMemberLeave(serverID, app.BotUserID)
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+func resetBotToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireHuman(w, r)
+	if !ok {
+		return
+	}
+
+	app, ok := loadOwnedApplication(w, r, user)
+	if !ok {
+		return
+	}
+
+	var token string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", app.BotUserID).Delete(&database.UserToken{}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		token, err = issueBotToken(tx, app.BotUserID)
+		return err
+	})
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to reset token", http.StatusInternalServerError)
+		return
+	}
+
+	// connections made with the old token have to re-identify
+	websocket.DisconnectUser(app.BotUserID, "bot token reset")
+
+	response := toOwnerApplicationResponse(app)
+	response.BotToken = token
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func resetClientSecret(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireHuman(w, r)
+	if !ok {
+		return
+	}
+
+	app, ok := loadOwnedApplication(w, r, user)
+	if !ok {
+		return
+	}
+
+	secret, err := oauth2.GenerateSecret()
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	app.OAuth2SecretHash = oauth2.Hash(secret)
+	if err := database.DB.Model(&database.Application{}).Where("id = ?", app.ID).Update("oauth2_secret_hash", app.OAuth2SecretHash).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to reset secret", http.StatusInternalServerError)
+		return
+	}
+
+	response := toOwnerApplicationResponse(app)
+	response.ClientSecret = secret
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// getAuthorization returns what the authorize screen needs: the application and the servers it can be added to
+func getAuthorization(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireHuman(w, r)
+	if !ok {
+		return
+	}
+
+	app, ok := loadAuthorizableApplication(w, r, user)
+	if !ok {
+		return
+	}
+
+	var servers []database.Server
+	err := database.DB.
+		Where("owner_id = ?", user.ID).
+		Where("id NOT IN (?)", database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", app.BotUserID)).
+		Order("name ASC").
+		Find(&servers).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch servers", http.StatusInternalServerError)
+		return
+	}
+
+	serverList := make([]serverBrief, 0, len(servers))
+	for _, s := range servers {
+		serverList = append(serverList, serverBrief{ID: s.ID.String(), Name: s.Name, Icon: s.Icon})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"application": toApplicationResponse(app),
+		"servers":     serverList,
+	})
+}
+
+func authorizeApplication(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireHuman(w, r)
+	if !ok {
+		return
+	}
+
+	app, ok := loadAuthorizableApplication(w, r, user)
+	if !ok {
+		return
+	}
+
+	var body authorizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	serverID, err := uuid.FromString(body.ServerID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	var server database.Server
+	if err := database.DB.Where("id = ?", serverID).First(&server).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "server not found", http.StatusNotFound)
+		return
+	}
+
+	// only the owner can add bots for now
+	if server.OwnerID != user.ID {
+		httpresponder.SendErrorResponse(w, r, "you do not have permission to add bots to this server", http.StatusForbidden)
+		return
+	}
+
+	var existing int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", serverID, app.BotUserID).Count(&existing)
+	if existing > 0 {
+		httpresponder.SendErrorResponse(w, r, "bot is already in this server", http.StatusConflict)
+		return
+	}
+
+	member := database.ServerMember{
+		ServerID: serverID,
+		UserID:   app.BotUserID,
+		JoinedAt: time.Now(),
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&member).Error; err != nil {
+			return err
+		}
+		return membercount.Members(tx, serverID, 1)
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to add bot", http.StatusInternalServerError)
+		return
+	}
+
+	// connected bot sessions start receiving the server's events right away
+	if hub := websocket.GetHub(); hub != nil {
+		for _, client := range hub.GetUserClients(app.BotUserID) {
+			hub.SubscribeToServer(client, serverID)
+		}
+	}
+
+	websocket.NotifyServerMemberJoin(serverID, websocket.UserBrief{
+		ID:            app.BotUser.ID,
+		Username:      app.BotUser.Username,
+		Domain:        app.BotUser.Domain,
+		ProfilePicURL: app.BotUser.ProfilePicURL,
+		Bot:           true,
+	})
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"server": serverBrief{ID: server.ID.String(), Name: server.Name, Icon: server.Icon},
+		"bot":    toBotBrief(&app.BotUser),
+	})
+}
+
+// helpers
+
+// requireHuman loads the current user and refuses bots, they cant manage applications
+func requireHuman(w http.ResponseWriter, r *http.Request) (*database.User, bool) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if user.IsBot {
+		httpresponder.SendErrorResponse(w, r, "bots cannot use this endpoint", http.StatusForbidden)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func loadApplication(w http.ResponseWriter, r *http.Request) (*database.Application, bool) {
+	appID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid application id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var app database.Application
+	if err := database.DB.Preload("BotUser").Where("id = ?", appID).First(&app).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "application not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &app, true
+}
+
+func loadOwnedApplication(w http.ResponseWriter, r *http.Request, user *database.User) (*database.Application, bool) {
+	app, ok := loadApplication(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if app.OwnerID != user.ID {
+		// same response as a missing one so ids cant be probed
+		httpresponder.SendErrorResponse(w, r, "application not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return app, true
+}
+
+// loadAuthorizableApplication loads an application the user is allowed to add to servers
+func loadAuthorizableApplication(w http.ResponseWriter, r *http.Request, user *database.User) (*database.Application, bool) {
+	app, ok := loadApplication(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if !app.IsPublic && app.OwnerID != user.ID {
+		httpresponder.SendErrorResponse(w, r, "application not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return app, true
+}
+
+func issueBotToken(tx *gorm.DB, botUserID uuid.UUID) (string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	userToken := database.UserToken{
+		UserID:    botUserID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(botTokenLifetime).Unix(),
+	}
+
+	if err := tx.Create(&userToken).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func toBotBrief(u *database.User) botBrief {
+	return botBrief{
+		ID:       u.ID.String(),
+		Username: u.Username,
+		Domain:   u.Domain,
+	}
+}
+
+func toApplicationResponse(app *database.Application) applicationResponse {
+	return applicationResponse{
+		ID:          app.ID.String(),
+		Name:        app.Name,
+		Description: app.Description,
+		Icon:        app.Icon,
+		IsPublic:    app.IsPublic,
+		OwnerID:     app.OwnerID.String(),
+		Bot:         toBotBrief(&app.BotUser),
+		CreatedAt:   app.CreatedAt,
+
+		ActivityVerified: app.ActivityVerifiedAt != nil,
+	}
+}
+
+// toOwnerApplicationResponse includes the fields only the owner may see
+func toOwnerApplicationResponse(app *database.Application) applicationResponse {
+	response := toApplicationResponse(app)
+	response.InteractionsURL = app.InteractionsURL
+	response.InteractionsSecret = app.InteractionsSecret
+	response.OAuth2Enabled = app.OAuth2SecretHash != ""
+	if app.RedirectURIs != "" {
+		response.RedirectURIs = strings.Split(app.RedirectURIs, "\n")
+	}
+	return response
+}
+
+// publicHost reports whether the url isnt an internal address written out, e.g localhost or 169.254.169.254
+func publicHost(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return publicnet.IsPublic(ip)
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package applicationroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/activity"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+)
+
+// applications can reference this many images in activities
+const maxAssets = 150
+
+var assetKeyPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+type assetResponse struct {
+	Key       string    `json:"key"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type putAssetRequest struct {
+	URL string `json:"url"`
+}
+
+func registerAssetRoutes(r chi.Router) {
+	r.Get("/assets", listAssets)
+	r.Put("/assets/{key}", putAsset)
+	r.Delete("/assets/{key}", deleteAsset)
+}
+
+func listAssets(w http.ResponseWriter, r *http.Request) {
+	app, ok := loadManageableApplication(w, r)
+	if !ok {
+		return
+	}
+
+	var assets []database.ApplicationAsset
+	if err := database.DB.Where("application_id = ?", app.ID).Order("`key` ASC").Find(&assets).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch assets", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]assetResponse, 0, len(assets))
+	for _, asset := range assets {
+		response = append(response, toAssetResponse(&asset))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func putAsset(w http.ResponseWriter, r *http.Request) {
+	app, ok := loadManageableApplication(w, r)
+	if !ok {
+		return
+	}
+
+	key := chi.URLParam(r, "key")
+	if !assetKeyPattern.MatchString(key) {
+		httpresponder.SendErrorResponse(w, r, "asset keys are 1-32 lowercase letters, digits, _ or -", http.StatusBadRequest)
+		return
+	}
+
+	var body putAssetRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := url.Parse(body.URL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || len(body.URL) > 255 {
+		httpresponder.SendErrorResponse(w, r, "url must be an https url of at most 255 characters", http.StatusBadRequest)
+		return
+	}
+
+	var asset database.ApplicationAsset
+	err = database.DB.Where("application_id = ? AND `key` = ?", app.ID, key).First(&asset).Error
+	if err != nil {
+		var count int64
+		database.DB.Model(&database.ApplicationAsset{}).Where("application_id = ?", app.ID).Count(&count)
+		if count >= maxAssets {
+			httpresponder.SendErrorResponse(w, r, "asset limit reached", http.StatusBadRequest)
+			return
+		}
+		asset = database.ApplicationAsset{ApplicationID: app.ID, Key: key}
+	}
+
+	asset.URL = body.URL
+	if err := database.DB.Save(&asset).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to save asset", http.StatusInternalServerError)
+		return
+	}
+
+	activity.Invalidate(app.ID)
+
+	httpresponder.SendSuccessResponse(w, r, toAssetResponse(&asset))
+}
+
+func deleteAsset(w http.ResponseWriter, r *http.Request) {
+	app, ok := loadManageableApplication(w, r)
+	if !ok {
+		return
+	}
+
+	// hard delete so the key can be reused
+	result := database.DB.Unscoped().Where("application_id = ? AND `key` = ?", app.ID, chi.URLParam(r, "key")).Delete(&database.ApplicationAsset{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete asset", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "asset not found", http.StatusNotFound)
+		return
+	}
+
+	activity.Invalidate(app.ID)
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+func toAssetResponse(asset *database.ApplicationAsset) assetResponse {
+	return assetResponse{
+		Key:       asset.Key,
+		URL:       asset.URL,
+		CreatedAt: asset.CreatedAt,
+	}
+}
@@ -0,0 +1,235 @@
+package applicationroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	"github.com/hindsightchat/backend/src/lib/commands"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	uuid "github.com/satori/go.uuid"
+)
+
+type commandResponse struct {
+	ID            string            `json:"id"`
+	ApplicationID string            `json:"application_id"`
+	ServerID      *string           `json:"server_id,omitempty"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	Options       []commands.Option `json:"options"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+type upsertCommandRequest struct {
+	ServerID    *string           `json:"server_id"` // omit for a global command
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Options     []commands.Option `json:"options"`
+}
+
+func registerCommandRoutes(r chi.Router) {
+	r.Get("/commands", listCommands)
+	// creating a command with an existing name overwrites it
+	r.Post("/commands", upsertCommand)
+	r.Delete("/commands/{commandId}", deleteCommand)
+}
+
+func listCommands(w http.ResponseWriter, r *http.Request) {
+	app, ok := loadManageableApplication(w, r)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Where("application_id = ?", app.ID)
+	if serverIDStr := r.URL.Query().Get("server_id"); serverIDStr != "" {
+		serverID, err := uuid.FromString(serverIDStr)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("server_id = ?", serverID)
+	}
+
+	var cmds []database.ApplicationCommand
+	if err := query.Order("name ASC").Find(&cmds).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch commands", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]commandResponse, 0, len(cmds))
+	for _, cmd := range cmds {
+		response = append(response, toCommandResponse(&cmd))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func upsertCommand(w http.ResponseWriter, r *http.Request) {
+	app, ok := loadManageableApplication(w, r)
+	if !ok {
+		return
+	}
+
+	var body upsertCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.Options == nil {
+		body.Options = []commands.Option{}
+	}
+
+	if err := commands.Validate(body.Name, body.Description, body.Options); err != nil {
+		httpresponder.SendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var serverID *uuid.UUID
+	if body.ServerID != nil {
+		id, err := uuid.FromString(*body.ServerID)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+			return
+		}
+
+		// server commands only make sense where the bot is
+		var count int64
+		database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", id, app.BotUserID).Count(&count)
+		if count == 0 {
+			httpresponder.SendErrorResponse(w, r, "bot is not in this server", http.StatusForbidden)
+			return
+		}
+
+		serverID = &id
+	}
+
+	optionsJSON, err := json.Marshal(body.Options)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid options", http.StatusBadRequest)
+		return
+	}
+
+	var cmd database.ApplicationCommand
+	query := database.DB.Where("application_id = ? AND name = ?", app.ID, body.Name)
+	if serverID != nil {
+		query = query.Where("server_id = ?", *serverID)
+	} else {
+		query = query.Where("server_id IS NULL")
+	}
+
+	if err := query.First(&cmd).Error; err == nil {
+		err = database.DB.Model(&cmd).Updates(map[string]any{
+			"description": body.Description,
+			"options":     string(optionsJSON),
+		}).Error
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update command", http.StatusInternalServerError)
+			return
+		}
+		cmd.Description = body.Description
+		cmd.Options = string(optionsJSON)
+	} else {
+		cmd = database.ApplicationCommand{
+			ApplicationID: app.ID,
+			ServerID:      serverID,
+			Name:          body.Name,
+			Description:   body.Description,
+			Options:       string(optionsJSON),
+		}
+
+		// cap per scope so a runaway bot cant flood the command picker
+		var existing int64
+		countQuery := database.DB.Model(&database.ApplicationCommand{}).Where("application_id = ?", app.ID)
+		if serverID != nil {
+			countQuery = countQuery.Where("server_id = ?", *serverID)
+		} else {
+			countQuery = countQuery.Where("server_id IS NULL")
+		}
+		countQuery.Count(&existing)
+		if existing >= 100 {
+			httpresponder.SendErrorResponse(w, r, "too many commands", http.StatusBadRequest)
+			return
+		}
+
+		if err := database.DB.Create(&cmd).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to create command", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toCommandResponse(&cmd))
+}
+
+func deleteCommand(w http.ResponseWriter, r *http.Request) {
+	app, ok := loadManageableApplication(w, r)
+	if !ok {
+		return
+	}
+
+	commandID, err := uuid.FromString(chi.URLParam(r, "commandId"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid command id", http.StatusBadRequest)
+		return
+	}
+
+	// hard delete so the name can be registered again
+	result := database.DB.Unscoped().Where("id = ? AND application_id = ?", commandID, app.ID).Delete(&database.ApplicationCommand{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete command", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "command not found", http.StatusNotFound)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// loadManageableApplication allows both the owner and the application's own bot
+func loadManageableApplication(w http.ResponseWriter, r *http.Request) (*database.Application, bool) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	app, ok := loadApplication(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if app.OwnerID != user.ID && app.BotUserID != user.ID {
+		httpresponder.SendErrorResponse(w, r, "application not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return app, true
+}
+
+func toCommandResponse(cmd *database.ApplicationCommand) commandResponse {
+	var serverID *string
+	if cmd.ServerID != nil {
+		s := cmd.ServerID.String()
+		serverID = &s
+	}
+
+	options := []commands.Option{}
+	if cmd.Options != "" {
+		json.Unmarshal([]byte(cmd.Options), &options)
+	}
+
+	return commandResponse{
+		ID:            cmd.ID.String(),
+		ApplicationID: cmd.ApplicationID.String(),
+		ServerID:      serverID,
+		Name:          cmd.Name,
+		Description:   cmd.Description,
+		Options:       options,
+		UpdatedAt:     cmd.UpdatedAt,
+	}
+}
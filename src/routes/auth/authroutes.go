@@ -3,12 +3,21 @@ package authroutes
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/hindsightchat/backend/src/lib/authhelper"
+	"github.com/hindsightchat/backend/src/lib/consent"
 	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
 	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/ipban"
+	"github.com/hindsightchat/backend/src/lib/nameblock"
+	"github.com/hindsightchat/backend/src/lib/ratelimit"
+	"github.com/hindsightchat/backend/src/lib/recovery"
+	"github.com/hindsightchat/backend/src/lib/securitylog"
+	"github.com/hindsightchat/backend/src/middleware"
 	uuid "github.com/satori/go.uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -16,13 +25,21 @@ import (
 
 type loginRequest struct {
 	Email    string `json:"email"`
+	Handle   string `json:"handle"` // alternative to email, e.g robbie@hindsight.chat or robbie.hindsight.chat
 	Password string `json:"password"`
+	// a one time recovery code instead of the password, it is used up by logging in
+	RecoveryCode string `json:"recovery_code"`
 }
 
+// compared against when no account matches, so unknown logins take as long as wrong passwords
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("hindsight-dummy-password"), bcrypt.DefaultCost)
+
 type RegisterRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Email    string `json:"email"`
+	// required when the instance has terms configured, accepts the current versions
+	AcceptTerms bool `json:"acceptTerms"`
 }
 
 type simpleUser struct {
@@ -91,7 +108,7 @@ func RegisterRoutes(r chi.Router) {
 
 		})
 
-		r.Post("/login", func(w http.ResponseWriter, r *http.Request) {
+		r.With(middleware.RateLimit(ratelimit.Auth)).Post("/login", func(w http.ResponseWriter, r *http.Request) {
 			authToken, ok := r.Context().Value("authToken").(string)
 
 			if ok && authToken != "" {
@@ -99,6 +116,11 @@ func RegisterRoutes(r chi.Router) {
 				return
 			}
 
+			if banned, reason := ipban.IsRequestBanned(r); banned {
+				httpresponder.SendErrorResponse(w, r, ipban.RejectionMessage(reason), http.StatusForbidden)
+				return
+			}
+
 			var body loginRequest
 			err := json.NewDecoder(r.Body).Decode(&body)
 
@@ -107,27 +129,61 @@ func RegisterRoutes(r chi.Router) {
 				return
 			}
 
-			if body.Email == "" || body.Password == "" {
-				httpresponder.SendErrorResponse(w, r, "Email and password are required", http.StatusBadRequest)
+			if (body.Email == "" && body.Handle == "") || (body.Password == "" && body.RecoveryCode == "") {
+				httpresponder.SendErrorResponse(w, r, "Email or handle and password or recovery code are required", http.StatusBadRequest)
 				return
 			}
 
-			user, err := gorm.G[database.User](database.DB).Where("email = ?", body.Email).First(r.Context())
+			// the message only depends on what was sent, never on which account exists
+			invalidMessage := "Invalid email or password"
+			query := database.DB.WithContext(r.Context()).Where("email = ?", body.Email)
+			if body.Email == "" {
+				invalidMessage = "Invalid handle or password"
+				query = database.DB.WithContext(r.Context()).Scopes(database.ByHandle(body.Handle))
+			}
+
+			var user database.User
+			err = query.First(&user).Error
 
-			if err != nil {
-				// invalid email
-				httpresponder.SendErrorResponse(w, r, "Invalid email or password", http.StatusUnauthorized)
-				return
+			passwordHash := dummyPasswordHash
+			if err == nil {
+				passwordHash = []byte(user.Password)
 			}
 
-			err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password))
+			// compared even without an account, so timing doesnt reveal which logins exist
+			usingCode := body.Password == ""
+			if usingCode {
+				passwordHash = dummyPasswordHash
+			}
+			valid := bcrypt.CompareHashAndPassword(passwordHash, []byte(body.Password)) == nil && err == nil
+
+			if usingCode && err == nil {
+				check := recovery.Consume
+				if user.DisabledAt != nil {
+					// the login is refused anyway, the code is only checked so it isnt spent for nothing
+					check = recovery.Valid
+				}
+				valid, err = check(user.ID, body.RecoveryCode)
+				if err != nil {
+					httpresponder.SendErrorResponse(w, r, "Failed to check recovery code", http.StatusInternalServerError)
+					return
+				}
+			}
 
-			if err != nil {
-				// invalid password
-				httpresponder.SendErrorResponse(w, r, "Invalid email or password", http.StatusUnauthorized)
+			if !valid {
+				httpresponder.SendErrorResponse(w, r, invalidMessage, http.StatusUnauthorized)
 				return
 			}
 
+			if user.DisabledAt != nil {
+				httpresponder.SendErrorResponse(w, r, "This account has been disabled", http.StatusForbidden)
+				return
+			}
+
+			if usingCode {
+				securitylog.Record(r, user.ID, securitylog.ActionRecoveryCodeUsed)
+			}
+
 			// create auth token and save to database
 
 			token := uuid.NewV4()
@@ -135,7 +191,8 @@ func RegisterRoutes(r chi.Router) {
 			userToken := database.UserToken{
 				UserID:    user.ID,
 				Token:     token.String(),
-				ExpiresAt: time.Now().Add(7 * 24 * time.Hour).Unix(), // expires in 7 days
+				ExpiresAt: time.Now().Add(instance.SessionLifetime).Unix(),
+				IP:        ipban.ClientIP(r),
 			}
 
 			err = gorm.G[database.UserToken](database.DB).Create(r.Context(), &userToken)
@@ -168,7 +225,7 @@ func RegisterRoutes(r chi.Router) {
 			httpresponder.SendSuccessResponse(w, r, returnUser)
 		})
 
-		r.Post("/register", func(w http.ResponseWriter, r *http.Request) {
+		r.With(middleware.RateLimit(ratelimit.Auth)).Post("/register", func(w http.ResponseWriter, r *http.Request) {
 			// check authToken
 			authToken, ok := r.Context().Value("authToken").(string)
 
@@ -177,6 +234,16 @@ func RegisterRoutes(r chi.Router) {
 				return
 			}
 
+			if banned, reason := ipban.IsRequestBanned(r); banned {
+				httpresponder.SendErrorResponse(w, r, ipban.RejectionMessage(reason), http.StatusForbidden)
+				return
+			}
+
+			if !instance.RegistrationAllowed() {
+				httpresponder.SendErrorResponse(w, r, "Registration is closed on this instance", http.StatusForbidden)
+				return
+			}
+
 			var body RegisterRequest
 			err := json.NewDecoder(r.Body).Decode(&body)
 
@@ -185,26 +252,35 @@ func RegisterRoutes(r chi.Router) {
 				return
 			}
 
-			// domain will be defaulted to hindsight.chat for now
-			domain := "hindsight.chat"
+			// domain will be defaulted to the instance domain for now
+			domain := instance.Domain
 
 			if body.Username == "" || body.Password == "" || body.Email == "" {
 				httpresponder.SendErrorResponse(w, r, "Username, password, and email are required", http.StatusBadRequest)
 				return
 			}
 
-			// check if valid domain format e.g has no spaces and only contains letters, numbers, and hyphens and a .
+			// the handle is written before an @ and before the first dot of the username, neither may be ambiguous.
+			// checked normalized too since fullwidth dots and @s fold into plain ones
+			if len(body.Username) > 50 || strings.ContainsAny(body.Username+database.NormalizeHandle(body.Username), "@. ") {
+				httpresponder.SendErrorResponse(w, r, "Username must be at most 50 characters without @, dots or spaces", http.StatusBadRequest)
+				return
+			}
 
-			if !isValidDomain(domain) {
-				httpresponder.SendErrorResponse(w, r, "Invalid domain format", http.StatusBadRequest)
+			if err := nameblock.Check(body.Username); err != nil {
+				httpresponder.SendErrorResponseWithDetails(w, r, err.Error(), http.StatusBadRequest, map[string]string{"field": "username", "reason": nameblock.Reason(err)})
+				return
+			}
+
+			if consent.Enforced() && !body.AcceptTerms {
+				httpresponder.SendErrorResponse(w, r, "You must accept the terms of service", http.StatusBadRequest)
 				return
 			}
 
-			// check if email already exists
+			// check if valid domain format e.g has no spaces and only contains letters, numbers, and hyphens and a .
 
-			realuser, err := gorm.G[database.User](database.DB).Where("email = ? OR username = ?", body.Email, body.Username+"."+domain).First(r.Context())
-			if err == nil && realuser.ID != uuid.Nil {
-				httpresponder.SendErrorResponse(w, r, "Email or username already in use", http.StatusBadRequest)
+			if !isValidDomain(domain) {
+				httpresponder.SendErrorResponse(w, r, "Invalid domain format", http.StatusBadRequest)
 				return
 			}
 
@@ -216,17 +292,37 @@ func RegisterRoutes(r chi.Router) {
 			}
 
 			user := database.User{
-				Username:         body.Username + "." + domain, // username will be username.domain so e.g "robbie.hindsig.ht"
+				Handle:           body.Username,
+				Username:         database.FullUsername(body.Username, domain), // e.g "robbie.hindsig.ht"
 				Password:         string(hashedPassword),
 				Email:            body.Email,
 				Domain:           domain,
 				IsDomainVerified: true, // default true since this is our domain
+				RegistrationIP:   ipban.ClientIP(r),
 			}
 
-			err = gorm.G[database.User](database.DB).Create(r.Context(), &user)
+			// the unique indexes decide who gets an email or username, checking first would race
+			err = database.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&user).Error; err != nil {
+					return err
+				}
+				if body.AcceptTerms {
+					return consent.Accept(tx, user.ID, ipban.ClientIP(r))
+				}
+				return nil
+			})
+
+			if key, ok := database.DuplicateKey(err); ok {
+				field, message := "username", "Username already in use"
+				if key == "idx_users_email" {
+					field, message = "email", "Email already in use"
+				}
+				httpresponder.SendErrorResponseWithDetails(w, r, message, http.StatusConflict, map[string]string{"field": field})
+				return
+			}
 
 			if err != nil {
-				httpresponder.SendErrorResponse(w, r, "Failed to create user: "+err.Error(), http.StatusInternalServerError)
+				httpresponder.SendErrorResponse(w, r, "Failed to create user", http.StatusInternalServerError)
 				return
 			}
 
@@ -237,7 +333,8 @@ func RegisterRoutes(r chi.Router) {
 			userToken := database.UserToken{
 				UserID:    user.ID,
 				Token:     token.String(),
-				ExpiresAt: time.Now().Add(7 * 24 * time.Hour).Unix(), // expires in 7 days
+				ExpiresAt: time.Now().Add(instance.SessionLifetime).Unix(),
+				IP:        ipban.ClientIP(r),
 			}
 
 			err = gorm.G[database.UserToken](database.DB).Create(r.Context(), &userToken)
@@ -0,0 +1,508 @@
+package bridgeroutes
+
+// api for bridges mirroring channels to other networks (matrix, irc...).
+// bridges are regular application bots, messages they relay keep the remote id and author
+// so edits / deletes can be mirrored and the same remote event is never posted twice
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/imageproxy"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/msgcount"
+	"github.com/hindsightchat/backend/src/middleware"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm/clause"
+)
+
+var protocolPattern = regexp.MustCompile(`^[a-z0-9_-]{1,16}$`)
+
+type puppetResponse struct {
+	ID          string    `json:"id"`
+	Protocol    string    `json:"protocol"`
+	RemoteID    string    `json:"remote_id"`
+	DisplayName string    `json:"display_name"`
+	Avatar      string    `json:"avatar,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type upsertPuppetRequest struct {
+	Protocol    string `json:"protocol"`
+	RemoteID    string `json:"remote_id"`
+	DisplayName string `json:"display_name"`
+	Avatar      string `json:"avatar"`
+}
+
+type relayMessageRequest struct {
+	Protocol  string `json:"protocol"`
+	RemoteID  string `json:"remote_id"` // dedupe key, e.g the matrix event id
+	PuppetID  string `json:"puppet_id"` // optional, the bridge posts as itself without one
+	Content   string `json:"content"`
+	ReplyToID string `json:"reply_to_id"`
+	// alternative to reply_to_id for replies to other relayed messages
+	ReplyToRemoteID string `json:"reply_to_remote_id"`
+}
+
+type editMessageRequest struct {
+	RemoteID string `json:"remote_id"`
+	Content  string `json:"content"`
+}
+
+type bridgedMessageResponse struct {
+	ID        string    `json:"id"`
+	ChannelID string    `json:"channel_id"`
+	RemoteID  string    `json:"remote_id"`
+	PuppetID  *string   `json:"puppet_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// true when the remote event was already relayed and nothing was posted
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+func RegisterRoutes(r chi.Router) {
+	r.Route("/bridges", func(r chi.Router) {
+		r.Use(middleware.RouteRequiresAuthentication)
+
+		r.Get("/puppets", listPuppets)
+		r.Put("/puppets", upsertPuppet)
+		r.Delete("/puppets/{id}", deletePuppet)
+
+		r.Route("/channels/{channelID}/messages", func(r chi.Router) {
+			// look up a relayed message by its remote id (?remote_id=)
+			r.Get("/", getBridgedMessage)
+			r.Post("/", relayMessage)
+			r.Patch("/", editBridgedMessage)
+			r.Delete("/", deleteBridgedMessage)
+		})
+	})
+}
+
+func listPuppets(w http.ResponseWriter, r *http.Request) {
+	_, app, ok := requireBridge(w, r)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Where("application_id = ?", app.ID)
+	if protocol := r.URL.Query().Get("protocol"); protocol != "" {
+		query = query.Where("protocol = ?", protocol)
+	}
+
+	var puppets []database.BridgePuppet
+	if err := query.Order("created_at ASC").Find(&puppets).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch puppets", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]puppetResponse, 0, len(puppets))
+	for _, p := range puppets {
+		response = append(response, toPuppetResponse(&p))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// upsertPuppet creates the puppet for a remote user or updates its name / avatar
+func upsertPuppet(w http.ResponseWriter, r *http.Request) {
+	_, app, ok := requireBridge(w, r)
+	if !ok {
+		return
+	}
+
+	var body upsertPuppetRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	body.DisplayName = strings.TrimSpace(body.DisplayName)
+	switch {
+	case !protocolPattern.MatchString(body.Protocol):
+		httpresponder.SendErrorResponse(w, r, "invalid protocol", http.StatusBadRequest)
+		return
+	case body.RemoteID == "" || len(body.RemoteID) > 255:
+		httpresponder.SendErrorResponse(w, r, "remote_id must be between 1 and 255 characters", http.StatusBadRequest)
+		return
+	case body.DisplayName == "" || len(body.DisplayName) > 80:
+		httpresponder.SendErrorResponse(w, r, "display_name must be between 1 and 80 characters", http.StatusBadRequest)
+		return
+	case len(body.Avatar) > 255:
+		httpresponder.SendErrorResponse(w, r, "avatar url too long", http.StatusBadRequest)
+		return
+	}
+
+	puppet := database.BridgePuppet{
+		ApplicationID: app.ID,
+		Protocol:      body.Protocol,
+		RemoteID:      body.RemoteID,
+		DisplayName:   body.DisplayName,
+		Avatar:        body.Avatar,
+	}
+
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "application_id"}, {Name: "protocol"}, {Name: "remote_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"display_name", "avatar", "updated_at"}),
+	}).Create(&puppet).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to save puppet", http.StatusInternalServerError)
+		return
+	}
+
+	// the id generated for the insert is discarded when an existing row was updated
+	if err := database.DB.Where("application_id = ? AND protocol = ? AND remote_id = ?", app.ID, body.Protocol, body.RemoteID).First(&puppet).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to load puppet", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toPuppetResponse(&puppet))
+}
+
+func deletePuppet(w http.ResponseWriter, r *http.Request) {
+	_, app, ok := requireBridge(w, r)
+	if !ok {
+		return
+	}
+
+	puppetID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid puppet id", http.StatusBadRequest)
+		return
+	}
+
+	// hard delete so the remote user can be puppeted again
+	result := database.DB.Unscoped().Where("id = ? AND application_id = ?", puppetID, app.ID).Delete(&database.BridgePuppet{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete puppet", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "puppet not found", http.StatusNotFound)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+func getBridgedMessage(w http.ResponseWriter, r *http.Request) {
+	bot, _, ok := requireBridge(w, r)
+	if !ok {
+		return
+	}
+
+	channel, ok := loadBridgedChannel(w, r, bot.ID)
+	if !ok {
+		return
+	}
+
+	msg, ok := loadByRemoteID(w, r, channel.ID, bot.ID, r.URL.Query().Get("remote_id"))
+	if !ok {
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toBridgedMessageResponse(msg))
+}
+
+func relayMessage(w http.ResponseWriter, r *http.Request) {
+	bot, app, ok := requireBridge(w, r)
+	if !ok {
+		return
+	}
+
+	var body relayMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case !protocolPattern.MatchString(body.Protocol):
+		httpresponder.SendErrorResponse(w, r, "invalid protocol", http.StatusBadRequest)
+		return
+	case body.RemoteID == "" || len(body.RemoteID) > 255:
+		httpresponder.SendErrorResponse(w, r, "remote_id must be between 1 and 255 characters", http.StatusBadRequest)
+		return
+	case body.Content == "":
+		httpresponder.SendErrorResponse(w, r, "content is required", http.StatusBadRequest)
+		return
+	}
+	if quota := instance.CheckMessageLength(body.Content, false); quota != nil {
+		httpresponder.SendErrorResponseWithDetails(w, r, "content is too long", http.StatusBadRequest, quota)
+		return
+	}
+
+	channel, ok := loadBridgedChannel(w, r, bot.ID)
+	if !ok {
+		return
+	}
+
+	// redelivery of something already relayed, hand back the original
+	var existing database.ChannelMessage
+	if err := database.DB.Where("author_id = ? AND remote_id = ?", bot.ID, body.RemoteID).First(&existing).Error; err == nil {
+		response := toBridgedMessageResponse(&existing)
+		response.Duplicate = true
+		httpresponder.SendSuccessResponse(w, r, response)
+		return
+	}
+
+	author := &websocket.UserBrief{
+		ID:            bot.ID,
+		Username:      bot.Username,
+		Domain:        bot.Domain,
+		ProfilePicURL: bot.ProfilePicURL,
+		Bot:           true,
+	}
+
+	var puppetID *uuid.UUID
+	if body.PuppetID != "" {
+		id, err := uuid.FromString(body.PuppetID)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid puppet id", http.StatusBadRequest)
+			return
+		}
+
+		var puppet database.BridgePuppet
+		if err := database.DB.Where("id = ? AND application_id = ?", id, app.ID).First(&puppet).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "puppet not found", http.StatusNotFound)
+			return
+		}
+
+		puppetID = &puppet.ID
+		// shown as the remote user, the bot flag and bridge info keep it from passing as a local account
+		author = &websocket.UserBrief{
+			ID:            puppet.ID,
+			Username:      puppet.DisplayName,
+			Domain:        puppet.Protocol,
+			ProfilePicURL: imageproxy.URL(puppet.Avatar),
+			Bot:           true,
+		}
+	}
+
+	var replyToID *uuid.UUID
+	switch {
+	case body.ReplyToID != "":
+		id, err := uuid.FromString(body.ReplyToID)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid reply_to_id", http.StatusBadRequest)
+			return
+		}
+		replyToID = &id
+	case body.ReplyToRemoteID != "":
+		var parent database.ChannelMessage
+		if err := database.DB.Where("channel_id = ? AND author_id = ? AND remote_id = ?", channel.ID, bot.ID, body.ReplyToRemoteID).First(&parent).Error; err == nil {
+			replyToID = &parent.ID
+		}
+	}
+
+	if replyToID != nil {
+		var count int64
+		database.DB.Model(&database.ChannelMessage{}).Where("id = ? AND channel_id = ?", replyToID, channel.ID).Count(&count)
+		if count == 0 {
+			httpresponder.SendErrorResponse(w, r, "reply target not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	remoteID := body.RemoteID
+	msg := database.ChannelMessage{
+		ChannelID:      channel.ID,
+		AuthorID:       bot.ID,
+		Content:        body.Content,
+		Attachments:    "[]",
+		ReplyToID:      replyToID,
+		RemoteProtocol: body.Protocol,
+		RemoteID:       &remoteID,
+		PuppetID:       puppetID,
+	}
+
+	if err := msgcount.CreateChannelMessage(&msg); err != nil {
+		// lost a race with a concurrent delivery of the same event
+		if err := database.DB.Where("author_id = ? AND remote_id = ?", bot.ID, remoteID).First(&existing).Error; err == nil {
+			response := toBridgedMessageResponse(&existing)
+			response.Duplicate = true
+			httpresponder.SendSuccessResponse(w, r, response)
+			return
+		}
+		httpresponder.SendErrorResponse(w, r, "failed to create message", http.StatusInternalServerError)
+		return
+	}
+
+	websocket.PublishChannelMessage(channel.ServerID, &msg, author)
+
+	httpresponder.SendSuccessResponse(w, r, toBridgedMessageResponse(&msg))
+}
+
+func editBridgedMessage(w http.ResponseWriter, r *http.Request) {
+	bot, _, ok := requireBridge(w, r)
+	if !ok {
+		return
+	}
+
+	var body editMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Content == "" {
+		httpresponder.SendErrorResponse(w, r, "content is required", http.StatusBadRequest)
+		return
+	}
+	if quota := instance.CheckMessageLength(body.Content, false); quota != nil {
+		httpresponder.SendErrorResponseWithDetails(w, r, "content is too long", http.StatusBadRequest, quota)
+		return
+	}
+
+	channel, ok := loadBridgedChannel(w, r, bot.ID)
+	if !ok {
+		return
+	}
+
+	msg, ok := loadByRemoteID(w, r, channel.ID, bot.ID, body.RemoteID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if err := database.DB.Model(msg).Updates(map[string]any{"content": body.Content, "edited_at": now}).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to edit message", http.StatusInternalServerError)
+		return
+	}
+
+	websocket.NotifyChannelMessageUpdate(channel.ServerID, websocket.ChannelMessagePayload{
+		ID:        msg.ID,
+		ChannelID: channel.ID,
+		ServerID:  channel.ServerID,
+		AuthorID:  bot.ID,
+		Content:   body.Content,
+		EditedAt:  &now,
+		Bridge:    &websocket.BridgeInfo{Protocol: msg.RemoteProtocol, RemoteID: body.RemoteID, PuppetID: msg.PuppetID},
+	})
+
+	httpresponder.SendSuccessResponse(w, r, toBridgedMessageResponse(msg))
+}
+
+func deleteBridgedMessage(w http.ResponseWriter, r *http.Request) {
+	bot, _, ok := requireBridge(w, r)
+	if !ok {
+		return
+	}
+
+	channel, ok := loadBridgedChannel(w, r, bot.ID)
+	if !ok {
+		return
+	}
+
+	msg, ok := loadByRemoteID(w, r, channel.ID, bot.ID, r.URL.Query().Get("remote_id"))
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Delete(msg).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete message", http.StatusInternalServerError)
+		return
+	}
+	msgcount.Deleted(channel.ID, 1)
+
+	websocket.NotifyChannelMessageDelete(channel.ServerID, websocket.MessageDeletePayload{
+		MessageID: msg.ID,
+		ChannelID: &channel.ID,
+		ServerID:  &channel.ServerID,
+	})
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// requireBridge returns the calling bot and its application, only bots can bridge
+func requireBridge(w http.ResponseWriter, r *http.Request) (*database.User, *database.Application, bool) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	if !user.IsBot {
+		httpresponder.SendErrorResponse(w, r, "only bots can use the bridge api", http.StatusForbidden)
+		return nil, nil, false
+	}
+
+	var app database.Application
+	if err := database.DB.Where("bot_user_id = ?", user.ID).First(&app).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "application not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	return user, &app, true
+}
+
+// loadBridgedChannel loads the channel from the url, the bot has to be in its server
+func loadBridgedChannel(w http.ResponseWriter, r *http.Request, botID uuid.UUID) (*database.Channel, bool) {
+	channelID, err := uuid.FromString(chi.URLParam(r, "channelID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid channel id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var channel database.Channel
+	if err := database.DB.Where("id = ?", channelID).First(&channel).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	var count int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", channel.ServerID, botID).Count(&count)
+	if count == 0 {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return nil, false
+	}
+
+	return &channel, true
+}
+
+func loadByRemoteID(w http.ResponseWriter, r *http.Request, channelID, botID uuid.UUID, remoteID string) (*database.ChannelMessage, bool) {
+	if remoteID == "" {
+		httpresponder.SendErrorResponse(w, r, "remote_id is required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var msg database.ChannelMessage
+	if err := database.DB.Where("channel_id = ? AND author_id = ? AND remote_id = ?", channelID, botID, remoteID).First(&msg).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "message not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &msg, true
+}
+
+func toPuppetResponse(p *database.BridgePuppet) puppetResponse {
+	return puppetResponse{
+		ID:          p.ID.String(),
+		Protocol:    p.Protocol,
+		RemoteID:    p.RemoteID,
+		DisplayName: p.DisplayName,
+		Avatar:      p.Avatar,
+		CreatedAt:   p.CreatedAt,
+	}
+}
+
+func toBridgedMessageResponse(msg *database.ChannelMessage) bridgedMessageResponse {
+	response := bridgedMessageResponse{
+		ID:        msg.ID.String(),
+		ChannelID: msg.ChannelID.String(),
+		CreatedAt: msg.CreatedAt,
+	}
+	if msg.RemoteID != nil {
+		response.RemoteID = *msg.RemoteID
+	}
+	if msg.PuppetID != nil {
+		id := msg.PuppetID.String()
+		response.PuppetID = &id
+	}
+	return response
+}
@@ -0,0 +1,224 @@
+package conversationroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+)
+
+type conversationResponse struct {
+	ID           string                `json:"id"`
+	Name         string                `json:"name,omitempty"`
+	IsGroup      bool                  `json:"is_group"`
+	Participants []participantResponse `json:"participants"`
+	LastReadAt   *time.Time            `json:"last_read_at,omitempty"`
+	LastMessage  *messageResponse      `json:"last_message,omitempty"`
+	CreatedAt    time.Time             `json:"created_at"`
+	Hidden       bool                  `json:"hidden,omitempty"`
+	HideTyping   bool                  `json:"hide_typing"`
+
+	MessageTombstones  bool   `json:"message_tombstones"`
+	ImportedFrom       string `json:"imported_from,omitempty"`         // e.g discord, history recreated from a data export
+	UpgradedToServerID string `json:"upgraded_to_server_id,omitempty"` // the group was turned into this server
+	OwnerID            string `json:"owner_id,omitempty"`
+	AnnouncementOnly   bool   `json:"announcement_only"` // only the owner can post
+}
+
+// getConversation returns one conversation the caller participates in.
+// the last message is left out of hidden conversations that aren't unlocked in this session
+func getConversation(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	convID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return
+	}
+
+	var own database.DMParticipant
+	if err := database.DB.Preload("Conversation").Where("conversation_id = ? AND user_id = ?", convID, user.ID).First(&own).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "conversation not found", http.StatusNotFound)
+		return
+	}
+
+	var participants []database.DMParticipant
+	err = database.DB.
+		Preload("User").
+		Where("conversation_id = ?", convID).
+		Order("joined_at ASC, user_id ASC").
+		Find(&participants).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch participants", http.StatusInternalServerError)
+		return
+	}
+
+	response := conversationResponse{
+		ID:           own.Conversation.ID.String(),
+		Name:         own.Conversation.Name,
+		IsGroup:      own.Conversation.IsGroup,
+		ImportedFrom: own.Conversation.ImportedFrom,
+		Participants: make([]participantResponse, 0, len(participants)),
+		LastReadAt:   own.LastReadAt,
+		CreatedAt:    own.Conversation.CreatedAt,
+		Hidden:       own.Hidden,
+		HideTyping:   own.HideTyping,
+
+		MessageTombstones: own.Conversation.MessageTombstones,
+	}
+	if own.Conversation.UpgradedToServerID != nil {
+		response.UpgradedToServerID = own.Conversation.UpgradedToServerID.String()
+	}
+	if own.Conversation.OwnerID != nil {
+		response.OwnerID = own.Conversation.OwnerID.String()
+	}
+	response.AnnouncementOnly = own.Conversation.AnnouncementOnly
+
+	for _, p := range participants {
+		response.Participants = append(response.Participants, participantResponse{
+			ID:       p.User.ID.String(),
+			Username: p.User.Username,
+			Domain:   p.User.Domain,
+			Bot:      p.User.IsBot,
+			Admin:    p.IsAdmin,
+			JoinedAt: p.JoinedAt,
+
+			DeliveredAt: p.LastDeliveredAt,
+		})
+	}
+
+	if !own.Hidden || hiddenconv.IsUnlocked(r.Context(), hiddenconv.Session(r), convID) {
+		var last database.DirectMessage
+		err := database.DB.
+			Where("conversation_id = ?", convID).
+			Scopes(restriction.VisibleDirectMessages(user.ID)).
+			Preload("Author").
+			Order("created_at DESC").
+			First(&last).Error
+		if err == nil {
+			lastMessage := toMessageResponse(&last)
+			response.LastMessage = &lastMessage
+		}
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+type updateConversationSettingsRequest struct {
+	MessageTombstones *bool `json:"message_tombstones"`
+
+	// groups only, and only the owner may change it
+	AnnouncementOnly *bool `json:"announcement_only"`
+
+	// groups only, the owner and admins may rename
+	Name *string `json:"name"`
+
+	// only applies to the caller
+	HideTyping *bool `json:"hide_typing"`
+}
+
+// updateConversationSettings changes conversation wide settings, any participant may except for
+// announcement_only which is the owners and name which is the owners and admins. hide_typing is per participant
+func updateConversationSettings(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	convID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return
+	}
+
+	var own database.DMParticipant
+	if err := database.DB.Preload("Conversation").Where("conversation_id = ? AND user_id = ?", convID, user.ID).First(&own).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "conversation not found", http.StatusNotFound)
+		return
+	}
+
+	var body updateConversationSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	conversation := own.Conversation
+
+	if body.AnnouncementOnly != nil {
+		if !conversation.IsGroup {
+			httpresponder.SendErrorResponse(w, r, "only group conversations can be announcement only", http.StatusBadRequest)
+			return
+		}
+		if !isGroupOwner(&conversation, user.ID) {
+			httpresponder.SendErrorResponse(w, r, "only the owner can change who posts", http.StatusForbidden)
+			return
+		}
+		if err := database.DB.Model(&conversation).Update("announcement_only", *body.AnnouncementOnly).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update conversation", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if body.Name != nil {
+		if !conversation.IsGroup {
+			httpresponder.SendErrorResponse(w, r, "only group conversations have a name", http.StatusBadRequest)
+			return
+		}
+		if !isGroupOwner(&conversation, user.ID) && !own.IsAdmin {
+			httpresponder.SendErrorResponse(w, r, "only the owner and admins can rename this group", http.StatusForbidden)
+			return
+		}
+		name := strings.TrimSpace(*body.Name)
+		if name == "" || len(name) > 100 {
+			httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+			return
+		}
+		if err := database.DB.Model(&conversation).Update("name", name).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update conversation", http.StatusInternalServerError)
+			return
+		}
+		if hub := websocket.GetHub(); hub != nil {
+			hub.DispatchToConversation(conversation.ID, websocket.EventDMUpdate, map[string]any{
+				"conversation_id": conversation.ID,
+				"name":            name,
+			})
+		}
+	}
+
+	if body.MessageTombstones != nil {
+		if err := database.DB.Model(&conversation).Update("message_tombstones", *body.MessageTombstones).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update conversation", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if body.HideTyping != nil {
+		if err := database.DB.Model(&own).Update("hide_typing", *body.HideTyping).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update conversation", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"id":                 conversation.ID.String(),
+		"name":               conversation.Name,
+		"message_tombstones": conversation.MessageTombstones,
+		"announcement_only":  conversation.AnnouncementOnly,
+		"hide_typing":        own.HideTyping,
+	})
+}
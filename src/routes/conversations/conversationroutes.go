@@ -9,16 +9,26 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/hindsightchat/backend/src/lib/authhelper"
 	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
 	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/imageproxy"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/membercount"
+	"github.com/hindsightchat/backend/src/lib/pagination"
+	"github.com/hindsightchat/backend/src/lib/privacy"
+	"github.com/hindsightchat/backend/src/lib/restriction"
 	"github.com/hindsightchat/backend/src/middleware"
 	"github.com/hindsightchat/backend/src/routes/websocket"
+	"github.com/hindsightchat/backend/src/types"
 	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
 )
 
 type authorBrief struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	Domain   string `json:"domain"`
+	Bot      bool   `json:"bot,omitempty"`
 }
 
 type messageResponse struct {
@@ -29,6 +39,13 @@ type messageResponse struct {
 	ReplyToID   *string     `json:"reply_to_id,omitempty"`
 	CreatedAt   time.Time   `json:"created_at"`
 	EditedAt    *time.Time  `json:"edited_at,omitempty"`
+
+	Embeds []types.Embed `json:"embeds,omitempty"`
+
+	Encrypted      bool   `json:"encrypted,omitempty"`
+	SenderDeviceID string `json:"sender_device_id,omitempty"`
+
+	Deleted bool `json:"deleted,omitempty"` // tombstone, everything but the id, author and time is redacted
 }
 
 type CreateConversationRequest struct {
@@ -63,6 +80,11 @@ func RegisterRoutes(r chi.Router) {
 				return
 			}
 
+			if quota := instance.CheckGroupSize(len(req.UserIDs) + 1); quota != nil {
+				httpresponder.SendErrorResponseWithDetails(w, r, "Group conversations can have at most "+strconv.FormatInt(quota.Max, 10)+" participants", http.StatusBadRequest, quota)
+				return
+			}
+
 			var participantIDs []uuid.UUID
 			for _, idStr := range req.UserIDs {
 				id, err := uuid.FromString(idStr)
@@ -87,6 +109,16 @@ func RegisterRoutes(r chi.Router) {
 				}
 			}
 
+			denied, err := privacy.CheckNewConversation(user.ID, participantIDs)
+			if err != nil {
+				httpresponder.SendErrorResponse(w, r, "Failed to create conversation", http.StatusInternalServerError)
+				return
+			}
+			if denied != nil {
+				httpresponder.SendErrorResponseWithDetails(w, r, "User "+denied.UserID+" does not accept new conversations", http.StatusForbidden, denied)
+				return
+			}
+
 			groupName := req.Title
 
 			if groupName == "" {
@@ -115,13 +147,7 @@ func RegisterRoutes(r chi.Router) {
 			conv := database.DMConversation{
 				Name:    groupName,
 				IsGroup: true,
-			}
-
-			// create conversation
-			err = database.DB.Create(&conv).Error
-			if err != nil {
-				httpresponder.SendErrorResponse(w, r, "Failed to create conversation", http.StatusInternalServerError)
-				return
+				OwnerID: &user.ID,
 			}
 
 			// create participant entries for each user (including the creator)
@@ -129,22 +155,32 @@ func RegisterRoutes(r chi.Router) {
 
 			// add creator as participant
 			participants = append(participants, database.DMParticipant{
-				ConversationID: conv.ID,
-				UserID:         user.ID,
-				JoinedAt:       time.Now(),
+				UserID:   user.ID,
+				JoinedAt: time.Now(),
 			})
 
 			for _, participantID := range participantIDs {
 				participants = append(participants, database.DMParticipant{
-					ConversationID: conv.ID,
-					UserID:         participantID,
-					JoinedAt:       time.Now(),
+					UserID:   participantID,
+					JoinedAt: time.Now(),
 				})
 			}
 
-			err = database.DB.Create(&participants).Error
+			err = database.DB.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&conv).Error; err != nil {
+					return err
+				}
+				// participants get the id once the conversation exists
+				for i := range participants {
+					participants[i].ConversationID = conv.ID
+				}
+				if err := tx.Create(&participants).Error; err != nil {
+					return err
+				}
+				return membercount.Participants(tx, conv.ID, int64(len(participants)))
+			})
 			if err != nil {
-				httpresponder.SendErrorResponse(w, r, "Failed to add participants to conversation", http.StatusInternalServerError)
+				httpresponder.SendErrorResponse(w, r, "Failed to create conversation", http.StatusInternalServerError)
 				return
 			}
 
@@ -157,10 +193,40 @@ func RegisterRoutes(r chi.Router) {
 		})
 
 		r.Route("/{id}", func(r chi.Router) {
+			// metadata, participants and the last message
+			r.Get("/", getConversation)
+			r.Patch("/settings", updateConversationSettings)
+
+			// end to end encryption key exchange
+			r.Post("/sender-keys", distributeSenderKeys)
+
+			// message counts, ?since=<message id> for how many came after it
+			r.Get("/messages/count", getMessageCount)
+
+			// who read up to a message
+			r.Get("/messages/{messageID}/receipts", getReceipts)
+
+			// paginated, /users/@me/conversations only inlines the first few with ?participant_limit
+			r.Get("/participants", getParticipants)
+
+			// groups only, the owner and admins manage participants, only the owner makes admins
+			r.Post("/participants", addParticipant)
+			r.Patch("/participants/{userID}", updateParticipant)
+			r.Delete("/participants/{userID}", removeParticipant)
+
+			// hidden conversations, per participant
+			r.Post("/hide", hideConversation)
+			r.Post("/unhide", unhideConversation)
+			r.Post("/unlock", unlockConversation)
+			r.Post("/lock", lockConversation)
+
+			// turns a group into a server
+			r.Post("/upgrade", upgradeConversation)
+
 			r.Get("/messages", func(w http.ResponseWriter, r *http.Request) {
 				// query params:
 				// - limit (optional, default 50, max 100)
-				// - before (optional, message ID to paginate before)
+				// - before (optional, message ID to paginate before), cursor is the same and takes next_cursor
 				// - after (optional, message ID to paginate after)
 				// - around (optional, message ID to paginate around, returns messages before and after the given ID)
 
@@ -183,6 +249,7 @@ func RegisterRoutes(r chi.Router) {
 				// verify user is a participant in this conversation
 				var participant database.DMParticipant
 				err = database.DB.
+					Preload("Conversation").
 					Where("conversation_id = ? AND user_id = ?", convUUID, user.ID).
 					First(&participant).Error
 
@@ -191,28 +258,38 @@ func RegisterRoutes(r chi.Router) {
 					return
 				}
 
+				// hidden conversations have to be unlocked for this session first
+				if participant.Hidden && !hiddenconv.IsUnlocked(r.Context(), hiddenconv.Session(r), convUUID) {
+					httpresponder.SendErrorResponse(w, r, "conversation is locked", http.StatusLocked)
+					return
+				}
+
 				// get query params
-				limitStr := r.URL.Query().Get("limit")
-				before := r.URL.Query().Get("before")
+				before := pagination.Cursor(r, "before")
 				after := r.URL.Query().Get("after")
 				around := r.URL.Query().Get("around")
 
-				// set default limit
-				limit := 50
-				if limitStr != "" {
-					limitInt, err := strconv.Atoi(limitStr)
-					if err != nil || limitInt <= 0 || limitInt > 100 {
-						httpresponder.SendErrorResponse(w, r, "Invalid limit value! Must be a number between 1 and 100.", http.StatusBadRequest)
-						return
-					}
-					limit = limitInt
+				limit, ok := pagination.Limit(r)
+				if !ok {
+					httpresponder.SendErrorResponse(w, r, "Invalid limit value! Must be a number between 1 and 100.", http.StatusBadRequest)
+					return
+				}
+
+				// one more than asked for tells whether there is another page
+				hasMore := false
+
+				// with tombstones on, deleted messages stay in history as redacted placeholders
+				db := database.DB
+				if participant.Conversation.MessageTombstones {
+					db = database.DB.Unscoped()
 				}
 
 				var messages []database.DirectMessage
 
 				// build query based on pagination params
-				query := database.DB.
+				query := db.
 					Where("conversation_id = ?", convUUID).
+					Scopes(restriction.VisibleDirectMessages(user.ID)).
 					Preload("Author")
 
 				if around != "" {
@@ -225,7 +302,7 @@ func RegisterRoutes(r chi.Router) {
 
 					// get the reference message to find its created_at
 					var refMessage database.DirectMessage
-					err = database.DB.Where("id = ? AND conversation_id = ?", aroundUUID, convUUID).First(&refMessage).Error
+					err = db.Where("id = ? AND conversation_id = ?", aroundUUID, convUUID).Scopes(restriction.VisibleDirectMessages(user.ID)).First(&refMessage).Error
 					if err != nil {
 						httpresponder.SendErrorResponse(w, r, "Reference message not found!", http.StatusNotFound)
 						return
@@ -235,8 +312,9 @@ func RegisterRoutes(r chi.Router) {
 
 					// get messages before (older)
 					var beforeMessages []database.DirectMessage
-					database.DB.
+					db.
 						Where("conversation_id = ? AND created_at < ?", convUUID, refMessage.CreatedAt).
+						Scopes(restriction.VisibleDirectMessages(user.ID)).
 						Order("created_at DESC").
 						Limit(halfLimit).
 						Preload("Author").
@@ -244,8 +322,9 @@ func RegisterRoutes(r chi.Router) {
 
 					// get messages after (newer), including the reference message
 					var afterMessages []database.DirectMessage
-					database.DB.
+					db.
 						Where("conversation_id = ? AND created_at >= ?", convUUID, refMessage.CreatedAt).
+						Scopes(restriction.VisibleDirectMessages(user.ID)).
 						Order("created_at ASC").
 						Limit(limit - halfLimit).
 						Preload("Author").
@@ -268,7 +347,7 @@ func RegisterRoutes(r chi.Router) {
 
 					// get the reference message
 					var refMessage database.DirectMessage
-					err = database.DB.Where("id = ? AND conversation_id = ?", beforeUUID, convUUID).First(&refMessage).Error
+					err = db.Where("id = ? AND conversation_id = ?", beforeUUID, convUUID).Scopes(restriction.VisibleDirectMessages(user.ID)).First(&refMessage).Error
 					if err != nil {
 						httpresponder.SendErrorResponse(w, r, "Reference message not found!", http.StatusNotFound)
 						return
@@ -277,7 +356,7 @@ func RegisterRoutes(r chi.Router) {
 					err = query.
 						Where("created_at < ?", refMessage.CreatedAt).
 						Order("created_at DESC").
-						Limit(limit).
+						Limit(limit + 1).
 						Find(&messages).Error
 
 					if err != nil {
@@ -285,6 +364,10 @@ func RegisterRoutes(r chi.Router) {
 						return
 					}
 
+					if hasMore = len(messages) > limit; hasMore {
+						messages = messages[:limit]
+					}
+
 					// reverse to get chronological order
 					for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
 						messages[i], messages[j] = messages[j], messages[i]
@@ -300,7 +383,7 @@ func RegisterRoutes(r chi.Router) {
 
 					// get the reference message
 					var refMessage database.DirectMessage
-					err = database.DB.Where("id = ? AND conversation_id = ?", afterUUID, convUUID).First(&refMessage).Error
+					err = db.Where("id = ? AND conversation_id = ?", afterUUID, convUUID).Scopes(restriction.VisibleDirectMessages(user.ID)).First(&refMessage).Error
 					if err != nil {
 						httpresponder.SendErrorResponse(w, r, "Reference message not found!", http.StatusNotFound)
 						return
@@ -309,7 +392,7 @@ func RegisterRoutes(r chi.Router) {
 					err = query.
 						Where("created_at > ?", refMessage.CreatedAt).
 						Order("created_at ASC").
-						Limit(limit).
+						Limit(limit + 1).
 						Find(&messages).Error
 
 					if err != nil {
@@ -317,11 +400,15 @@ func RegisterRoutes(r chi.Router) {
 						return
 					}
 
+					if hasMore = len(messages) > limit; hasMore {
+						messages = messages[:limit]
+					}
+
 				} else {
 					// no pagination: get most recent messages
 					err = query.
 						Order("created_at DESC").
-						Limit(limit).
+						Limit(limit + 1).
 						Find(&messages).Error
 
 					if err != nil {
@@ -329,6 +416,10 @@ func RegisterRoutes(r chi.Router) {
 						return
 					}
 
+					if hasMore = len(messages) > limit; hasMore {
+						messages = messages[:limit]
+					}
+
 					// reverse to get chronological order
 					for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
 						messages[i], messages[j] = messages[j], messages[i]
@@ -337,35 +428,63 @@ func RegisterRoutes(r chi.Router) {
 
 				// build response
 				response := make([]messageResponse, 0, len(messages))
-				for _, msg := range messages {
-					msgResp := messageResponse{
-						ID:          msg.ID.String(),
-						Content:     msg.Content,
-						Attachments: msg.Attachments,
-						Author: authorBrief{
-							ID:       msg.Author.ID.String(),
-							Username: msg.Author.Username,
-							Domain:   msg.Author.Domain,
-						},
-						CreatedAt: msg.CreatedAt,
-						EditedAt:  msg.EditedAt,
-					}
-
-					if msg.ReplyToID != nil {
-						replyID := msg.ReplyToID.String()
-						msgResp.ReplyToID = &replyID
-					}
+				for i := range messages {
+					response = append(response, toMessageResponse(&messages[i]))
+				}
 
-					response = append(response, msgResp)
+				// the cursor pages further back in history, newer pages are asked for with after=<newest id>
+				nextCursor := ""
+				if len(messages) > 0 && after == "" && around == "" {
+					nextCursor = messages[0].ID.String()
 				}
 
-				httpresponder.SendSuccessResponse(w, r, response)
+				httpresponder.SendListResponse(w, r, response, nextCursor, hasMore, nil)
 			})
 		})
 	})
 }
 
 // notifyNewGroupDM notifies all participants of a new group DM and subscribes them to the conversation
+// toMessageResponse converts a message with its Author preloaded, deleted messages come out as tombstones
+func toMessageResponse(msg *database.DirectMessage) messageResponse {
+	if msg.DeletedAt.Valid {
+		return messageResponse{
+			ID:        msg.ID.String(),
+			Author:    authorBrief{ID: msg.AuthorID.String()},
+			CreatedAt: msg.CreatedAt,
+			Deleted:   true,
+		}
+	}
+
+	response := messageResponse{
+		ID:          msg.ID.String(),
+		Content:     msg.Content,
+		Attachments: msg.Attachments,
+		Author: authorBrief{
+			ID:       msg.Author.ID.String(),
+			Username: msg.Author.Username,
+			Domain:   msg.Author.Domain,
+			Bot:      msg.Author.IsBot,
+		},
+		CreatedAt:      msg.CreatedAt,
+		EditedAt:       msg.EditedAt,
+		Encrypted:      msg.Encrypted,
+		SenderDeviceID: msg.SenderDeviceID,
+	}
+
+	if msg.ReplyToID != nil {
+		replyID := msg.ReplyToID.String()
+		response.ReplyToID = &replyID
+	}
+
+	if msg.Embeds != nil {
+		json.Unmarshal([]byte(*msg.Embeds), &response.Embeds)
+		imageproxy.ProxyEmbeds(response.Embeds)
+	}
+
+	return response
+}
+
 func notifyNewGroupDM(conv *database.DMConversation, participants []database.DMParticipant, creator *database.User) {
 	hub := websocket.GetHub()
 	print("Notifying new group DM to participants: ", len(participants))
@@ -410,7 +529,7 @@ func notifyNewGroupDM(conv *database.DMConversation, participants []database.DMP
 
 	// notify each participant and subscribe them to the conversation
 	for _, participant := range participants {
-		hub.DispatchToUser(participant.UserID, websocket.EventDMCreate, payload)
+		hub.DispatchToUserOnce(participant.UserID, websocket.EventDMCreate, conv.ID.String(), payload)
 
 		// subscribe all of the user's clients to the new conversation
 		for _, client := range hub.GetUserClients(participant.UserID) {
@@ -0,0 +1,61 @@
+package conversationroutes_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hindsightchat/backend/src/testserver"
+)
+
+type dispatchedMessage struct {
+	ID             string `json:"id"`
+	ConversationID string `json:"conversation_id"`
+	Content        string `json:"content"`
+}
+
+func TestConversationMessageDispatch(t *testing.T) {
+	s := testserver.Start(t)
+	f := s.NewFixture(t)
+
+	send := func(content, nonce string) string {
+		t.Helper()
+		f.GA.Send(t, map[string]any{"op": 22, "nonce": nonce, "d": map[string]string{"conversation_id": f.ConversationID, "content": content}})
+
+		var ack struct {
+			ID string `json:"id"`
+		}
+		f.GA.ExpectAck(t, nonce).Decode(t, &ack)
+		return ack.ID
+	}
+
+	// without focus the peer only gets notified
+	first := send("while away", "first")
+
+	var notified struct {
+		ConversationID string `json:"conversation_id"`
+		MessageID      string `json:"message_id"`
+	}
+	f.GB.Expect(t, "DM_MESSAGE_NOTIFY").Decode(t, &notified)
+	if notified.MessageID != first || notified.ConversationID != f.ConversationID {
+		t.Fatalf("DM_MESSAGE_NOTIFY = %+v, want message %s in %s", notified, first, f.ConversationID)
+	}
+
+	f.GB.Send(t, map[string]any{"op": 4, "nonce": "focus", "d": map[string]string{"conversation_id": f.ConversationID}})
+	f.GB.ExpectAck(t, "focus")
+
+	second := send("while looking", "second")
+
+	var created dispatchedMessage
+	f.GB.Expect(t, "DM_MESSAGE_CREATE").Decode(t, &created)
+	if created.ID != second || created.Content != "while looking" {
+		t.Fatalf("DM_MESSAGE_CREATE = %+v, want message %s with its content", created, second)
+	}
+
+	var history []dispatchedMessage
+	if status := s.Request(t, f.B, http.MethodGet, "/conversation/"+f.ConversationID+"/messages", nil, &history); status != http.StatusOK {
+		t.Fatalf("get messages: status %d", status)
+	}
+	if len(history) != 2 || history[0].ID != first || history[1].ID != second {
+		t.Fatalf("history = %+v, want %s then %s", history, first, second)
+	}
+}
@@ -0,0 +1,123 @@
+package conversationroutes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/msgcount"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// getMessageCount returns the total messages in the conversation, and with ?since=<message id> how many came after it.
+// shadowed messages the user cant see aren't counted
+func getMessageCount(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	convID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return
+	}
+
+	var participant database.DMParticipant
+	if err := database.DB.Where("conversation_id = ? AND user_id = ?", convID, user.ID).First(&participant).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "conversation not found", http.StatusNotFound)
+		return
+	}
+	if participant.Hidden && !hiddenconv.IsUnlocked(r.Context(), hiddenconv.Session(r), convID) {
+		httpresponder.SendErrorResponse(w, r, "conversation is locked", http.StatusLocked)
+		return
+	}
+
+	var since *uuid.UUID
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		messageID, err := uuid.FromString(raw)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+			return
+		}
+		since = &messageID
+	}
+
+	// the counters include shadowed messages, conversations that have any are counted by hand so the
+	// receiver cant tell they exist
+	var shadowed database.DirectMessage
+	if err := database.DB.Select("id").Where("conversation_id = ? AND shadowed = ?", convID, true).Limit(1).Find(&shadowed).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to count messages", http.StatusInternalServerError)
+		return
+	}
+	count := countWithCounters
+	if shadowed.ID != uuid.Nil {
+		count = countVisible
+	}
+
+	response, err := count(convID, user.ID, since)
+	if errors.Is(err, msgcount.ErrUnknownMessage) {
+		httpresponder.SendErrorResponse(w, r, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to count messages", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func countWithCounters(convID, _ uuid.UUID, since *uuid.UUID) (map[string]any, error) {
+	total, err := msgcount.Total(msgcount.Conversation, convID)
+	if err != nil {
+		return nil, err
+	}
+	response := map[string]any{"total": total}
+
+	if since != nil {
+		count, err := msgcount.Since(msgcount.Conversation, convID, *since)
+		if err != nil {
+			return nil, err
+		}
+		response["since"] = count
+	}
+	return response, nil
+}
+
+func countVisible(convID, viewerID uuid.UUID, since *uuid.UUID) (map[string]any, error) {
+	visible := database.DB.Model(&database.DirectMessage{}).
+		Where("conversation_id = ?", convID).
+		Scopes(restriction.VisibleDirectMessages(viewerID))
+
+	var total int64
+	if err := visible.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	response := map[string]any{"total": total}
+
+	if since != nil {
+		var ref database.DirectMessage
+		err := visible.Session(&gorm.Session{}).Select("created_at").Where("id = ?", *since).Take(&ref).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, msgcount.ErrUnknownMessage
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var count int64
+		if err := visible.Session(&gorm.Session{}).Where("created_at > ?", ref.CreatedAt).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		response["since"] = count
+	}
+	return response, nil
+}
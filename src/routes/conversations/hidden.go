@@ -0,0 +1,148 @@
+package conversationroutes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type passcodeRequest struct {
+	Passcode string `json:"passcode"`
+}
+
+// loadParticipation returns the callers participant row and the passcode from the body
+func loadParticipation(w http.ResponseWriter, r *http.Request, withBody bool) (*database.DMParticipant, string, bool) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return nil, "", false
+	}
+
+	convID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	var body passcodeRequest
+	if withBody {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+			return nil, "", false
+		}
+	}
+
+	var participant database.DMParticipant
+	if err := database.DB.Where("conversation_id = ? AND user_id = ?", convID, user.ID).First(&participant).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "conversation not found", http.StatusNotFound)
+		return nil, "", false
+	}
+
+	return &participant, body.Passcode, true
+}
+
+func checkPasscode(w http.ResponseWriter, r *http.Request, participant *database.DMParticipant, passcode string) bool {
+	if !participant.Hidden {
+		httpresponder.SendErrorResponse(w, r, "conversation is not hidden", http.StatusConflict)
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(participant.PasscodeHash), []byte(passcode)) != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid passcode", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func hideConversation(w http.ResponseWriter, r *http.Request) {
+	participant, passcode, ok := loadParticipation(w, r, true)
+	if !ok {
+		return
+	}
+
+	if participant.Hidden {
+		httpresponder.SendErrorResponse(w, r, "conversation is already hidden", http.StatusConflict)
+		return
+	}
+	if len(passcode) < 4 || len(passcode) > 64 {
+		httpresponder.SendErrorResponse(w, r, "passcode must be between 4 and 64 characters", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to hide conversation", http.StatusInternalServerError)
+		return
+	}
+
+	err = database.DB.Model(participant).Updates(map[string]any{
+		"hidden":        true,
+		"passcode_hash": string(hash),
+	}).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to hide conversation", http.StatusInternalServerError)
+		return
+	}
+
+	// whoever hid it is still looking at it
+	hiddenconv.Unlock(r.Context(), hiddenconv.Session(r), participant.ConversationID)
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{"hidden": true})
+}
+
+func unhideConversation(w http.ResponseWriter, r *http.Request) {
+	participant, passcode, ok := loadParticipation(w, r, true)
+	if !ok || !checkPasscode(w, r, participant, passcode) {
+		return
+	}
+
+	err := database.DB.Model(participant).Updates(map[string]any{
+		"hidden":        false,
+		"passcode_hash": "",
+	}).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to unhide conversation", http.StatusInternalServerError)
+		return
+	}
+
+	hiddenconv.Lock(r.Context(), hiddenconv.Session(r), participant.ConversationID)
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{"hidden": false})
+}
+
+func unlockConversation(w http.ResponseWriter, r *http.Request) {
+	participant, passcode, ok := loadParticipation(w, r, true)
+	if !ok || !checkPasscode(w, r, participant, passcode) {
+		return
+	}
+
+	session := hiddenconv.Session(r)
+	if session == "" {
+		httpresponder.SendErrorResponse(w, r, "unlocking needs a session token", http.StatusBadRequest)
+		return
+	}
+
+	if err := hiddenconv.Unlock(r.Context(), session, participant.ConversationID); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to unlock conversation", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{"unlocked": true})
+}
+
+func lockConversation(w http.ResponseWriter, r *http.Request) {
+	participant, _, ok := loadParticipation(w, r, false)
+	if !ok {
+		return
+	}
+
+	hiddenconv.Lock(r.Context(), hiddenconv.Session(r), participant.ConversationID)
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{"unlocked": false})
+}
@@ -0,0 +1,278 @@
+package conversationroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/membercount"
+	"github.com/hindsightchat/backend/src/lib/privacy"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+type addParticipantRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type updateParticipantRequest struct {
+	Admin *bool `json:"admin"`
+}
+
+// loadGroupManager returns the callers participant row with its group, when the caller owns the group or
+// is one of its admins. writes the error otherwise
+func loadGroupManager(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*database.DMParticipant, bool) {
+	convID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var own database.DMParticipant
+	if err := database.DB.Preload("Conversation").Where("conversation_id = ? AND user_id = ?", convID, userID).First(&own).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "conversation not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !own.Conversation.IsGroup {
+		httpresponder.SendErrorResponse(w, r, "only group conversations have participants to manage", http.StatusBadRequest)
+		return nil, false
+	}
+	if !isGroupOwner(&own.Conversation, userID) && !own.IsAdmin {
+		httpresponder.SendErrorResponse(w, r, "only the owner and admins can manage this group", http.StatusForbidden)
+		return nil, false
+	}
+	return &own, true
+}
+
+func isGroupOwner(conv *database.DMConversation, userID uuid.UUID) bool {
+	return conv.OwnerID != nil && *conv.OwnerID == userID
+}
+
+// addParticipant adds one of the callers friends to the group
+func addParticipant(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	own, ok := loadGroupManager(w, r, user.ID)
+	if !ok {
+		return
+	}
+	conv := own.Conversation
+
+	var body addParticipantRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	targetID, err := uuid.FromString(body.UserID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if quota := instance.CheckGroupSize(int(conv.ParticipantCount) + 1); quota != nil {
+		httpresponder.SendErrorResponseWithDetails(w, r, "group conversations can have at most "+strconv.FormatInt(quota.Max, 10)+" participants", http.StatusBadRequest, quota)
+		return
+	}
+
+	// same rule as creating the group, you can only bring in your friends
+	var friends int64
+	database.DB.Model(&database.Friendship{}).
+		Where("(user1_id = ? AND user2_id = ?) OR (user1_id = ? AND user2_id = ?)", user.ID, targetID, targetID, user.ID).
+		Count(&friends)
+	if friends == 0 {
+		httpresponder.SendErrorResponse(w, r, "you can only add your friends", http.StatusBadRequest)
+		return
+	}
+
+	denied, err := privacy.CheckNewConversation(user.ID, []uuid.UUID{targetID})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to add participant", http.StatusInternalServerError)
+		return
+	}
+	if denied != nil {
+		httpresponder.SendErrorResponseWithDetails(w, r, "user does not accept new conversations", http.StatusForbidden, denied)
+		return
+	}
+
+	added := false
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var existing database.DMParticipant
+		err := tx.Unscoped().Where("conversation_id = ? AND user_id = ?", conv.ID, targetID).Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case existing.ID == uuid.Nil:
+			err = tx.Create(&database.DMParticipant{ConversationID: conv.ID, UserID: targetID}).Error
+		case existing.DeletedAt.Valid:
+			// removed before, the row comes back so the unique index stays happy
+			err = tx.Unscoped().Model(&existing).Updates(map[string]any{
+				"deleted_at": nil,
+				"joined_at":  time.Now(),
+				"is_admin":   false,
+			}).Error
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		added = true
+		return membercount.Participants(tx, conv.ID, 1)
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to add participant", http.StatusInternalServerError)
+		return
+	}
+	if !added {
+		httpresponder.SendErrorResponse(w, r, "user is already in the conversation", http.StatusConflict)
+		return
+	}
+
+	var target database.User
+	database.DB.Where("id = ?", targetID).First(&target)
+
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToConversation(conv.ID, websocket.EventDMParticipantAdd, map[string]any{
+			"conversation_id": conv.ID,
+			"user":            websocket.UserBrief{ID: target.ID, Username: target.Username, Domain: target.Domain, Bot: target.IsBot},
+			"added_by":        user.ID,
+		})
+	}
+
+	// the new participant gets the group like everyone did when it was created
+	var participants []database.DMParticipant
+	database.DB.Where("conversation_id = ? AND user_id = ?", conv.ID, targetID).Find(&participants)
+	notifyNewGroupDM(&conv, participants, user)
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"added": true})
+}
+
+// removeParticipant takes someone out of the group. the owner cant be removed and only the owner removes admins
+func removeParticipant(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	own, ok := loadGroupManager(w, r, user.ID)
+	if !ok {
+		return
+	}
+	conv := own.Conversation
+
+	targetID, err := uuid.FromString(chi.URLParam(r, "userID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	var target database.DMParticipant
+	if err := database.DB.Where("conversation_id = ? AND user_id = ?", conv.ID, targetID).First(&target).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "participant not found", http.StatusNotFound)
+		return
+	}
+	if isGroupOwner(&conv, targetID) {
+		httpresponder.SendErrorResponse(w, r, "the owner cannot be removed", http.StatusForbidden)
+		return
+	}
+	if target.IsAdmin && !isGroupOwner(&conv, user.ID) {
+		httpresponder.SendErrorResponse(w, r, "only the owner can remove admins", http.StatusForbidden)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&target)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return membercount.Participants(tx, conv.ID, -1)
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to remove participant", http.StatusInternalServerError)
+		return
+	}
+
+	// the removed user hears about it before their clients stop getting the conversations events
+	websocket.NotifyConversationLeave(conv.ID, targetID, map[string]any{
+		"conversation_id": conv.ID,
+		"user_id":         targetID,
+		"removed_by":      user.ID,
+	})
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"removed": true})
+}
+
+// updateParticipant makes a participant admin or takes it back, owner only
+func updateParticipant(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	own, ok := loadGroupManager(w, r, user.ID)
+	if !ok {
+		return
+	}
+	conv := own.Conversation
+	if !isGroupOwner(&conv, user.ID) {
+		httpresponder.SendErrorResponse(w, r, "only the owner can change admins", http.StatusForbidden)
+		return
+	}
+
+	targetID, err := uuid.FromString(chi.URLParam(r, "userID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	if targetID == user.ID {
+		httpresponder.SendErrorResponse(w, r, "the owner is always admin", http.StatusBadRequest)
+		return
+	}
+
+	var body updateParticipantRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Admin == nil {
+		httpresponder.SendErrorResponse(w, r, "admin is required", http.StatusBadRequest)
+		return
+	}
+
+	result := database.DB.Model(&database.DMParticipant{}).
+		Where("conversation_id = ? AND user_id = ?", conv.ID, targetID).
+		Update("is_admin", *body.Admin)
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update participant", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "participant not found", http.StatusNotFound)
+		return
+	}
+
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToConversation(conv.ID, websocket.EventDMUpdate, map[string]any{
+			"conversation_id": conv.ID,
+			"user_id":         targetID,
+			"admin":           *body.Admin,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"user_id": targetID,
+		"admin":   *body.Admin,
+	})
+}
@@ -0,0 +1,111 @@
+package conversationroutes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/pagination"
+	uuid "github.com/satori/go.uuid"
+)
+
+type participantResponse struct {
+	ID       string    `json:"id"`
+	Username string    `json:"username"`
+	Domain   string    `json:"domain"`
+	Bot      bool      `json:"bot,omitempty"`
+	Admin    bool      `json:"admin,omitempty"` // may add, remove and rename, the owner always may
+	JoinedAt time.Time `json:"joined_at"`
+
+	// messages created up to here reached one of their clients
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// getParticipants pages through the participants of a conversation in join order.
+// query params: limit (default 50, max 100), cursor (user id of the last participant of the previous page, after works too)
+func getParticipants(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	convID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return
+	}
+
+	var own int64
+	database.DB.Model(&database.DMParticipant{}).Where("conversation_id = ? AND user_id = ?", convID, user.ID).Count(&own)
+	if own == 0 {
+		httpresponder.SendErrorResponse(w, r, "conversation not found", http.StatusNotFound)
+		return
+	}
+
+	var total int64
+	database.DB.Model(&database.DMConversation{}).Where("id = ?", convID).Select("participant_count").Scan(&total)
+
+	limit, ok := pagination.Limit(r)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, pagination.LimitError, http.StatusBadRequest)
+		return
+	}
+
+	query := database.DB.
+		Preload("User").
+		Where("conversation_id = ?", convID).
+		Order("joined_at ASC, user_id ASC").
+		Limit(limit + 1)
+
+	if raw := pagination.Cursor(r, "after"); raw != "" {
+		cursorID, err := uuid.FromString(raw)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+
+		var cursor database.DMParticipant
+		if err := database.DB.Where("conversation_id = ? AND user_id = ?", convID, cursorID).First(&cursor).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "cursor participant not found", http.StatusNotFound)
+			return
+		}
+
+		query = query.Where("joined_at > ? OR (joined_at = ? AND user_id > ?)", cursor.JoinedAt, cursor.JoinedAt, cursor.UserID)
+	}
+
+	var participants []database.DMParticipant
+	if err := query.Find(&participants).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch participants", http.StatusInternalServerError)
+		return
+	}
+
+	hasMore := len(participants) > limit
+	if hasMore {
+		participants = participants[:limit]
+	}
+
+	response := make([]participantResponse, 0, len(participants))
+	for _, p := range participants {
+		response = append(response, participantResponse{
+			ID:       p.User.ID.String(),
+			Username: p.User.Username,
+			Domain:   p.User.Domain,
+			Bot:      p.User.IsBot,
+			Admin:    p.IsAdmin,
+			JoinedAt: p.JoinedAt,
+
+			DeliveredAt: p.LastDeliveredAt,
+		})
+	}
+
+	nextCursor := ""
+	if len(participants) > 0 {
+		nextCursor = participants[len(participants)-1].UserID.String()
+	}
+
+	httpresponder.SendListResponse(w, r, response, nextCursor, hasMore, &total)
+}
@@ -0,0 +1,89 @@
+package conversationroutes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	"github.com/hindsightchat/backend/src/lib/usersettings"
+	uuid "github.com/satori/go.uuid"
+)
+
+type receiptResponse struct {
+	UserID string    `json:"user_id"`
+	ReadAt time.Time `json:"read_at"`
+}
+
+// getReceipts lists the participants that read up to the message.
+// the author and anyone who turned read receipts off are left out
+func getReceipts(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	convID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := uuid.FromString(chi.URLParam(r, "messageID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	var participant database.DMParticipant
+	if err := database.DB.Where("conversation_id = ? AND user_id = ?", convID, user.ID).First(&participant).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "conversation not found", http.StatusNotFound)
+		return
+	}
+	if participant.Hidden && !hiddenconv.IsUnlocked(r.Context(), hiddenconv.Session(r), convID) {
+		httpresponder.SendErrorResponse(w, r, "conversation is locked", http.StatusLocked)
+		return
+	}
+
+	var message database.DirectMessage
+	err = database.DB.
+		Where("id = ? AND conversation_id = ?", messageID, convID).
+		Scopes(restriction.VisibleDirectMessages(user.ID)).
+		First(&message).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "message not found", http.StatusNotFound)
+		return
+	}
+
+	var readers []database.DMParticipant
+	err = database.DB.
+		Where("conversation_id = ? AND user_id <> ? AND last_read_at >= ?", convID, message.AuthorID, message.CreatedAt).
+		Order("last_read_at ASC").
+		Find(&readers).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch receipts", http.StatusInternalServerError)
+		return
+	}
+
+	userIDs := make([]uuid.UUID, len(readers))
+	for i, p := range readers {
+		userIDs[i] = p.UserID
+	}
+	settings, _ := usersettings.ForUsers(userIDs)
+
+	receipts := make([]receiptResponse, 0, len(readers))
+	for _, p := range readers {
+		// your own receipt is always visible to you
+		if s, ok := settings[p.UserID]; ok && s.HideReadReceipts && p.UserID != user.ID {
+			continue
+		}
+		receipts = append(receipts, receiptResponse{UserID: p.UserID.String(), ReadAt: *p.LastReadAt})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, receipts)
+}
@@ -0,0 +1,125 @@
+package conversationroutes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+)
+
+// one request covers every device of every participant, this is plenty for group dms
+const maxDistributions = 500
+
+type distributeSenderKeysRequest struct {
+	SenderDeviceID string                  `json:"sender_device_id"`
+	Distributions  []senderKeyDistribution `json:"distributions"`
+}
+
+type senderKeyDistribution struct {
+	UserID     string `json:"user_id"`
+	DeviceID   string `json:"device_id"`
+	Ciphertext string `json:"ciphertext"` // the sender key, encrypted for that device
+}
+
+// distributeSenderKeys shares the senders key for the conversation with the other participants devices.
+// the server only relays the ciphertext
+func distributeSenderKeys(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	convID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return
+	}
+
+	var body distributeSenderKeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Distributions) == 0 || len(body.Distributions) > maxDistributions {
+		httpresponder.SendErrorResponse(w, r, "invalid number of distributions", http.StatusBadRequest)
+		return
+	}
+
+	var participantIDs []uuid.UUID
+	database.DB.Model(&database.DMParticipant{}).Where("conversation_id = ?", convID).Pluck("user_id", &participantIDs)
+
+	participants := make(map[uuid.UUID]bool, len(participantIDs))
+	for _, id := range participantIDs {
+		participants[id] = true
+	}
+	if !participants[user.ID] {
+		httpresponder.SendErrorResponse(w, r, "not a participant of this conversation", http.StatusForbidden)
+		return
+	}
+
+	// the sending device has to be registered so recipients can verify who sent the key
+	var senderDevices int64
+	database.DB.Model(&database.DeviceKey{}).Where("user_id = ? AND device_id = ?", user.ID, body.SenderDeviceID).Count(&senderDevices)
+	if senderDevices == 0 {
+		httpresponder.SendErrorResponse(w, r, "sender device not registered", http.StatusBadRequest)
+		return
+	}
+
+	var devices []database.DeviceKey
+	database.DB.Where("user_id IN ?", participantIDs).Find(&devices)
+
+	known := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		known[d.UserID.String()+"/"+d.DeviceID] = true
+	}
+
+	rows := make([]database.SenderKeyDistribution, 0, len(body.Distributions))
+	for _, dist := range body.Distributions {
+		recipientID, err := uuid.FromString(dist.UserID)
+		if err != nil || !participants[recipientID] {
+			httpresponder.SendErrorResponse(w, r, "recipients must be participants of this conversation", http.StatusBadRequest)
+			return
+		}
+		if !known[recipientID.String()+"/"+dist.DeviceID] {
+			httpresponder.SendErrorResponse(w, r, "unknown recipient device", http.StatusBadRequest)
+			return
+		}
+		if dist.Ciphertext == "" || len(dist.Ciphertext) > 4096 {
+			httpresponder.SendErrorResponse(w, r, "invalid ciphertext", http.StatusBadRequest)
+			return
+		}
+
+		rows = append(rows, database.SenderKeyDistribution{
+			ConversationID:    convID,
+			SenderID:          user.ID,
+			SenderDeviceID:    body.SenderDeviceID,
+			RecipientID:       recipientID,
+			RecipientDeviceID: dist.DeviceID,
+			Ciphertext:        dist.Ciphertext,
+		})
+	}
+
+	if err := database.DB.Create(&rows).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to store sender keys", http.StatusInternalServerError)
+		return
+	}
+
+	for _, row := range rows {
+		websocket.NotifySenderKeyDistribution(row.RecipientID, websocket.SenderKeyDistributionPayload{
+			ID:                row.ID,
+			ConversationID:    convID,
+			SenderID:          user.ID,
+			SenderDeviceID:    row.SenderDeviceID,
+			RecipientDeviceID: row.RecipientDeviceID,
+			Ciphertext:        row.Ciphertext,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]int{"distributed": len(rows)})
+}
@@ -0,0 +1,182 @@
+package conversationroutes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/serversetup"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+var errAlreadyUpgraded = errors.New("already upgraded")
+
+type upgradeRequest struct {
+	Name string `json:"name"` // defaults to the name of the group
+}
+
+type upgradeResponse struct {
+	ServerID  string   `json:"server_id"`
+	ChannelID string   `json:"channel_id"`
+	Skipped   []string `json:"skipped,omitempty"` // participants already in as many servers as they may be
+}
+
+// upgradeConversation turns a group dm into a server owned by the caller, with a general channel and
+// the participants as members. the group and its history stay as they are
+func upgradeConversation(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	convID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return
+	}
+
+	var body upgradeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var own database.DMParticipant
+	if err := database.DB.Preload("Conversation").Where("conversation_id = ? AND user_id = ?", convID, user.ID).First(&own).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "conversation not found", http.StatusNotFound)
+		return
+	}
+
+	conv := own.Conversation
+	if !conv.IsGroup {
+		httpresponder.SendErrorResponse(w, r, "only group conversations can be upgraded", http.StatusBadRequest)
+		return
+	}
+	if conv.UpgradedToServerID != nil {
+		httpresponder.SendErrorResponse(w, r, "conversation was already upgraded", http.StatusConflict)
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		name = conv.Name
+	}
+	if name == "" || len(name) > 100 {
+		httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	var participantIDs []uuid.UUID
+	if err := database.DB.Model(&database.DMParticipant{}).Where("conversation_id = ?", convID).Pluck("user_id", &participantIDs).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch participants", http.StatusInternalServerError)
+		return
+	}
+
+	serverCounts, err := countServers(participantIDs)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch participants", http.StatusInternalServerError)
+		return
+	}
+
+	maxServers := instance.GetLimits().MaxServersPerUser
+	if serverCounts[user.ID] >= int64(maxServers) {
+		quota := &instance.QuotaError{Limit: "max_servers_per_user", Max: int64(maxServers)}
+		httpresponder.SendErrorResponseWithDetails(w, r, "you are in too many servers", http.StatusBadRequest, quota)
+		return
+	}
+
+	// the owner always joins, anyone else at their limit is left out rather than failing the upgrade
+	memberIDs := make([]uuid.UUID, 0, len(participantIDs))
+	skipped := make([]string, 0)
+	for _, id := range participantIDs {
+		if id != user.ID && serverCounts[id] >= int64(maxServers) {
+			skipped = append(skipped, id.String())
+			continue
+		}
+		memberIDs = append(memberIDs, id)
+	}
+
+	server := database.Server{Name: name, OwnerID: user.ID}
+	var channel *database.Channel
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		channel, err = serversetup.Create(tx, &server, memberIDs)
+		if err != nil {
+			return err
+		}
+
+		// claimed in the transaction so two racing upgrades cant both create a server
+		result := tx.Model(&database.DMConversation{}).
+			Where("id = ? AND upgraded_to_server_id IS NULL", convID).
+			Update("upgraded_to_server_id", server.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errAlreadyUpgraded
+		}
+		return nil
+	})
+	if errors.Is(err, errAlreadyUpgraded) {
+		httpresponder.SendErrorResponse(w, r, "conversation was already upgraded", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create server", http.StatusInternalServerError)
+		return
+	}
+
+	websocket.NotifyServerCreate(websocket.ServerSummary{
+		ID:          server.ID,
+		Name:        server.Name,
+		MemberCount: server.MemberCount,
+	}, memberIDs)
+
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToConversation(convID, websocket.EventDMUpgrade, map[string]any{
+			"conversation_id": convID,
+			"server_id":       server.ID,
+			"upgraded_by":     user.ID,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, upgradeResponse{
+		ServerID:  server.ID.String(),
+		ChannelID: channel.ID.String(),
+		Skipped:   skipped,
+	})
+}
+
+// countServers returns how many servers each of the users is a member of
+func countServers(userIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	var rows []struct {
+		UserID uuid.UUID
+		Count  int64
+	}
+	err := database.DB.Model(&database.ServerMember{}).
+		Select("user_id, COUNT(*) AS count").
+		Where("user_id IN ?", userIDs).
+		Group("user_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uuid.UUID]int64, len(rows))
+	for _, row := range rows {
+		counts[row.UserID] = row.Count
+	}
+	return counts, nil
+}
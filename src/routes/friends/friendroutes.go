@@ -4,16 +4,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/hindsightchat/backend/src/lib/authhelper"
 	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
 	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/friendwatch"
 	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/membercount"
+	"github.com/hindsightchat/backend/src/lib/pagination"
+	"github.com/hindsightchat/backend/src/lib/privacy"
+	"github.com/hindsightchat/backend/src/lib/ratelimit"
+	"github.com/hindsightchat/backend/src/middleware"
 	websocket "github.com/hindsightchat/backend/src/routes/websocket"
 	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type sendRequestBody struct {
@@ -34,6 +41,13 @@ type friendshipResponse struct {
 	User           userBrief `json:"user"`
 	ConversationID string    `json:"conversation_id"`
 	Since          time.Time `json:"since"`
+
+	NotifyOnline     bool `json:"notify_online"`      // FRIEND_ONLINE when they connect
+	NotifyOnlinePush bool `json:"notify_online_push"` // and a push when you arent connected
+}
+
+type onlineNotificationsRequest struct {
+	Push bool `json:"push"`
 }
 
 type userBrief struct {
@@ -56,7 +70,7 @@ func RegisterRoutes(r chi.Router) {
 		r.Get("/requests/outgoing", getOutgoingRequests)
 
 		// send friend request
-		r.Post("/requests", sendFriendRequest)
+		r.With(middleware.RateLimit(ratelimit.FriendRequests)).Post("/requests", sendFriendRequest)
 
 		// accept friend request
 		r.Post("/requests/{id}/accept", acceptFriendRequest)
@@ -69,9 +83,15 @@ func RegisterRoutes(r chi.Router) {
 
 		// remove friend
 		r.Delete("/{id}", removeFriend)
+
+		// hear when the friend comes online, {"push": true} for a push too
+		r.Put("/{id}/online-notifications", enableOnlineNotifications)
+		r.Delete("/{id}/online-notifications", disableOnlineNotifications)
 	})
 }
 
+// getFriends returns the users friends, newest friendship first.
+// query params: limit (default 50, max 100), cursor (id of the last friendship of the previous page)
 func getFriends(w http.ResponseWriter, r *http.Request) {
 	user, err := authhelper.GetUserFromRequest(r)
 	if err != nil || user == nil {
@@ -79,18 +99,57 @@ func getFriends(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var friendships []database.Friendship
-	err = database.DB.
+	limit, ok := pagination.Limit(r)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, pagination.LimitError, http.StatusBadRequest)
+		return
+	}
+
+	mine := database.DB.
+		Model(&database.Friendship{}).
+		Where("user1_id = ? OR user2_id = ?", user.ID, user.ID)
+
+	var total int64
+	if err := mine.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch friends", http.StatusInternalServerError)
+		return
+	}
+
+	query := mine.Session(&gorm.Session{}).
 		Preload("User1").
 		Preload("User2").
-		Where("user1_id = ? OR user2_id = ?", user.ID, user.ID).
-		Find(&friendships).Error
+		Order("created_at DESC, id DESC").
+		Limit(limit + 1)
 
-	if err != nil {
+	if raw := pagination.Cursor(r, ""); raw != "" {
+		cursorID, err := uuid.FromString(raw)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+
+		var cursor database.Friendship
+		if err := mine.Session(&gorm.Session{}).Where("id = ?", cursorID).First(&cursor).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "cursor friendship not found", http.StatusNotFound)
+			return
+		}
+
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+
+	var friendships []database.Friendship
+	if err := query.Find(&friendships).Error; err != nil {
 		httpresponder.SendErrorResponse(w, r, "failed to fetch friends", http.StatusInternalServerError)
 		return
 	}
 
+	hasMore := len(friendships) > limit
+	if hasMore {
+		friendships = friendships[:limit]
+	}
+
+	watching := friendwatch.Watching(user.ID)
+
 	friends := make([]friendshipResponse, 0, len(friendships))
 	for _, f := range friendships {
 		var friend database.User
@@ -120,6 +179,8 @@ func getFriends(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		wantsPush, notify := watching[friend.ID]
+
 		friends = append(friends, friendshipResponse{
 			ID:             f.ID.String(),
 			ConversationID: f.ConversationID.String(),
@@ -130,12 +191,22 @@ func getFriends(w http.ResponseWriter, r *http.Request) {
 				Domain:   friend.Domain,
 				Presence: &presence,
 			},
+
+			NotifyOnline:     notify,
+			NotifyOnlinePush: wantsPush,
 		})
 	}
 
-	httpresponder.SendSuccessResponse(w, r, friends)
+	nextCursor := ""
+	if len(friendships) > 0 {
+		nextCursor = friendships[len(friendships)-1].ID.String()
+	}
+
+	httpresponder.SendListResponse(w, r, friends, nextCursor, hasMore, &total)
 }
 
+// getPendingRequests returns incoming requests, newest first.
+// query params: limit (default 50, max 100), cursor (id of the last request of the previous page, before works too)
 func getPendingRequests(w http.ResponseWriter, r *http.Request) {
 	user, err := authhelper.GetUserFromRequest(r)
 	if err != nil || user == nil {
@@ -143,40 +214,15 @@ func getPendingRequests(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var requests []database.FriendRequest
-	err = database.DB.
-		Preload("Sender").
-		Preload("Receiver").
-		Where("receiver_id = ? AND status = ?", user.ID, database.FriendRequestPending).
-		Find(&requests).Error
-
-	if err != nil {
-		httpresponder.SendErrorResponse(w, r, "failed to fetch requests", http.StatusInternalServerError)
-		return
-	}
-
-	response := make([]friendRequestResponse, 0, len(requests))
-	for _, req := range requests {
-		response = append(response, friendRequestResponse{
-			ID:        req.ID.String(),
-			Status:    int(req.Status),
-			CreatedAt: req.CreatedAt,
-			Sender: userBrief{
-				ID:       req.Sender.ID.String(),
-				Username: req.Sender.Username,
-				Domain:   req.Sender.Domain,
-			},
-			Receiver: userBrief{
-				ID:       req.Receiver.ID.String(),
-				Username: req.Receiver.Username,
-				Domain:   req.Receiver.Domain,
-			},
-		})
-	}
+	// shadowed requests stay invisible to the receiver
+	query := database.DB.
+		Model(&database.FriendRequest{}).
+		Where("receiver_id = ? AND status = ? AND shadowed = ?", user.ID, database.FriendRequestPending, false)
 
-	httpresponder.SendSuccessResponse(w, r, response)
+	listRequests(w, r, query)
 }
 
+// getOutgoingRequests returns requests the user sent, newest first, same query params as getPendingRequests
 func getOutgoingRequests(w http.ResponseWriter, r *http.Request) {
 	user, err := authhelper.GetUserFromRequest(r)
 	if err != nil || user == nil {
@@ -184,18 +230,60 @@ func getOutgoingRequests(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var requests []database.FriendRequest
-	err = database.DB.
+	query := database.DB.
+		Model(&database.FriendRequest{}).
+		Where("sender_id = ? AND status = ?", user.ID, database.FriendRequestPending)
+
+	listRequests(w, r, query)
+}
+
+// listRequests sends one page of the requests matched by query, with the total count of all of them
+func listRequests(w http.ResponseWriter, r *http.Request, query *gorm.DB) {
+	limit, ok := pagination.Limit(r)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, pagination.LimitError, http.StatusBadRequest)
+		return
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch requests", http.StatusInternalServerError)
+		return
+	}
+
+	page := query.Session(&gorm.Session{}).
 		Preload("Sender").
 		Preload("Receiver").
-		Where("sender_id = ? AND status = ?", user.ID, database.FriendRequestPending).
-		Find(&requests).Error
+		Order("created_at DESC, id DESC").
+		Limit(limit + 1)
 
-	if err != nil {
+	if raw := pagination.Cursor(r, "before"); raw != "" {
+		cursorID, err := uuid.FromString(raw)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+
+		var cursor database.FriendRequest
+		if err := query.Session(&gorm.Session{}).Where("id = ?", cursorID).First(&cursor).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "cursor request not found", http.StatusNotFound)
+			return
+		}
+
+		page = page.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+
+	var requests []database.FriendRequest
+	if err := page.Find(&requests).Error; err != nil {
 		httpresponder.SendErrorResponse(w, r, "failed to fetch requests", http.StatusInternalServerError)
 		return
 	}
 
+	hasMore := len(requests) > limit
+	if hasMore {
+		requests = requests[:limit]
+	}
+
 	response := make([]friendRequestResponse, 0, len(requests))
 	for _, req := range requests {
 		response = append(response, friendRequestResponse{
@@ -215,7 +303,12 @@ func getOutgoingRequests(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	httpresponder.SendSuccessResponse(w, r, response)
+	nextCursor := ""
+	if len(requests) > 0 {
+		nextCursor = requests[len(requests)-1].ID.String()
+	}
+
+	httpresponder.SendListResponse(w, r, response, nextCursor, hasMore, &total)
 }
 
 func sendFriendRequest(w http.ResponseWriter, r *http.Request) {
@@ -246,9 +339,7 @@ func sendFriendRequest(w http.ResponseWriter, r *http.Request) {
 		}
 	} else if body.Username != "" {
 		// username can be "user.domain" or "user@domain"
-		// depends what we choose at the end lol
-		username := strings.Replace(body.Username, "@", ".", 1)
-		if err := database.DB.Where("username = ?", username).First(&targetUser).Error; err != nil {
+		if err := database.DB.Scopes(database.ByHandle(body.Username)).First(&targetUser).Error; err != nil {
 			httpresponder.SendErrorResponse(w, r, "user not found", http.StatusNotFound)
 			return
 		}
@@ -263,6 +354,12 @@ func sendFriendRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// bots dont have friends
+	if targetUser.IsBot || user.IsBot {
+		httpresponder.SendErrorResponse(w, r, "bots cannot be friended", http.StatusBadRequest)
+		return
+	}
+
 	fmt.Printf("User %s (%s) is sending friend request to %s (%s)\n", user.Username, user.ID.String(), targetUser.Username, targetUser.ID.String())
 
 	// check if already friends
@@ -274,37 +371,58 @@ func sendFriendRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// check if request already exists (either direction)
-	var existingRequest database.FriendRequest
-	err = database.DB.Where(
-		"((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)) AND status = ?",
-		user.ID, targetUser.ID, targetUser.ID, user.ID, database.FriendRequestPending,
-	).First(&existingRequest).Error
+	// the pending key allows one pending request per pair, so concurrent sends can't both create one
+	pendingKey := database.FriendRequestPendingKey(user.ID, targetUser.ID)
 
-	if err == nil {
-		// if they sent us a request, auto-accept it
-		if existingRequest.SenderID == targetUser.ID {
-			acceptRequest(w, r, user, &existingRequest, &targetUser)
+	// answering a request the target sent us is always allowed
+	var incoming int64
+	database.DB.Model(&database.FriendRequest{}).Where("pending_key = ? AND sender_id = ?", pendingKey, targetUser.ID).Count(&incoming)
+	if incoming == 0 {
+		denied, err := privacy.CheckFriendRequest(user.ID, targetUser.ID)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to create request", http.StatusInternalServerError)
+			return
+		}
+		if denied != nil {
+			httpresponder.SendErrorResponseWithDetails(w, r, "this user does not accept friend requests from you", http.StatusForbidden, denied)
 			return
 		}
-		httpresponder.SendErrorResponse(w, r, "friend request already sent", http.StatusBadRequest)
-		return
 	}
-
-	// create new request
 	request := database.FriendRequest{
 		SenderID:   user.ID,
 		ReceiverID: targetUser.ID,
 		Status:     database.FriendRequestPending,
+		PendingKey: &pendingKey,
+		// restricted senders get a normal looking response but the receiver never sees it
+		Shadowed: user.RestrictedAt != nil,
 	}
 
-	if err := database.DB.Create(&request).Error; err != nil {
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&request)
+	if result.Error != nil {
 		httpresponder.SendErrorResponse(w, r, "failed to create request", http.StatusInternalServerError)
 		return
 	}
 
-	// notify target via websocket
-	notifyFriendRequest(&request, user, &targetUser)
+	if result.RowsAffected == 0 {
+		var existingRequest database.FriendRequest
+		if err := database.DB.Where("pending_key = ?", pendingKey).First(&existingRequest).Error; err != nil {
+			// resolved between the insert and now
+			httpresponder.SendErrorResponse(w, r, "friend request changed, try again", http.StatusConflict)
+			return
+		}
+
+		// if they sent us a request, auto-accept it
+		if existingRequest.SenderID == targetUser.ID {
+			acceptRequest(w, r, user, &existingRequest, &targetUser)
+			return
+		}
+
+		// sending again is a no-op, answer with the pending request
+		request = existingRequest
+	} else if !request.Shadowed {
+		// notify target via websocket
+		notifyFriendRequest(&request, user, &targetUser)
+	}
 
 	httpresponder.SendSuccessResponse(w, r, friendRequestResponse{
 		ID:        request.ID.String(),
@@ -366,45 +484,60 @@ func acceptRequest(w http.ResponseWriter, r *http.Request, user *database.User,
 
 	tx := database.DB.Begin()
 
-	// update request status
-	if err := tx.Model(request).Update("status", database.FriendRequestAccepted).Error; err != nil {
+	// update request status, only if nobody else accepted it in the meantime
+	result := tx.Model(&database.FriendRequest{}).
+		Where("id = ? AND status = ?", request.ID, database.FriendRequestPending).
+		Updates(map[string]any{"status": database.FriendRequestAccepted, "pending_key": nil})
+	if result.Error != nil {
 		tx.Rollback()
 		httpresponder.SendErrorResponse(w, r, "failed to accept request", http.StatusInternalServerError)
 		return
 	}
-
-	// create dm conversation
-	conversation := database.DMConversation{
-		IsGroup: false,
-	}
-	if err := tx.Create(&conversation).Error; err != nil {
+	if result.RowsAffected == 0 {
 		tx.Rollback()
-		httpresponder.SendErrorResponse(w, r, "failed to create conversation", http.StatusInternalServerError)
+		httpresponder.SendErrorResponse(w, r, "request already handled", http.StatusConflict)
 		return
 	}
 
-	// add participants using verified user ids
-	now := time.Now()
-	participants := []database.DMParticipant{
-		{ConversationID: conversation.ID, UserID: verifiedUser.ID, JoinedAt: now},
-		{ConversationID: conversation.ID, UserID: verifiedOther.ID, JoinedAt: now},
-	}
-	if err := tx.Create(&participants).Error; err != nil {
+	// the dm is kept when friends remove each other, adding them back picks it up again
+	conversation, restored, err := friendConversation(tx, verifiedUser.ID, verifiedOther.ID)
+	if err != nil {
 		tx.Rollback()
-		httpresponder.SendErrorResponse(w, r, "failed to add participants", http.StatusInternalServerError)
+		httpresponder.SendErrorResponse(w, r, "failed to create conversation", http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Printf("Created conversation %s with participients: %s (%s) & %s (%s)\n", conversation.ID, verifiedUser.Username, verifiedUser.ID.String(), verifiedOther.Username, verifiedOther.ID.String())
+	if restored {
+		fmt.Printf("Reusing conversation %s for %s (%s) & %s (%s)\n", conversation.ID, verifiedUser.Username, verifiedUser.ID.String(), verifiedOther.Username, verifiedOther.ID.String())
+	} else {
+		fmt.Printf("Created conversation %s with participients: %s (%s) & %s (%s)\n", conversation.ID, verifiedUser.Username, verifiedUser.ID.String(), verifiedOther.Username, verifiedOther.ID.String())
+	}
 
-	// create friendship
+	// create friendship, or bring back the removed one since the pair is unique
 	user1ID, user2ID := orderUserIDs(verifiedUser.ID, verifiedOther.ID)
-	friendship := database.Friendship{
-		User1ID:        user1ID,
-		User2ID:        user2ID,
-		ConversationID: conversation.ID,
+	now := time.Now()
+	var friendship database.Friendship
+	err = tx.Unscoped().Where("user1_id = ? AND user2_id = ?", user1ID, user2ID).Limit(1).Find(&friendship).Error
+	if err == nil {
+		if friendship.ID == uuid.Nil {
+			friendship = database.Friendship{
+				User1ID:        user1ID,
+				User2ID:        user2ID,
+				ConversationID: conversation.ID,
+			}
+			err = tx.Create(&friendship).Error
+		} else {
+			friendship.DeletedAt = gorm.DeletedAt{}
+			friendship.ConversationID = conversation.ID
+			friendship.CreatedAt = now
+			err = tx.Unscoped().Model(&friendship).Updates(map[string]any{
+				"deleted_at":      nil,
+				"conversation_id": conversation.ID,
+				"created_at":      now,
+			}).Error
+		}
 	}
-	if err := tx.Create(&friendship).Error; err != nil {
+	if err != nil {
 		tx.Rollback()
 		httpresponder.SendErrorResponse(w, r, "failed to create friendship", http.StatusInternalServerError)
 		return
@@ -416,7 +549,7 @@ func acceptRequest(w http.ResponseWriter, r *http.Request, user *database.User,
 	}
 
 	// notify both users via websocket
-	notifyFriendAccepted(&verifiedUser, &verifiedOther, &friendship, &conversation)
+	notifyFriendAccepted(&verifiedUser, &verifiedOther, &friendship, conversation, restored)
 
 	httpresponder.SendSuccessResponse(w, r, friendshipResponse{
 		ID:             friendship.ID.String(),
@@ -445,7 +578,7 @@ func declineFriendRequest(w http.ResponseWriter, r *http.Request) {
 
 	result := database.DB.Model(&database.FriendRequest{}).
 		Where("id = ? AND receiver_id = ? AND status = ?", requestID, user.ID, database.FriendRequestPending).
-		Update("status", database.FriendRequestDeclined)
+		Updates(map[string]any{"status": database.FriendRequestDeclined, "pending_key": nil})
 
 	if result.RowsAffected == 0 {
 		httpresponder.SendErrorResponse(w, r, "request not found", http.StatusNotFound)
@@ -468,9 +601,10 @@ func cancelFriendRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := database.DB.Where("id = ? AND sender_id = ? AND status = ?",
-		requestID, user.ID, database.FriendRequestPending).
-		Delete(&database.FriendRequest{})
+	// soft delete and free the pair for new requests in one statement
+	result := database.DB.Model(&database.FriendRequest{}).
+		Where("id = ? AND sender_id = ? AND status = ?", requestID, user.ID, database.FriendRequestPending).
+		Updates(map[string]any{"pending_key": nil, "deleted_at": time.Now()})
 
 	if result.RowsAffected == 0 {
 		httpresponder.SendErrorResponse(w, r, "request not found", http.StatusNotFound)
@@ -508,14 +642,77 @@ func removeFriend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	friendwatch.Forget(user.ID, friendID)
+
 	// notify both users
 	notifyFriendRemoved(user.ID, friendID)
 
 	httpresponder.SendSuccessResponse(w, r, map[string]bool{"removed": true})
 }
 
+func enableOnlineNotifications(w http.ResponseWriter, r *http.Request) {
+	user, friendID, ok := loadFriend(w, r)
+	if !ok {
+		return
+	}
+
+	var body onlineNotificationsRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := friendwatch.Watch(user.ID, friendID, body.Push); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update online notifications", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"notify_online": true, "notify_online_push": body.Push})
+}
+
+func disableOnlineNotifications(w http.ResponseWriter, r *http.Request) {
+	user, friendID, ok := loadFriend(w, r)
+	if !ok {
+		return
+	}
+
+	if err := friendwatch.Unwatch(user.ID, friendID); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update online notifications", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"notify_online": false, "notify_online_push": false})
+}
+
 // helpers
 
+// loadFriend returns the caller and the friend of the url, when they are friends
+func loadFriend(w http.ResponseWriter, r *http.Request) (*database.User, uuid.UUID, bool) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return nil, uuid.Nil, false
+	}
+
+	friendID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid friend id", http.StatusBadRequest)
+		return nil, uuid.Nil, false
+	}
+
+	user1ID, user2ID := orderUserIDs(user.ID, friendID)
+	var count int64
+	database.DB.Model(&database.Friendship{}).Where("user1_id = ? AND user2_id = ?", user1ID, user2ID).Count(&count)
+	if count == 0 {
+		httpresponder.SendErrorResponse(w, r, "friendship not found", http.StatusNotFound)
+		return nil, uuid.Nil, false
+	}
+
+	return user, friendID, true
+}
+
 func orderUserIDs(a, b uuid.UUID) (uuid.UUID, uuid.UUID) {
 	if a.String() < b.String() {
 		return a, b
@@ -523,6 +720,44 @@ func orderUserIDs(a, b uuid.UUID) (uuid.UUID, uuid.UUID) {
 	return b, a
 }
 
+// friendConversation returns the 1:1 conversation the two users still share from an earlier friendship,
+// restored is then true. otherwise a new one is created with both as participants
+func friendConversation(tx *gorm.DB, userID, otherID uuid.UUID) (conversation *database.DMConversation, restored bool, err error) {
+	var existing database.DMConversation
+	err = tx.Model(&database.DMConversation{}).
+		Joins("JOIN dm_participants pa ON pa.conversation_id = dm_conversations.id AND pa.user_id = ? AND pa.deleted_at IS NULL", userID).
+		Joins("JOIN dm_participants pb ON pb.conversation_id = dm_conversations.id AND pb.user_id = ? AND pb.deleted_at IS NULL", otherID).
+		Where("dm_conversations.is_group = ?", false).
+		Order("dm_conversations.created_at ASC").
+		Limit(1).
+		Find(&existing).Error
+	if err != nil {
+		return nil, false, err
+	}
+	if existing.ID != uuid.Nil {
+		return &existing, true, nil
+	}
+
+	conversation = &database.DMConversation{IsGroup: false}
+	if err := tx.Create(conversation).Error; err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	participants := []database.DMParticipant{
+		{ConversationID: conversation.ID, UserID: userID, JoinedAt: now},
+		{ConversationID: conversation.ID, UserID: otherID, JoinedAt: now},
+	}
+	if err := tx.Create(&participants).Error; err != nil {
+		return nil, false, err
+	}
+	if err := membercount.Participants(tx, conversation.ID, int64(len(participants))); err != nil {
+		return nil, false, err
+	}
+
+	return conversation, false, nil
+}
+
 func notifyFriendRequest(request *database.FriendRequest, sender, receiver *database.User) {
 	hub := websocket.GetHub()
 	if hub == nil {
@@ -541,7 +776,7 @@ func notifyFriendRequest(request *database.FriendRequest, sender, receiver *data
 	})
 }
 
-func notifyFriendAccepted(user, friend *database.User, friendship *database.Friendship, conversation *database.DMConversation) {
+func notifyFriendAccepted(user, friend *database.User, friendship *database.Friendship, conversation *database.DMConversation, restored bool) {
 	hub := websocket.GetHub()
 	if hub == nil {
 		return
@@ -564,17 +799,36 @@ func notifyFriendAccepted(user, friend *database.User, friendship *database.Frie
 		},
 	})
 
-	// also dispatch dm create to both
-	hub.DispatchToUser(user.ID, websocket.EventDMCreate, payload)
-	hub.DispatchToUser(friend.ID, websocket.EventDMCreate, payload)
-
-	// subscribe both to the new conversation
+	// subscribe both to the conversation, new or picked up again
 	for _, client := range hub.GetUserClients(user.ID) {
 		hub.SubscribeToConversation(client, conversation.ID)
 	}
 	for _, client := range hub.GetUserClients(friend.ID) {
 		hub.SubscribeToConversation(client, conversation.ID)
 	}
+
+	if restored {
+		// clients still have the dm, they relink it to the friendship instead of adding a new one
+		hub.DispatchToUserOnce(user.ID, websocket.EventFriendshipRestored, conversation.ID.String(), friendshipRestoredPayload(friendship, conversation, friend))
+		hub.DispatchToUserOnce(friend.ID, websocket.EventFriendshipRestored, conversation.ID.String(), friendshipRestoredPayload(friendship, conversation, user))
+		return
+	}
+
+	// also dispatch dm create to both, once per conversation as it may already exist
+	hub.DispatchToUserOnce(user.ID, websocket.EventDMCreate, conversation.ID.String(), payload)
+	hub.DispatchToUserOnce(friend.ID, websocket.EventDMCreate, conversation.ID.String(), payload)
+}
+
+func friendshipRestoredPayload(friendship *database.Friendship, conversation *database.DMConversation, other *database.User) map[string]any {
+	return map[string]any{
+		"friendship_id":   friendship.ID,
+		"conversation_id": conversation.ID,
+		"user": map[string]any{
+			"id":       other.ID,
+			"username": other.Username,
+			"domain":   other.Domain,
+		},
+	}
 }
 
 func notifyFriendRemoved(userID, friendID uuid.UUID) {
@@ -0,0 +1,65 @@
+package friendroutes_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hindsightchat/backend/src/testserver"
+)
+
+func TestFriendRequestCreatesDM(t *testing.T) {
+	s := testserver.Start(t)
+
+	alice, bob := s.Register(t, "alice"), s.Register(t, "bob")
+	ga, gb := s.Connect(t, alice), s.Connect(t, bob)
+
+	var request struct {
+		ID string `json:"id"`
+	}
+	if status := s.Request(t, alice, http.MethodPost, "/friends/requests", map[string]string{"user_id": bob.ID}, &request); status != http.StatusOK {
+		t.Fatalf("send friend request: status %d", status)
+	}
+
+	var created struct {
+		ID       string `json:"id"`
+		SenderID string `json:"sender_id"`
+	}
+	gb.Expect(t, "FRIEND_REQUEST_CREATE").Decode(t, &created)
+	if created.ID != request.ID || created.SenderID != alice.ID {
+		t.Fatalf("FRIEND_REQUEST_CREATE = %+v, want request %s from %s", created, request.ID, alice.ID)
+	}
+
+	var friendship struct {
+		ConversationID string `json:"conversation_id"`
+	}
+	if status := s.Request(t, bob, http.MethodPost, "/friends/requests/"+request.ID+"/accept", nil, &friendship); status != http.StatusOK {
+		t.Fatalf("accept friend request: status %d", status)
+	}
+	if friendship.ConversationID == "" {
+		t.Fatal("accepting did not return the dm")
+	}
+
+	var accepted struct {
+		ConversationID string `json:"conversation_id"`
+	}
+	ga.Expect(t, "FRIEND_REQUEST_ACCEPTED").Decode(t, &accepted)
+	if accepted.ConversationID != friendship.ConversationID {
+		t.Fatalf("FRIEND_REQUEST_ACCEPTED carries dm %s, want %s", accepted.ConversationID, friendship.ConversationID)
+	}
+
+	for name, g := range map[string]*testserver.Gateway{"alice": ga, "bob": gb} {
+		var dm struct {
+			ConversationID string `json:"conversation_id"`
+		}
+		g.Expect(t, "DM_CREATE").Decode(t, &dm)
+		if dm.ConversationID != friendship.ConversationID {
+			t.Fatalf("DM_CREATE to %s carries dm %s, want %s", name, dm.ConversationID, friendship.ConversationID)
+		}
+	}
+
+	for _, user := range []*testserver.User{alice, bob} {
+		if status := s.Request(t, user, http.MethodGet, "/conversation/"+friendship.ConversationID, nil, nil); status != http.StatusOK {
+			t.Fatalf("get dm as %s: status %d", user.Username, status)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package instanceroutes
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/ratelimit"
+)
+
+type instanceResponse struct {
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description,omitempty"`
+	Domain       string                 `json:"domain"`
+	Federation   bool                   `json:"federation"` // always false until instances can talk to each other
+	Registration string                 `json:"registration"`
+	Version      string                 `json:"version"`
+	Limits       instance.Limits        `json:"limits"`
+	Sessions     instance.SessionPolicy `json:"sessions"`
+	RateLimits   []ratelimit.Bucket     `json:"rate_limits"`
+}
+
+func RegisterRoutes(r chi.Router) {
+	// public, clients read it before anyone logs in
+	r.Get("/instance", getInstance)
+}
+
+func getInstance(w http.ResponseWriter, r *http.Request) {
+	settings := instance.Current()
+
+	httpresponder.SendSuccessResponse(w, r, instanceResponse{
+		Name:         settings.Name,
+		Description:  settings.Description,
+		Domain:       instance.Domain,
+		Registration: settings.Registration,
+		Version:      instance.Version,
+		Limits:       instance.GetLimits(),
+		RateLimits:   ratelimit.All,
+		Sessions:     instance.GetSessionPolicy(),
+	})
+}
@@ -0,0 +1,467 @@
+package interactionroutes
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	"github.com/hindsightchat/backend/src/lib/commands"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/msgcount"
+	"github.com/hindsightchat/backend/src/lib/publicnet"
+	"github.com/hindsightchat/backend/src/middleware"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+)
+
+// applications have this long to respond to an interaction
+const interactionLifetime = 15 * time.Minute
+
+// webhook deliveries are given this long, including an inline response
+const webhookTimeout = 5 * time.Second
+
+// callback types
+const (
+	ResponseMessage   = "message"   // posts a message in the channel as the bot
+	ResponseEphemeral = "ephemeral" // only the invoking user sees it
+)
+
+// interactions urls are set by any application owner, so only public addresses are dialed and redirects
+// are not followed, otherwise commands could make us post to internal services
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         publicnet.Dialer(webhookTimeout).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+type availableCommand struct {
+	ID            string            `json:"id"`
+	ApplicationID string            `json:"application_id"`
+	Application   string            `json:"application"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	Options       []commands.Option `json:"options"`
+}
+
+type invokeRequest struct {
+	ServerID  string         `json:"server_id"`
+	ChannelID string         `json:"channel_id"`
+	CommandID string         `json:"command_id"`
+	Options   map[string]any `json:"options"`
+}
+
+type callbackRequest struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// what the application receives, over the webhook or the gateway
+type interactionPayload struct {
+	ID            uuid.UUID           `json:"id"`
+	ApplicationID uuid.UUID           `json:"application_id"`
+	Token         string              `json:"token"`
+	ServerID      uuid.UUID           `json:"server_id"`
+	ChannelID     uuid.UUID           `json:"channel_id"`
+	User          websocket.UserBrief `json:"user"`
+	Data          interactionData     `json:"data"`
+	ExpiresAt     time.Time           `json:"expires_at"`
+}
+
+type interactionData struct {
+	CommandID uuid.UUID      `json:"command_id"`
+	Name      string         `json:"name"`
+	Options   map[string]any `json:"options"`
+}
+
+func RegisterRoutes(r chi.Router) {
+	r.Route("/interactions", func(r chi.Router) {
+		// authenticated by the interaction token, so the application doesnt need its bot token
+		r.Post("/{id}/{token}/callback", respondToInteraction)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RouteRequiresAuthentication)
+
+			// commands usable in a server, for the command picker
+			r.Get("/commands", listAvailableCommands)
+
+			// run a command
+			r.Post("/", invokeCommand)
+		})
+	})
+}
+
+func listAvailableCommands(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(r.URL.Query().Get("server_id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if !isMember(serverID, user.ID) {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return
+	}
+
+	// applications whose bot is in the server
+	botIDs := database.DB.Model(&database.ServerMember{}).Select("user_id").Where("server_id = ?", serverID)
+
+	var cmds []database.ApplicationCommand
+	err = database.DB.
+		Preload("Application").
+		Joins("JOIN applications ON applications.id = application_commands.application_id AND applications.deleted_at IS NULL").
+		Where("applications.bot_user_id IN (?)", botIDs).
+		Where("application_commands.server_id IS NULL OR application_commands.server_id = ?", serverID).
+		Order("application_commands.name ASC").
+		Find(&cmds).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch commands", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]availableCommand, 0, len(cmds))
+	for _, cmd := range cmds {
+		options := []commands.Option{}
+		json.Unmarshal([]byte(cmd.Options), &options)
+
+		response = append(response, availableCommand{
+			ID:            cmd.ID.String(),
+			ApplicationID: cmd.ApplicationID.String(),
+			Application:   cmd.Application.Name,
+			Name:          cmd.Name,
+			Description:   cmd.Description,
+			Options:       options,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func invokeCommand(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body invokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	serverID, err := uuid.FromString(body.ServerID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+	channelID, err := uuid.FromString(body.ChannelID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid channel id", http.StatusBadRequest)
+		return
+	}
+	commandID, err := uuid.FromString(body.CommandID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid command id", http.StatusBadRequest)
+		return
+	}
+
+	if !isMember(serverID, user.ID) {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return
+	}
+
+	var channel database.Channel
+	if err := database.DB.Where("id = ? AND server_id = ?", channelID, serverID).First(&channel).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return
+	}
+
+	var cmd database.ApplicationCommand
+	if err := database.DB.Preload("Application").Where("id = ?", commandID).First(&cmd).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "command not found", http.StatusNotFound)
+		return
+	}
+
+	if cmd.ServerID != nil && *cmd.ServerID != serverID {
+		httpresponder.SendErrorResponse(w, r, "command not found", http.StatusNotFound)
+		return
+	}
+
+	app := cmd.Application
+	if !isMember(serverID, app.BotUserID) {
+		httpresponder.SendErrorResponse(w, r, "command not found", http.StatusNotFound)
+		return
+	}
+
+	var definitions []commands.Option
+	json.Unmarshal([]byte(cmd.Options), &definitions)
+
+	if body.Options == nil {
+		body.Options = map[string]any{}
+	}
+	resolved, err := commands.Resolve(definitions, body.Options)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// gateway delivery needs the bot to be connected
+	hub := websocket.GetHub()
+	if app.InteractionsURL == "" && (hub == nil || !hub.IsUserOnline(app.BotUserID)) {
+		httpresponder.SendErrorResponse(w, r, "application is not responding", http.StatusServiceUnavailable)
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create interaction", http.StatusInternalServerError)
+		return
+	}
+
+	data := interactionData{CommandID: cmd.ID, Name: cmd.Name, Options: resolved}
+	dataJSON, _ := json.Marshal(data)
+
+	interaction := database.Interaction{
+		ApplicationID: app.ID,
+		CommandID:     cmd.ID,
+		UserID:        user.ID,
+		ServerID:      serverID,
+		ChannelID:     channelID,
+		Token:         token,
+		Data:          string(dataJSON),
+		ExpiresAt:     time.Now().Add(interactionLifetime),
+	}
+
+	if err := database.DB.Create(&interaction).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create interaction", http.StatusInternalServerError)
+		return
+	}
+
+	payload := interactionPayload{
+		ID:            interaction.ID,
+		ApplicationID: app.ID,
+		Token:         token,
+		ServerID:      serverID,
+		ChannelID:     channelID,
+		User: websocket.UserBrief{
+			ID:            user.ID,
+			Username:      user.Username,
+			Domain:        user.Domain,
+			ProfilePicURL: user.ProfilePicURL,
+		},
+		Data:      data,
+		ExpiresAt: interaction.ExpiresAt,
+	}
+
+	if app.InteractionsURL != "" {
+		inline, err := deliverWebhook(r.Context(), &app, &payload)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "application is not responding", http.StatusBadGateway)
+			return
+		}
+
+		// the webhook may answer straight away instead of calling back later
+		if inline != nil && inline.Type != "" {
+			if status, err := applyResponse(&interaction, &app, inline); err != nil {
+				httpresponder.SendErrorResponse(w, r, err.Error(), status)
+				return
+			}
+		}
+	} else {
+		hub.DispatchToUser(app.BotUserID, websocket.EventInteractionCreate, payload)
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"id":         interaction.ID,
+		"expires_at": interaction.ExpiresAt,
+	})
+}
+
+func respondToInteraction(w http.ResponseWriter, r *http.Request) {
+	interactionID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid interaction id", http.StatusBadRequest)
+		return
+	}
+
+	var interaction database.Interaction
+	err = database.DB.Where("id = ? AND token = ?", interactionID, chi.URLParam(r, "token")).First(&interaction).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "unknown interaction", http.StatusNotFound)
+		return
+	}
+
+	var body callbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var app database.Application
+	if err := database.DB.Where("id = ?", interaction.ApplicationID).First(&app).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "unknown interaction", http.StatusNotFound)
+		return
+	}
+
+	if status, err := applyResponse(&interaction, &app, &body); err != nil {
+		httpresponder.SendErrorResponse(w, r, err.Error(), status)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"responded": true})
+}
+
+// applyResponse posts the application's reply, each interaction can be answered once
+func applyResponse(interaction *database.Interaction, app *database.Application, body *callbackRequest) (int, error) {
+	if body.Type != ResponseMessage && body.Type != ResponseEphemeral {
+		return http.StatusBadRequest, errors.New("type must be message or ephemeral")
+	}
+	if body.Content == "" {
+		return http.StatusBadRequest, errors.New("content is required")
+	}
+	if quota := instance.CheckMessageLength(body.Content, false); quota != nil {
+		return http.StatusBadRequest, quota
+	}
+	if time.Now().After(interaction.ExpiresAt) {
+		return http.StatusGone, errors.New("interaction has expired")
+	}
+
+	// guarded so two racing callbacks cant both post
+	now := time.Now()
+	result := database.DB.Model(&database.Interaction{}).
+		Where("id = ? AND responded_at IS NULL", interaction.ID).
+		Update("responded_at", now)
+	if result.Error != nil {
+		return http.StatusInternalServerError, errors.New("failed to record response")
+	}
+	if result.RowsAffected == 0 {
+		return http.StatusConflict, errors.New("interaction already responded to")
+	}
+	interaction.RespondedAt = &now
+
+	var bot database.User
+	if err := database.DB.Where("id = ?", app.BotUserID).First(&bot).Error; err != nil {
+		return http.StatusInternalServerError, errors.New("failed to load bot")
+	}
+
+	author := &websocket.UserBrief{
+		ID:            bot.ID,
+		Username:      bot.Username,
+		Domain:        bot.Domain,
+		ProfilePicURL: bot.ProfilePicURL,
+		Bot:           true,
+	}
+
+	if body.Type == ResponseEphemeral {
+		if hub := websocket.GetHub(); hub != nil {
+			hub.DispatchToUser(interaction.UserID, websocket.EventEphemeralMessage, websocket.ChannelMessagePayload{
+				ID:            uuid.NewV4(),
+				ChannelID:     interaction.ChannelID,
+				ServerID:      interaction.ServerID,
+				AuthorID:      bot.ID,
+				Author:        author,
+				Content:       body.Content,
+				CreatedAt:     now,
+				InteractionID: &interaction.ID,
+			})
+		}
+		return http.StatusOK, nil
+	}
+
+	msg := database.ChannelMessage{
+		ChannelID:     interaction.ChannelID,
+		AuthorID:      bot.ID,
+		Content:       body.Content,
+		Attachments:   "[]",
+		InteractionID: &interaction.ID,
+	}
+	if err := msgcount.CreateChannelMessage(&msg); err != nil {
+		return http.StatusInternalServerError, errors.New("failed to create message")
+	}
+
+	websocket.PublishChannelMessage(interaction.ServerID, &msg, author)
+
+	return http.StatusOK, nil
+}
+
+// deliverWebhook POSTs the interaction to the application, signed with its interactions secret.
+// the signature is hex(hmac_sha256(secret, timestamp + "." + body))
+func deliverWebhook(ctx context.Context, app *database.Application, payload *interactionPayload) (*callbackRequest, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(app.InteractionsSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, app.InteractionsURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hindsight-Timestamp", timestamp)
+	req.Header.Set("X-Hindsight-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.New("webhook returned " + resp.Status)
+	}
+
+	var inline callbackRequest
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if len(data) > 0 {
+		json.Unmarshal(data, &inline)
+	}
+
+	return &inline, nil
+}
+
+func isMember(serverID, userID uuid.UUID) bool {
+	var count int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", serverID, userID).Count(&count)
+	return count > 0
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
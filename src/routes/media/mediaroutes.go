@@ -0,0 +1,25 @@
+package mediaroutes
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/storage"
+)
+
+func RegisterRoutes(r chi.Router) {
+	files := http.StripPrefix(storage.PathPrefix, http.FileServer(http.Dir(storage.Dir())))
+
+	r.Get(storage.PathPrefix+"*", func(w http.ResponseWriter, r *http.Request) {
+		// no directory listings
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable") // names are random, files never change
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,136 @@
+package messageroutes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/agegate"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/ratelimit"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	"github.com/hindsightchat/backend/src/lib/translation"
+	"github.com/hindsightchat/backend/src/middleware"
+	uuid "github.com/satori/go.uuid"
+)
+
+type translateRequest struct {
+	TargetLanguage string `json:"target_language"` // e.g en, de, pt-BR
+}
+
+type translateResponse struct {
+	MessageID string `json:"message_id"`
+	*translation.Result
+}
+
+func RegisterRoutes(r chi.Router) {
+	r.Route("/messages", func(r chi.Router) {
+		r.Use(middleware.RouteRequiresAuthentication)
+
+		// works for channel messages and dms, ids are unique across both
+		r.With(middleware.RateLimit(ratelimit.Translate)).Post("/{id}/translate", translateMessage)
+
+		// send a channel message to one of your dms, ids of the original are in the card
+		r.Post("/{id}/share", shareMessage)
+	})
+}
+
+func translateMessage(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !translation.Enabled() {
+		httpresponder.SendErrorResponse(w, r, "translation is not available on this instance", http.StatusNotImplemented)
+		return
+	}
+
+	messageID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	var body translateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !translation.ValidLanguage(body.TargetLanguage) {
+		httpresponder.SendErrorResponse(w, r, "invalid target language", http.StatusBadRequest)
+		return
+	}
+
+	content, status, msg := loadReadableContent(r, messageID, user.ID)
+	if status != http.StatusOK {
+		httpresponder.SendErrorResponse(w, r, msg, status)
+		return
+	}
+	if content == "" {
+		httpresponder.SendErrorResponse(w, r, "message has no text to translate", http.StatusBadRequest)
+		return
+	}
+
+	result, err := translation.Translate(r.Context(), messageID.String(), content, body.TargetLanguage)
+	if errors.Is(err, translation.ErrUnsupported) {
+		httpresponder.SendErrorResponse(w, r, "unsupported target language", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[translation] failed to translate %s: %v", messageID, err)
+		httpresponder.SendErrorResponse(w, r, "translation failed", http.StatusBadGateway)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, translateResponse{MessageID: messageID.String(), Result: result})
+}
+
+// loadReadableContent returns the content of a channel message or dm the user can see,
+// channel messages only when their server has translation turned on
+func loadReadableContent(r *http.Request, messageID, userID uuid.UUID) (string, int, string) {
+	var channelMsg database.ChannelMessage
+	if err := database.DB.Preload("Channel.Server").Where("id = ?", messageID).First(&channelMsg).Error; err == nil {
+		var count int64
+		database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", channelMsg.Channel.ServerID, userID).Count(&count)
+		if count == 0 {
+			return "", http.StatusNotFound, "message not found"
+		}
+		if !agegate.CanRead(userID, &channelMsg.Channel) {
+			return "", http.StatusForbidden, agegate.ErrorMessage
+		}
+		if !channelMsg.Channel.Server.TranslationEnabled {
+			return "", http.StatusForbidden, "translation is disabled in this server"
+		}
+		return channelMsg.Content, http.StatusOK, ""
+	}
+
+	var dm database.DirectMessage
+	err := database.DB.
+		Where("id = ?", messageID).
+		Scopes(restriction.VisibleDirectMessages(userID)).
+		First(&dm).Error
+	if err != nil {
+		return "", http.StatusNotFound, "message not found"
+	}
+
+	var participant database.DMParticipant
+	if err := database.DB.Where("conversation_id = ? AND user_id = ?", dm.ConversationID, userID).First(&participant).Error; err != nil {
+		return "", http.StatusNotFound, "message not found"
+	}
+	// hidden conversations have to be unlocked for this session first
+	if participant.Hidden && !hiddenconv.IsUnlocked(r.Context(), hiddenconv.Session(r), dm.ConversationID) {
+		return "", http.StatusLocked, "conversation is locked"
+	}
+	if dm.Encrypted {
+		return "", http.StatusBadRequest, "encrypted messages cannot be translated"
+	}
+
+	return dm.Content, http.StatusOK, ""
+}
@@ -0,0 +1,149 @@
+package messageroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/agegate"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/imageproxy"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	"github.com/hindsightchat/backend/src/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// optional comment sent along with the shared message
+	maxShareCommentLength = 2000
+
+	// the card quotes this much of the shared message
+	maxSharedContentLength = 1000
+)
+
+type shareRequest struct {
+	ConversationID string `json:"conversation_id"`
+	Content        string `json:"content"` // optional comment
+}
+
+// shareMessage re-posts a channel message into one of the users dms as a card pointing back at the original
+func shareMessage(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	var body shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Content) > maxShareCommentLength {
+		httpresponder.SendErrorResponse(w, r, "content must be at most 2000 characters", http.StatusBadRequest)
+		return
+	}
+
+	conversationID, err := uuid.FromString(body.ConversationID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+		return
+	}
+
+	// the user has to be able to read the message
+	var source database.ChannelMessage
+	if err := database.DB.Preload("Channel.Server").Preload("Author").Where("id = ?", messageID).First(&source).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "message not found", http.StatusNotFound)
+		return
+	}
+
+	var count int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", source.Channel.ServerID, user.ID).Count(&count)
+	if count == 0 {
+		httpresponder.SendErrorResponse(w, r, "message not found", http.StatusNotFound)
+		return
+	}
+	if !agegate.CanRead(user.ID, &source.Channel) {
+		httpresponder.SendErrorResponse(w, r, agegate.ErrorMessage, http.StatusForbidden)
+		return
+	}
+
+	// the other participants never had to verify their age
+	if source.Channel.NSFW {
+		httpresponder.SendErrorResponse(w, r, "messages from nsfw channels cannot be shared", http.StatusForbidden)
+		return
+	}
+
+	// and has to be in the conversation it goes to
+	database.DB.Model(&database.DMParticipant{}).Where("conversation_id = ? AND user_id = ?", conversationID, user.ID).Count(&count)
+	if count == 0 {
+		httpresponder.SendErrorResponse(w, r, "conversation not found", http.StatusNotFound)
+		return
+	}
+	if !websocket.CanPostInConversation(conversationID, user.ID) {
+		httpresponder.SendErrorResponse(w, r, "only the owner can post in this conversation", http.StatusForbidden)
+		return
+	}
+
+	quoted := source.Content
+	if len(quoted) > maxSharedContentLength {
+		quoted = quoted[:maxSharedContentLength-3] + "..."
+	}
+
+	embeds, err := json.Marshal([]types.Embed{{
+		Description: quoted,
+		Author: &types.EmbedAuthor{
+			Name:    source.Author.Username,
+			IconURL: source.Author.ProfilePicURL,
+		},
+		Fields: []types.EmbedField{
+			{Name: "server_id", Value: source.Channel.ServerID.String(), Inline: true},
+			{Name: "channel_id", Value: source.ChannelID.String(), Inline: true},
+			{Name: "message_id", Value: source.ID.String(), Inline: true},
+		},
+		Footer:    "#" + source.Channel.Name + " in " + source.Channel.Server.Name,
+		Timestamp: source.CreatedAt.UTC().Format(time.RFC3339),
+	}})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to encode embed", http.StatusInternalServerError)
+		return
+	}
+	encoded := string(embeds)
+
+	msg := database.DirectMessage{
+		ConversationID: conversationID,
+		AuthorID:       user.ID,
+		Content:        body.Content,
+		Attachments:    source.Attachments,
+		Embeds:         &encoded,
+	}
+	if msg.Attachments == "" {
+		msg.Attachments = "[]"
+	}
+
+	err = websocket.SendDirectMessage(&msg, &websocket.UserBrief{
+		ID:            user.ID,
+		Username:      user.Username,
+		Domain:        user.Domain,
+		ProfilePicURL: imageproxy.URL(user.ProfilePicURL),
+		Bot:           user.IsBot,
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to share message", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"message_id":      msg.ID,
+		"conversation_id": conversationID,
+	})
+}
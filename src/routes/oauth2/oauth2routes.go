@@ -0,0 +1,621 @@
+package oauth2routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/agegate"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/oauth2"
+	"github.com/hindsightchat/backend/src/middleware"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+type authorizeRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	ResponseType        string `json:"response_type"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// token endpoint responses follow rfc 6749 instead of our usual envelope so standard clients work
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type applicationBrief struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+}
+
+func RegisterRoutes(r chi.Router) {
+	r.Route("/oauth2", func(r chi.Router) {
+		// consent screen, called by the hindsight frontend with the user's session
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RouteRequiresAuthentication)
+
+			r.Get("/authorize", getAuthorize)
+			r.Post("/authorize", postAuthorize)
+
+			// apps the user granted access to
+			r.Get("/grants", listGrants)
+			r.Delete("/grants/{applicationId}", revokeGrant)
+		})
+
+		// called by the third party with client credentials
+		r.Post("/token", exchangeToken)
+		r.Post("/token/revoke", revokeToken)
+
+		// scoped apis, these only accept oauth2 access tokens
+		r.With(middleware.RouteRequiresOAuth2Scope(oauth2.ScopeIdentify)).Get("/@me", getMe)
+		r.With(middleware.RouteRequiresOAuth2Scope(oauth2.ScopeGuilds)).Get("/@me/servers", getMyServers)
+		r.With(middleware.RouteRequiresOAuth2Scope(oauth2.ScopeMessagesRead)).Get("/channels/{id}/messages", getChannelMessages)
+	})
+}
+
+// getAuthorize validates an authorization request and returns what the consent screen should show
+func getAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := authorizeRequest{
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		ResponseType:        query.Get("response_type"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	app, scopes, err := validateAuthorizeRequest(&req)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"application":  toApplicationBrief(app),
+		"scopes":       scopes,
+		"redirect_uri": req.RedirectURI,
+	})
+}
+
+// postAuthorize is called when the user approves, it returns where to send the browser
+func postAuthorize(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if user.IsBot {
+		httpresponder.SendErrorResponse(w, r, "bots cannot authorize applications", http.StatusForbidden)
+		return
+	}
+
+	var req authorizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	app, scopes, err := validateAuthorizeRequest(&req)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code, err := oauth2.GenerateSecret()
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create authorization code", http.StatusInternalServerError)
+		return
+	}
+
+	authCode := database.OAuth2AuthorizationCode{
+		CodeHash:            oauth2.Hash(code),
+		ApplicationID:       app.ID,
+		UserID:              user.ID,
+		Scopes:              strings.Join(scopes, " "),
+		RedirectURI:         req.RedirectURI,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		ExpiresAt:           time.Now().Add(oauth2.CodeLifetime),
+	}
+
+	if err := database.DB.Create(&authCode).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create authorization code", http.StatusInternalServerError)
+		return
+	}
+
+	redirect, _ := url.Parse(req.RedirectURI)
+	params := redirect.Query()
+	params.Set("code", code)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	redirect.RawQuery = params.Encode()
+
+	httpresponder.SendSuccessResponse(w, r, map[string]string{"redirect_to": redirect.String()})
+}
+
+func validateAuthorizeRequest(req *authorizeRequest) (*database.Application, []string, error) {
+	if req.ResponseType != "code" {
+		return nil, nil, errors.New("response_type must be code")
+	}
+
+	app, err := loadClient(req.ClientID)
+	if err != nil {
+		return nil, nil, errors.New("unknown client_id")
+	}
+
+	if app.OAuth2SecretHash == "" {
+		return nil, nil, errors.New("application has not enabled oauth2")
+	}
+
+	if !oauth2.RedirectURIAllowed(app, req.RedirectURI) {
+		return nil, nil, errors.New("redirect_uri is not registered for this application")
+	}
+
+	scopes, err := oauth2.ParseScopes(req.Scope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+		return nil, nil, errors.New("code_challenge_method must be S256")
+	}
+
+	return app, scopes, nil
+}
+
+func exchangeToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	// client credentials via basic auth or the form
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	app, err := loadClient(clientID)
+	if err != nil || !oauth2.SecretMatches(app.OAuth2SecretHash, clientSecret) {
+		sendOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		exchangeAuthorizationCode(w, r, app)
+	case "refresh_token":
+		exchangeRefreshToken(w, r, app)
+	default:
+		sendOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+func exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, app *database.Application) {
+	var authCode database.OAuth2AuthorizationCode
+	err := database.DB.
+		Where("code_hash = ? AND application_id = ?", oauth2.Hash(r.PostForm.Get("code")), app.ID).
+		Scopes(oauth2.ActiveUser("oauth2_authorization_codes")).
+		First(&authCode).Error
+	if err != nil || authCode.UsedAt != nil || time.Now().After(authCode.ExpiresAt) {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	if r.PostForm.Get("redirect_uri") != authCode.RedirectURI {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
+		return
+	}
+
+	if !oauth2.VerifyPKCE(authCode.CodeChallenge, authCode.CodeChallengeMethod, r.PostForm.Get("code_verifier")) {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier mismatch")
+		return
+	}
+
+	var response *tokenResponse
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// codes are single use, the guard makes a concurrent second exchange fail
+		result := tx.Model(&database.OAuth2AuthorizationCode{}).
+			Where("id = ? AND used_at IS NULL", authCode.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var err error
+		response, err = issueToken(tx, app.ID, authCode.UserID, authCode.Scopes)
+		return err
+	})
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+	if err != nil {
+		sendOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	sendTokenResponse(w, response)
+}
+
+func exchangeRefreshToken(w http.ResponseWriter, r *http.Request, app *database.Application) {
+	var existing database.OAuth2Token
+	err := database.DB.
+		Where("refresh_token_hash = ? AND application_id = ? AND refresh_expires_at > ?", oauth2.Hash(r.PostForm.Get("refresh_token")), app.ID, time.Now()).
+		Scopes(oauth2.ActiveUser("oauth2_tokens")).
+		First(&existing).Error
+	if err != nil {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	// optional narrowing of scopes
+	scopes := existing.Scopes
+	if requested := r.PostForm.Get("scope"); requested != "" {
+		parsed, err := oauth2.ParseScopes(requested)
+		if err != nil {
+			sendOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+			return
+		}
+		for _, scope := range parsed {
+			if !oauth2.HasScope(existing.Scopes, scope) {
+				sendOAuthError(w, http.StatusBadRequest, "invalid_scope", "cannot widen scopes on refresh")
+				return
+			}
+		}
+		scopes = strings.Join(parsed, " ")
+	}
+
+	var response *tokenResponse
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// refresh tokens rotate, the old pair stops working
+		result := tx.Unscoped().Where("id = ?", existing.ID).Delete(&database.OAuth2Token{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var err error
+		response, err = issueToken(tx, app.ID, existing.UserID, scopes)
+		return err
+	})
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+	if err != nil {
+		sendOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	sendTokenResponse(w, response)
+}
+
+// revokeToken implements rfc 7009, it always answers 200 for valid clients
+func revokeToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	app, err := loadClient(clientID)
+	if err != nil || !oauth2.SecretMatches(app.OAuth2SecretHash, clientSecret) {
+		sendOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	hash := oauth2.Hash(r.PostForm.Get("token"))
+	database.DB.Unscoped().
+		Where("application_id = ? AND (access_token_hash = ? OR refresh_token_hash = ?)", app.ID, hash, hash).
+		Delete(&database.OAuth2Token{})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func listGrants(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var tokens []database.OAuth2Token
+	err = database.DB.
+		Preload("Application").
+		Where("user_id = ? AND refresh_expires_at > ?", user.ID, time.Now()).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch grants", http.StatusInternalServerError)
+		return
+	}
+
+	// one entry per application, a user may have several live tokens for the same app
+	seen := map[uuid.UUID]bool{}
+	grants := make([]map[string]any, 0, len(tokens))
+	for _, t := range tokens {
+		if seen[t.ApplicationID] {
+			continue
+		}
+		seen[t.ApplicationID] = true
+		grants = append(grants, map[string]any{
+			"application": toApplicationBrief(&t.Application),
+			"scopes":      strings.Fields(t.Scopes),
+			"granted_at":  t.CreatedAt,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, grants)
+}
+
+func revokeGrant(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	appID, err := uuid.FromString(chi.URLParam(r, "applicationId"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid application id", http.StatusBadRequest)
+		return
+	}
+
+	err = database.DB.Unscoped().
+		Where("user_id = ? AND application_id = ?", user.ID, appID).
+		Delete(&database.OAuth2Token{}).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to revoke access", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"revoked": true})
+}
+
+// scoped apis
+
+func getMe(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// no email, that would need its own scope
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"id":              user.ID.String(),
+		"username":        user.Username,
+		"domain":          user.Domain,
+		"profile_pic_url": user.ProfilePicURL,
+		"bot":             user.IsBot,
+	})
+}
+
+func getMyServers(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var memberships []database.ServerMember
+	err = database.DB.
+		Preload("Server").
+		Where("user_id = ?", user.ID).
+		Find(&memberships).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch servers", http.StatusInternalServerError)
+		return
+	}
+
+	servers := make([]map[string]any, 0, len(memberships))
+	for _, m := range memberships {
+		servers = append(servers, map[string]any{
+			"id":        m.Server.ID.String(),
+			"name":      m.Server.Name,
+			"icon":      m.Server.Icon,
+			"owner":     m.Server.OwnerID == user.ID,
+			"joined_at": m.JoinedAt,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, servers)
+}
+
+func getChannelMessages(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid channel id", http.StatusBadRequest)
+		return
+	}
+
+	var channel database.Channel
+	if err := database.DB.Where("id = ?", channelID).First(&channel).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return
+	}
+
+	var count int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", channel.ServerID, user.ID).Count(&count)
+	if count == 0 {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return
+	}
+
+	if !agegate.CanRead(user.ID, &channel) {
+		httpresponder.SendErrorResponse(w, r, agegate.ErrorMessage, http.StatusForbidden)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limitInt, err := strconv.Atoi(limitStr)
+		if err != nil || limitInt <= 0 || limitInt > 100 {
+			httpresponder.SendErrorResponse(w, r, "invalid limit, must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = limitInt
+	}
+
+	query := database.DB.Preload("Author").Where("channel_id = ?", channelID)
+
+	if before := r.URL.Query().Get("before"); before != "" {
+		beforeID, err := uuid.FromString(before)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid before id", http.StatusBadRequest)
+			return
+		}
+		var ref database.ChannelMessage
+		if err := database.DB.Where("id = ? AND channel_id = ?", beforeID, channelID).First(&ref).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "reference message not found", http.StatusNotFound)
+			return
+		}
+		query = query.Where("created_at < ?", ref.CreatedAt)
+	}
+
+	var messages []database.ChannelMessage
+	if err := query.Order("created_at DESC").Limit(limit).Find(&messages).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]any, 0, len(messages))
+	for _, m := range messages {
+		response = append(response, map[string]any{
+			"id":         m.ID.String(),
+			"channel_id": m.ChannelID.String(),
+			"content":    m.Content,
+			"author": map[string]any{
+				"id":       m.Author.ID.String(),
+				"username": m.Author.Username,
+				"domain":   m.Author.Domain,
+				"bot":      m.Author.IsBot,
+			},
+			"reply_to_id": m.ReplyToID,
+			"created_at":  m.CreatedAt,
+			"edited_at":   m.EditedAt,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// helpers
+
+func loadClient(clientID string) (*database.Application, error) {
+	appID, err := uuid.FromString(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var app database.Application
+	if err := database.DB.Where("id = ?", appID).First(&app).Error; err != nil {
+		return nil, err
+	}
+
+	return &app, nil
+}
+
+func issueToken(tx *gorm.DB, appID, userID uuid.UUID, scopes string) (*tokenResponse, error) {
+	accessToken, err := oauth2.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := oauth2.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token := database.OAuth2Token{
+		ApplicationID:    appID,
+		UserID:           userID,
+		AccessTokenHash:  oauth2.Hash(accessToken),
+		RefreshTokenHash: oauth2.Hash(refreshToken),
+		Scopes:           scopes,
+		ExpiresAt:        now.Add(oauth2.AccessTokenLifetime),
+		RefreshExpiresAt: now.Add(oauth2.RefreshTokenLifetime),
+	}
+
+	if err := tx.Create(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(oauth2.AccessTokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scopes,
+	}, nil
+}
+
+func sendTokenResponse(w http.ResponseWriter, response *tokenResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func sendOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(oauthError{Error: code, ErrorDescription: description})
+}
+
+func toApplicationBrief(app *database.Application) applicationBrief {
+	return applicationBrief{
+		ID:          app.ID.String(),
+		Name:        app.Name,
+		Description: app.Description,
+		Icon:        app.Icon,
+	}
+}
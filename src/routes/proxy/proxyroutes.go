@@ -0,0 +1,48 @@
+package proxyroutes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/imageproxy"
+)
+
+func RegisterRoutes(r chi.Router) {
+	// public, the signature is what stops it from being an open proxy
+	r.Get(imageproxy.PathPrefix+"{signature}/{url}", proxyImage)
+}
+
+func proxyImage(w http.ResponseWriter, r *http.Request) {
+	raw, ok := imageproxy.Verify(chi.URLParam(r, "signature"), chi.URLParam(r, "url"))
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	img, err := imageproxy.Fetch(r.Context(), raw)
+	switch {
+	case errors.Is(err, imageproxy.ErrInvalidURL), errors.Is(err, imageproxy.ErrForbidden):
+		httpresponder.SendErrorResponse(w, r, "url not allowed", http.StatusForbidden)
+		return
+	case errors.Is(err, imageproxy.ErrTooLarge):
+		httpresponder.SendErrorResponse(w, r, "image too large", http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, imageproxy.ErrNotAnImage):
+		httpresponder.SendErrorResponse(w, r, "not an image", http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		httpresponder.SendErrorResponse(w, r, "failed to fetch image", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(img.Body)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.WriteHeader(http.StatusOK)
+	w.Write(img.Body)
+}
@@ -0,0 +1,215 @@
+package reportroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/ratelimit"
+	"github.com/hindsightchat/backend/src/middleware"
+	uuid "github.com/satori/go.uuid"
+)
+
+type createReportRequest struct {
+	TargetType string `json:"target_type"` // message, user or server
+	TargetID   string `json:"target_id"`
+	Reason     string `json:"reason"`
+	Details    string `json:"details"`
+}
+
+type reportResponse struct {
+	ID         string     `json:"id"`
+	TargetType string     `json:"target_type"`
+	TargetID   string     `json:"target_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	Status     int        `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+func RegisterRoutes(r chi.Router) {
+	r.Route("/reports", func(r chi.Router) {
+		r.Use(middleware.RouteRequiresAuthentication)
+
+		// file a report
+		r.With(middleware.RateLimit(ratelimit.Reports)).Post("/", createReport)
+
+		// reports i have filed
+		r.Get("/", getMyReports)
+	})
+}
+
+func createReport(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body createReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	targetID, err := uuid.FromString(body.TargetID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid target id", http.StatusBadRequest)
+		return
+	}
+
+	reason := strings.ToLower(strings.TrimSpace(body.Reason))
+	if !database.ReportReasons[reason] {
+		httpresponder.SendErrorResponse(w, r, "invalid reason", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Details) > 1000 {
+		httpresponder.SendErrorResponse(w, r, "details must be at most 1000 characters", http.StatusBadRequest)
+		return
+	}
+
+	report := database.Report{
+		ReporterID: user.ID,
+		TargetType: body.TargetType,
+		TargetID:   targetID,
+		Reason:     reason,
+		Details:    body.Details,
+		Status:     database.ReportOpen,
+	}
+
+	// resolve the target and capture context for moderators
+	switch body.TargetType {
+	case database.ReportTargetMessage:
+		if !fillMessageContext(&report, user.ID) {
+			httpresponder.SendErrorResponse(w, r, "message not found", http.StatusNotFound)
+			return
+		}
+
+	case database.ReportTargetUser:
+		if targetID == user.ID {
+			httpresponder.SendErrorResponse(w, r, "cannot report yourself", http.StatusBadRequest)
+			return
+		}
+
+		var target database.User
+		if err := database.DB.Where("id = ?", targetID).First(&target).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "user not found", http.StatusNotFound)
+			return
+		}
+		report.ReportedUserID = &target.ID
+
+	case database.ReportTargetServer:
+		var server database.Server
+		if err := database.DB.Where("id = ?", targetID).First(&server).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "server not found", http.StatusNotFound)
+			return
+		}
+		report.ServerID = &server.ID
+		report.ReportedUserID = &server.OwnerID
+
+	default:
+		httpresponder.SendErrorResponse(w, r, "target_type must be message, user or server", http.StatusBadRequest)
+		return
+	}
+
+	// one open report per reporter and target is enough
+	var existing database.Report
+	err = database.DB.Where("reporter_id = ? AND target_type = ? AND target_id = ? AND status IN ?",
+		user.ID, report.TargetType, report.TargetID, []database.ReportStatus{database.ReportOpen, database.ReportReviewing}).
+		First(&existing).Error
+
+	if err == nil {
+		httpresponder.SendErrorResponse(w, r, "you already reported this", http.StatusConflict)
+		return
+	}
+
+	if err := database.DB.Create(&report).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create report", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toReportResponse(&report))
+}
+
+func getMyReports(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var reports []database.Report
+	err = database.DB.
+		Where("reporter_id = ?", user.ID).
+		Order("created_at DESC").
+		Limit(100).
+		Find(&reports).Error
+
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch reports", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]reportResponse, 0, len(reports))
+	for _, report := range reports {
+		response = append(response, toReportResponse(&report))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// helpers
+
+// fillMessageContext looks the message up in dms then channels, only if the reporter can see it
+func fillMessageContext(report *database.Report, reporterID uuid.UUID) bool {
+	var dm database.DirectMessage
+	if err := database.DB.Where("id = ?", report.TargetID).First(&dm).Error; err == nil {
+		var participant database.DMParticipant
+		err = database.DB.Where("conversation_id = ? AND user_id = ?", dm.ConversationID, reporterID).First(&participant).Error
+		if err != nil {
+			return false
+		}
+
+		report.ReportedUserID = &dm.AuthorID
+		report.ConversationID = &dm.ConversationID
+		report.MessageContent = dm.Content
+		return true
+	}
+
+	var msg database.ChannelMessage
+	if err := database.DB.Preload("Channel").Where("id = ?", report.TargetID).First(&msg).Error; err != nil {
+		return false
+	}
+
+	var membership database.ServerMember
+	err := database.DB.Where("server_id = ? AND user_id = ?", msg.Channel.ServerID, reporterID).First(&membership).Error
+	if err != nil {
+		return false
+	}
+
+	report.ReportedUserID = &msg.AuthorID
+	report.ServerID = &msg.Channel.ServerID
+	report.ChannelID = &msg.ChannelID
+	report.MessageContent = msg.Content
+	return true
+}
+
+func toReportResponse(report *database.Report) reportResponse {
+	return reportResponse{
+		ID:         report.ID.String(),
+		TargetType: report.TargetType,
+		TargetID:   report.TargetID.String(),
+		Reason:     report.Reason,
+		Details:    report.Details,
+		Status:     int(report.Status),
+		CreatedAt:  report.CreatedAt,
+		ResolvedAt: report.ResolvedAt,
+	}
+}
@@ -0,0 +1,362 @@
+package serverroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	responsecache "github.com/hindsightchat/backend/src/lib/cache/response"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const maxCategoriesPerServer = 50
+
+type categoryResponse struct {
+	ID       string `json:"id"`
+	ServerID string `json:"server_id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+}
+
+func toCategoryResponse(c *database.ChannelCategory) categoryResponse {
+	return categoryResponse{
+		ID:       c.ID.String(),
+		ServerID: c.ServerID.String(),
+		Name:     c.Name,
+		Position: c.Position,
+	}
+}
+
+type categoryRequest struct {
+	Name string `json:"name"`
+}
+
+type categoryPositionsRequest struct {
+	CategoryIDs []string `json:"category_ids"` // every category of the server, in the new order
+}
+
+// CHANNEL_CATEGORY_UPDATE for a reorder, told apart from a single category by the categories list
+type categoryPositionsEvent struct {
+	ServerID   string             `json:"server_id"`
+	Categories []categoryResponse `json:"categories"`
+}
+
+// serverCategory checks the category belongs to the server, for putting channels into it
+func serverCategory(serverID uuid.UUID, raw string) (*uuid.UUID, bool) {
+	categoryID, err := uuid.FromString(raw)
+	if err != nil {
+		return nil, false
+	}
+	var count int64
+	database.DB.Model(&database.ChannelCategory{}).Where("id = ? AND server_id = ?", categoryID, serverID).Count(&count)
+	if count == 0 {
+		return nil, false
+	}
+	return &categoryID, true
+}
+
+// loadManagedCategory loads the category of the url in the server of the url, needs manage channels
+func loadManagedCategory(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*database.ChannelCategory, bool) {
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	categoryID, err := uuid.FromString(chi.URLParam(r, "categoryID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid category id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !permissions.MemberHas(serverID, userID, permissions.ManageChannels) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage channels", http.StatusForbidden)
+		return nil, false
+	}
+
+	var category database.ChannelCategory
+	if err := database.DB.Where("id = ? AND server_id = ?", categoryID, serverID).First(&category).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "category not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &category, true
+}
+
+// listCategories returns the categories of the server in order, open to members
+func listCategories(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	var membership database.ServerMember
+	if err := database.DB.Where("server_id = ? AND user_id = ?", serverID, user.ID).First(&membership).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return
+	}
+
+	var categories []database.ChannelCategory
+	if err := database.DB.Where("server_id = ?", serverID).Order("position ASC").Find(&categories).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch categories", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]categoryResponse, 0, len(categories))
+	for i := range categories {
+		response = append(response, toCategoryResponse(&categories[i]))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// createCategory adds a category after the existing ones, needs manage channels
+func createCategory(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if !permissions.MemberHas(serverID, user.ID, permissions.ManageChannels) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage channels", http.StatusForbidden)
+		return
+	}
+
+	var body categoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > maxChannelNameLength {
+		httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	var stats struct {
+		Count    int64
+		Position *int
+	}
+	err = database.DB.Model(&database.ChannelCategory{}).
+		Select("COUNT(*) AS count, MAX(position) AS position").
+		Where("server_id = ?", serverID).
+		Scan(&stats).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create category", http.StatusInternalServerError)
+		return
+	}
+	if stats.Count >= maxCategoriesPerServer {
+		httpresponder.SendErrorResponse(w, r, "this server has too many categories", http.StatusBadRequest)
+		return
+	}
+
+	category := database.ChannelCategory{ServerID: serverID, Name: name}
+	if stats.Position != nil {
+		category.Position = *stats.Position + 1
+	}
+
+	if err := database.DB.Create(&category).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create category", http.StatusInternalServerError)
+		return
+	}
+
+	responsecache.Invalidate(r.Context(), responsecache.ServerChannelsKey(serverID.String()))
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(serverID, websocket.EventCategoryCreate, toCategoryResponse(&category))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toCategoryResponse(&category))
+}
+
+// updateCategory renames a category
+func updateCategory(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	category, ok := loadManagedCategory(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	var body categoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > maxChannelNameLength {
+		httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	if name != category.Name {
+		if err := database.DB.Model(category).Update("name", name).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update category", http.StatusInternalServerError)
+			return
+		}
+
+		responsecache.Invalidate(r.Context(), responsecache.ServerChannelsKey(category.ServerID.String()))
+		if hub := websocket.GetHub(); hub != nil {
+			hub.DispatchToServer(category.ServerID, websocket.EventCategoryUpdate, toCategoryResponse(category))
+		}
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toCategoryResponse(category))
+}
+
+// deleteCategory removes a category, its channels stay and end up outside any category
+func deleteCategory(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	category, ok := loadManagedCategory(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	var moved []database.Channel
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("category_id = ?", category.ID).Find(&moved).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&database.Channel{}).Where("category_id = ?", category.ID).UpdateColumn("category_id", nil).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(category).Error
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete category", http.StatusInternalServerError)
+		return
+	}
+
+	responsecache.Invalidate(r.Context(), responsecache.ServerChannelsKey(category.ServerID.String()))
+	if hub := websocket.GetHub(); hub != nil {
+		for i := range moved {
+			moved[i].CategoryID = nil
+			hub.DispatchToServer(category.ServerID, websocket.EventChannelUpdate, toChannelResponse(&moved[i]))
+		}
+		hub.DispatchToServer(category.ServerID, websocket.EventCategoryDelete, map[string]any{
+			"id":        category.ID,
+			"server_id": category.ServerID,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// updateCategoryPositions puts the categories in the given order, the list has to name every category once
+func updateCategoryPositions(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if !permissions.MemberHas(serverID, user.ID, permissions.ManageChannels) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage channels", http.StatusForbidden)
+		return
+	}
+
+	var body categoryPositionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var categories []database.ChannelCategory
+	if err := database.DB.Where("server_id = ?", serverID).Find(&categories).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch categories", http.StatusInternalServerError)
+		return
+	}
+
+	byID := make(map[uuid.UUID]*database.ChannelCategory, len(categories))
+	for i := range categories {
+		byID[categories[i].ID] = &categories[i]
+	}
+
+	if len(body.CategoryIDs) != len(categories) {
+		httpresponder.SendErrorResponse(w, r, "category_ids must list every category of the server once", http.StatusBadRequest)
+		return
+	}
+
+	ordered := make([]*database.ChannelCategory, 0, len(body.CategoryIDs))
+	for _, raw := range body.CategoryIDs {
+		categoryID, err := uuid.FromString(raw)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid category id", http.StatusBadRequest)
+			return
+		}
+		category, ok := byID[categoryID]
+		if !ok {
+			httpresponder.SendErrorResponse(w, r, "category_ids must list every category of the server once", http.StatusBadRequest)
+			return
+		}
+		delete(byID, categoryID)
+		ordered = append(ordered, category)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for position, category := range ordered {
+			if category.Position == position {
+				continue
+			}
+			if err := tx.Model(&database.ChannelCategory{}).Where("id = ?", category.ID).UpdateColumn("position", position).Error; err != nil {
+				return err
+			}
+			category.Position = position
+		}
+		return nil
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to reorder categories", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]categoryResponse, 0, len(ordered))
+	for _, category := range ordered {
+		response = append(response, toCategoryResponse(category))
+	}
+
+	responsecache.Invalidate(r.Context(), responsecache.ServerChannelsKey(serverID.String()))
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(serverID, websocket.EventCategoryUpdate, categoryPositionsEvent{ServerID: serverID.String(), Categories: response})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
@@ -0,0 +1,419 @@
+package serverroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	responsecache "github.com/hindsightchat/backend/src/lib/cache/response"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxChannelNameLength        = 100
+	maxChannelDescriptionLength = 500
+	maxChannelTopicLength       = 1024
+)
+
+type channelPositionsRequest struct {
+	ChannelIDs []string `json:"channel_ids"` // every channel of the server, in the new order
+}
+
+// CHANNEL_UPDATE for a reorder, told apart from a single channel by the channels list
+type channelPositionsEvent struct {
+	ServerID string            `json:"server_id"`
+	Channels []channelResponse `json:"channels"`
+}
+
+type createChannelRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Topic       string `json:"topic"`
+	NSFW        bool   `json:"nsfw"`
+	Type        int    `json:"type"` // 0 text, 1 voice, 2 announcement
+	CategoryID  string `json:"category_id"`
+
+	// a channel of the same server to start from, its type, topic, nsfw flag and category are copied.
+	// channels have no permission overwrites or notification settings of their own, roles and the
+	// server notification settings already apply to every channel, so there is nothing else to copy
+	CloneFrom string `json:"clone_from"`
+}
+
+type updateChannelRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Topic       *string `json:"topic"`
+	NSFW        *bool   `json:"nsfw"`
+	Type        *int    `json:"type"`        // text channels can become announcement channels and back
+	CategoryID  *string `json:"category_id"` // empty string moves the channel out of its category
+}
+
+// loadManagedChannel loads the channel of the url and checks the caller may manage channels in its server.
+// under /servers/{id} the channel also has to belong to that server
+func loadManagedChannel(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*database.Channel, bool) {
+	channelID, err := uuid.FromString(chi.URLParam(r, "channelID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid channel id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	query := database.DB.Where("id = ?", channelID)
+	if rawServerID := chi.URLParam(r, "id"); rawServerID != "" {
+		serverID, err := uuid.FromString(rawServerID)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+			return nil, false
+		}
+		query = query.Where("server_id = ?", serverID)
+	}
+
+	var channel database.Channel
+	if err := query.First(&channel).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if !permissions.MemberHas(channel.ServerID, userID, permissions.ManageChannels) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage channels", http.StatusForbidden)
+		return nil, false
+	}
+
+	return &channel, true
+}
+
+// createChannel adds a channel at the end of the server's list, needs manage channels.
+// with clone_from the new channel starts from the settings of another channel of the server
+func createChannel(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if !permissions.MemberHas(serverID, user.ID, permissions.ManageChannels) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage channels", http.StatusForbidden)
+		return
+	}
+
+	var body createChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.CloneFrom != "" {
+		templateID, err := uuid.FromString(body.CloneFrom)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid clone_from channel id", http.StatusBadRequest)
+			return
+		}
+		var template database.Channel
+		if err := database.DB.Where("id = ? AND server_id = ?", templateID, serverID).First(&template).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "clone_from channel not found", http.StatusBadRequest)
+			return
+		}
+
+		body.Type = template.Type
+		body.NSFW = body.NSFW || template.NSFW
+		if body.Topic == "" {
+			body.Topic = template.Topic
+		}
+		if body.CategoryID == "" && template.CategoryID != nil {
+			body.CategoryID = template.CategoryID.String()
+		}
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > maxChannelNameLength {
+		httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(body.Description) > maxChannelDescriptionLength {
+		httpresponder.SendErrorResponse(w, r, "description must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+	if len(body.Topic) > maxChannelTopicLength {
+		httpresponder.SendErrorResponse(w, r, "topic must be at most 1024 characters", http.StatusBadRequest)
+		return
+	}
+	switch body.Type {
+	case database.ChannelTypeText, database.ChannelTypeVoice, database.ChannelTypeAnnouncement:
+	default:
+		httpresponder.SendErrorResponse(w, r, "unknown channel type", http.StatusBadRequest)
+		return
+	}
+
+	var categoryID *uuid.UUID
+	if body.CategoryID != "" {
+		id, ok := serverCategory(serverID, body.CategoryID)
+		if !ok {
+			httpresponder.SendErrorResponse(w, r, "category not found", http.StatusBadRequest)
+			return
+		}
+		categoryID = id
+	}
+
+	var stats struct {
+		Count    int64
+		Position *int
+	}
+	err = database.DB.Model(&database.Channel{}).
+		Select("COUNT(*) AS count, MAX(position) AS position").
+		Where("server_id = ?", serverID).
+		Scan(&stats).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create channel", http.StatusInternalServerError)
+		return
+	}
+	if maxChannels := instance.GetLimits().MaxChannelsPerServer; stats.Count >= int64(maxChannels) {
+		quota := &instance.QuotaError{Limit: "max_channels_per_server", Max: int64(maxChannels)}
+		httpresponder.SendErrorResponseWithDetails(w, r, "this server has too many channels", http.StatusBadRequest, quota)
+		return
+	}
+
+	channel := database.Channel{
+		ServerID:    serverID,
+		Name:        name,
+		Description: body.Description,
+		Topic:       body.Topic,
+		NSFW:        body.NSFW,
+		Type:        body.Type,
+		CategoryID:  categoryID,
+	}
+	if stats.Position != nil {
+		channel.Position = *stats.Position + 1
+	}
+
+	if err := database.DB.Create(&channel).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create channel", http.StatusInternalServerError)
+		return
+	}
+
+	responsecache.Invalidate(r.Context(), responsecache.ServerChannelsKey(serverID.String()))
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(serverID, websocket.EventChannelCreate, toChannelResponse(&channel))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toChannelResponse(&channel))
+}
+
+// updateChannel changes the name, description, topic, nsfw flag or type of a channel
+func updateChannel(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channel, ok := loadManagedChannel(w, r, user.ID)
+	if !ok {
+		return
+	}
+	serverID := channel.ServerID
+
+	var body updateChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]any{}
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" || len(name) > maxChannelNameLength {
+			httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+			return
+		}
+		updates["name"] = name
+	}
+	if body.Description != nil {
+		if len(*body.Description) > maxChannelDescriptionLength {
+			httpresponder.SendErrorResponse(w, r, "description must be at most 500 characters", http.StatusBadRequest)
+			return
+		}
+		updates["description"] = *body.Description
+	}
+	if body.Topic != nil {
+		if len(*body.Topic) > maxChannelTopicLength {
+			httpresponder.SendErrorResponse(w, r, "topic must be at most 1024 characters", http.StatusBadRequest)
+			return
+		}
+		updates["topic"] = *body.Topic
+	}
+	if body.NSFW != nil {
+		updates["nsfw"] = *body.NSFW
+	}
+	if body.Type != nil && *body.Type != channel.Type {
+		if channel.Type == database.ChannelTypeVoice || (*body.Type != database.ChannelTypeText && *body.Type != database.ChannelTypeAnnouncement) {
+			httpresponder.SendErrorResponse(w, r, "only text and announcement channels can be switched", http.StatusBadRequest)
+			return
+		}
+		updates["type"] = *body.Type
+	}
+	if body.CategoryID != nil {
+		if *body.CategoryID == "" {
+			updates["category_id"] = nil
+			channel.CategoryID = nil
+		} else {
+			categoryID, ok := serverCategory(serverID, *body.CategoryID)
+			if !ok {
+				httpresponder.SendErrorResponse(w, r, "category not found", http.StatusBadRequest)
+				return
+			}
+			updates["category_id"] = *categoryID
+			channel.CategoryID = categoryID
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := database.DB.Model(channel).Updates(updates).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update channel", http.StatusInternalServerError)
+			return
+		}
+
+		responsecache.Invalidate(r.Context(), responsecache.ServerChannelsKey(serverID.String()))
+		if hub := websocket.GetHub(); hub != nil {
+			hub.DispatchToServer(serverID, websocket.EventChannelUpdate, toChannelResponse(channel))
+		}
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toChannelResponse(channel))
+}
+
+// deleteChannel removes a channel and the follows it was part of, needs manage channels
+func deleteChannel(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channel, ok := loadManagedChannel(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("source_channel_id = ? OR target_channel_id = ?", channel.ID, channel.ID).Delete(&database.ChannelFollow{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(channel).Error
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete channel", http.StatusInternalServerError)
+		return
+	}
+
+	responsecache.Invalidate(r.Context(), responsecache.ServerChannelsKey(channel.ServerID.String()))
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(channel.ServerID, websocket.EventChannelDelete, map[string]any{
+			"id":        channel.ID,
+			"server_id": channel.ServerID,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// updateChannelPositions puts the channels in the given order, all in one transaction, needs manage channels.
+// the list has to name every channel of the server once
+func updateChannelPositions(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if !permissions.MemberHas(serverID, user.ID, permissions.ManageChannels) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage channels", http.StatusForbidden)
+		return
+	}
+
+	var body channelPositionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var channels []database.Channel
+	if err := database.DB.Where("server_id = ?", serverID).Find(&channels).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch channels", http.StatusInternalServerError)
+		return
+	}
+
+	byID := make(map[uuid.UUID]*database.Channel, len(channels))
+	for i := range channels {
+		byID[channels[i].ID] = &channels[i]
+	}
+
+	if len(body.ChannelIDs) != len(channels) {
+		httpresponder.SendErrorResponse(w, r, "channel_ids must list every channel of the server once", http.StatusBadRequest)
+		return
+	}
+
+	ordered := make([]*database.Channel, 0, len(body.ChannelIDs))
+	for _, raw := range body.ChannelIDs {
+		channelID, err := uuid.FromString(raw)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid channel id", http.StatusBadRequest)
+			return
+		}
+		channel, ok := byID[channelID]
+		if !ok {
+			httpresponder.SendErrorResponse(w, r, "channel_ids must list every channel of the server once", http.StatusBadRequest)
+			return
+		}
+		delete(byID, channelID)
+		ordered = append(ordered, channel)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for position, channel := range ordered {
+			if channel.Position == position {
+				continue
+			}
+			if err := tx.Model(&database.Channel{}).Where("id = ?", channel.ID).UpdateColumn("position", position).Error; err != nil {
+				return err
+			}
+			channel.Position = position
+		}
+		return nil
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to reorder channels", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]channelResponse, 0, len(ordered))
+	for _, channel := range ordered {
+		response = append(response, toChannelResponse(channel))
+	}
+
+	responsecache.Invalidate(r.Context(), responsecache.ServerChannelsKey(serverID.String()))
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(serverID, websocket.EventChannelUpdate, channelPositionsEvent{ServerID: serverID.String(), Channels: response})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
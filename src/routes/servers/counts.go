@@ -0,0 +1,77 @@
+package serverroutes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/msgcount"
+	uuid "github.com/satori/go.uuid"
+)
+
+// getChannelMessageCount returns the total messages in the channel, and with ?since=<message id> how many came after it
+func getChannelMessageCount(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	channelID, err := uuid.FromString(chi.URLParam(r, "channelID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid channel id", http.StatusBadRequest)
+		return
+	}
+
+	var members int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", serverID, user.ID).Count(&members)
+	if members == 0 {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return
+	}
+
+	var channels int64
+	database.DB.Model(&database.Channel{}).Where("id = ? AND server_id = ?", channelID, serverID).Count(&channels)
+	if channels == 0 {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return
+	}
+
+	total, err := msgcount.Total(msgcount.Channel, channelID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to count messages", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]any{"total": total}
+
+	if since := r.URL.Query().Get("since"); since != "" {
+		messageID, err := uuid.FromString(since)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+			return
+		}
+
+		count, err := msgcount.Since(msgcount.Channel, channelID, messageID)
+		if errors.Is(err, msgcount.ErrUnknownMessage) {
+			httpresponder.SendErrorResponse(w, r, "message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to count messages", http.StatusInternalServerError)
+			return
+		}
+		response["since"] = count
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
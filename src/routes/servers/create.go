@@ -0,0 +1,96 @@
+package serverroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/serversetup"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+type createServerRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type createServerResponse struct {
+	serverResponse
+	ChannelID string `json:"channel_id"` // the general channel it starts with
+}
+
+// createServer creates a server owned by the caller, with an everyone role, a general channel and the
+// caller as its only member
+func createServer(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body createServerRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > 100 {
+		httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	description := strings.TrimSpace(body.Description)
+	if len(description) > 500 {
+		httpresponder.SendErrorResponse(w, r, "description must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	var joined int64
+	if err := database.DB.Model(&database.ServerMember{}).Where("user_id = ?", user.ID).Count(&joined).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create server", http.StatusInternalServerError)
+		return
+	}
+	if maxServers := instance.GetLimits().MaxServersPerUser; joined >= int64(maxServers) {
+		quota := &instance.QuotaError{Limit: "max_servers_per_user", Max: int64(maxServers)}
+		httpresponder.SendErrorResponseWithDetails(w, r, "you are in too many servers", http.StatusBadRequest, quota)
+		return
+	}
+
+	server := database.Server{Name: name, Description: description, OwnerID: user.ID}
+	var channel *database.Channel
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		channel, err = serversetup.Create(tx, &server, []uuid.UUID{user.ID})
+		return err
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create server", http.StatusInternalServerError)
+		return
+	}
+
+	websocket.NotifyServerCreate(websocket.ServerSummary{
+		ID:          server.ID,
+		Name:        server.Name,
+		MemberCount: server.MemberCount,
+	}, []uuid.UUID{user.ID})
+
+	httpresponder.SendSuccessResponse(w, r, createServerResponse{
+		serverResponse: serverResponse{
+			ID:          server.ID.String(),
+			Name:        server.Name,
+			Description: server.Description,
+			OwnerID:     server.OwnerID.String(),
+			MemberCount: server.MemberCount,
+			JoinedAt:    server.CreatedAt,
+			Version:     server.Version,
+		},
+		ChannelID: channel.ID.String(),
+	})
+}
@@ -0,0 +1,228 @@
+package serverroutes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/domainverify"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+var errDomainTaken = errors.New("domain taken")
+
+type domainResponse struct {
+	Domain     string     `json:"domain"`
+	Status     string     `json:"status"` // pending, verified or failed
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+
+	// either one proves the domain
+	TXTName      string `json:"txt_name"`
+	TXTValue     string `json:"txt_value"`
+	WellKnownURL string `json:"well_known_url"` // serve the token as the body
+	Token        string `json:"token"`
+}
+
+type setDomainRequest struct {
+	Domain string `json:"domain"`
+}
+
+// loadManagedServer returns the server when the user may manage it, writing the error otherwise
+func loadManagedServer(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*database.Server, bool) {
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !permissions.MemberHas(serverID, userID, permissions.ManageServer) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage this server", http.StatusForbidden)
+		return nil, false
+	}
+
+	var server database.Server
+	if err := database.DB.Where("id = ?", serverID).First(&server).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "server not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &server, true
+}
+
+func getServerDomain(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	server, ok := loadManagedServer(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if server.OwnedDomain == nil {
+		httpresponder.SendErrorResponse(w, r, "server has no domain", http.StatusNotFound)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toDomainResponse(server))
+}
+
+// setServerDomain claims a domain for the server, it stays pending until verified. a domain only
+// belongs to another server once that one verified it, unverified claims are taken over
+func setServerDomain(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	server, ok := loadManagedServer(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	var body setDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	domain, ok := domainverify.Normalize(body.Domain)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, "invalid domain", http.StatusBadRequest)
+		return
+	}
+
+	if server.OwnedDomain != nil && *server.OwnedDomain == domain {
+		httpresponder.SendSuccessResponse(w, r, toDomainResponse(server))
+		return
+	}
+
+	token, err := domainverify.NewToken()
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create verification token", http.StatusInternalServerError)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var holder database.Server
+		err := tx.Where("owned_domain = ? AND id <> ?", domain, server.ID).Limit(1).Find(&holder).Error
+		if err != nil {
+			return err
+		}
+		if holder.ID != uuid.Nil {
+			if holder.DomainStatus == database.DomainStatusVerified {
+				return errDomainTaken
+			}
+			if err := tx.Model(&holder).Updates(clearedDomain()).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(server).Updates(map[string]any{
+			"owned_domain":              domain,
+			"domain_status":             database.DomainStatusPending,
+			"domain_verification_token": token,
+			"domain_verified_at":        nil,
+			"domain_checked_at":         nil,
+		}).Error
+	})
+	if errors.Is(err, errDomainTaken) {
+		httpresponder.SendErrorResponse(w, r, "domain is verified by another server", http.StatusConflict)
+		return
+	}
+	if _, duplicate := database.DuplicateKey(err); duplicate {
+		httpresponder.SendErrorResponse(w, r, "domain was claimed at the same time, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to set domain", http.StatusInternalServerError)
+		return
+	}
+
+	database.DB.Where("id = ?", server.ID).First(server)
+
+	httpresponder.SendSuccessResponse(w, r, toDomainResponse(server))
+}
+
+// verifyServerDomain checks the proof right away instead of waiting for the next scheduled check
+func verifyServerDomain(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	server, ok := loadManagedServer(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if server.OwnedDomain == nil {
+		httpresponder.SendErrorResponse(w, r, "server has no domain", http.StatusNotFound)
+		return
+	}
+
+	domainverify.Verify(server)
+
+	httpresponder.SendSuccessResponse(w, r, toDomainResponse(server))
+}
+
+func deleteServerDomain(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	server, ok := loadManagedServer(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Model(server).Updates(clearedDomain()).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to remove domain", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+func clearedDomain() map[string]any {
+	return map[string]any{
+		"owned_domain":              nil,
+		"domain_status":             "",
+		"domain_verification_token": "",
+		"domain_verified_at":        nil,
+		"domain_checked_at":         nil,
+	}
+}
+
+func toDomainResponse(server *database.Server) domainResponse {
+	name, value := domainverify.Record(*server.OwnedDomain, server.DomainVerificationToken)
+	return domainResponse{
+		Domain:       *server.OwnedDomain,
+		Status:       server.DomainStatus,
+		VerifiedAt:   server.DomainVerifiedAt,
+		CheckedAt:    server.DomainCheckedAt,
+		TXTName:      name,
+		TXTValue:     value,
+		WellKnownURL: domainverify.WellKnownURL(*server.OwnedDomain),
+		Token:        server.DomainVerificationToken,
+	}
+}
+
+// verifiedDomain is the domain shown with the verified badge, empty unless the server proved it
+func verifiedDomain(server *database.Server) string {
+	if server.OwnedDomain == nil || server.DomainStatus != database.DomainStatusVerified {
+		return ""
+	}
+	return *server.OwnedDomain
+}
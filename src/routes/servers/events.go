@@ -0,0 +1,490 @@
+package serverroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxEventTitleLength       = 100
+	maxEventDescriptionLength = 1000
+	maxEventLocationLength    = 255
+)
+
+type eventResponse struct {
+	ID          string     `json:"id"`
+	ServerID    string     `json:"server_id"`
+	CreatorID   string     `json:"creator_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	StartsAt    time.Time  `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+	ChannelID   *string    `json:"channel_id,omitempty"`
+	Location    string     `json:"location,omitempty"`
+	Going       int64      `json:"going"`
+	Interested  int64      `json:"interested"`
+	RSVP        string     `json:"rsvp,omitempty"` // your answer, not set in gateway dispatches
+}
+
+type eventRequest struct {
+	Title       *string    `json:"title"`
+	Description *string    `json:"description"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	ChannelID   *string    `json:"channel_id"` // either a channel of the server
+	Location    *string    `json:"location"`   // or somewhere else
+}
+
+type rsvpRequest struct {
+	Status string `json:"status"` // going or interested
+}
+
+type rsvpResponse struct {
+	User   userBrief `json:"user"`
+	Status string    `json:"status"`
+}
+
+type userBrief struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Domain   string `json:"domain"`
+}
+
+type rsvpCount struct {
+	EventID uuid.UUID
+	Status  string
+	Count   int64
+}
+
+func toEventResponse(event *database.ScheduledEvent) eventResponse {
+	response := eventResponse{
+		ID:          event.ID.String(),
+		ServerID:    event.ServerID.String(),
+		CreatorID:   event.CreatorID.String(),
+		Title:       event.Title,
+		Description: event.Description,
+		StartsAt:    event.StartsAt,
+		EndsAt:      event.EndsAt,
+		Location:    event.Location,
+	}
+	if event.ChannelID != nil {
+		channelID := event.ChannelID.String()
+		response.ChannelID = &channelID
+	}
+	return response
+}
+
+// withRSVPs fills the rsvp counts, and the viewers own answer when viewerID is set
+func withRSVPs(responses []eventResponse, eventIDs []uuid.UUID, viewerID *uuid.UUID) {
+	if len(eventIDs) == 0 {
+		return
+	}
+
+	var counts []rsvpCount
+	database.DB.Model(&database.EventRSVP{}).
+		Select("event_id, status, COUNT(*) AS count").
+		Where("event_id IN ?", eventIDs).
+		Group("event_id, status").
+		Scan(&counts)
+
+	var own []database.EventRSVP
+	if viewerID != nil {
+		database.DB.Where("event_id IN ? AND user_id = ?", eventIDs, *viewerID).Find(&own)
+	}
+
+	byID := make(map[string]*eventResponse, len(responses))
+	for i := range responses {
+		byID[responses[i].ID] = &responses[i]
+	}
+	for _, c := range counts {
+		if response, ok := byID[c.EventID.String()]; ok {
+			if c.Status == database.RSVPGoing {
+				response.Going = c.Count
+			} else {
+				response.Interested = c.Count
+			}
+		}
+	}
+	for _, rsvp := range own {
+		if response, ok := byID[rsvp.EventID.String()]; ok {
+			response.RSVP = rsvp.Status
+		}
+	}
+}
+
+// loadMemberEvent checks membership and loads the event of the url, writing the error response when either fails
+func loadMemberEvent(w http.ResponseWriter, r *http.Request) (*database.User, *database.ScheduledEvent, bool) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	eventID, err := uuid.FromString(chi.URLParam(r, "eventID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid event id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	if !isMember(serverID, user.ID) {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return nil, nil, false
+	}
+
+	var event database.ScheduledEvent
+	if err := database.DB.Where("id = ? AND server_id = ?", eventID, serverID).First(&event).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "event not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	return user, &event, true
+}
+
+func isMember(serverID, userID uuid.UUID) bool {
+	var count int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", serverID, userID).Count(&count)
+	return count > 0
+}
+
+// applyEventRequest validates the request onto event, returns the error message when it isnt valid
+func applyEventRequest(event *database.ScheduledEvent, body *eventRequest) string {
+	if body.Title != nil {
+		event.Title = strings.TrimSpace(*body.Title)
+	}
+	if body.Description != nil {
+		event.Description = *body.Description
+	}
+	if body.StartsAt != nil {
+		event.StartsAt = *body.StartsAt
+	}
+	if body.EndsAt != nil {
+		event.EndsAt = body.EndsAt
+	}
+	if body.ChannelID != nil {
+		if *body.ChannelID == "" {
+			event.ChannelID = nil
+		} else {
+			channelID, err := uuid.FromString(*body.ChannelID)
+			if err != nil {
+				return "invalid channel id"
+			}
+			var count int64
+			database.DB.Model(&database.Channel{}).Where("id = ? AND server_id = ?", channelID, event.ServerID).Count(&count)
+			if count == 0 {
+				return "channel not found"
+			}
+			event.ChannelID = &channelID
+		}
+	}
+	if body.Location != nil {
+		event.Location = strings.TrimSpace(*body.Location)
+	}
+
+	switch {
+	case event.Title == "" || len(event.Title) > maxEventTitleLength:
+		return "title must be between 1 and 100 characters"
+	case len(event.Description) > maxEventDescriptionLength:
+		return "description must be at most 1000 characters"
+	case len(event.Location) > maxEventLocationLength:
+		return "location must be at most 255 characters"
+	case event.StartsAt.IsZero():
+		return "starts_at is required"
+	case event.EndsAt != nil && !event.EndsAt.After(event.StartsAt):
+		return "ends_at must be after starts_at"
+	case (event.ChannelID == nil) == (event.Location == ""):
+		return "set either channel_id or location"
+	}
+	return ""
+}
+
+// listEvents returns the upcoming and running events of the server, soonest first.
+// query params: limit (default 50, max 100)
+func listEvents(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if !isMember(serverID, user.ID) {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 100 {
+			httpresponder.SendErrorResponse(w, r, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	var events []database.ScheduledEvent
+	err = database.DB.
+		Where("server_id = ?", serverID).
+		Where("starts_at >= ? OR ends_at >= ?", now, now).
+		Order("starts_at ASC").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch events", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]eventResponse, 0, len(events))
+	eventIDs := make([]uuid.UUID, 0, len(events))
+	for i := range events {
+		response = append(response, toEventResponse(&events[i]))
+		eventIDs = append(eventIDs, events[i].ID)
+	}
+	withRSVPs(response, eventIDs, &user.ID)
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func getEvent(w http.ResponseWriter, r *http.Request) {
+	user, event, ok := loadMemberEvent(w, r)
+	if !ok {
+		return
+	}
+
+	response := []eventResponse{toEventResponse(event)}
+	withRSVPs(response, []uuid.UUID{event.ID}, &user.ID)
+
+	httpresponder.SendSuccessResponse(w, r, response[0])
+}
+
+// createEvent schedules an event, needs manage events
+func createEvent(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if !permissions.MemberHas(serverID, user.ID, permissions.ManageEvents) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage events", http.StatusForbidden)
+		return
+	}
+
+	var body eventRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	event := database.ScheduledEvent{ServerID: serverID, CreatorID: user.ID}
+	if msg := applyEventRequest(&event, &body); msg != "" {
+		httpresponder.SendErrorResponse(w, r, msg, http.StatusBadRequest)
+		return
+	}
+	if !event.StartsAt.After(time.Now()) {
+		httpresponder.SendErrorResponse(w, r, "starts_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DB.Create(&event).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create event", http.StatusInternalServerError)
+		return
+	}
+
+	response := toEventResponse(&event)
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(serverID, websocket.EventScheduledEventCreate, response)
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// updateEvent changes an event, the creator or members with manage events may
+func updateEvent(w http.ResponseWriter, r *http.Request) {
+	user, event, ok := loadMemberEvent(w, r)
+	if !ok {
+		return
+	}
+
+	if event.CreatorID != user.ID && !permissions.MemberHas(event.ServerID, user.ID, permissions.ManageEvents) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage events", http.StatusForbidden)
+		return
+	}
+
+	var body eventRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	previousStart := event.StartsAt
+	if msg := applyEventRequest(event, &body); msg != "" {
+		httpresponder.SendErrorResponse(w, r, msg, http.StatusBadRequest)
+		return
+	}
+
+	// moved events get reminded again
+	if !event.StartsAt.Equal(previousStart) {
+		event.RemindedAt = nil
+	}
+
+	err := database.DB.Model(event).Select("title", "description", "starts_at", "ends_at", "channel_id", "location", "reminded_at").Updates(event).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update event", http.StatusInternalServerError)
+		return
+	}
+
+	responses := []eventResponse{toEventResponse(event)}
+	withRSVPs(responses, []uuid.UUID{event.ID}, nil)
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(event.ServerID, websocket.EventScheduledEventUpdate, responses[0])
+	}
+
+	withRSVPs(responses, []uuid.UUID{event.ID}, &user.ID)
+	httpresponder.SendSuccessResponse(w, r, responses[0])
+}
+
+// deleteEvent cancels an event, the creator or members with manage events may
+func deleteEvent(w http.ResponseWriter, r *http.Request) {
+	user, event, ok := loadMemberEvent(w, r)
+	if !ok {
+		return
+	}
+
+	if event.CreatorID != user.ID && !permissions.MemberHas(event.ServerID, user.ID, permissions.ManageEvents) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage events", http.StatusForbidden)
+		return
+	}
+
+	if err := database.DB.Delete(event).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete event", http.StatusInternalServerError)
+		return
+	}
+
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(event.ServerID, websocket.EventScheduledEventDelete, map[string]string{
+			"id":        event.ID.String(),
+			"server_id": event.ServerID.String(),
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// listRSVPs pages through the answers to an event.
+// query params: limit (default 50, max 100), after (user id of the last rsvp of the previous page)
+func listRSVPs(w http.ResponseWriter, r *http.Request) {
+	_, event, ok := loadMemberEvent(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 100 {
+			httpresponder.SendErrorResponse(w, r, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	query := database.DB.Preload("User").Where("event_id = ?", event.ID).Order("user_id ASC").Limit(limit)
+	if after := r.URL.Query().Get("after"); after != "" {
+		afterID, err := uuid.FromString(after)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid after id", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("user_id > ?", afterID)
+	}
+
+	var rsvps []database.EventRSVP
+	if err := query.Find(&rsvps).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch rsvps", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]rsvpResponse, 0, len(rsvps))
+	for _, rsvp := range rsvps {
+		response = append(response, rsvpResponse{
+			User:   userBrief{ID: rsvp.User.ID.String(), Username: rsvp.User.Username, Domain: rsvp.User.Domain},
+			Status: rsvp.Status,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func setRSVP(w http.ResponseWriter, r *http.Request) {
+	user, event, ok := loadMemberEvent(w, r)
+	if !ok {
+		return
+	}
+
+	var body rsvpRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Status != database.RSVPGoing && body.Status != database.RSVPInterested {
+		httpresponder.SendErrorResponse(w, r, "status must be going or interested", http.StatusBadRequest)
+		return
+	}
+
+	rsvp := database.EventRSVP{EventID: event.ID, UserID: user.ID, Status: body.Status}
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "event_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "updated_at"}),
+	}).Create(&rsvp).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to save rsvp", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]string{"event_id": event.ID.String(), "status": body.Status})
+}
+
+func deleteRSVP(w http.ResponseWriter, r *http.Request) {
+	user, event, ok := loadMemberEvent(w, r)
+	if !ok {
+		return
+	}
+
+	// hard delete, the unique index would trip over soft deleted answers
+	if err := database.DB.Unscoped().Where("event_id = ? AND user_id = ?", event.ID, user.ID).Delete(&database.EventRSVP{}).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to remove rsvp", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]string{"event_id": event.ID.String()})
+}
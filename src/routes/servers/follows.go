@@ -0,0 +1,334 @@
+package serverroutes
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/agegate"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/imageproxy"
+	"github.com/hindsightchat/backend/src/lib/msgcount"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+)
+
+type followRequest struct {
+	TargetChannelID string `json:"target_channel_id"`
+}
+
+type followResponse struct {
+	ID              string    `json:"id"`
+	SourceChannelID string    `json:"source_channel_id"`
+	SourceServerID  string    `json:"source_server_id"`
+	TargetChannelID string    `json:"target_channel_id"`
+	TargetServerID  string    `json:"target_server_id"`
+	CreatorID       string    `json:"creator_id"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func toFollowResponse(follow *database.ChannelFollow) followResponse {
+	return followResponse{
+		ID:              follow.ID.String(),
+		SourceChannelID: follow.SourceChannelID.String(),
+		SourceServerID:  follow.SourceChannel.ServerID.String(),
+		TargetChannelID: follow.TargetChannelID.String(),
+		TargetServerID:  follow.TargetChannel.ServerID.String(),
+		CreatorID:       follow.CreatorID.String(),
+		CreatedAt:       follow.CreatedAt,
+	}
+}
+
+// loadServerChannel reads the server and channel of the url, writing the error response when either is off
+func loadServerChannel(w http.ResponseWriter, r *http.Request) (*database.User, *database.Channel, bool) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	channelID, err := uuid.FromString(chi.URLParam(r, "channelID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid channel id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	var channel database.Channel
+	if err := database.DB.Where("id = ? AND server_id = ?", channelID, serverID).First(&channel).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	return user, &channel, true
+}
+
+// followChannel makes a channel of another server follow this announcement channel.
+// the user needs to be able to read the announcement channel and manage webhooks where the posts land
+func followChannel(w http.ResponseWriter, r *http.Request) {
+	user, source, ok := loadServerChannel(w, r)
+	if !ok {
+		return
+	}
+
+	if !isMember(source.ServerID, user.ID) || !agegate.CanRead(user.ID, source) {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return
+	}
+	if source.Type != database.ChannelTypeAnnouncement {
+		httpresponder.SendErrorResponse(w, r, "only announcement channels can be followed", http.StatusBadRequest)
+		return
+	}
+
+	var body followRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	targetID, err := uuid.FromString(body.TargetChannelID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid target channel id", http.StatusBadRequest)
+		return
+	}
+
+	var target database.Channel
+	if err := database.DB.Where("id = ?", targetID).First(&target).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "target channel not found", http.StatusNotFound)
+		return
+	}
+	if target.ServerID == source.ServerID {
+		httpresponder.SendErrorResponse(w, r, "target channel must be in another server", http.StatusBadRequest)
+		return
+	}
+	if target.Type == database.ChannelTypeVoice {
+		httpresponder.SendErrorResponse(w, r, "target channel must be a text channel", http.StatusBadRequest)
+		return
+	}
+	if source.NSFW && !target.NSFW {
+		httpresponder.SendErrorResponse(w, r, "nsfw channels can only be followed into nsfw channels", http.StatusBadRequest)
+		return
+	}
+	if !permissions.MemberHas(target.ServerID, user.ID, permissions.ManageWebhooks) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage webhooks in the target server", http.StatusForbidden)
+		return
+	}
+
+	var existing int64
+	database.DB.Model(&database.ChannelFollow{}).Where("source_channel_id = ? AND target_channel_id = ?", source.ID, target.ID).Count(&existing)
+	if existing > 0 {
+		httpresponder.SendErrorResponse(w, r, "channel already follows this channel", http.StatusConflict)
+		return
+	}
+
+	follow := database.ChannelFollow{SourceChannelID: source.ID, TargetChannelID: target.ID, CreatorID: user.ID}
+	if err := database.DB.Create(&follow).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to follow channel", http.StatusInternalServerError)
+		return
+	}
+	follow.SourceChannel = *source
+	follow.TargetChannel = target
+
+	httpresponder.SendSuccessResponse(w, r, toFollowResponse(&follow))
+}
+
+// listFollowers returns the channels following this announcement channel, needs manage channels
+func listFollowers(w http.ResponseWriter, r *http.Request) {
+	user, channel, ok := loadServerChannel(w, r)
+	if !ok {
+		return
+	}
+
+	if !permissions.MemberHas(channel.ServerID, user.ID, permissions.ManageChannels) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage channels", http.StatusForbidden)
+		return
+	}
+
+	listFollows(w, r, "source_channel_id = ?", channel.ID)
+}
+
+// listFollowing returns the announcement channels this channel follows, needs manage webhooks
+func listFollowing(w http.ResponseWriter, r *http.Request) {
+	user, channel, ok := loadServerChannel(w, r)
+	if !ok {
+		return
+	}
+
+	if !permissions.MemberHas(channel.ServerID, user.ID, permissions.ManageWebhooks) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage webhooks", http.StatusForbidden)
+		return
+	}
+
+	listFollows(w, r, "target_channel_id = ?", channel.ID)
+}
+
+func listFollows(w http.ResponseWriter, r *http.Request, where string, channelID uuid.UUID) {
+	var follows []database.ChannelFollow
+	if err := database.DB.Preload("SourceChannel").Preload("TargetChannel").Where(where, channelID).Order("created_at ASC").Find(&follows).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch follows", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]followResponse, 0, len(follows))
+	for i := range follows {
+		response = append(response, toFollowResponse(&follows[i]))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// removeFollower stops a follow from the announcement side, needs manage channels
+func removeFollower(w http.ResponseWriter, r *http.Request) {
+	user, channel, ok := loadServerChannel(w, r)
+	if !ok {
+		return
+	}
+
+	if !permissions.MemberHas(channel.ServerID, user.ID, permissions.ManageChannels) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage channels", http.StatusForbidden)
+		return
+	}
+
+	deleteFollow(w, r, "source_channel_id = ?", channel.ID)
+}
+
+// unfollowChannel stops a follow from the following side, needs manage webhooks
+func unfollowChannel(w http.ResponseWriter, r *http.Request) {
+	user, channel, ok := loadServerChannel(w, r)
+	if !ok {
+		return
+	}
+
+	if !permissions.MemberHas(channel.ServerID, user.ID, permissions.ManageWebhooks) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage webhooks", http.StatusForbidden)
+		return
+	}
+
+	deleteFollow(w, r, "target_channel_id = ?", channel.ID)
+}
+
+func deleteFollow(w http.ResponseWriter, r *http.Request, where string, channelID uuid.UUID) {
+	followID, err := uuid.FromString(chi.URLParam(r, "followID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid follow id", http.StatusBadRequest)
+		return
+	}
+
+	// hard delete so the channels can follow again, the unique index would trip over soft deleted rows
+	result := database.DB.Unscoped().Where("id = ?", followID).Where(where, channelID).Delete(&database.ChannelFollow{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to remove follow", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "follow not found", http.StatusNotFound)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// publishMessage sends a post of an announcement channel to every following channel.
+// the author or members with manage messages may, a post is published once
+func publishMessage(w http.ResponseWriter, r *http.Request) {
+	user, channel, ok := loadServerChannel(w, r)
+	if !ok {
+		return
+	}
+
+	if channel.Type != database.ChannelTypeAnnouncement {
+		httpresponder.SendErrorResponse(w, r, "only posts of announcement channels can be published", http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := uuid.FromString(chi.URLParam(r, "messageID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	var msg database.ChannelMessage
+	if err := database.DB.Where("id = ? AND channel_id = ?", messageID, channel.ID).First(&msg).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "message not found", http.StatusNotFound)
+		return
+	}
+
+	if msg.AuthorID != user.ID && !permissions.MemberHas(channel.ServerID, user.ID, permissions.ManageMessages) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage messages", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	result := database.DB.Model(&database.ChannelMessage{}).
+		Where("id = ? AND published_at IS NULL", msg.ID).
+		UpdateColumn("published_at", now)
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to publish message", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "message was already published", http.StatusConflict)
+		return
+	}
+	msg.PublishedAt = &now
+
+	var follows []database.ChannelFollow
+	database.DB.Preload("TargetChannel").Where("source_channel_id = ?", channel.ID).Find(&follows)
+
+	go mirrorMessage(channel, &msg, follows)
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"message_id":   msg.ID,
+		"published_at": now,
+		"followers":    len(follows),
+	})
+}
+
+// mirrorMessage stores a copy of the post in every following channel, shown under the name of the source
+func mirrorMessage(channel *database.Channel, msg *database.ChannelMessage, follows []database.ChannelFollow) {
+	var server database.Server
+	if err := database.DB.Select("id", "name", "icon").Where("id = ?", channel.ServerID).First(&server).Error; err != nil {
+		return
+	}
+
+	author := &websocket.UserBrief{
+		ID:            server.ID,
+		Username:      server.Name + " #" + channel.Name,
+		ProfilePicURL: imageproxy.URL(server.Icon),
+		Bot:           true,
+	}
+	followed := websocket.FollowInfo{
+		ServerID:   server.ID,
+		ServerName: server.Name,
+		ChannelID:  channel.ID,
+		MessageID:  msg.ID,
+	}
+
+	for _, follow := range follows {
+		mirrored := database.ChannelMessage{
+			ChannelID:       follow.TargetChannelID,
+			AuthorID:        msg.AuthorID,
+			Content:         msg.Content,
+			Attachments:     msg.Attachments,
+			Embeds:          msg.Embeds,
+			SourceMessageID: &msg.ID,
+			SourceChannelID: &channel.ID,
+		}
+		if err := msgcount.CreateChannelMessage(&mirrored); err != nil {
+			log.Printf("[follows] failed to mirror %s into %s: %v", msg.ID, follow.TargetChannelID, err)
+			continue
+		}
+
+		websocket.PublishFollowedMessage(follow.TargetChannel.ServerID, &mirrored, author, followed)
+	}
+}
@@ -0,0 +1,345 @@
+package serverroutes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/membercount"
+	"github.com/hindsightchat/backend/src/lib/pagination"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	"github.com/hindsightchat/backend/src/lib/randcode"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// codes are randcode.Readable, lowercase since CaseSensitiveMiddleware lowercases the path before
+	// the code is looked up. 10 of these are as strong as 8 mixed case ones
+	inviteCodeLength = 10
+	maxInviteUses    = 1000
+	maxInviteAge     = 30 * 24 * time.Hour
+
+	// when the request doesnt say
+	defaultInviteAge = 7 * 24 * time.Hour
+)
+
+var (
+	errInviteUsedUp  = errors.New("invite used up")
+	errAlreadyJoined = errors.New("already a member")
+)
+
+type inviteResponse struct {
+	Code      string     `json:"code"`
+	ServerID  string     `json:"server_id"`
+	CreatorID string     `json:"creator_id"`
+	MaxUses   int        `json:"max_uses"` // 0 for unlimited
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Temporary bool       `json:"temporary"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type createInviteRequest struct {
+	MaxUses int  `json:"max_uses"`
+	MaxAge  *int `json:"max_age"` // seconds, 0 never expires, defaults to 7 days
+
+	// members who join through it are removed again unless they get a role in time
+	Temporary bool `json:"temporary"`
+}
+
+// createInvite makes a new invite for the server, needs the create invites permission
+func createInvite(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if !permissions.MemberHas(serverID, user.ID, permissions.CreateInvites) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to create invites", http.StatusForbidden)
+		return
+	}
+
+	var body createInviteRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if body.MaxUses < 0 || body.MaxUses > maxInviteUses {
+		httpresponder.SendErrorResponse(w, r, "max_uses must be between 0 and 1000", http.StatusBadRequest)
+		return
+	}
+
+	age := defaultInviteAge
+	if body.MaxAge != nil {
+		age = time.Duration(*body.MaxAge) * time.Second
+		if age < 0 || age > maxInviteAge {
+			httpresponder.SendErrorResponse(w, r, "max_age must be between 0 and 30 days", http.StatusBadRequest)
+			return
+		}
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	invite := database.Invite{
+		Code:      code,
+		ServerID:  serverID,
+		CreatorID: user.ID,
+		MaxUses:   body.MaxUses,
+		Temporary: body.Temporary,
+	}
+	if age > 0 {
+		expiresAt := time.Now().Add(age)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if err := database.DB.Create(&invite).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toInviteResponse(&invite))
+}
+
+// listInvites returns the servers invites that still work, newest first, needs manage server.
+// query params: limit (default 50, max 100), cursor (code of the last invite of the previous page)
+func listInvites(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	server, ok := loadManagedServer(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	limit, ok := pagination.Limit(r)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, pagination.LimitError, http.StatusBadRequest)
+		return
+	}
+
+	usable := database.DB.
+		Model(&database.Invite{}).
+		Where("server_id = ? AND (expires_at IS NULL OR expires_at > ?)", server.ID, time.Now()).
+		Where("max_uses = 0 OR uses < max_uses")
+
+	var total int64
+	if err := usable.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+
+	query := usable.Session(&gorm.Session{}).Order("created_at DESC, id DESC").Limit(limit + 1)
+	if code := pagination.Cursor(r, ""); code != "" {
+		var cursor database.Invite
+		if err := usable.Session(&gorm.Session{}).Where("code = ?", code).First(&cursor).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "cursor invite not found", http.StatusNotFound)
+			return
+		}
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+
+	var invites []database.Invite
+	if err := query.Find(&invites).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+
+	hasMore := len(invites) > limit
+	if hasMore {
+		invites = invites[:limit]
+	}
+
+	response := make([]inviteResponse, 0, len(invites))
+	for i := range invites {
+		response = append(response, toInviteResponse(&invites[i]))
+	}
+
+	nextCursor := ""
+	if len(invites) > 0 {
+		nextCursor = invites[len(invites)-1].Code
+	}
+
+	httpresponder.SendListResponse(w, r, response, nextCursor, hasMore, &total)
+}
+
+// deleteInvite revokes an invite, its creator and members who can manage the server may
+func deleteInvite(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var invite database.Invite
+	if err := database.DB.Where("code = ?", chi.URLParam(r, "code")).First(&invite).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "invite not found", http.StatusNotFound)
+		return
+	}
+
+	if invite.CreatorID != user.ID && !permissions.MemberHas(invite.ServerID, user.ID, permissions.ManageServer) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to delete this invite", http.StatusForbidden)
+		return
+	}
+
+	if err := database.DB.Delete(&invite).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete invite", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// joinInvite makes the caller a member of the invites server
+func joinInvite(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var invite database.Invite
+	if err := database.DB.Preload("Server").Where("code = ?", chi.URLParam(r, "code")).First(&invite).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "invite not found", http.StatusNotFound)
+		return
+	}
+	if invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now()) {
+		httpresponder.SendErrorResponse(w, r, "invite has expired", http.StatusGone)
+		return
+	}
+
+	var joined int64
+	if err := database.DB.Model(&database.ServerMember{}).Where("user_id = ?", user.ID).Count(&joined).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to join server", http.StatusInternalServerError)
+		return
+	}
+	if maxServers := instance.GetLimits().MaxServersPerUser; joined >= int64(maxServers) {
+		quota := &instance.QuotaError{Limit: "max_servers_per_user", Max: int64(maxServers)}
+		httpresponder.SendErrorResponseWithDetails(w, r, "you are in too many servers", http.StatusBadRequest, quota)
+		return
+	}
+
+	serverID := invite.ServerID
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var existing database.ServerMember
+		err := tx.Unscoped().Where("server_id = ? AND user_id = ?", serverID, user.ID).Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing.ID != uuid.Nil && !existing.DeletedAt.Valid {
+			return errAlreadyJoined
+		}
+
+		// counted in the same transaction so the last use cant be taken twice
+		result := tx.Model(&database.Invite{}).
+			Where("id = ? AND (max_uses = 0 OR uses < max_uses)", invite.ID).
+			UpdateColumn("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInviteUsedUp
+		}
+
+		if existing.ID == uuid.Nil {
+			err = tx.Create(&database.ServerMember{ServerID: serverID, UserID: user.ID, JoinedAt: time.Now(), Temporary: invite.Temporary}).Error
+		} else {
+			// left before, the row comes back so the unique index stays happy
+			err = tx.Unscoped().Model(&existing).Updates(map[string]any{
+				"deleted_at":    nil,
+				"joined_at":     time.Now(),
+				"hide_activity": false,
+				"temporary":     invite.Temporary,
+			}).Error
+		}
+		if err != nil {
+			return err
+		}
+		return membercount.Members(tx, serverID, 1)
+	})
+	if errors.Is(err, errAlreadyJoined) {
+		httpresponder.SendErrorResponse(w, r, "you are already a member of this server", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errInviteUsedUp) {
+		httpresponder.SendErrorResponse(w, r, "invite has been used up", http.StatusGone)
+		return
+	}
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to join server", http.StatusInternalServerError)
+		return
+	}
+
+	server := invite.Server
+	database.DB.Select("member_count").Where("id = ?", serverID).First(&server)
+
+	// the joiner is subscribed first, so their clients see the member add too
+	websocket.NotifyServerCreate(websocket.ServerSummary{
+		ID:          server.ID,
+		Name:        server.Name,
+		Icon:        server.Icon,
+		MemberCount: server.MemberCount,
+	}, []uuid.UUID{user.ID})
+
+	websocket.NotifyServerMemberJoin(serverID, websocket.UserBrief{
+		ID:            user.ID,
+		Username:      user.Username,
+		Domain:        user.Domain,
+		ProfilePicURL: user.ProfilePicURL,
+		Bot:           user.IsBot,
+	})
+
+	httpresponder.SendSuccessResponse(w, r, serverResponse{
+		ID:          server.ID.String(),
+		Name:        server.Name,
+		Description: server.Description,
+		Icon:        server.Icon,
+		OwnerID:     server.OwnerID.String(),
+		MemberCount: server.MemberCount,
+		JoinedAt:    time.Now(),
+		Version:     server.Version,
+
+		VerifiedDomain: verifiedDomain(&server),
+	})
+}
+
+func newInviteCode() (string, error) {
+	return randcode.String(randcode.Readable, inviteCodeLength)
+}
+
+func toInviteResponse(invite *database.Invite) inviteResponse {
+	return inviteResponse{
+		Code:      invite.Code,
+		ServerID:  invite.ServerID.String(),
+		CreatorID: invite.CreatorID.String(),
+		MaxUses:   invite.MaxUses,
+		Uses:      invite.Uses,
+		ExpiresAt: invite.ExpiresAt,
+		Temporary: invite.Temporary,
+		CreatedAt: invite.CreatedAt,
+	}
+}
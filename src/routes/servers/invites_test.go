@@ -0,0 +1,22 @@
+package serverroutes
+
+import (
+	"strings"
+	"testing"
+)
+
+// the path is lowercased before routing, codes have to survive that
+func TestNewInviteCodeIsLowercase(t *testing.T) {
+	for range 200 {
+		code, err := newInviteCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != inviteCodeLength {
+			t.Fatalf("code %q has length %d, want %d", code, len(code), inviteCodeLength)
+		}
+		if code != strings.ToLower(code) {
+			t.Fatalf("code %q changes when lowercased", code)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package serverroutes
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/agegate"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/pagination"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// getChannelMessages returns the history of a channel in chronological order, same as dm history.
+// query params:
+// - limit (optional, default 50, max 100)
+// - before (optional, message id to paginate before), cursor is the same and takes next_cursor
+// - after (optional, message id to paginate after)
+// - around (optional, message id to paginate around, returns messages before and after it)
+func getChannelMessages(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := uuid.FromString(chi.URLParam(r, "channelID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid channel id", http.StatusBadRequest)
+		return
+	}
+
+	var channel database.Channel
+	if err := database.DB.Preload("Server").Where("id = ?", channelID).First(&channel).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return
+	}
+
+	// channels of servers you arent in dont exist for you
+	var membership int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", channel.ServerID, user.ID).Count(&membership)
+	if membership == 0 {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return
+	}
+
+	if !agegate.CanRead(user.ID, &channel) {
+		httpresponder.SendErrorResponse(w, r, agegate.ErrorMessage, http.StatusForbidden)
+		return
+	}
+
+	limit, ok := pagination.Limit(r)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, pagination.LimitError, http.StatusBadRequest)
+		return
+	}
+
+	before := pagination.Cursor(r, "before")
+	after := r.URL.Query().Get("after")
+	around := r.URL.Query().Get("around")
+
+	// with tombstones on, deleted messages stay in history as redacted placeholders
+	db := database.DB
+	if channel.Server.MessageTombstones {
+		db = database.DB.Unscoped()
+	}
+
+	history := func() *gorm.DB {
+		// webhooks may be deleted since, their posts still show under the name they had
+		return db.Where("channel_id = ?", channel.ID).
+			Preload("Author").
+			Preload("Webhook", func(db *gorm.DB) *gorm.DB { return db.Unscoped() })
+	}
+
+	// the message a before, after or around param points at, it has to be in this channel
+	reference := func(raw string) (*database.ChannelMessage, bool) {
+		messageID, err := uuid.FromString(raw)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+			return nil, false
+		}
+		var msg database.ChannelMessage
+		if err := db.Where("id = ? AND channel_id = ?", messageID, channel.ID).First(&msg).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "reference message not found", http.StatusNotFound)
+			return nil, false
+		}
+		return &msg, true
+	}
+
+	var messages []database.ChannelMessage
+	hasMore := false
+
+	switch {
+	case around != "":
+		ref, ok := reference(around)
+		if !ok {
+			return
+		}
+
+		halfLimit := limit / 2
+
+		// older half, newest first so the limit keeps the closest ones
+		var older []database.ChannelMessage
+		err = history().Where("created_at < ?", ref.CreatedAt).Order("created_at DESC").Limit(halfLimit).Find(&older).Error
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to fetch messages", http.StatusInternalServerError)
+			return
+		}
+
+		// newer half, including the reference message
+		var newer []database.ChannelMessage
+		err = history().Where("created_at >= ?", ref.CreatedAt).Order("created_at ASC").Limit(limit - halfLimit).Find(&newer).Error
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to fetch messages", http.StatusInternalServerError)
+			return
+		}
+
+		messages = make([]database.ChannelMessage, 0, len(older)+len(newer))
+		for i := len(older) - 1; i >= 0; i-- {
+			messages = append(messages, older[i])
+		}
+		messages = append(messages, newer...)
+
+	case after != "":
+		ref, ok := reference(after)
+		if !ok {
+			return
+		}
+
+		err = history().Where("created_at > ?", ref.CreatedAt).Order("created_at ASC").Limit(limit + 1).Find(&messages).Error
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to fetch messages", http.StatusInternalServerError)
+			return
+		}
+
+		if hasMore = len(messages) > limit; hasMore {
+			messages = messages[:limit]
+		}
+
+	default:
+		// the newest messages, or the ones older than before
+		query := history()
+		if before != "" {
+			ref, ok := reference(before)
+			if !ok {
+				return
+			}
+			query = query.Where("created_at < ?", ref.CreatedAt)
+		}
+
+		if err := query.Order("created_at DESC").Limit(limit + 1).Find(&messages).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to fetch messages", http.StatusInternalServerError)
+			return
+		}
+
+		if hasMore = len(messages) > limit; hasMore {
+			messages = messages[:limit]
+		}
+
+		// back to chronological order
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	followed := followedSources(messages)
+
+	response := make([]websocket.ChannelMessagePayload, 0, len(messages))
+	for i := range messages {
+		payload := websocket.StoredChannelMessage(channel.ServerID, &messages[i])
+		if info, ok := followed[messages[i].ID]; ok && !payload.Deleted {
+			payload.Followed = &info
+		}
+		response = append(response, payload)
+	}
+
+	// the cursor pages further back in history, newer pages are asked for with after=<newest id>
+	nextCursor := ""
+	if len(messages) > 0 && after == "" && around == "" {
+		nextCursor = messages[0].ID.String()
+	}
+
+	httpresponder.SendListResponse(w, r, response, nextCursor, hasMore, nil)
+}
+
+// followedSources is where mirrored posts of followed announcement channels came from, by message id
+func followedSources(messages []database.ChannelMessage) map[uuid.UUID]websocket.FollowInfo {
+	channelIDs := make([]uuid.UUID, 0)
+	for _, msg := range messages {
+		if msg.SourceChannelID != nil && msg.SourceMessageID != nil {
+			channelIDs = append(channelIDs, *msg.SourceChannelID)
+		}
+	}
+
+	result := make(map[uuid.UUID]websocket.FollowInfo)
+	if len(channelIDs) == 0 {
+		return result
+	}
+
+	var sources []database.Channel
+	database.DB.Preload("Server").Where("id IN ?", channelIDs).Find(&sources)
+
+	byID := make(map[uuid.UUID]*database.Channel, len(sources))
+	for i := range sources {
+		byID[sources[i].ID] = &sources[i]
+	}
+
+	for _, msg := range messages {
+		if msg.SourceChannelID == nil || msg.SourceMessageID == nil {
+			continue
+		}
+		source, ok := byID[*msg.SourceChannelID]
+		if !ok {
+			continue
+		}
+		result[msg.ID] = websocket.FollowInfo{
+			ServerID:   source.ServerID,
+			ServerName: source.Server.Name,
+			ChannelID:  source.ID,
+			MessageID:  *msg.SourceMessageID,
+		}
+	}
+
+	return result
+}
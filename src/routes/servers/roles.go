@@ -0,0 +1,617 @@
+package serverroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+var roleColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// users per bulk role assignment request, added and removed together
+const maxRoleMembersBatch = 100
+
+// roles per server, the default one included
+const maxRolesPerServer = 250
+
+type roleResponse struct {
+	ID          string `json:"id"`
+	ServerID    string `json:"server_id"`
+	Name        string `json:"name"`
+	Color       string `json:"color,omitempty"`
+	Permissions uint64 `json:"permissions"` // permission bits, see the permissions package
+	Position    int    `json:"position"`
+	IsDefault   bool   `json:"is_default"`
+	Mentionable bool   `json:"mentionable"`
+}
+
+type createRoleRequest struct {
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Permissions uint64 `json:"permissions"`
+	Mentionable bool   `json:"mentionable"`
+}
+
+type updateRoleRequest struct {
+	Name        *string `json:"name"`
+	Color       *string `json:"color"` // #RRGGBB, empty clears it
+	Permissions *uint64 `json:"permissions"`
+	Mentionable *bool   `json:"mentionable"`
+}
+
+type reorderRolesRequest struct {
+	Roles []struct {
+		ID       string `json:"id"`
+		Position int    `json:"position"`
+	} `json:"roles"`
+}
+
+type roleMembersRequest struct {
+	Add    []string `json:"add"`    // user ids that get the role
+	Remove []string `json:"remove"` // user ids that lose it
+}
+
+type roleMembersResponse struct {
+	ServerID string      `json:"server_id"`
+	RoleID   string      `json:"role_id"`
+	Added    []uuid.UUID `json:"added"`   // only users that didnt have the role before
+	Removed  []uuid.UUID `json:"removed"` // only users that had it
+}
+
+func toRoleResponse(role *database.Role) roleResponse {
+	return roleResponse{
+		ID:          role.ID.String(),
+		ServerID:    role.ServerID.String(),
+		Name:        role.Name,
+		Color:       role.Color,
+		Permissions: role.Permissions,
+		Position:    role.Position,
+		IsDefault:   role.IsDefault,
+		Mentionable: role.Mentionable,
+	}
+}
+
+// roleManager is the caller when they may manage roles: what they can grant and where their reach ends
+type roleManager struct {
+	serverID    uuid.UUID
+	permissions uint64
+	top         int // roles at or above this position are out of reach
+}
+
+// loadRoleManager checks the caller has manage roles in the server of the url
+func loadRoleManager(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*roleManager, bool) {
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	perms, err := permissions.ForMember(serverID, userID)
+	if err != nil || !permissions.Has(perms, permissions.ManageRoles) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage roles", http.StatusForbidden)
+		return nil, false
+	}
+
+	top, err := permissions.TopRolePosition(serverID, userID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage roles", http.StatusForbidden)
+		return nil, false
+	}
+
+	return &roleManager{serverID: serverID, permissions: perms, top: top}, true
+}
+
+// reaches reports whether the role sits below the managers top role, the server owner reaches every role
+func (m *roleManager) reaches(role *database.Role) bool {
+	return role.Position < m.top
+}
+
+// canGrant reports whether every changed bit is one the manager has themselves
+func (m *roleManager) canGrant(before, after uint64) bool {
+	return (before^after)&^m.permissions == 0
+}
+
+// listRoles returns the servers roles lowest first, any member can see them
+func listRoles(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	var members int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", serverID, user.ID).Count(&members)
+	if members == 0 {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return
+	}
+
+	roles, err := serverRoles(serverID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, roles)
+}
+
+// createRole adds a role right above the default one, needs manage roles and only grants what the caller has
+func createRole(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	manager, ok := loadRoleManager(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	var body createRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > 100 {
+		httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	if body.Color != "" && !roleColorPattern.MatchString(body.Color) {
+		httpresponder.SendErrorResponse(w, r, "color must be #RRGGBB", http.StatusBadRequest)
+		return
+	}
+	if !manager.canGrant(0, body.Permissions) {
+		httpresponder.SendErrorResponse(w, r, "cannot grant permissions you dont have", http.StatusForbidden)
+		return
+	}
+
+	var count int64
+	database.DB.Model(&database.Role{}).Where("server_id = ?", manager.serverID).Count(&count)
+	if count >= maxRolesPerServer {
+		httpresponder.SendErrorResponse(w, r, "this server has too many roles", http.StatusBadRequest)
+		return
+	}
+
+	role := database.Role{
+		ServerID:    manager.serverID,
+		Name:        name,
+		Color:       body.Color,
+		Permissions: body.Permissions,
+		Position:    1,
+		Mentionable: body.Mentionable,
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// everything above the default role moves up one to make room
+		err := tx.Model(&database.Role{}).
+			Where("server_id = ? AND is_default = ? AND position >= ?", manager.serverID, false, 1).
+			UpdateColumn("position", gorm.Expr("position + 1")).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&role).Error
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create role", http.StatusInternalServerError)
+		return
+	}
+
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(manager.serverID, websocket.EventServerRoleCreate, toRoleResponse(&role))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toRoleResponse(&role))
+}
+
+// reorderRoles moves roles to new positions, all in one go. roles at or above the callers own top role
+// can't be moved and nothing can be moved up to it, the default role always stays at the bottom
+func reorderRoles(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	manager, ok := loadRoleManager(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	var body reorderRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Roles) == 0 || len(body.Roles) > maxRolesPerServer {
+		httpresponder.SendErrorResponse(w, r, "roles must list between 1 and 250 roles", http.StatusBadRequest)
+		return
+	}
+
+	positions := make(map[uuid.UUID]int, len(body.Roles))
+	for _, entry := range body.Roles {
+		roleID, err := uuid.FromString(entry.ID)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid role id", http.StatusBadRequest)
+			return
+		}
+		if _, seen := positions[roleID]; seen {
+			httpresponder.SendErrorResponse(w, r, "a role can only be listed once", http.StatusBadRequest)
+			return
+		}
+		if entry.Position < 1 || entry.Position >= manager.top {
+			httpresponder.SendErrorResponse(w, r, "roles can only be moved between the default role and your own top role", http.StatusForbidden)
+			return
+		}
+		positions[roleID] = entry.Position
+	}
+
+	roleIDs := make([]uuid.UUID, 0, len(positions))
+	for roleID := range positions {
+		roleIDs = append(roleIDs, roleID)
+	}
+
+	var roles []database.Role
+	if err := database.DB.Where("server_id = ? AND id IN ?", manager.serverID, roleIDs).Find(&roles).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+	if len(roles) != len(roleIDs) {
+		httpresponder.SendErrorResponse(w, r, "role not found", http.StatusNotFound)
+		return
+	}
+	for _, role := range roles {
+		if role.IsDefault {
+			httpresponder.SendErrorResponse(w, r, "the default role cannot be moved", http.StatusBadRequest)
+			return
+		}
+		if !manager.reaches(&role) {
+			httpresponder.SendErrorResponse(w, r, "cannot move a role at or above your own top role", http.StatusForbidden)
+			return
+		}
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, role := range roles {
+			if err := tx.Model(&database.Role{}).Where("id = ?", role.ID).UpdateColumn("position", positions[role.ID]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to reorder roles", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := serverRoles(manager.serverID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(manager.serverID, websocket.EventServerRolesUpdate, map[string]any{
+			"server_id": manager.serverID,
+			"roles":     response,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// deleteRole removes a role and takes it from every member, the default role can't be deleted
+func deleteRole(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	manager, ok := loadRoleManager(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	roleID, err := uuid.FromString(chi.URLParam(r, "roleID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid role id", http.StatusBadRequest)
+		return
+	}
+
+	var role database.Role
+	if err := database.DB.Where("id = ? AND server_id = ?", roleID, manager.serverID).First(&role).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "role not found", http.StatusNotFound)
+		return
+	}
+	if role.IsDefault {
+		httpresponder.SendErrorResponse(w, r, "the default role cannot be deleted", http.StatusBadRequest)
+		return
+	}
+	if !manager.reaches(&role) {
+		httpresponder.SendErrorResponse(w, r, "cannot delete a role at or above your own top role", http.StatusForbidden)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM server_member_roles WHERE role_id = ?", role.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete role", http.StatusInternalServerError)
+		return
+	}
+
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToServer(manager.serverID, websocket.EventServerRoleDelete, map[string]any{
+			"server_id": manager.serverID,
+			"role_id":   role.ID,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+func serverRoles(serverID uuid.UUID) ([]roleResponse, error) {
+	var roles []database.Role
+	if err := database.DB.Where("server_id = ?", serverID).Order("position ASC, created_at ASC").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+
+	response := make([]roleResponse, 0, len(roles))
+	for i := range roles {
+		response = append(response, toRoleResponse(&roles[i]))
+	}
+	return response, nil
+}
+
+// updateRole changes the name, color, permission bits or whether members can be mentioned through the role,
+// needs manage roles. roles at or above the callers own top role are out of reach
+func updateRole(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	manager, ok := loadRoleManager(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	roleID, err := uuid.FromString(chi.URLParam(r, "roleID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid role id", http.StatusBadRequest)
+		return
+	}
+
+	var role database.Role
+	if err := database.DB.Where("id = ? AND server_id = ?", roleID, manager.serverID).First(&role).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "role not found", http.StatusNotFound)
+		return
+	}
+
+	// the default role sits below everyone, so it is always within reach
+	if !role.IsDefault && !manager.reaches(&role) {
+		httpresponder.SendErrorResponse(w, r, "cannot edit a role at or above your own top role", http.StatusForbidden)
+		return
+	}
+
+	var body updateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]any{}
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" || len(name) > 100 {
+			httpresponder.SendErrorResponse(w, r, "name must be between 1 and 100 characters", http.StatusBadRequest)
+			return
+		}
+		updates["name"] = name
+	}
+	if body.Color != nil {
+		if *body.Color != "" && !roleColorPattern.MatchString(*body.Color) {
+			httpresponder.SendErrorResponse(w, r, "color must be #RRGGBB", http.StatusBadRequest)
+			return
+		}
+		updates["color"] = *body.Color
+	}
+	if body.Mentionable != nil {
+		// the default role is @everyone, that has its own permission
+		if role.IsDefault && *body.Mentionable {
+			httpresponder.SendErrorResponse(w, r, "the default role cannot be mentionable", http.StatusBadRequest)
+			return
+		}
+		updates["mentionable"] = *body.Mentionable
+	}
+	if body.Permissions != nil {
+		if !manager.canGrant(role.Permissions, *body.Permissions) {
+			httpresponder.SendErrorResponse(w, r, "cannot grant permissions you dont have", http.StatusForbidden)
+			return
+		}
+		updates["permissions"] = *body.Permissions
+	}
+
+	if len(updates) > 0 {
+		if err := database.DB.Model(&role).Updates(updates).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update role", http.StatusInternalServerError)
+			return
+		}
+
+		if hub := websocket.GetHub(); hub != nil {
+			hub.DispatchToServer(manager.serverID, websocket.EventServerRoleUpdate, toRoleResponse(&role))
+		}
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toRoleResponse(&role))
+}
+
+// updateRoleMembers adds and removes a role for many members in one transaction, needs manage roles and a top role above it.
+// members that already have (or never had) the role are skipped, one SERVER_MEMBERS_UPDATE covers the batch
+func updateRoleMembers(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	manager, ok := loadRoleManager(w, r, user.ID)
+	if !ok {
+		return
+	}
+	serverID := manager.serverID
+
+	roleID, err := uuid.FromString(chi.URLParam(r, "roleID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid role id", http.StatusBadRequest)
+		return
+	}
+
+	var role database.Role
+	if err := database.DB.Where("id = ? AND server_id = ?", roleID, serverID).First(&role).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "role not found", http.StatusNotFound)
+		return
+	}
+	if role.IsDefault {
+		httpresponder.SendErrorResponse(w, r, "every member has the default role", http.StatusBadRequest)
+		return
+	}
+
+	// otherwise anyone with manage roles could hand out administrator through a higher role
+	if !manager.reaches(&role) {
+		httpresponder.SendErrorResponse(w, r, "cannot assign a role at or above your own top role", http.StatusForbidden)
+		return
+	}
+
+	// handing out a role grants its permissions, held to the same rule as creating and editing roles
+	if !manager.canGrant(0, role.Permissions) {
+		httpresponder.SendErrorResponse(w, r, "cannot assign a role with permissions you dont have", http.StatusForbidden)
+		return
+	}
+
+	var body roleMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Add)+len(body.Remove) == 0 {
+		httpresponder.SendErrorResponse(w, r, "add or remove at least one user", http.StatusBadRequest)
+		return
+	}
+	if len(body.Add)+len(body.Remove) > maxRoleMembersBatch {
+		httpresponder.SendErrorResponse(w, r, "at most 100 users per request", http.StatusBadRequest)
+		return
+	}
+
+	adding := make(map[uuid.UUID]bool, len(body.Add))
+	userIDs := make([]uuid.UUID, 0, len(body.Add)+len(body.Remove))
+	for i, list := range [][]string{body.Add, body.Remove} {
+		for _, raw := range list {
+			userID, err := uuid.FromString(raw)
+			if err != nil {
+				httpresponder.SendErrorResponse(w, r, "invalid user id", http.StatusBadRequest)
+				return
+			}
+			if _, seen := adding[userID]; seen {
+				httpresponder.SendErrorResponse(w, r, "a user can only be listed once", http.StatusBadRequest)
+				return
+			}
+			adding[userID] = i == 0
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	var members []database.ServerMember
+	if err := database.DB.Select("id", "user_id").Where("server_id = ? AND user_id IN ?", serverID, userIDs).Find(&members).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch members", http.StatusInternalServerError)
+		return
+	}
+	if len(members) != len(userIDs) {
+		httpresponder.SendErrorResponse(w, r, "all users must be members of this server", http.StatusBadRequest)
+		return
+	}
+
+	memberIDs := make([]uuid.UUID, 0, len(members))
+	for _, m := range members {
+		memberIDs = append(memberIDs, m.ID)
+	}
+
+	response := roleMembersResponse{ServerID: serverID.String(), RoleID: roleID.String(), Added: []uuid.UUID{}, Removed: []uuid.UUID{}}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var assigned []uuid.UUID
+		if err := tx.Table("server_member_roles").Where("role_id = ? AND server_member_id IN ?", roleID, memberIDs).Pluck("server_member_id", &assigned).Error; err != nil {
+			return err
+		}
+		has := make(map[uuid.UUID]bool, len(assigned))
+		for _, id := range assigned {
+			has[id] = true
+		}
+
+		var insert []map[string]any
+		var added, remove []uuid.UUID
+		for _, m := range members {
+			switch {
+			case adding[m.UserID] && !has[m.ID]:
+				insert = append(insert, map[string]any{"server_member_id": m.ID, "role_id": roleID})
+				added = append(added, m.ID)
+				response.Added = append(response.Added, m.UserID)
+			case !adding[m.UserID] && has[m.ID]:
+				remove = append(remove, m.ID)
+				response.Removed = append(response.Removed, m.UserID)
+			}
+		}
+
+		if len(insert) > 0 {
+			if err := tx.Table("server_member_roles").Create(insert).Error; err != nil {
+				return err
+			}
+			// a role makes a temporary membership permanent
+			if err := tx.Model(&database.ServerMember{}).Where("id IN ? AND temporary = ?", added, true).Update("temporary", false).Error; err != nil {
+				return err
+			}
+		}
+		if len(remove) > 0 {
+			if err := tx.Exec("DELETE FROM server_member_roles WHERE role_id = ? AND server_member_id IN ?", roleID, remove).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update role members", http.StatusInternalServerError)
+		return
+	}
+
+	if len(response.Added)+len(response.Removed) > 0 {
+		if hub := websocket.GetHub(); hub != nil {
+			hub.DispatchToServer(serverID, websocket.EventServerMembersUpdate, response)
+		}
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
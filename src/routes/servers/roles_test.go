@@ -0,0 +1,56 @@
+package serverroutes
+
+import (
+	"math"
+	"testing"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+)
+
+func TestRoleManagerReaches(t *testing.T) {
+	moderator := &roleManager{permissions: 1, top: 5}
+	owner := &roleManager{permissions: 1, top: math.MaxInt}
+
+	cases := []struct {
+		name     string
+		manager  *roleManager
+		position int
+		want     bool
+	}{
+		{"below own top role", moderator, 4, true},
+		{"own top role", moderator, 5, false},
+		{"above own top role", moderator, 9, false},
+		{"owner reaches every role", owner, 1000, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.manager.reaches(&database.Role{Position: c.position}); got != c.want {
+				t.Fatalf("reaches(position %d) with top %d = %v, want %v", c.position, c.manager.top, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRoleManagerCanGrant(t *testing.T) {
+	manager := &roleManager{permissions: 0b0110, top: 5}
+
+	cases := []struct {
+		name          string
+		before, after uint64
+		want          bool
+	}{
+		{"granting own bits", 0, 0b0110, true},
+		{"granting a bit the manager lacks", 0, 0b1000, false},
+		{"keeping bits the manager lacks", 0b1000, 0b1010, true},
+		{"removing a bit the manager lacks", 0b1000, 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := manager.canGrant(c.before, c.after); got != c.want {
+				t.Fatalf("canGrant(%b, %b) = %v, want %v", c.before, c.after, got, c.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,22 @@
 package serverroutes
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/hindsightchat/backend/src/lib/authhelper"
+	responsecache "github.com/hindsightchat/backend/src/lib/cache/response"
 	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
 	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	"github.com/hindsightchat/backend/src/lib/precondition"
+	"github.com/hindsightchat/backend/src/lib/ratelimit"
 	"github.com/hindsightchat/backend/src/middleware"
 	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
 )
 
 type serverResponse struct {
@@ -18,18 +25,82 @@ type serverResponse struct {
 	Description string    `json:"description,omitempty"`
 	Icon        string    `json:"icon,omitempty"`
 	OwnerID     string    `json:"owner_id"`
+	MemberCount int64     `json:"member_count"`
 	JoinedAt    time.Time `json:"joined_at"`
+	Version     int64     `json:"version"` // send as If-Match when updating the server
+
+	VerifiedDomain string `json:"verified_domain,omitempty"` // owned domain the server proved, shown with a badge
 }
 
 func RegisterRoutes(r chi.Router) {
 	r.Route("/servers", func(r chi.Router) {
 		r.Use(middleware.RouteRequiresAuthentication)
 
+		// new server with the caller as owner
+		r.Post("/", createServer)
+
 		r.Route("/{id}", func(r chi.Router) {
 
 			// get channels
 			r.Get("/channels", GetServerChannels)
 
+			// message counts, ?since=<message id> for how many came after it
+			r.Get("/channels/{channelID}/messages/count", getChannelMessageCount)
+
+			// channels, creating and changing them needs manage channels
+			r.Post("/channels", createChannel)
+			r.Patch("/channels/positions", updateChannelPositions)
+			r.Patch("/channels/{channelID}", updateChannel)
+			r.Delete("/channels/{channelID}", deleteChannel)
+
+			// channel categories, listing is open to members, the rest needs manage channels
+			r.Get("/categories", listCategories)
+			r.Post("/categories", createCategory)
+			r.Patch("/categories/positions", updateCategoryPositions)
+			r.Patch("/categories/{categoryID}", updateCategory)
+			r.Delete("/categories/{categoryID}", deleteCategory)
+
+			// following announcement channels across servers
+			r.Post("/channels/{channelID}/followers", followChannel)
+			r.Get("/channels/{channelID}/followers", listFollowers)
+			r.Delete("/channels/{channelID}/followers/{followID}", removeFollower)
+			r.Get("/channels/{channelID}/following", listFollowing)
+			r.Delete("/channels/{channelID}/following/{followID}", unfollowChannel)
+			r.Post("/channels/{channelID}/messages/{messageID}/publish", publishMessage)
+
+			// roles, listing is open to members, the rest needs manage roles
+			r.Get("/roles", listRoles)
+			r.Post("/roles", createRole)
+			r.Patch("/roles", reorderRoles)
+			r.Patch("/roles/{roleID}", updateRole)
+			r.Delete("/roles/{roleID}", deleteRole)
+
+			// bulk role assignment, {"add": [user ids], "remove": [user ids]}
+			r.Patch("/roles/{roleID}/members", updateRoleMembers)
+
+			// server wide feature toggles
+			r.Patch("/features", updateServerFeatures)
+
+			// invites, creating needs create invites, listing needs manage server
+			r.Post("/invites", createInvite)
+			r.Get("/invites", listInvites)
+
+			// owned domain, proven through dns or /.well-known, needs manage server
+			r.Get("/domain", getServerDomain)
+			r.Put("/domain", setServerDomain)
+			r.Post("/domain/verify", verifyServerDomain)
+			r.Delete("/domain", deleteServerDomain)
+
+			// scheduled events, creating needs manage events
+			r.Get("/events", listEvents)
+			r.Post("/events", createEvent)
+			r.Get("/events/{eventID}", getEvent)
+			r.Patch("/events/{eventID}", updateEvent)
+			r.Delete("/events/{eventID}", deleteEvent)
+			r.Get("/events/{eventID}/rsvps", listRSVPs)
+			r.Put("/events/{eventID}/rsvp", setRSVP)
+			r.Delete("/events/{eventID}/rsvp", deleteRSVP)
+
 			// get specific server info
 			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 				user, err := authhelper.GetUserFromRequest(r)
@@ -61,20 +132,77 @@ func RegisterRoutes(r chi.Router) {
 					return
 				}
 
-				httpresponder.SendSuccessResponse(w, r, serverResponse{
-					ID:          server.ID.String(),
-					Name:        server.Name,
-					Description: server.Description,
-					Icon:        server.Icon,
-					OwnerID:     server.OwnerID.String(),
-					JoinedAt:    membership.CreatedAt,
-				})
+				// polled between gateway reconnects, unchanged servers cost a 304. the etag also works as If-Match
+				err = responsecache.Conditional(w, r, responsecache.ServerKey(server.ID.String(), user.ID.String()), toServerResponse(&server, &membership))
+				if err != nil {
+					httpresponder.SendErrorResponse(w, r, "failed to fetch server", http.StatusInternalServerError)
+				}
 			})
 		})
 	})
 
+	// channels by channel id alone, the server is taken from the channel
+	r.Route("/channels/{channelID}", func(r chi.Router) {
+		r.Use(middleware.RouteRequiresAuthentication)
+
+		r.Patch("/", updateChannel)
+		r.Delete("/", deleteChannel)
+
+		// history, the same pagination as dms: limit, before, after, around
+		r.Get("/messages", getChannelMessages)
+	})
+
+	// revoking and joining by code, the server is taken from the invite
+	r.Route("/invites", func(r chi.Router) {
+		r.Use(middleware.RouteRequiresAuthentication)
+
+		r.Delete("/{code}", deleteInvite)
+		r.With(middleware.RateLimit(ratelimit.InviteJoin)).Post("/{code}/join", joinInvite)
+	})
+}
+
+func toServerResponse(server *database.Server, membership *database.ServerMember) serverResponse {
+	return serverResponse{
+		ID:          server.ID.String(),
+		Name:        server.Name,
+		Description: server.Description,
+		Icon:        server.Icon,
+		OwnerID:     server.OwnerID.String(),
+		MemberCount: server.MemberCount,
+		JoinedAt:    membership.CreatedAt,
+		Version:     server.Version,
+
+		VerifiedDomain: verifiedDomain(server),
+	}
+}
+
+// serverIfMatch is precondition.IfMatch for servers. besides the version it takes the etag of GET /servers/{id},
+// which stands for the version the response was built from. a stale etag has already been answered with 412
+func serverIfMatch(w http.ResponseWriter, r *http.Request, serverID, userID uuid.UUID) (expected int64, conditional bool, ok bool) {
+	expected, conditional, err := precondition.IfMatch(r)
+	if err == nil {
+		return expected, conditional, true
+	}
+
+	var server database.Server
+	var membership database.ServerMember
+	if database.DB.Where("id = ?", serverID).First(&server).Error != nil ||
+		database.DB.Where("server_id = ? AND user_id = ?", serverID, userID).First(&membership).Error != nil {
+		httpresponder.SendErrorResponse(w, r, "server not found", http.StatusNotFound)
+		return 0, false, false
+	}
+
+	etag, err := responsecache.ETag(toServerResponse(&server, &membership))
+	if err != nil || strings.TrimPrefix(strings.TrimSpace(r.Header.Get("If-Match")), "W/") != etag {
+		precondition.Failed(w, r, "server")
+		return 0, false, false
+	}
+	return server.Version, true, true
 }
 
+// channels change rarely, clients revalidate with If-None-Match
+const channelsCacheTTL = 60 * time.Second
+
 type channelResponse struct {
 	ID          string `json:"id"`
 	ServerID    string `json:"server_id"`
@@ -82,6 +210,32 @@ type channelResponse struct {
 	Description string `json:"description,omitempty"`
 	Type        int    `json:"type"`
 	Position    int    `json:"position"`
+	Topic       string `json:"topic,omitempty"`
+	NSFW        bool   `json:"nsfw"`
+	CategoryID  string `json:"category_id,omitempty"`
+
+	Category *categoryResponse `json:"category,omitempty"` // only in the channel list
+}
+
+func toChannelResponse(c *database.Channel) channelResponse {
+	response := channelResponse{
+		ID:          c.ID.String(),
+		ServerID:    c.ServerID.String(),
+		Name:        c.Name,
+		Description: c.Description,
+		Type:        c.Type,
+		Position:    c.Position,
+		Topic:       c.Topic,
+		NSFW:        c.NSFW,
+	}
+	if c.CategoryID != nil {
+		response.CategoryID = c.CategoryID.String()
+	}
+	if c.Category != nil {
+		category := toCategoryResponse(c.Category)
+		response.Category = &category
+	}
+	return response
 }
 
 // get specific server's channels
@@ -106,28 +260,110 @@ func GetServerChannels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var channels []database.Channel
-	err = database.DB.
-		Where("server_id = ?", serverID).
-		Order("position ASC").
-		Find(&channels).Error
+	// the same for every member, so cached per server once membership is checked
+	err = responsecache.Respond(w, r, responsecache.ServerChannelsKey(serverID.String()), channelsCacheTTL, func() (any, error) {
+		var channels []database.Channel
+		err := database.DB.
+			Preload("Category").
+			Where("server_id = ?", serverID).
+			Order("position ASC").
+			Find(&channels).Error
+
+		if err != nil {
+			return nil, err
+		}
+
+		response := make([]channelResponse, 0, len(channels))
+		for i := range channels {
+			response = append(response, toChannelResponse(&channels[i]))
+		}
+		return response, nil
+	})
 
 	if err != nil {
 		httpresponder.SendErrorResponse(w, r, "failed to fetch channels", http.StatusInternalServerError)
+	}
+}
+
+type updateFeaturesRequest struct {
+	TranslationEnabled *bool `json:"translation_enabled"`
+	MessageTombstones  *bool `json:"message_tombstones"`
+}
+
+// update server feature toggles, needs manage server
+func updateServerFeatures(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	response := make([]channelResponse, 0, len(channels))
-	for _, c := range channels {
-		response = append(response, channelResponse{
-			ID:          c.ID.String(),
-			ServerID:    c.ServerID.String(),
-			Name:        c.Name,
-			Description: c.Description,
-			Type:        c.Type,
-			Position:    c.Position,
-		})
+	serverID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if !permissions.MemberHas(serverID, user.ID, permissions.ManageServer) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage this server", http.StatusForbidden)
+		return
 	}
 
-	httpresponder.SendSuccessResponse(w, r, response)
+	expected, conditional, ok := serverIfMatch(w, r, serverID, user.ID)
+	if !ok {
+		return
+	}
+
+	var body updateFeaturesRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]any{}
+	if body.TranslationEnabled != nil {
+		updates["translation_enabled"] = *body.TranslationEnabled
+	}
+	if body.MessageTombstones != nil {
+		updates["message_tombstones"] = *body.MessageTombstones
+	}
+
+	if len(updates) > 0 {
+		updates["version"] = gorm.Expr("version + 1")
+
+		query := database.DB.Model(&database.Server{}).Where("id = ?", serverID)
+		if conditional {
+			query = query.Where("version = ?", expected)
+		}
+
+		result := query.Updates(updates)
+		if result.Error != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to update server", http.StatusInternalServerError)
+			return
+		}
+		if conditional && result.RowsAffected == 0 {
+			precondition.Failed(w, r, "server")
+			return
+		}
+	}
+
+	var server database.Server
+	if err := database.DB.Where("id = ?", serverID).First(&server).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "server not found", http.StatusNotFound)
+		return
+	}
+
+	// nothing to change still has to match
+	if conditional && len(updates) == 0 && server.Version != expected {
+		precondition.Failed(w, r, "server")
+		return
+	}
+
+	precondition.SetETag(w, server.Version)
+	httpresponder.SendSuccessResponse(w, r, map[string]any{
+		"id":                  server.ID.String(),
+		"translation_enabled": server.TranslationEnabled,
+		"message_tombstones":  server.MessageTombstones,
+		"version":             server.Version,
+	})
 }
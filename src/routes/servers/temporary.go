@@ -0,0 +1,94 @@
+package serverroutes
+
+// members who joined through a temporary invite are removed again when they still have no role after
+// TEMPORARY_MEMBER_HOURS (default 24). assigning any role makes the membership permanent
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/membercount"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	"gorm.io/gorm"
+)
+
+const (
+	temporaryInterval = 5 * time.Minute
+
+	defaultTemporaryHours = 24
+
+	// members removed per round, the rest wait for the next one
+	temporaryBatchSize = 500
+)
+
+func temporaryGrace() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("TEMPORARY_MEMBER_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = defaultTemporaryHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// RunTemporaryMembers removes expired temporary members every interval, start it once per instance
+func RunTemporaryMembers() {
+	ticker := time.NewTicker(temporaryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removeTemporaryMembers()
+	}
+}
+
+func removeTemporaryMembers() {
+	// one instance per round
+	rdb := valkeydb.GetValkeyClient()
+	if rdb == nil {
+		return
+	}
+	ok, err := rdb.SetNX(context.Background(), valkeydb.TEMPORARY_MEMBER_LOCK_KEY, "1", temporaryInterval/2).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	var members []database.ServerMember
+	err = database.DB.Select("id", "server_id", "user_id").
+		Where("temporary = ? AND joined_at < ?", true, time.Now().Add(-temporaryGrace())).
+		Where("NOT EXISTS (SELECT 1 FROM server_member_roles r WHERE r.server_member_id = server_members.id)").
+		Limit(temporaryBatchSize).
+		Find(&members).Error
+	if err != nil {
+		log.Printf("[temporary members] %v", err)
+		return
+	}
+
+	removed := 0
+	for _, member := range members {
+		gone := false
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			// a role may have been given since the select
+			result := tx.Where("id = ? AND temporary = ?", member.ID, true).Delete(&database.ServerMember{})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			gone = true
+			return membercount.Members(tx, member.ServerID, -1)
+		})
+		if err != nil {
+			log.Printf("[temporary members] %s in %s: %v", member.UserID, member.ServerID, err)
+			continue
+		}
+		if gone {
+			removed++
+			websocket.NotifyServerMemberLeave(member.ServerID, member.UserID)
+		}
+	}
+
+	if removed > 0 {
+		log.Printf("[temporary members] removed %d members", removed)
+	}
+}
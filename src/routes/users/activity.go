@@ -0,0 +1,109 @@
+package usersroutes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/activity"
+	"github.com/hindsightchat/backend/src/lib/activityhistory"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	"github.com/hindsightchat/backend/src/lib/usersettings"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	"github.com/hindsightchat/backend/src/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+// setActivity is for desktop clients reporting a started activity (game, editor...) outside the gateway
+func setActivity(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body types.Activity
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sanitized, err := activity.Sanitize(&body)
+	if errors.Is(err, activity.ErrUnknownApplication) {
+		httpresponder.SendErrorResponse(w, r, "unknown application", http.StatusBadRequest)
+		return
+	}
+	if sanitized == nil {
+		httpresponder.SendErrorResponse(w, r, "activity is empty", http.StatusBadRequest)
+		return
+	}
+
+	if !websocket.SetUserActivity(user.ID, sanitized) {
+		httpresponder.SendErrorResponse(w, r, "no connected client", http.StatusConflict)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, sanitized)
+}
+
+func clearActivity(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// nothing to clear when offline, presence is gone anyway
+	websocket.SetUserActivity(user.ID, nil)
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"cleared": true})
+}
+
+// getActivityHistory returns what the user played recently, for themselves and their friends and only
+// when they opted in
+func getActivityHistory(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if targetID != user.ID {
+		friends, err := restriction.FriendIDs(user.ID)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to fetch activity history", http.StatusInternalServerError)
+			return
+		}
+		if !friends[targetID] {
+			httpresponder.SendErrorResponse(w, r, "only friends can see activity history", http.StatusForbidden)
+			return
+		}
+	}
+
+	settings, err := usersettings.Get(targetID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch activity history", http.StatusInternalServerError)
+		return
+	}
+	if !settings.ActivityHistory {
+		httpresponder.SendErrorResponse(w, r, "activity history is not shared", http.StatusForbidden)
+		return
+	}
+
+	entries, err := activityhistory.History(targetID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch activity history", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, entries)
+}
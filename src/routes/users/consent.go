@@ -0,0 +1,85 @@
+package usersroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	usercache "github.com/hindsightchat/backend/src/lib/cache/user"
+	"github.com/hindsightchat/backend/src/lib/consent"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/ipban"
+)
+
+type consentResponse struct {
+	Current           consent.Versions `json:"current"`
+	Outstanding       consent.Versions `json:"outstanding"`
+	TosVersion        string           `json:"tos_version,omitempty"`
+	TosAcceptedAt     *time.Time       `json:"tos_accepted_at,omitempty"`
+	PrivacyVersion    string           `json:"privacy_version,omitempty"`
+	PrivacyAcceptedAt *time.Time       `json:"privacy_accepted_at,omitempty"`
+}
+
+type acceptConsentRequest struct {
+	// must match the current versions so clients cant accept something they never showed
+	TosVersion     string `json:"tos_version"`
+	PrivacyVersion string `json:"privacy_version"`
+}
+
+func getConsent(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toConsentResponse(user))
+}
+
+func acceptConsent(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body acceptConsentRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	current := consent.Current()
+	if body.TosVersion != current.Tos || body.PrivacyVersion != current.Privacy {
+		httpresponder.SendErrorResponse(w, r, "versions do not match the current terms", http.StatusConflict)
+		return
+	}
+
+	if err := consent.Accept(database.DB, user.ID, ipban.ClientIP(r)); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to record consent", http.StatusInternalServerError)
+		return
+	}
+
+	usercache.UserCacheInstance.Delete(user.ID.String())
+
+	var updated database.User
+	if err := database.DB.Where("id = ?", user.ID).First(&updated).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toConsentResponse(&updated))
+}
+
+func toConsentResponse(user *database.User) consentResponse {
+	return consentResponse{
+		Current:           consent.Current(),
+		Outstanding:       consent.Outstanding(user),
+		TosVersion:        user.TosVersion,
+		TosAcceptedAt:     user.TosAcceptedAt,
+		PrivacyVersion:    user.PrivacyVersion,
+		PrivacyAcceptedAt: user.PrivacyAcceptedAt,
+	}
+}
@@ -0,0 +1,81 @@
+package usersroutes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/pagination"
+)
+
+type dataAccessResponse struct {
+	ID         string    `json:"id"`
+	Action     string    `json:"action"`
+	Reason     string    `json:"reason"`
+	AccessedBy userBrief `json:"accessed_by"`
+	AccessedAt time.Time `json:"accessed_at"`
+}
+
+// listDataAccess reports admin reads of the users data, newest first.
+// query params: limit (default 50, max 100), cursor (id of the last entry of the previous page, before works too)
+func listDataAccess(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit, ok := pagination.Limit(r)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, pagination.LimitError, http.StatusBadRequest)
+		return
+	}
+
+	var total int64
+	if err := database.DB.Model(&database.DataAccessLog{}).Where("target_user_id = ?", user.ID).Count(&total).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch data access log", http.StatusInternalServerError)
+		return
+	}
+
+	query := database.DB.Preload("Actor").Where("target_user_id = ?", user.ID).Order("created_at DESC, id DESC").Limit(limit + 1)
+
+	if raw := pagination.Cursor(r, "before"); raw != "" {
+		var cursor database.DataAccessLog
+		if err := database.DB.Where("target_user_id = ? AND id = ?", user.ID, raw).First(&cursor).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "cursor entry not found", http.StatusNotFound)
+			return
+		}
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+
+	var entries []database.DataAccessLog
+	if err := query.Find(&entries).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch data access log", http.StatusInternalServerError)
+		return
+	}
+
+	hasMore := len(entries) > limit
+	if hasMore {
+		entries = entries[:limit]
+	}
+
+	response := make([]dataAccessResponse, 0, len(entries))
+	for _, e := range entries {
+		response = append(response, dataAccessResponse{
+			ID:         e.ID.String(),
+			Action:     e.Action,
+			Reason:     e.Reason,
+			AccessedBy: userBrief{ID: e.Actor.ID.String(), Username: e.Actor.Username, Domain: e.Actor.Domain},
+			AccessedAt: e.CreatedAt,
+		})
+	}
+
+	nextCursor := ""
+	if len(entries) > 0 {
+		nextCursor = entries[len(entries)-1].ID.String()
+	}
+
+	httpresponder.SendListResponse(w, r, response, nextCursor, hasMore, &total)
+}
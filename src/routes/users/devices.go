@@ -0,0 +1,258 @@
+package usersroutes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// users can register this many encryption devices
+const maxDevices = 20
+
+// keys are base64 and well below this, it just keeps junk out
+const maxKeyLength = 1024
+
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type deviceKeyResponse struct {
+	DeviceID     string    `json:"device_id"`
+	Name         string    `json:"name,omitempty"`
+	IdentityKey  string    `json:"identity_key"`
+	SignedPreKey string    `json:"signed_pre_key"`
+	Signature    string    `json:"signature"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type putDeviceKeyRequest struct {
+	Name         string `json:"name"`
+	IdentityKey  string `json:"identity_key"`
+	SignedPreKey string `json:"signed_pre_key"`
+	Signature    string `json:"signature"`
+}
+
+type senderKeyResponse struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	SenderDeviceID string    `json:"sender_device_id"`
+	Ciphertext     string    `json:"ciphertext"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func listOwnDevices(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sendDeviceKeys(w, r, user.ID)
+}
+
+// listUserDevices returns someone's public device keys, needed to encrypt sender keys for them
+func listUserDevices(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	sendDeviceKeys(w, r, userID)
+}
+
+func sendDeviceKeys(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	var devices []database.DeviceKey
+	if err := database.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&devices).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch devices", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]deviceKeyResponse, 0, len(devices))
+	for _, d := range devices {
+		response = append(response, toDeviceKeyResponse(&d))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// putDeviceKey registers a device or replaces its keys (e.g after rotating the signed pre key)
+func putDeviceKey(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID := chi.URLParam(r, "deviceID")
+	if !deviceIDPattern.MatchString(deviceID) {
+		httpresponder.SendErrorResponse(w, r, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	var body putDeviceKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, key := range []string{body.IdentityKey, body.SignedPreKey, body.Signature} {
+		if !validKey(key) {
+			httpresponder.SendErrorResponse(w, r, "keys must be base64 encoded", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(body.Name) > 64 {
+		httpresponder.SendErrorResponse(w, r, "name must be at most 64 characters", http.StatusBadRequest)
+		return
+	}
+
+	var device database.DeviceKey
+	err = database.DB.Where("user_id = ? AND device_id = ?", user.ID, deviceID).First(&device).Error
+	if err != nil {
+		var count int64
+		database.DB.Model(&database.DeviceKey{}).Where("user_id = ?", user.ID).Count(&count)
+		if count >= maxDevices {
+			httpresponder.SendErrorResponse(w, r, "device limit reached", http.StatusBadRequest)
+			return
+		}
+		device = database.DeviceKey{UserID: user.ID, DeviceID: deviceID}
+	}
+
+	device.Name = body.Name
+	device.IdentityKey = body.IdentityKey
+	device.SignedPreKey = body.SignedPreKey
+	device.Signature = body.Signature
+
+	if err := database.DB.Save(&device).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to save device", http.StatusInternalServerError)
+		return
+	}
+
+	websocket.NotifyDeviceKeysUpdate(user.ID, websocket.DeviceKeysUpdatePayload{UserID: user.ID, DeviceID: deviceID})
+
+	httpresponder.SendSuccessResponse(w, r, toDeviceKeyResponse(&device))
+}
+
+func deleteDeviceKey(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID := chi.URLParam(r, "deviceID")
+
+	var deleted int64
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("user_id = ? AND device_id = ?", user.ID, deviceID).Delete(&database.DeviceKey{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+
+		// sender keys for the device are useless now
+		return tx.Unscoped().Where("recipient_id = ? AND recipient_device_id = ?", user.ID, deviceID).Delete(&database.SenderKeyDistribution{}).Error
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete device", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		httpresponder.SendErrorResponse(w, r, "device not found", http.StatusNotFound)
+		return
+	}
+
+	websocket.NotifyDeviceKeysUpdate(user.ID, websocket.DeviceKeysUpdatePayload{UserID: user.ID, DeviceID: deviceID, Removed: true})
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// listPendingSenderKeys returns sender keys shared with the device that it hasnt acknowledged yet
+func listPendingSenderKeys(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var keys []database.SenderKeyDistribution
+	err = database.DB.
+		Where("recipient_id = ? AND recipient_device_id = ?", user.ID, chi.URLParam(r, "deviceID")).
+		Order("created_at ASC").
+		Find(&keys).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch sender keys", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]senderKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		response = append(response, senderKeyResponse{
+			ID:             k.ID.String(),
+			ConversationID: k.ConversationID.String(),
+			SenderID:       k.SenderID.String(),
+			SenderDeviceID: k.SenderDeviceID,
+			Ciphertext:     k.Ciphertext,
+			CreatedAt:      k.CreatedAt,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func ackSenderKey(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keyID, err := uuid.FromString(chi.URLParam(r, "keyID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid sender key id", http.StatusBadRequest)
+		return
+	}
+
+	result := database.DB.Unscoped().
+		Where("id = ? AND recipient_id = ? AND recipient_device_id = ?", keyID, user.ID, chi.URLParam(r, "deviceID")).
+		Delete(&database.SenderKeyDistribution{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to acknowledge sender key", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "sender key not found", http.StatusNotFound)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"acknowledged": true})
+}
+
+func validKey(key string) bool {
+	if key == "" || len(key) > maxKeyLength {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(key)
+	return err == nil
+}
+
+func toDeviceKeyResponse(d *database.DeviceKey) deviceKeyResponse {
+	return deviceKeyResponse{
+		DeviceID:     d.DeviceID,
+		Name:         d.Name,
+		IdentityKey:  d.IdentityKey,
+		SignedPreKey: d.SignedPreKey,
+		Signature:    d.Signature,
+		UpdatedAt:    d.UpdatedAt,
+	}
+}
@@ -0,0 +1,111 @@
+package usersroutes
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/discordimport"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+)
+
+// discord packages of heavy users get big, most of it is not messages
+const maxImportSize = 1 << 30
+
+type importJobResponse struct {
+	ID            string     `json:"id"`
+	Source        string     `json:"source"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	Conversations int        `json:"conversations"`
+	Messages      int64      `json:"messages"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+func toImportJobResponse(job *database.ImportJob) importJobResponse {
+	return importJobResponse{
+		ID:            job.ID.String(),
+		Source:        job.Source,
+		Status:        job.Status,
+		Error:         job.Error,
+		Conversations: job.Conversations,
+		Messages:      job.Messages,
+		CreatedAt:     job.CreatedAt,
+		FinishedAt:    job.FinishedAt,
+	}
+}
+
+func listImports(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var jobs []database.ImportJob
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(50).Find(&jobs).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch imports", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]importJobResponse, 0, len(jobs))
+	for i := range jobs {
+		response = append(response, toImportJobResponse(&jobs[i]))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+// importDiscord takes a discord data package (the zip) as the request body and imports its dms in the
+// background, poll the import list for the outcome
+func importDiscord(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// one at a time, a second package is most likely the same one again. jobs that died with their
+	// instance dont count
+	discordimport.FailStale()
+	var running int64
+	database.DB.Model(&database.ImportJob{}).
+		Where("user_id = ? AND status IN ?", user.ID, []string{database.ImportStatusPending, database.ImportStatusRunning}).
+		Count(&running)
+	if running > 0 {
+		httpresponder.SendErrorResponse(w, r, "an import is already running", http.StatusConflict)
+		return
+	}
+
+	file, err := os.CreateTemp("", "discord-import-*.zip")
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to store archive", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = io.Copy(file, http.MaxBytesReader(w, r.Body, maxImportSize))
+	file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		httpresponder.SendErrorResponse(w, r, "archive must be at most 1GB", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	job := database.ImportJob{UserID: user.ID, Source: discordimport.Source, Status: database.ImportStatusPending}
+	if err := database.DB.Create(&job).Error; err != nil {
+		os.Remove(file.Name())
+		httpresponder.SendErrorResponse(w, r, "failed to create import", http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		defer os.Remove(file.Name())
+		discordimport.Run(&job, file.Name())
+	}()
+
+	httpresponder.SendSuccessResponse(w, r, toImportJobResponse(&job))
+}
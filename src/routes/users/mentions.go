@@ -0,0 +1,131 @@
+package usersroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/inbox"
+	"github.com/hindsightchat/backend/src/lib/pagination"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+type mentionResponse struct {
+	referencedMessage
+	Reason string     `json:"reason"` // mention or reply
+	ReadAt *time.Time `json:"read_at,omitempty"`
+}
+
+type markMentionsReadRequest struct {
+	MessageIDs []string `json:"message_ids"` // empty marks everything as read
+}
+
+// listMentions returns messages that mention the user or reply to them, newest first.
+// query params: limit (default 50, max 100), cursor (message id of the last entry of the previous page, before
+// works too), unread=true. with unread=true total is the unread count
+func listMentions(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit, ok := pagination.Limit(r)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, pagination.LimitError, http.StatusBadRequest)
+		return
+	}
+
+	mine := database.DB.Model(&database.InboxEntry{}).Where("user_id = ?", user.ID)
+	if r.URL.Query().Get("unread") == "true" {
+		mine = mine.Where("read_at IS NULL")
+	}
+
+	var total int64
+	if err := mine.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch mentions", http.StatusInternalServerError)
+		return
+	}
+
+	query := mine.Session(&gorm.Session{}).Order("created_at DESC, id DESC").Limit(limit + 1)
+
+	if raw := pagination.Cursor(r, "before"); raw != "" {
+		var cursor database.InboxEntry
+		if err := database.DB.Where("user_id = ? AND message_id = ?", user.ID, raw).First(&cursor).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "cursor message not in inbox", http.StatusNotFound)
+			return
+		}
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+
+	var entries []database.InboxEntry
+	if err := query.Find(&entries).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch mentions", http.StatusInternalServerError)
+		return
+	}
+
+	hasMore := len(entries) > limit
+	if hasMore {
+		entries = entries[:limit]
+	}
+
+	refs := make([]messageRef, len(entries))
+	for i, e := range entries {
+		refs[i] = messageRef{Kind: e.Kind, MessageID: e.MessageID}
+	}
+	messages := loadReferencedMessages(r, user.ID, refs)
+
+	response := make([]mentionResponse, 0, len(entries))
+	for _, e := range entries {
+		if m, ok := messages[e.MessageID]; ok {
+			response = append(response, mentionResponse{referencedMessage: m, Reason: e.Reason, ReadAt: e.ReadAt})
+		}
+	}
+
+	// entries whose message is gone still move the cursor
+	nextCursor := ""
+	if len(entries) > 0 {
+		nextCursor = entries[len(entries)-1].MessageID.String()
+	}
+
+	httpresponder.SendListResponse(w, r, response, nextCursor, hasMore, &total)
+}
+
+func markMentionsRead(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body markMentionsReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body.MessageIDs) > 100 {
+		httpresponder.SendErrorResponse(w, r, "too many message ids", http.StatusBadRequest)
+		return
+	}
+
+	messageIDs := make([]uuid.UUID, 0, len(body.MessageIDs))
+	for _, id := range body.MessageIDs {
+		messageID, err := uuid.FromString(id)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+			return
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+
+	if err := inbox.MarkRead(user.ID, messageIDs); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to mark mentions as read", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{"unread": inbox.UnreadCount(user.ID)})
+}
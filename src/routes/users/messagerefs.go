@@ -0,0 +1,110 @@
+package usersroutes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/agegate"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	uuid "github.com/satori/go.uuid"
+)
+
+// messageRef points at a channel or direct message, kind is database.MessageKindChannel or MessageKindDM
+type messageRef struct {
+	Kind      string
+	MessageID uuid.UUID
+}
+
+type referencedMessage struct {
+	ID             string     `json:"id"` // message id
+	Kind           string     `json:"kind"`
+	ServerID       string     `json:"server_id,omitempty"`
+	ChannelID      string     `json:"channel_id,omitempty"`
+	ConversationID string     `json:"conversation_id,omitempty"`
+	Author         userBrief  `json:"author"`
+	Content        string     `json:"content"`
+	Encrypted      bool       `json:"encrypted,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	EditedAt       *time.Time `json:"edited_at,omitempty"`
+}
+
+// loadReferencedMessages loads the referenced messages the user can still read, keyed by message id.
+// deleted messages, servers and conversations the user left and locked hidden conversations are left out
+func loadReferencedMessages(r *http.Request, userID uuid.UUID, refs []messageRef) map[uuid.UUID]referencedMessage {
+	var channelIDs, dmIDs []uuid.UUID
+	for _, ref := range refs {
+		if ref.Kind == database.MessageKindChannel {
+			channelIDs = append(channelIDs, ref.MessageID)
+		} else {
+			dmIDs = append(dmIDs, ref.MessageID)
+		}
+	}
+
+	result := make(map[uuid.UUID]referencedMessage, len(refs))
+
+	if len(channelIDs) > 0 {
+		var messages []database.ChannelMessage
+		database.DB.
+			Preload("Author").
+			Preload("Channel").
+			Where("id IN ?", channelIDs).
+			Where("channel_id IN (?)", database.DB.Model(&database.Channel{}).Select("id").
+				Where("server_id IN (?)", database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", userID))).
+			Scopes(agegate.ReadableChannelMessages(userID)).
+			Find(&messages)
+
+		for _, m := range messages {
+			result[m.ID] = referencedMessage{
+				ID:        m.ID.String(),
+				Kind:      database.MessageKindChannel,
+				ServerID:  m.Channel.ServerID.String(),
+				ChannelID: m.ChannelID.String(),
+				Author:    userBrief{ID: m.Author.ID.String(), Username: m.Author.Username, Domain: m.Author.Domain},
+				Content:   m.Content,
+				CreatedAt: m.CreatedAt,
+				EditedAt:  m.EditedAt,
+			}
+		}
+	}
+
+	if len(dmIDs) > 0 {
+		var participations []database.DMParticipant
+		database.DB.Where("user_id = ?", userID).Find(&participations)
+
+		// conversations the user is still in, minus hidden ones that stay locked
+		session := hiddenconv.Session(r)
+		readable := make([]uuid.UUID, 0, len(participations))
+		for _, p := range participations {
+			if p.Hidden && !hiddenconv.IsUnlocked(r.Context(), session, p.ConversationID) {
+				continue
+			}
+			readable = append(readable, p.ConversationID)
+		}
+
+		var messages []database.DirectMessage
+		if len(readable) > 0 {
+			database.DB.
+				Preload("Author").
+				Where("id IN ? AND conversation_id IN ?", dmIDs, readable).
+				Scopes(restriction.VisibleDirectMessages(userID)).
+				Find(&messages)
+		}
+
+		for _, m := range messages {
+			result[m.ID] = referencedMessage{
+				ID:             m.ID.String(),
+				Kind:           database.MessageKindDM,
+				ConversationID: m.ConversationID.String(),
+				Author:         userBrief{ID: m.Author.ID.String(), Username: m.Author.Username, Domain: m.Author.Domain},
+				Content:        m.Content,
+				Encrypted:      m.Encrypted,
+				CreatedAt:      m.CreatedAt,
+				EditedAt:       m.EditedAt,
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,201 @@
+package usersroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/notifyprefs"
+	"github.com/hindsightchat/backend/src/lib/push"
+	uuid "github.com/satori/go.uuid"
+)
+
+type notificationSettingResponse struct {
+	ServerID         string `json:"server_id"`
+	Level            string `json:"level"`
+	SuppressEveryone bool   `json:"suppress_everyone"`
+}
+
+// fields left out are unchanged
+type updateNotificationSettingRequest struct {
+	Level            *string `json:"level"`
+	SuppressEveryone *bool   `json:"suppress_everyone"`
+}
+
+type pushDeviceResponse struct {
+	ID        string    `json:"id"`
+	Platform  string    `json:"platform"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type registerPushDeviceRequest struct {
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+}
+
+func getNotificationSetting(w http.ResponseWriter, r *http.Request) {
+	user, serverID, ok := loadNotificationServer(w, r)
+	if !ok {
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toNotificationSettingResponse(serverID, notifyprefs.Get(user.ID, serverID)))
+}
+
+func updateNotificationSetting(w http.ResponseWriter, r *http.Request) {
+	user, serverID, ok := loadNotificationServer(w, r)
+	if !ok {
+		return
+	}
+
+	var body updateNotificationSettingRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	setting := notifyprefs.Get(user.ID, serverID)
+	if body.Level != nil {
+		if !notifyprefs.ValidLevel(*body.Level) {
+			httpresponder.SendErrorResponse(w, r, "level must be all, mentions or none", http.StatusBadRequest)
+			return
+		}
+		setting.Level = *body.Level
+	}
+	if body.SuppressEveryone != nil {
+		setting.SuppressEveryone = *body.SuppressEveryone
+	}
+
+	if err := notifyprefs.Set(user.ID, serverID, setting); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toNotificationSettingResponse(serverID, setting))
+}
+
+func listPushDevices(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var devices []database.PushDevice
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&devices).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch push devices", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]pushDeviceResponse, 0, len(devices))
+	for _, d := range devices {
+		response = append(response, toPushDeviceResponse(&d))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func registerPushDevice(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body registerPushDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !push.ValidPlatform(body.Platform) {
+		httpresponder.SendErrorResponse(w, r, "platform must be apns, fcm or web", http.StatusBadRequest)
+		return
+	}
+	if body.Token == "" || len(body.Token) > 512 {
+		httpresponder.SendErrorResponse(w, r, "token must be between 1 and 512 characters", http.StatusBadRequest)
+		return
+	}
+
+	// a device token moves to whoever logged in on the device last
+	device := database.PushDevice{Token: body.Token}
+	err = database.DB.Where("token = ?", body.Token).
+		Assign(map[string]any{"user_id": user.ID, "platform": body.Platform}).
+		FirstOrCreate(&device).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to register push device", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toPushDeviceResponse(&device))
+}
+
+func deletePushDevice(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	result := database.DB.Unscoped().Where("id = ? AND user_id = ?", deviceID, user.ID).Delete(&database.PushDevice{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete push device", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "push device not found", http.StatusNotFound)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+// loadNotificationServer resolves the server from the url, settings only exist for servers the user is in
+func loadNotificationServer(w http.ResponseWriter, r *http.Request) (*database.User, uuid.UUID, bool) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return nil, uuid.Nil, false
+	}
+
+	serverID, err := uuid.FromString(chi.URLParam(r, "serverID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+		return nil, uuid.Nil, false
+	}
+
+	var count int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", serverID, user.ID).Count(&count)
+	if count == 0 {
+		httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+		return nil, uuid.Nil, false
+	}
+
+	return user, serverID, true
+}
+
+func toNotificationSettingResponse(serverID uuid.UUID, setting notifyprefs.Setting) notificationSettingResponse {
+	return notificationSettingResponse{
+		ServerID:         serverID.String(),
+		Level:            setting.Level,
+		SuppressEveryone: setting.SuppressEveryone,
+	}
+}
+
+func toPushDeviceResponse(d *database.PushDevice) pushDeviceResponse {
+	return pushDeviceResponse{
+		ID:        d.ID.String(),
+		Platform:  d.Platform,
+		CreatedAt: d.CreatedAt,
+	}
+}
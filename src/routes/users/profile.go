@@ -0,0 +1,368 @@
+package usersroutes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	usercache "github.com/hindsightchat/backend/src/lib/cache/user"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hashblock"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/imageproxy"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/precondition"
+	"github.com/hindsightchat/backend/src/lib/privacy"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	"github.com/hindsightchat/backend/src/lib/storage"
+	"github.com/hindsightchat/backend/src/lib/usersettings"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxBioLength = 190
+	maxMutuals   = 50
+)
+
+type profileResponse struct {
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	Domain        string    `json:"domain"`
+	ProfilePicURL string    `json:"profile_pic_url,omitempty"`
+	BannerURL     string    `json:"banner_url,omitempty"`
+	AccentColor   *int      `json:"accent_color,omitempty"`
+	Bio           string    `json:"bio,omitempty"`
+	Bot           bool      `json:"bot,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Version       int64     `json:"version"` // send as If-Match when updating your profile
+
+	// left out on your own profile
+	MutualFriends []userBrief    `json:"mutual_friends,omitempty"`
+	MutualServers []mutualServer `json:"mutual_servers,omitempty"`
+
+	// bio, banner and mutual servers are hidden by the users profile visibility
+	Limited bool `json:"limited,omitempty"`
+}
+
+type mutualServer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Icon string `json:"icon,omitempty"`
+}
+
+// fields left out are unchanged, accent_color null resets it
+type updateProfileRequest struct {
+	Bio         *string         `json:"bio"`
+	AccentColor json.RawMessage `json:"accent_color"`
+}
+
+// getProfile returns the profile of /users/{id}, or your own under /users/@me
+func getProfile(w http.ResponseWriter, r *http.Request) {
+	viewer, err := authhelper.GetUserFromRequest(r)
+	if err != nil || viewer == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	target := viewer
+	if id := chi.URLParam(r, "id"); id != "" {
+		userID, err := uuid.FromString(id)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		var user database.User
+		if err := database.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "user not found", http.StatusNotFound)
+			return
+		}
+		target = &user
+	}
+
+	response := toProfileResponse(target)
+
+	if target.ID == viewer.ID {
+		precondition.SetETag(w, target.ProfileVersion)
+		httpresponder.SendSuccessResponse(w, r, response)
+		return
+	}
+
+	settings, err := usersettings.Get(target.ID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to load profile", http.StatusInternalServerError)
+		return
+	}
+	visible, err := privacy.ProfileVisible(viewer.ID, target.ID, settings)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to load profile", http.StatusInternalServerError)
+		return
+	}
+
+	response.MutualFriends = mutualFriends(viewer.ID, target.ID)
+	if visible {
+		response.MutualServers = mutualServers(viewer.ID, target.ID)
+	} else {
+		response.Bio = ""
+		response.BannerURL = ""
+		response.Limited = true
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func updateProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	expected, conditional, err := precondition.IfMatch(r)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid If-Match header", http.StatusBadRequest)
+		return
+	}
+
+	var body updateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]any{}
+
+	if body.Bio != nil {
+		if utf8.RuneCountInString(*body.Bio) > maxBioLength {
+			httpresponder.SendErrorResponse(w, r, "bio is too long", http.StatusBadRequest)
+			return
+		}
+		updates["bio"] = *body.Bio
+	}
+
+	if len(body.AccentColor) > 0 {
+		var color *int
+		if err := json.Unmarshal(body.AccentColor, &color); err != nil || (color != nil && (*color < 0 || *color > 0xFFFFFF)) {
+			httpresponder.SendErrorResponse(w, r, "accent_color must be a 0xRRGGBB number or null", http.StatusBadRequest)
+			return
+		}
+		updates["accent_color"] = color
+	}
+
+	var version *int64
+	if conditional {
+		version = &expected
+	}
+
+	if len(updates) == 0 {
+		// nothing to change still has to match
+		if err := database.DB.Where("id = ?", user.ID).First(user).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to load profile", http.StatusInternalServerError)
+			return
+		}
+		if version != nil && user.ProfileVersion != *version {
+			precondition.Failed(w, r, "profile")
+			return
+		}
+		precondition.SetETag(w, user.ProfileVersion)
+		httpresponder.SendSuccessResponse(w, r, toProfileResponse(user))
+		return
+	}
+
+	ok, err := updateProfileFields(user, updates, version)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update profile", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		precondition.Failed(w, r, "profile")
+		return
+	}
+
+	broadcastProfileUpdate(user.ID, updates)
+
+	precondition.SetETag(w, user.ProfileVersion)
+	httpresponder.SendSuccessResponse(w, r, toProfileResponse(user))
+}
+
+// updateProfileFields applies the changes and bumps the profile version, only when it still is version if set.
+// user is reloaded afterwards, ok is false when the version didnt match
+func updateProfileFields(user *database.User, changes map[string]any, version *int64) (ok bool, err error) {
+	updates := make(map[string]any, len(changes)+1)
+	for k, v := range changes {
+		updates[k] = v
+	}
+	updates["profile_version"] = gorm.Expr("profile_version + 1")
+
+	query := database.DB.Model(&database.User{}).Where("id = ?", user.ID)
+	if version != nil {
+		query = query.Where("profile_version = ?", *version)
+	}
+
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	usercache.UserCacheInstance.Delete(user.ID.String())
+	return true, database.DB.Where("id = ?", user.ID).First(user).Error
+}
+
+// setBanner takes the raw image as the request body
+func setBanner(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	maxSize := instance.GetLimits().MaxUploadSize
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSize))
+	if err != nil {
+		quota := &instance.QuotaError{Limit: "max_upload_size", Max: maxSize}
+		httpresponder.SendErrorResponseWithDetails(w, r, "banner is too large", http.StatusRequestEntityTooLarge, quota)
+		return
+	}
+
+	ext, err := storage.ImageExtension(data)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "banner must be a png, jpeg, gif or webp image", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if err := hashblock.Check(user.ID, data); err != nil {
+		httpresponder.SendErrorResponse(w, r, "this file is not allowed", http.StatusForbidden)
+		return
+	}
+
+	url, err := storage.Save("banners", data, ext)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to store banner", http.StatusInternalServerError)
+		return
+	}
+
+	previous := user.BannerURL
+	if _, err := updateProfileFields(user, map[string]any{"banner_url": url}, nil); err != nil {
+		storage.Delete(url)
+		httpresponder.SendErrorResponse(w, r, "failed to update profile", http.StatusInternalServerError)
+		return
+	}
+	storage.Delete(previous)
+
+	broadcastProfileUpdate(user.ID, map[string]any{"banner_url": url})
+
+	httpresponder.SendSuccessResponse(w, r, toProfileResponse(user))
+}
+
+func deleteBanner(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	previous := user.BannerURL
+	if _, err := updateProfileFields(user, map[string]any{"banner_url": ""}, nil); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update profile", http.StatusInternalServerError)
+		return
+	}
+	storage.Delete(previous)
+
+	broadcastProfileUpdate(user.ID, map[string]any{"banner_url": ""})
+
+	httpresponder.SendSuccessResponse(w, r, toProfileResponse(user))
+}
+
+// broadcastProfileUpdate sends profile changes to everyone who can see the user. while the profile isnt
+// visible to everyone, bio and banner changes only go to the users own clients, others see them on refetch
+func broadcastProfileUpdate(userID uuid.UUID, fields map[string]any) {
+	settings, err := usersettings.Get(userID)
+	if err == nil && privacy.OrDefault(settings.ProfileVisibility) == privacy.Everyone {
+		websocket.BroadcastUserUpdate(userID, fields)
+		return
+	}
+
+	public := make(map[string]any)
+	private := make(map[string]any)
+	for field, value := range fields {
+		if field == "bio" || field == "banner_url" {
+			private[field] = value
+		} else {
+			public[field] = value
+		}
+	}
+
+	if len(public) > 0 {
+		websocket.BroadcastUserUpdate(userID, public)
+	}
+	if len(private) > 0 {
+		websocket.NotifyUserUpdate(userID, private)
+	}
+}
+
+func toProfileResponse(user *database.User) profileResponse {
+	return profileResponse{
+		ID:            user.ID.String(),
+		Username:      user.Username,
+		Domain:        user.Domain,
+		ProfilePicURL: imageproxy.URL(user.ProfilePicURL),
+		BannerURL:     user.BannerURL,
+		AccentColor:   user.AccentColor,
+		Bio:           user.Bio,
+		Bot:           user.IsBot,
+		CreatedAt:     user.CreatedAt,
+		Version:       user.ProfileVersion,
+	}
+}
+
+func mutualFriends(a, b uuid.UUID) []userBrief {
+	friendsA, errA := restriction.FriendIDs(a)
+	friendsB, errB := restriction.FriendIDs(b)
+	if errA != nil || errB != nil {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0)
+	for id := range friendsA {
+		if friendsB[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var users []database.User
+	database.DB.Where("id IN ?", ids).Order("username ASC").Limit(maxMutuals).Find(&users)
+
+	mutuals := make([]userBrief, 0, len(users))
+	for _, u := range users {
+		mutuals = append(mutuals, userBrief{ID: u.ID.String(), Username: u.Username, Domain: u.Domain})
+	}
+	return mutuals
+}
+
+func mutualServers(a, b uuid.UUID) []mutualServer {
+	var servers []database.Server
+	database.DB.
+		Where("id IN (?)", database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", a)).
+		Where("id IN (?)", database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", b)).
+		Order("name ASC").
+		Limit(maxMutuals).
+		Find(&servers)
+
+	mutuals := make([]mutualServer, 0, len(servers))
+	for _, s := range servers {
+		mutuals = append(mutuals, mutualServer{ID: s.ID.String(), Name: s.Name, Icon: s.Icon})
+	}
+	return mutuals
+}
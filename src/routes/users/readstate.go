@@ -0,0 +1,147 @@
+package usersroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/inbox"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxAckConversations = 200
+
+type ackAllRequest struct {
+	ServerID        *string  `json:"server_id"`        // only this servers channels
+	ConversationIDs []string `json:"conversation_ids"` // only these conversations
+}
+
+// ackAll marks everything read, or only a server and/or a list of conversations when given.
+// the users sessions get one MESSAGE_ACK covering all of it instead of one per conversation and channel
+func ackAll(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body ackAllRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(body.ConversationIDs) > maxAckConversations {
+		httpresponder.SendErrorResponse(w, r, "at most 200 conversations at once", http.StatusBadRequest)
+		return
+	}
+
+	scoped := body.ServerID != nil || len(body.ConversationIDs) > 0
+
+	// conversations the user is in, all of them unless only a server was asked for
+	var convIDs []uuid.UUID
+	if !scoped || len(body.ConversationIDs) > 0 {
+		query := database.DB.Model(&database.DMParticipant{}).Where("user_id = ?", user.ID)
+		if len(body.ConversationIDs) > 0 {
+			ids := make([]uuid.UUID, 0, len(body.ConversationIDs))
+			for _, raw := range body.ConversationIDs {
+				id, err := uuid.FromString(raw)
+				if err != nil {
+					httpresponder.SendErrorResponse(w, r, "invalid conversation id", http.StatusBadRequest)
+					return
+				}
+				ids = append(ids, id)
+			}
+			query = query.Where("conversation_id IN ?", ids)
+		}
+		if err := query.Pluck("conversation_id", &convIDs).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to mark as read", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// channels of the servers the user is in, all of them unless only conversations were asked for
+	var channelIDs []uuid.UUID
+	if !scoped || body.ServerID != nil {
+		servers := database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", user.ID)
+		if body.ServerID != nil {
+			serverID, err := uuid.FromString(*body.ServerID)
+			if err != nil {
+				httpresponder.SendErrorResponse(w, r, "invalid server id", http.StatusBadRequest)
+				return
+			}
+
+			var members int64
+			database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", serverID, user.ID).Count(&members)
+			if members == 0 {
+				httpresponder.SendErrorResponse(w, r, "not a member of this server", http.StatusForbidden)
+				return
+			}
+			servers = servers.Where("server_id = ?", serverID)
+		}
+
+		if err := database.DB.Model(&database.Channel{}).Where("server_id IN (?)", servers).Pluck("id", &channelIDs).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to mark as read", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	now := time.Now()
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if len(convIDs) > 0 {
+			err := tx.Model(&database.DMParticipant{}).
+				Where("user_id = ? AND conversation_id IN ?", user.ID, convIDs).
+				Update("last_read_at", now).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(channelIDs) > 0 {
+			states := make([]database.ChannelReadState, len(channelIDs))
+			for i, channelID := range channelIDs {
+				states[i] = database.ChannelReadState{UserID: user.ID, ChannelID: channelID, LastReadAt: now}
+			}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}, {Name: "channel_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"last_read_at", "updated_at"}),
+			}).CreateInBatches(&states, 500).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return inbox.MarkTargetsRead(tx, user.ID, convIDs, channelIDs, now)
+	})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to mark as read", http.StatusInternalServerError)
+		return
+	}
+
+	if convIDs == nil {
+		convIDs = []uuid.UUID{}
+	}
+	if channelIDs == nil {
+		channelIDs = []uuid.UUID{}
+	}
+
+	ack := map[string]any{
+		"user_id":          user.ID,
+		"conversation_ids": convIDs,
+		"channel_ids":      channelIDs,
+		"read_at":          now,
+	}
+	if hub := websocket.GetHub(); hub != nil {
+		hub.DispatchToUser(user.ID, websocket.EventMessageAck, ack)
+	}
+
+	httpresponder.SendSuccessResponse(w, r, ack)
+}
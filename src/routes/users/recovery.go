@@ -0,0 +1,112 @@
+package usersroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/recovery"
+	"github.com/hindsightchat/backend/src/lib/securitylog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type recoveryCodesResponse struct {
+	Remaining int64    `json:"remaining"`
+	Codes     []string `json:"codes,omitempty"` // only right after generating
+}
+
+type generateRecoveryCodesRequest struct {
+	Password string `json:"password"`
+}
+
+type securityEventResponse struct {
+	ID        string    `json:"id"`
+	Action    string    `json:"action"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func getRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	remaining, err := recovery.Remaining(user.ID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to count recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, recoveryCodesResponse{Remaining: remaining})
+}
+
+// generateRecoveryCodes replaces the users recovery codes, the password is required again since the
+// codes are as good as it
+func generateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body generateRecoveryCodesRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var account database.User
+	if err := database.DB.Select("id", "password").Where("id = ?", user.ID).First(&account).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "user not found", http.StatusNotFound)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(body.Password)) != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid password", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := recovery.Generate(user.ID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	securitylog.Record(r, user.ID, securitylog.ActionRecoveryCodesGenerated)
+
+	httpresponder.SendSuccessResponse(w, r, recoveryCodesResponse{
+		Remaining: int64(len(codes)),
+		Codes:     codes,
+	})
+}
+
+// listSecurityEvents returns the last 50 changes to how the user can sign in, newest first
+func listSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var events []database.SecurityEvent
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(50).Find(&events).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch security events", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]securityEventResponse, 0, len(events))
+	for _, event := range events {
+		response = append(response, securityEventResponse{
+			ID:        event.ID.String(),
+			Action:    event.Action,
+			IP:        event.IP,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
@@ -0,0 +1,169 @@
+package usersroutes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/agegate"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type savedMessageResponse struct {
+	referencedMessage
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// saveMessage bookmarks a channel or direct message the user can see
+func saveMessage(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := uuid.FromString(chi.URLParam(r, "messageID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	kind, err := visibleMessageKind(r, user.ID, messageID)
+	if errors.Is(err, errConversationLocked) {
+		httpresponder.SendErrorResponse(w, r, "conversation is locked", http.StatusLocked)
+		return
+	}
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "message not found", http.StatusNotFound)
+		return
+	}
+
+	saved := database.SavedMessage{UserID: user.ID, MessageID: messageID, Kind: kind}
+	if err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&saved).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to save message", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{"id": messageID.String(), "kind": kind})
+}
+
+func unsaveMessage(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := uuid.FromString(chi.URLParam(r, "messageID"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	result := database.DB.Unscoped().Where("user_id = ? AND message_id = ?", user.ID, messageID).Delete(&database.SavedMessage{})
+	if result.Error != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to remove saved message", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpresponder.SendErrorResponse(w, r, "message not saved", http.StatusNotFound)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{"removed": true})
+}
+
+// listSavedMessages returns saved messages, most recently saved first.
+// query params: limit (default 50, max 100), before (message id of the last entry of the previous page).
+// messages that were deleted or are no longer visible are left out
+func listSavedMessages(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 100 {
+			httpresponder.SendErrorResponse(w, r, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	query := database.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(limit)
+
+	if before := r.URL.Query().Get("before"); before != "" {
+		var cursor database.SavedMessage
+		if err := database.DB.Where("user_id = ? AND message_id = ?", user.ID, before).First(&cursor).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "before message not saved", http.StatusNotFound)
+			return
+		}
+		query = query.Where("created_at < ?", cursor.CreatedAt)
+	}
+
+	var saved []database.SavedMessage
+	if err := query.Find(&saved).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch saved messages", http.StatusInternalServerError)
+		return
+	}
+
+	refs := make([]messageRef, len(saved))
+	for i, s := range saved {
+		refs[i] = messageRef{Kind: s.Kind, MessageID: s.MessageID}
+	}
+	messages := loadReferencedMessages(r, user.ID, refs)
+
+	response := make([]savedMessageResponse, 0, len(saved))
+	for _, s := range saved {
+		if m, ok := messages[s.MessageID]; ok {
+			response = append(response, savedMessageResponse{referencedMessage: m, SavedAt: s.CreatedAt})
+		}
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+var errConversationLocked = errors.New("conversation is locked")
+
+// visibleMessageKind finds the message among the users direct and channel messages, dms of hidden
+// conversations only once the session unlocked them
+func visibleMessageKind(r *http.Request, userID, messageID uuid.UUID) (string, error) {
+	var participant database.DMParticipant
+	err := database.DB.
+		Where("user_id = ?", userID).
+		Where("conversation_id IN (?)", database.DB.Model(&database.DirectMessage{}).Select("conversation_id").Where("id = ?", messageID).Scopes(restriction.VisibleDirectMessages(userID))).
+		Take(&participant).Error
+	if err == nil {
+		if participant.Hidden && !hiddenconv.IsUnlocked(r.Context(), hiddenconv.Session(r), participant.ConversationID) {
+			return "", errConversationLocked
+		}
+		return database.MessageKindDM, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	var msg database.ChannelMessage
+	if err := database.DB.Preload("Channel").Where("id = ?", messageID).Take(&msg).Error; err != nil {
+		return "", err
+	}
+
+	var members int64
+	database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id = ?", msg.Channel.ServerID, userID).Count(&members)
+	if members == 0 || !agegate.CanRead(userID, &msg.Channel) {
+		return "", gorm.ErrRecordNotFound
+	}
+
+	return database.MessageKindChannel, nil
+}
@@ -0,0 +1,68 @@
+package usersroutes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	uuid "github.com/satori/go.uuid"
+)
+
+type serverPresenceSettingResponse struct {
+	ServerID     string `json:"server_id"`
+	HideActivity bool   `json:"hide_activity"`
+}
+
+type updateServerPresenceSettingRequest struct {
+	HideActivity *bool `json:"hide_activity"`
+}
+
+func getServerPresenceSetting(w http.ResponseWriter, r *http.Request) {
+	user, serverID, ok := loadNotificationServer(w, r)
+	if !ok {
+		return
+	}
+
+	var member database.ServerMember
+	if err := database.DB.Where("server_id = ? AND user_id = ?", serverID, user.ID).First(&member).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch presence settings", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toServerPresenceSettingResponse(serverID, member.HideActivity))
+}
+
+func updateServerPresenceSetting(w http.ResponseWriter, r *http.Request) {
+	user, serverID, ok := loadNotificationServer(w, r)
+	if !ok {
+		return
+	}
+
+	var body updateServerPresenceSettingRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.HideActivity == nil {
+		httpresponder.SendErrorResponse(w, r, "hide_activity is required", http.StatusBadRequest)
+		return
+	}
+
+	err := database.DB.Model(&database.ServerMember{}).
+		Where("server_id = ? AND user_id = ?", serverID, user.ID).
+		Update("hide_activity", *body.HideActivity).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update presence settings", http.StatusInternalServerError)
+		return
+	}
+
+	// the server should see the activity appear or disappear right away
+	websocket.RebroadcastPresence(user.ID)
+
+	httpresponder.SendSuccessResponse(w, r, toServerPresenceSettingResponse(serverID, *body.HideActivity))
+}
+
+func toServerPresenceSettingResponse(serverID uuid.UUID, hideActivity bool) serverPresenceSettingResponse {
+	return serverPresenceSettingResponse{
+		ServerID:     serverID.String(),
+		HideActivity: hideActivity,
+	}
+}
@@ -0,0 +1,206 @@
+package usersroutes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/activityhistory"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/digest"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/privacy"
+	"github.com/hindsightchat/backend/src/lib/statusschedule"
+	"github.com/hindsightchat/backend/src/lib/usersettings"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+)
+
+type settingsResponse struct {
+	Timezone       string                 `json:"timezone,omitempty"`
+	StatusSchedule []statusschedule.Entry `json:"status_schedule"`
+	HideTyping     bool                   `json:"hide_typing"`
+
+	HideReadReceipts bool `json:"hide_read_receipts"`
+
+	DigestEmails     bool `json:"digest_emails"`
+	DigestAfterHours int  `json:"digest_after_hours"`
+
+	FriendRequestsFrom string `json:"friend_requests_from"` // everyone, friends_of_friends, server_members or nobody
+	DMsFrom            string `json:"dms_from"`
+
+	ProfileVisibility string `json:"profile_visibility"` // everyone, friends or nobody
+
+	ActivityHistory bool `json:"activity_history"` // keep recently played and show it to friends
+}
+
+// fields left out are unchanged
+type updateSettingsRequest struct {
+	Timezone       *string                 `json:"timezone"`
+	StatusSchedule *[]statusschedule.Entry `json:"status_schedule"`
+	HideTyping     *bool                   `json:"hide_typing"`
+
+	HideReadReceipts *bool `json:"hide_read_receipts"`
+
+	DigestEmails     *bool `json:"digest_emails"`
+	DigestAfterHours *int  `json:"digest_after_hours"`
+
+	FriendRequestsFrom *string `json:"friend_requests_from"`
+	DMsFrom            *string `json:"dms_from"`
+
+	ProfileVisibility *string `json:"profile_visibility"`
+
+	ActivityHistory *bool `json:"activity_history"`
+}
+
+func getSettings(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := usersettings.Get(user.ID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toSettingsResponse(settings))
+}
+
+func updateSettings(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body updateSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	columns := make(map[string]any)
+
+	if body.Timezone != nil {
+		if *body.Timezone != "" {
+			if _, err := time.LoadLocation(*body.Timezone); err != nil || len(*body.Timezone) > 64 {
+				httpresponder.SendErrorResponse(w, r, "unknown timezone", http.StatusBadRequest)
+				return
+			}
+		}
+		columns["timezone"] = *body.Timezone
+	}
+
+	if body.StatusSchedule != nil {
+		if err := statusschedule.Validate(*body.StatusSchedule); err != nil {
+			httpresponder.SendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		encoded, err := json.Marshal(*body.StatusSchedule)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid status schedule", http.StatusBadRequest)
+			return
+		}
+		columns["status_schedule"] = string(encoded)
+	}
+
+	if body.HideTyping != nil {
+		columns["hide_typing"] = *body.HideTyping
+	}
+
+	if body.HideReadReceipts != nil {
+		columns["hide_read_receipts"] = *body.HideReadReceipts
+	}
+
+	if body.DigestEmails != nil {
+		columns["digest_emails"] = *body.DigestEmails
+	}
+
+	if body.DigestAfterHours != nil {
+		if *body.DigestAfterHours < digest.MinAfterHours || *body.DigestAfterHours > digest.MaxAfterHours {
+			httpresponder.SendErrorResponse(w, r, "digest_after_hours must be between 1 and 168", http.StatusBadRequest)
+			return
+		}
+		columns["digest_after_hours"] = *body.DigestAfterHours
+	}
+
+	if body.FriendRequestsFrom != nil {
+		if !privacy.Valid(*body.FriendRequestsFrom) {
+			httpresponder.SendErrorResponse(w, r, "friend_requests_from must be everyone, friends_of_friends, server_members or nobody", http.StatusBadRequest)
+			return
+		}
+		columns["friend_requests_from"] = *body.FriendRequestsFrom
+	}
+
+	if body.DMsFrom != nil {
+		if !privacy.Valid(*body.DMsFrom) {
+			httpresponder.SendErrorResponse(w, r, "dms_from must be everyone, friends_of_friends, server_members or nobody", http.StatusBadRequest)
+			return
+		}
+		columns["dms_from"] = *body.DMsFrom
+	}
+
+	if body.ProfileVisibility != nil {
+		if !privacy.ValidProfileVisibility(*body.ProfileVisibility) {
+			httpresponder.SendErrorResponse(w, r, "profile_visibility must be everyone, friends or nobody", http.StatusBadRequest)
+			return
+		}
+		columns["profile_visibility"] = *body.ProfileVisibility
+	}
+
+	if body.ActivityHistory != nil {
+		columns["activity_history"] = *body.ActivityHistory
+	}
+
+	if len(columns) == 0 {
+		getSettings(w, r)
+		return
+	}
+
+	settings, err := usersettings.Update(user.ID, columns)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to update settings", http.StatusInternalServerError)
+		return
+	}
+
+	// turning it off forgets what was kept so far
+	if body.ActivityHistory != nil && !*body.ActivityHistory {
+		activityhistory.Clear(user.ID)
+	}
+
+	// a new schedule or time zone can start or end a window right now
+	if body.StatusSchedule != nil || body.Timezone != nil {
+		websocket.RefreshStatusSchedule(user.ID)
+	}
+
+	httpresponder.SendSuccessResponse(w, r, toSettingsResponse(settings))
+}
+
+func toSettingsResponse(settings *database.UserSettings) settingsResponse {
+	schedule := statusschedule.Parse(settings.StatusSchedule)
+	if schedule == nil {
+		schedule = []statusschedule.Entry{}
+	}
+
+	return settingsResponse{
+		Timezone:       settings.Timezone,
+		StatusSchedule: schedule,
+		HideTyping:     settings.HideTyping,
+
+		HideReadReceipts: settings.HideReadReceipts,
+
+		DigestEmails:     settings.DigestEmails,
+		DigestAfterHours: settings.DigestAfterHours,
+
+		FriendRequestsFrom: privacy.OrDefault(settings.FriendRequestsFrom),
+		DMsFrom:            privacy.OrDefault(settings.DMsFrom),
+
+		ProfileVisibility: privacy.OrDefault(settings.ProfileVisibility),
+
+		ActivityHistory: settings.ActivityHistory,
+	}
+}
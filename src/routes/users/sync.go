@@ -0,0 +1,256 @@
+package usersroutes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/agegate"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// messages per kind per response, has_more is set when there were more
+	syncMessageLimit = 500
+
+	// soft deleted rows may be purged after this, older clients have to reload everything
+	syncTokenMaxAge = 30 * 24 * time.Hour
+)
+
+// rows changed when created, updated or soft deleted, deleted_at is always the latest
+const changedAt = "COALESCE(deleted_at, updated_at)"
+
+type syncResponse struct {
+	Token   string `json:"token"`    // pass as ?since= on the next sync
+	HasMore bool   `json:"has_more"` // sync again with the new token right away
+
+	Messages      []syncMessage      `json:"messages"`
+	Servers       []syncMembership   `json:"servers"`
+	Conversations []syncMembership   `json:"conversations"`
+	Friends       []syncFriendChange `json:"friends"`
+}
+
+type syncMessage struct {
+	referencedMessage
+	UpdatedAt time.Time `json:"updated_at"`
+	Deleted   bool      `json:"deleted,omitempty"` // only the ids are set
+}
+
+type syncMembership struct {
+	ID      string `json:"id"` // server or conversation id
+	Removed bool   `json:"removed,omitempty"`
+}
+
+type syncFriendChange struct {
+	User           userBrief `json:"user"`
+	ConversationID string    `json:"conversation_id"`
+	Removed        bool      `json:"removed,omitempty"`
+}
+
+// syncChanges returns what changed since the given sync token: messages in your servers and conversations,
+// memberships and friendships. without ?since only a token for now is returned.
+// locked hidden conversations are left out
+func syncChanges(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	until := time.Now()
+
+	sinceParam := r.URL.Query().Get("since")
+	if sinceParam == "" {
+		httpresponder.SendSuccessResponse(w, r, emptySync(until))
+		return
+	}
+
+	since, ok := parseSyncToken(sinceParam)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, "invalid sync token", http.StatusBadRequest)
+		return
+	}
+	if until.Sub(since) > syncTokenMaxAge {
+		httpresponder.SendErrorResponse(w, r, "sync token expired", http.StatusGone)
+		return
+	}
+
+	// reads until when called, it moves back below when messages are cut off
+	changedBetween := func(db *gorm.DB) *gorm.DB {
+		return db.Where(changedAt+" > ? AND "+changedAt+" <= ?", since, until)
+	}
+
+	// direct messages in conversations the user can read
+	var participations []database.DMParticipant
+	database.DB.Where("user_id = ?", user.ID).Find(&participations)
+
+	session := hiddenconv.Session(r)
+	readable := make([]uuid.UUID, 0, len(participations))
+	for _, p := range participations {
+		if p.Hidden && !hiddenconv.IsUnlocked(r.Context(), session, p.ConversationID) {
+			continue
+		}
+		readable = append(readable, p.ConversationID)
+	}
+
+	var dms []database.DirectMessage
+	if len(readable) > 0 {
+		err = database.DB.Unscoped().
+			Preload("Author").
+			Where("conversation_id IN ?", readable).
+			Scopes(changedBetween, restriction.VisibleDirectMessages(user.ID)).
+			Order(changedAt + " ASC").
+			Limit(syncMessageLimit + 1).
+			Find(&dms).Error
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to sync messages", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var channelMessages []database.ChannelMessage
+	err = database.DB.Unscoped().
+		Preload("Author").
+		Preload("Channel").
+		Where("channel_id IN (?)", database.DB.Model(&database.Channel{}).Select("id").
+			Where("server_id IN (?)", database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", user.ID))).
+		Scopes(changedBetween, agegate.ReadableChannelMessages(user.ID)).
+		Order(changedAt + " ASC").
+		Limit(syncMessageLimit + 1).
+		Find(&channelMessages).Error
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to sync messages", http.StatusInternalServerError)
+		return
+	}
+
+	// when either kind has more, stop both at the earlier cut off so the next sync continues from there
+	hasMore := false
+	if len(dms) > syncMessageLimit {
+		hasMore = true
+		until = rowChangedAt(dms[syncMessageLimit-1].BaseModel)
+	}
+	if len(channelMessages) > syncMessageLimit {
+		hasMore = true
+		if cut := rowChangedAt(channelMessages[syncMessageLimit-1].BaseModel); cut.Before(until) {
+			until = cut
+		}
+	}
+
+	response := emptySync(until)
+	response.HasMore = hasMore
+
+	for i := range dms {
+		m := &dms[i]
+		if rowChangedAt(m.BaseModel).After(until) {
+			break
+		}
+		response.Messages = append(response.Messages, toSyncMessage(m.BaseModel, m.AuthorID, referencedMessage{
+			ID:             m.ID.String(),
+			Kind:           database.MessageKindDM,
+			ConversationID: m.ConversationID.String(),
+			Author:         userBrief{ID: m.Author.ID.String(), Username: m.Author.Username, Domain: m.Author.Domain},
+			Content:        m.Content,
+			Encrypted:      m.Encrypted,
+			CreatedAt:      m.CreatedAt,
+			EditedAt:       m.EditedAt,
+		}))
+	}
+
+	for i := range channelMessages {
+		m := &channelMessages[i]
+		if rowChangedAt(m.BaseModel).After(until) {
+			break
+		}
+		response.Messages = append(response.Messages, toSyncMessage(m.BaseModel, m.AuthorID, referencedMessage{
+			ID:        m.ID.String(),
+			Kind:      database.MessageKindChannel,
+			ServerID:  m.Channel.ServerID.String(),
+			ChannelID: m.ChannelID.String(),
+			Author:    userBrief{ID: m.Author.ID.String(), Username: m.Author.Username, Domain: m.Author.Domain},
+			Content:   m.Content,
+			CreatedAt: m.CreatedAt,
+			EditedAt:  m.EditedAt,
+		}))
+	}
+
+	var members []database.ServerMember
+	database.DB.Unscoped().Where("user_id = ?", user.ID).Scopes(changedBetween).Order(changedAt + " ASC").Find(&members)
+	for _, m := range members {
+		response.Servers = append(response.Servers, syncMembership{ID: m.ServerID.String(), Removed: m.DeletedAt.Valid})
+	}
+
+	var joined []database.DMParticipant
+	database.DB.Unscoped().Where("user_id = ?", user.ID).Scopes(changedBetween).Order(changedAt + " ASC").Find(&joined)
+	for _, p := range joined {
+		response.Conversations = append(response.Conversations, syncMembership{ID: p.ConversationID.String(), Removed: p.DeletedAt.Valid})
+	}
+
+	var friendships []database.Friendship
+	database.DB.Unscoped().
+		Preload("User1").
+		Preload("User2").
+		Where("user1_id = ? OR user2_id = ?", user.ID, user.ID).
+		Scopes(changedBetween).
+		Order(changedAt + " ASC").
+		Find(&friendships)
+	for _, f := range friendships {
+		friend := f.User1
+		if f.User1ID == user.ID {
+			friend = f.User2
+		}
+		response.Friends = append(response.Friends, syncFriendChange{
+			User:           userBrief{ID: friend.ID.String(), Username: friend.Username, Domain: friend.Domain},
+			ConversationID: f.ConversationID.String(),
+			Removed:        f.DeletedAt.Valid,
+		})
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func emptySync(until time.Time) syncResponse {
+	return syncResponse{
+		Token:         strconv.FormatInt(until.UnixNano(), 36),
+		Messages:      []syncMessage{},
+		Servers:       []syncMembership{},
+		Conversations: []syncMembership{},
+		Friends:       []syncFriendChange{},
+	}
+}
+
+func parseSyncToken(token string) (time.Time, bool) {
+	nanos, err := strconv.ParseInt(token, 36, 64)
+	if err != nil || nanos <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+func rowChangedAt(row database.BaseModel) time.Time {
+	if row.DeletedAt.Valid {
+		return row.DeletedAt.Time
+	}
+	return row.UpdatedAt
+}
+
+// toSyncMessage strips deleted messages down to where they were
+func toSyncMessage(row database.BaseModel, authorID uuid.UUID, m referencedMessage) syncMessage {
+	if row.DeletedAt.Valid {
+		m = referencedMessage{
+			ID:             m.ID,
+			Kind:           m.Kind,
+			ServerID:       m.ServerID,
+			ChannelID:      m.ChannelID,
+			ConversationID: m.ConversationID,
+			Author:         userBrief{ID: authorID.String()},
+			CreatedAt:      m.CreatedAt,
+		}
+	}
+	return syncMessage{referencedMessage: m, UpdatedAt: rowChangedAt(row), Deleted: row.DeletedAt.Valid}
+}
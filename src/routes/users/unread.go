@@ -0,0 +1,142 @@
+package usersroutes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	uuid "github.com/satori/go.uuid"
+)
+
+type unreadConversation struct {
+	ConversationID string     `json:"conversation_id"`
+	FirstUnreadID  string     `json:"first_unread_id"` // oldest message from someone else after the read marker
+	Unread         int64      `json:"unread"`
+	LastReadAt     *time.Time `json:"last_read_at,omitempty"`
+}
+
+type unreadChannel struct {
+	ServerID      string     `json:"server_id"`
+	ChannelID     string     `json:"channel_id"`
+	FirstUnreadID string     `json:"first_unread_id"`
+	Unread        int64      `json:"unread"`
+	LastReadAt    *time.Time `json:"last_read_at,omitempty"`
+}
+
+type unreadResponse struct {
+	Conversations []unreadConversation `json:"conversations"`
+	Channels      []unreadChannel      `json:"channels"`
+}
+
+// getUnread returns the first unread message of every conversation and channel that has any, for the
+// "new messages" divider and jumping to it. the read markers are moved by message acks
+func getUnread(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversations, err := unreadConversations(user.ID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch unread messages", http.StatusInternalServerError)
+		return
+	}
+
+	channels, err := unreadChannels(user.ID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch unread messages", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, unreadResponse{Conversations: conversations, Channels: channels})
+}
+
+// unreadConversations leaves hidden conversations out, same as the conversation list
+func unreadConversations(userID uuid.UUID) ([]unreadConversation, error) {
+	var rows []struct {
+		ConversationID uuid.UUID
+		Unread         int64
+		LastReadAt     *time.Time
+		Since          time.Time
+	}
+	err := database.DB.Model(&database.DirectMessage{}).
+		Select("direct_messages.conversation_id, COUNT(*) AS unread, p.last_read_at, COALESCE(p.last_read_at, p.joined_at) AS since").
+		Joins("JOIN dm_participants p ON p.conversation_id = direct_messages.conversation_id AND p.user_id = ? AND p.deleted_at IS NULL AND p.hidden = ?", userID, false).
+		Where("direct_messages.author_id <> ?", userID).
+		Where("direct_messages.created_at > COALESCE(p.last_read_at, p.joined_at)").
+		Scopes(restriction.VisibleDirectMessages(userID)).
+		Group("direct_messages.conversation_id, p.last_read_at, p.joined_at").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]unreadConversation, 0, len(rows))
+	for _, row := range rows {
+		var first database.DirectMessage
+		err := database.DB.Select("direct_messages.id").
+			Where("conversation_id = ? AND author_id <> ? AND created_at > ?", row.ConversationID, userID, row.Since).
+			Scopes(restriction.VisibleDirectMessages(userID)).
+			Order("created_at ASC, id ASC").
+			First(&first).Error
+		if err != nil {
+			continue
+		}
+
+		result = append(result, unreadConversation{
+			ConversationID: row.ConversationID.String(),
+			FirstUnreadID:  first.ID.String(),
+			Unread:         row.Unread,
+			LastReadAt:     row.LastReadAt,
+		})
+	}
+	return result, nil
+}
+
+// unreadChannels covers the channels of every server the user is in
+func unreadChannels(userID uuid.UUID) ([]unreadChannel, error) {
+	var rows []struct {
+		ChannelID  uuid.UUID
+		ServerID   uuid.UUID
+		Unread     int64
+		LastReadAt *time.Time
+		Since      time.Time
+	}
+	err := database.DB.Model(&database.ChannelMessage{}).
+		Select("channel_messages.channel_id, c.server_id, COUNT(*) AS unread, rs.last_read_at, COALESCE(rs.last_read_at, sm.joined_at) AS since").
+		Joins("JOIN channels c ON c.id = channel_messages.channel_id AND c.deleted_at IS NULL").
+		Joins("JOIN server_members sm ON sm.server_id = c.server_id AND sm.user_id = ? AND sm.deleted_at IS NULL", userID).
+		Joins("LEFT JOIN channel_read_states rs ON rs.channel_id = channel_messages.channel_id AND rs.user_id = ? AND rs.deleted_at IS NULL", userID).
+		Where("channel_messages.author_id <> ?", userID).
+		Where("channel_messages.created_at > COALESCE(rs.last_read_at, sm.joined_at)").
+		Group("channel_messages.channel_id, c.server_id, rs.last_read_at, sm.joined_at").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]unreadChannel, 0, len(rows))
+	for _, row := range rows {
+		var first database.ChannelMessage
+		err := database.DB.Select("id").
+			Where("channel_id = ? AND author_id <> ? AND created_at > ?", row.ChannelID, userID, row.Since).
+			Order("created_at ASC, id ASC").
+			First(&first).Error
+		if err != nil {
+			continue
+		}
+
+		result = append(result, unreadChannel{
+			ServerID:      row.ServerID.String(),
+			ChannelID:     row.ChannelID.String(),
+			FirstUnreadID: first.ID.String(),
+			Unread:        row.Unread,
+			LastReadAt:    row.LastReadAt,
+		})
+	}
+	return result, nil
+}
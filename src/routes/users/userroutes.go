@@ -4,16 +4,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/hindsightchat/backend/src/lib/authhelper"
+	responsecache "github.com/hindsightchat/backend/src/lib/cache/response"
 	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
 	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
 	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/pagination"
 	"github.com/hindsightchat/backend/src/middleware"
 	"github.com/hindsightchat/backend/src/routes/websocket"
 	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
 )
 
 type conversationResponse struct {
@@ -23,6 +28,12 @@ type conversationResponse struct {
 	Participants []userBrief `json:"participants"`
 	LastReadAt   *time.Time  `json:"last_read_at,omitempty"`
 	CreatedAt    time.Time   `json:"created_at"`
+	Hidden       bool        `json:"hidden,omitempty"`
+
+	ParticipantCount   int    `json:"participant_count"`               // including you, may be more than len(Participants)
+	ImportedFrom       string `json:"imported_from,omitempty"`         // e.g discord, history recreated from a data export
+	UpgradedToServerID string `json:"upgraded_to_server_id,omitempty"` // the group was turned into this server
+	AnnouncementOnly   bool   `json:"announcement_only"`               // only the owner can post
 }
 
 type serverResponse struct {
@@ -32,8 +43,13 @@ type serverResponse struct {
 	Icon        string    `json:"icon,omitempty"`
 	OwnerID     string    `json:"owner_id"`
 	JoinedAt    time.Time `json:"joined_at"`
+
+	VerifiedDomain string `json:"verified_domain,omitempty"` // owned domain the server proved, shown with a badge
 }
 
+// GET /users/{id} cache, presence expiring without an update can lag behind by this much
+const userCacheTTL = 30 * time.Second
+
 type userBrief struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
@@ -49,9 +65,83 @@ func RegisterRoutes(r chi.Router) {
 		r.Route("/@me", func(r chi.Router) {
 			r.Get("/conversations", getConversations)
 			r.Get("/servers", getServers)
+
+			// first unread message per conversation and channel
+			r.Get("/unread", getUnread)
+
+			// mark everything read, or a server and/or some conversations
+			r.Post("/read-state/ack-all", ackAll)
+
+			r.Get("/profile", getProfile)
+			r.Patch("/profile", updateProfile)
+			r.Put("/profile/banner", setBanner)
+			r.Delete("/profile/banner", deleteBanner)
+
+			// terms of service / privacy policy acceptance
+			r.Get("/consent", getConsent)
+			r.Post("/consent", acceptConsent)
+
+			r.Get("/settings", getSettings)
+			r.Patch("/settings", updateSettings)
+
+			// per server notification preferences
+			r.Get("/servers/{serverID}/notifications", getNotificationSetting)
+			r.Patch("/servers/{serverID}/notifications", updateNotificationSetting)
+
+			// whether the server sees your activity, online status is always shown
+			r.Get("/servers/{serverID}/presence", getServerPresenceSetting)
+			r.Patch("/servers/{serverID}/presence", updateServerPresenceSetting)
+
+			// rich presence reported by desktop clients
+			r.Put("/activity", setActivity)
+			r.Delete("/activity", clearActivity)
+
+			r.Get("/push-devices", listPushDevices)
+			r.Post("/push-devices", registerPushDevice)
+			r.Delete("/push-devices/{id}", deletePushDevice)
+
+			// end to end encryption devices
+			r.Get("/devices", listOwnDevices)
+			r.Put("/devices/{deviceID}", putDeviceKey)
+			r.Delete("/devices/{deviceID}", deleteDeviceKey)
+			r.Get("/devices/{deviceID}/sender-keys", listPendingSenderKeys)
+			r.Delete("/devices/{deviceID}/sender-keys/{keyID}", ackSenderKey)
+
+			// personal bookmarks, independent of pins
+			r.Get("/saved-messages", listSavedMessages)
+			r.Post("/saved-messages/{messageID}", saveMessage)
+			r.Delete("/saved-messages/{messageID}", unsaveMessage)
+
+			// dm history from other platforms, the archive is the request body
+			r.Get("/imports", listImports)
+			r.Post("/imports/discord", importDiscord)
+
+			// mentions and replies across servers and dms
+			r.Get("/mentions", listMentions)
+			r.Post("/mentions/read", markMentionsRead)
+
+			// everything that changed since ?since=<token>, for clients coming back online
+			r.Get("/sync", syncChanges)
+
+			// which admins read your data and why
+			r.Get("/data-access", listDataAccess)
+
+			// one time codes that work instead of the password, generating needs the password
+			r.Get("/recovery-codes", getRecoveryCodes)
+			r.Post("/recovery-codes", generateRecoveryCodes)
+			r.Get("/security-events", listSecurityEvents)
 		})
 
 		r.Route("/{id}", func(r chi.Router) {
+			// bio, banner, accent color and what you have in common
+			r.Get("/profile", getProfile)
+
+			// public encryption keys
+			r.Get("/devices", listUserDevices)
+
+			// recently played, friends only and when the user opted in
+			r.Get("/activity-history", getActivityHistory)
+
 			// get user by ID
 			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 				userID := chi.URLParam(r, "id")
@@ -70,34 +160,40 @@ func RegisterRoutes(r chi.Router) {
 					return
 				}
 
-				var presence websocket.PresenceData
+				// presence changes invalidate it, see PresenceManager
+				responsecache.Respond(w, r, responsecache.UserKey(user.ID.String()), userCacheTTL, func() (any, error) {
+					var presence websocket.PresenceData
 
-				bytes, err := valkeydb.GetValkeyClient().Get(r.Context(), valkeydb.PRESENCE_PREFIX+user.ID.String()).Bytes()
+					bytes, err := valkeydb.GetValkeyClient().Get(r.Context(), valkeydb.PRESENCE_PREFIX+user.ID.String()).Bytes()
 
-				if err == nil {
-					if err := json.Unmarshal(bytes, &presence); err == nil {
-						// presence successfully loaded, can include in response if we want
+					if err == nil {
+						if err := json.Unmarshal(bytes, &presence); err == nil {
+							// presence successfully loaded, can include in response if we want
 
-						if presence.Status == "offline" {
-							// if offline, set presence to nil to avoid showing stale activity info
-							presence = websocket.PresenceData{}
+							if presence.Status == "offline" {
+								// if offline, set presence to nil to avoid showing stale activity info
+								presence = websocket.PresenceData{}
+							}
+						} else {
+							fmt.Printf("Failed to unmarshal presence for user %s: %v\n", user.Username, err)
 						}
-					} else {
-						fmt.Printf("Failed to unmarshal presence for user %s: %v\n", user.Username, err)
 					}
-				}
 
-				httpresponder.SendSuccessResponse(w, r, userBrief{
-					ID:       user.ID.String(),
-					Username: user.Username,
-					Domain:   user.Domain,
-					Presence: &presence,
+					return userBrief{
+						ID:       user.ID.String(),
+						Username: user.Username,
+						Domain:   user.Domain,
+						Presence: &presence,
+					}, nil
 				})
 			})
 		})
 	})
 }
 
+// getConversations returns the conversations the user is in, newest joined first.
+// query params: limit (default 50, max 100), cursor (id of the last conversation of the previous page, before works too),
+// participant_limit, include_hidden
 func getConversations(w http.ResponseWriter, r *http.Request) {
 	user, err := authhelper.GetUserFromRequest(r)
 	if err != nil || user == nil {
@@ -107,23 +203,95 @@ func getConversations(w http.ResponseWriter, r *http.Request) {
 
 	myUserID := user.ID.String()
 
-	// get conversations user is part of
-	var myParticipations []database.DMParticipant
-	err = database.DB.
+	// ?participant_limit=N only inlines the first N participants of each conversation, the rest are
+	// paginated through /conversation/{id}/participants
+	participantLimit := 0
+	if limitStr := r.URL.Query().Get("participant_limit"); limitStr != "" {
+		participantLimit, err = strconv.Atoi(limitStr)
+		if err != nil || participantLimit <= 0 || participantLimit > 100 {
+			httpresponder.SendErrorResponse(w, r, "participant_limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit, ok := pagination.Limit(r)
+	if !ok {
+		httpresponder.SendErrorResponse(w, r, pagination.LimitError, http.StatusBadRequest)
+		return
+	}
+
+	// hidden conversations are only listed with ?include_hidden=true, and only once unlocked in this session
+	visible := database.DB.Model(&database.DMParticipant{}).Where("user_id = ?", user.ID)
+	if r.URL.Query().Get("include_hidden") == "true" {
+		var hiddenIDs []uuid.UUID
+		err = database.DB.Model(&database.DMParticipant{}).
+			Where("user_id = ? AND hidden = ?", user.ID, true).
+			Pluck("conversation_id", &hiddenIDs).Error
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "failed to fetch conversations", http.StatusInternalServerError)
+			return
+		}
+
+		session := hiddenconv.Session(r)
+		unlocked := make([]uuid.UUID, 0, len(hiddenIDs))
+		for _, convID := range hiddenIDs {
+			if hiddenconv.IsUnlocked(r.Context(), session, convID) {
+				unlocked = append(unlocked, convID)
+			}
+		}
+
+		if len(unlocked) > 0 {
+			visible = visible.Where("hidden = ? OR conversation_id IN ?", false, unlocked)
+		} else {
+			visible = visible.Where("hidden = ?", false)
+		}
+	} else {
+		visible = visible.Where("hidden = ?", false)
+	}
+
+	var total int64
+	if err := visible.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch conversations", http.StatusInternalServerError)
+		return
+	}
+
+	page := visible.Session(&gorm.Session{}).
 		Preload("Conversation").
-		Where("user_id = ?", user.ID).
-		Find(&myParticipations).Error
+		Order("joined_at DESC, conversation_id DESC").
+		Limit(limit + 1)
+
+	if raw := pagination.Cursor(r, "before"); raw != "" {
+		cursorID, err := uuid.FromString(raw)
+		if err != nil {
+			httpresponder.SendErrorResponse(w, r, "invalid cursor", http.StatusBadRequest)
+			return
+		}
 
-	if err != nil {
+		var cursor database.DMParticipant
+		if err := visible.Session(&gorm.Session{}).Where("conversation_id = ?", cursorID).First(&cursor).Error; err != nil {
+			httpresponder.SendErrorResponse(w, r, "cursor conversation not found", http.StatusNotFound)
+			return
+		}
+
+		page = page.Where("joined_at < ? OR (joined_at = ? AND conversation_id < ?)", cursor.JoinedAt, cursor.JoinedAt, cursor.ConversationID)
+	}
+
+	var myParticipations []database.DMParticipant
+	if err := page.Find(&myParticipations).Error; err != nil {
 		httpresponder.SendErrorResponse(w, r, "failed to fetch conversations", http.StatusInternalServerError)
 		return
 	}
 
 	if len(myParticipations) == 0 {
-		httpresponder.SendSuccessResponse(w, r, []conversationResponse{})
+		httpresponder.SendListResponse(w, r, []conversationResponse{}, "", false, &total)
 		return
 	}
 
+	hasMore := len(myParticipations) > limit
+	if hasMore {
+		myParticipations = myParticipations[:limit]
+	}
+
 	// collect conversation ids
 	convIDs := make([]uuid.UUID, len(myParticipations))
 	for i, p := range myParticipations {
@@ -134,6 +302,7 @@ func getConversations(w http.ResponseWriter, r *http.Request) {
 	var allParticipants []database.DMParticipant
 	err = database.DB.
 		Where("conversation_id IN ?", convIDs).
+		Order("joined_at ASC").
 		Find(&allParticipants).Error
 
 	if err != nil {
@@ -141,12 +310,27 @@ func getConversations(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// group participants by conversation, keeping only the ones that get inlined
+	participantsByConv := make(map[string][]string)
+	participantCounts := make(map[string]int)
+	for _, p := range allParticipants {
+		convID := p.ConversationID.String()
+		participantCounts[convID]++
+
+		if p.UserID.String() == myUserID {
+			continue
+		}
+		if participantLimit > 0 && len(participantsByConv[convID]) >= participantLimit {
+			continue
+		}
+		participantsByConv[convID] = append(participantsByConv[convID], p.UserID.String())
+	}
+
 	// collect other user ids
 	userIDSet := make(map[string]uuid.UUID)
-	for _, p := range allParticipants {
-		uid := p.UserID.String()
-		if uid != myUserID {
-			userIDSet[uid] = p.UserID
+	for _, userIDs := range participantsByConv {
+		for _, uid := range userIDs {
+			userIDSet[uid] = uuid.FromStringOrNil(uid)
 		}
 	}
 
@@ -159,13 +343,6 @@ func getConversations(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// group participants by conversation
-	participantsByConv := make(map[string][]string)
-	for _, p := range allParticipants {
-		convID := p.ConversationID.String()
-		participantsByConv[convID] = append(participantsByConv[convID], p.UserID.String())
-	}
-
 	// build response
 	conversations := make([]conversationResponse, 0, len(myParticipations))
 	for _, p := range myParticipations {
@@ -175,28 +352,35 @@ func getConversations(w http.ResponseWriter, r *http.Request) {
 			ID:           convID,
 			Name:         p.Conversation.Name,
 			IsGroup:      p.Conversation.IsGroup,
+			ImportedFrom: p.Conversation.ImportedFrom,
 			LastReadAt:   p.LastReadAt,
 			CreatedAt:    p.Conversation.CreatedAt,
+			Hidden:       p.Hidden,
 			Participants: make([]userBrief, 0),
+
+			ParticipantCount: participantCounts[convID],
+			AnnouncementOnly: p.Conversation.AnnouncementOnly,
+		}
+		if p.Conversation.UpgradedToServerID != nil {
+			conv.UpgradedToServerID = p.Conversation.UpgradedToServerID.String()
 		}
 
 		// add other participants
 		for _, userID := range participantsByConv[convID] {
-			if userID != myUserID || p.Conversation.IsGroup {
-				if u, ok := usersMap[userID]; ok {
-					conv.Participants = append(conv.Participants, userBrief{
-						ID:       u.ID.String(),
-						Username: u.Username,
-						Domain:   u.Domain,
-					})
-				}
+			if u, ok := usersMap[userID]; ok {
+				conv.Participants = append(conv.Participants, userBrief{
+					ID:       u.ID.String(),
+					Username: u.Username,
+					Domain:   u.Domain,
+				})
 			}
 		}
 
 		conversations = append(conversations, conv)
 	}
 
-	httpresponder.SendSuccessResponse(w, r, conversations)
+	nextCursor := myParticipations[len(myParticipations)-1].ConversationID.String()
+	httpresponder.SendListResponse(w, r, conversations, nextCursor, hasMore, &total)
 }
 
 func getServers(w http.ResponseWriter, r *http.Request) {
@@ -219,14 +403,18 @@ func getServers(w http.ResponseWriter, r *http.Request) {
 
 	servers := make([]serverResponse, 0, len(memberships))
 	for _, m := range memberships {
-		servers = append(servers, serverResponse{
+		server := serverResponse{
 			ID:          m.Server.ID.String(),
 			Name:        m.Server.Name,
 			Description: m.Server.Description,
 			Icon:        m.Server.Icon,
 			OwnerID:     m.Server.OwnerID.String(),
 			JoinedAt:    m.JoinedAt,
-		})
+		}
+		if m.Server.OwnedDomain != nil && m.Server.DomainStatus == database.DomainStatusVerified {
+			server.VerifiedDomain = *m.Server.OwnedDomain
+		}
+		servers = append(servers, server)
 	}
 
 	httpresponder.SendSuccessResponse(w, r, servers)
@@ -0,0 +1,388 @@
+package webhookroutes
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hindsightchat/backend/src/lib/authhelper"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/httpresponder"
+	"github.com/hindsightchat/backend/src/lib/integrations"
+	"github.com/hindsightchat/backend/src/lib/msgcount"
+	"github.com/hindsightchat/backend/src/middleware"
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	"github.com/hindsightchat/backend/src/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+// github caps deliveries at 25MB, we dont need anywhere near that for the events we format
+const maxPayloadSize = 1 << 20
+
+type webhookResponse struct {
+	ID        string    `json:"id"`
+	ServerID  string    `json:"server_id"`
+	ChannelID string    `json:"channel_id"`
+	CreatorID string    `json:"creator_id"`
+	Type      string    `json:"type"`
+	Name      string    `json:"name"`
+	Avatar    string    `json:"avatar,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// only returned on creation
+	URL    string `json:"url,omitempty"`
+	Secret string `json:"secret,omitempty"`
+}
+
+type createWebhookRequest struct {
+	ChannelID string `json:"channel_id"`
+	Type      string `json:"type"` // github or gitlab
+	Name      string `json:"name"`
+	Avatar    string `json:"avatar"`
+}
+
+func RegisterRoutes(r chi.Router) {
+	r.Route("/webhooks", func(r chi.Router) {
+		// called by github / gitlab, authenticated by the url token and the delivery signature
+		r.Post("/{id}/{token}/github", executeGitHub)
+		r.Post("/{id}/{token}/gitlab", executeGitLab)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RouteRequiresAuthentication)
+
+			r.Get("/", listWebhooks)
+			r.Post("/", createWebhook)
+			r.Delete("/{id}", deleteWebhook)
+		})
+	})
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channel, ok := loadManagedChannel(w, r, r.URL.Query().Get("channel_id"), user.ID)
+	if !ok {
+		return
+	}
+
+	var hooks []database.Webhook
+	if err := database.DB.Where("channel_id = ?", channel.ID).Order("created_at ASC").Find(&hooks).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]webhookResponse, 0, len(hooks))
+	for _, hook := range hooks {
+		response = append(response, toWebhookResponse(&hook))
+	}
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body createWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.Type != database.WebhookTypeGitHub && body.Type != database.WebhookTypeGitLab {
+		httpresponder.SendErrorResponse(w, r, "type must be github or gitlab", http.StatusBadRequest)
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		if body.Type == database.WebhookTypeGitHub {
+			body.Name = "GitHub"
+		} else {
+			body.Name = "GitLab"
+		}
+	}
+	if len(body.Name) > 80 {
+		httpresponder.SendErrorResponse(w, r, "name must be at most 80 characters", http.StatusBadRequest)
+		return
+	}
+	if len(body.Avatar) > 255 {
+		httpresponder.SendErrorResponse(w, r, "avatar url too long", http.StatusBadRequest)
+		return
+	}
+
+	channel, ok := loadManagedChannel(w, r, body.ChannelID, user.ID)
+	if !ok {
+		return
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	hook := database.Webhook{
+		ServerID:  channel.ServerID,
+		ChannelID: channel.ID,
+		CreatorID: user.ID,
+		Type:      body.Type,
+		Name:      body.Name,
+		Avatar:    body.Avatar,
+		Token:     token,
+		Secret:    secret,
+	}
+
+	if err := database.DB.Create(&hook).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	response := toWebhookResponse(&hook)
+	response.URL = "/webhooks/" + hook.ID.String() + "/" + hook.Token + "/" + hook.Type
+	response.Secret = hook.Secret
+
+	httpresponder.SendSuccessResponse(w, r, response)
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := authhelper.GetUserFromRequest(r)
+	if err != nil || user == nil {
+		httpresponder.SendErrorResponse(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	hookID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	var hook database.Webhook
+	if err := database.DB.Where("id = ?", hookID).First(&hook).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if !ownsServer(hook.ServerID, user.ID) {
+		httpresponder.SendErrorResponse(w, r, "webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if err := database.DB.Delete(&hook).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	httpresponder.SendSuccessResponse(w, r, map[string]bool{"deleted": true})
+}
+
+func executeGitHub(w http.ResponseWriter, r *http.Request) {
+	hook, body, ok := loadExecutableWebhook(w, r, database.WebhookTypeGitHub)
+	if !ok {
+		return
+	}
+
+	if !integrations.VerifyGitHubSignature(hook.Secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		httpresponder.SendErrorResponse(w, r, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	embed, err := integrations.FormatGitHub(r.Header.Get("X-GitHub-Event"), body)
+	postEmbed(w, r, hook, embed, err)
+}
+
+func executeGitLab(w http.ResponseWriter, r *http.Request) {
+	hook, body, ok := loadExecutableWebhook(w, r, database.WebhookTypeGitLab)
+	if !ok {
+		return
+	}
+
+	if !integrations.VerifyGitLabToken(hook.Secret, r.Header.Get("X-Gitlab-Token")) {
+		httpresponder.SendErrorResponse(w, r, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	embed, err := integrations.FormatGitLab(r.Header.Get("X-Gitlab-Event"), body)
+	postEmbed(w, r, hook, embed, err)
+}
+
+// loadExecutableWebhook resolves the webhook from the url and reads the raw body, which is needed for signatures
+func loadExecutableWebhook(w http.ResponseWriter, r *http.Request, hookType string) (*database.Webhook, []byte, bool) {
+	hookID, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "webhook not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	var hook database.Webhook
+	if err := database.DB.Preload("Channel").Where("id = ?", hookID).First(&hook).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "webhook not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	token := chi.URLParam(r, "token")
+	if hook.Type != hookType || subtle.ConstantTimeCompare([]byte(hook.Token), []byte(token)) != 1 {
+		httpresponder.SendErrorResponse(w, r, "webhook not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	// the channel could have been deleted or moved since the webhook was created
+	if hook.Channel.ID != hook.ChannelID || hook.Channel.ServerID != hook.ServerID {
+		httpresponder.SendErrorResponse(w, r, "webhook channel not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize+1))
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to read body", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if len(body) > maxPayloadSize {
+		httpresponder.SendErrorResponse(w, r, "payload too large", http.StatusRequestEntityTooLarge)
+		return nil, nil, false
+	}
+
+	return &hook, body, true
+}
+
+// webhookOverrides reads the ?username= and ?avatar_url= the execute url may carry to post under another
+// name or avatar than the webhook's own, returns the error message when they arent valid
+func webhookOverrides(r *http.Request, hook *database.Webhook) (string, string, string) {
+	name, avatar := hook.Name, hook.Avatar
+
+	query := r.URL.Query()
+	if query.Has("username") {
+		name = strings.TrimSpace(query.Get("username"))
+		if name == "" || len(name) > 80 {
+			return "", "", "username must be between 1 and 80 characters"
+		}
+	}
+	if query.Has("avatar_url") {
+		avatar = query.Get("avatar_url")
+		if len(avatar) > 255 {
+			return "", "", "avatar url too long"
+		}
+		if parsed, err := url.Parse(avatar); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return "", "", "avatar url must be an https url"
+		}
+	}
+
+	return name, avatar, ""
+}
+
+// postEmbed stores the formatted event as a channel message and sends it to the channel
+func postEmbed(w http.ResponseWriter, r *http.Request, hook *database.Webhook, embed *types.Embed, formatErr error) {
+	name, avatar, invalid := webhookOverrides(r, hook)
+	if invalid != "" {
+		httpresponder.SendErrorResponse(w, r, invalid, http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(formatErr, integrations.ErrIgnored) {
+		// still a 2xx so the provider doesnt flag the hook as failing (pings end up here too)
+		httpresponder.SendSuccessResponse(w, r, map[string]bool{"posted": false})
+		return
+	}
+	if formatErr != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	embeds, err := json.Marshal([]types.Embed{*embed})
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to encode embed", http.StatusInternalServerError)
+		return
+	}
+	encoded := string(embeds)
+
+	msg := database.ChannelMessage{
+		ChannelID:   hook.ChannelID,
+		AuthorID:    hook.CreatorID,
+		Content:     "",
+		Attachments: "[]",
+		WebhookID:   &hook.ID,
+		Embeds:      &encoded,
+
+		WebhookName:   name,
+		WebhookAvatar: avatar,
+	}
+	if err := msgcount.CreateChannelMessage(&msg); err != nil {
+		httpresponder.SendErrorResponse(w, r, "failed to create message", http.StatusInternalServerError)
+		return
+	}
+
+	// webhooks show up under their own (or the overridden) name and avatar rather than the creator's
+	websocket.PublishChannelMessage(hook.Channel.ServerID, &msg, websocket.WebhookAuthor(&msg))
+
+	httpresponder.SendSuccessResponse(w, r, map[string]any{"posted": true, "message_id": msg.ID})
+}
+
+// loadManagedChannel loads a channel the user may manage webhooks for, only the server owner for now
+func loadManagedChannel(w http.ResponseWriter, r *http.Request, rawID string, userID uuid.UUID) (*database.Channel, bool) {
+	channelID, err := uuid.FromString(rawID)
+	if err != nil {
+		httpresponder.SendErrorResponse(w, r, "invalid channel id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var channel database.Channel
+	if err := database.DB.Where("id = ?", channelID).First(&channel).Error; err != nil {
+		httpresponder.SendErrorResponse(w, r, "channel not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if !ownsServer(channel.ServerID, userID) {
+		httpresponder.SendErrorResponse(w, r, "missing permission to manage webhooks", http.StatusForbidden)
+		return nil, false
+	}
+
+	return &channel, true
+}
+
+func ownsServer(serverID, userID uuid.UUID) bool {
+	var count int64
+	database.DB.Model(&database.Server{}).Where("id = ? AND owner_id = ?", serverID, userID).Count(&count)
+	return count > 0
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func toWebhookResponse(hook *database.Webhook) webhookResponse {
+	return webhookResponse{
+		ID:        hook.ID.String(),
+		ServerID:  hook.ServerID.String(),
+		ChannelID: hook.ChannelID.String(),
+		CreatorID: hook.CreatorID.String(),
+		Type:      hook.Type,
+		Name:      hook.Name,
+		Avatar:    hook.Avatar,
+		CreatedAt: hook.CreatedAt,
+	}
+}
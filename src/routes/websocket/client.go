@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/hindsightchat/backend/src/lib/instance"
 	"github.com/hindsightchat/backend/src/types"
 	uuid "github.com/satori/go.uuid"
 )
@@ -16,6 +17,16 @@ const (
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
 	maxMessageSize = 512 * 1024
+
+	// accounts per connection, including the first one
+	maxIdentities = 5
+
+	// how long a client remembers keyed dispatches it already got, see DispatchToUserOnce
+	dispatchDedupeTTL = 5 * time.Minute
+
+	// messages a connection may send per window before it gets closed with CloseRateLimited
+	rateLimitMessages = 120
+	rateLimitWindow   = time.Minute
 )
 
 type Client struct {
@@ -23,11 +34,16 @@ type Client struct {
 	conn      *websocket.Conn
 	send      chan []byte
 	sessionID string
+	ip        string // where the connection came from
 
 	userID     uuid.UUID
 	user       *UserBrief
 	identified bool
 
+	// extra accounts on the same connection share its conn and send buffer through the parent
+	parent     *Client
+	identities map[uuid.UUID]*Client
+
 	// presence
 	status   string
 	activity *types.Activity
@@ -41,6 +57,16 @@ type Client struct {
 	servers       map[uuid.UUID]bool
 	conversations map[uuid.UUID]bool
 	mu            sync.RWMutex
+
+	// set once send is closed, late dispatches from fan-out workers are dropped
+	closed bool
+
+	// event + idempotency key of recent keyed dispatches
+	dispatched map[string]time.Time
+
+	// incoming rate limit, only touched by the read pump
+	windowStart time.Time
+	windowCount int
 }
 
 func NewClient(hub *Hub, conn *websocket.Conn) *Client {
@@ -55,6 +81,20 @@ func NewClient(hub *Hub, conn *websocket.Conn) *Client {
 	}
 }
 
+// newIdentityClient creates a client for another account on the parents connection
+func newIdentityClient(parent *Client, userID uuid.UUID) *Client {
+	return &Client{
+		hub:           parent.hub,
+		conn:          parent.conn,
+		sessionID:     uuid.NewV4().String(),
+		userID:        userID,
+		parent:        parent,
+		servers:       make(map[uuid.UUID]bool),
+		conversations: make(map[uuid.UUID]bool),
+		status:        "online",
+	}
+}
+
 func (c *Client) SessionID() string {
 	return c.sessionID
 }
@@ -114,6 +154,23 @@ func (c *Client) ClearFocus() {
 	c.mu.Unlock()
 }
 
+func (c *Client) clearServerFocus(serverID uuid.UUID) {
+	c.mu.Lock()
+	if c.focusedServer != nil && *c.focusedServer == serverID {
+		c.focusedChannel = nil
+		c.focusedServer = nil
+	}
+	c.mu.Unlock()
+}
+
+func (c *Client) clearConversationFocus(convID uuid.UUID) {
+	c.mu.Lock()
+	if c.focusedConversation != nil && *c.focusedConversation == convID {
+		c.focusedConversation = nil
+	}
+	c.mu.Unlock()
+}
+
 func (c *Client) IsFocusedOnChannel(channelID uuid.UUID) bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -163,6 +220,48 @@ func (c *Client) IsInConversation(convID uuid.UUID) bool {
 	return c.conversations[convID]
 }
 
+// identities
+func (c *Client) Identity(userID uuid.UUID) *Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.identities[userID]
+}
+
+func (c *Client) IdentityCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.identities)
+}
+
+func (c *Client) AddIdentity(identity *Client) {
+	c.mu.Lock()
+	if c.identities == nil {
+		c.identities = make(map[uuid.UUID]*Client)
+	}
+	c.identities[identity.userID] = identity
+	c.mu.Unlock()
+}
+
+// RemoveIdentity drops the account from the connection and returns its client
+func (c *Client) RemoveIdentity(userID uuid.UUID) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	identity := c.identities[userID]
+	delete(c.identities, userID)
+	return identity
+}
+
+func (c *Client) takeIdentities() []*Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	identities := make([]*Client, 0, len(c.identities))
+	for _, identity := range c.identities {
+		identities = append(identities, identity)
+	}
+	c.identities = nil
+	return identities
+}
+
 func (c *Client) GetServerIDs() []uuid.UUID {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -186,6 +285,10 @@ func (c *Client) GetConversationIDs() []uuid.UUID {
 // pumps
 func (c *Client) ReadPump() {
 	defer func() {
+		// extra accounts go first, they write through this clients send buffer
+		for _, identity := range c.takeIdentities() {
+			c.hub.unregister <- identity
+		}
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
@@ -206,14 +309,38 @@ func (c *Client) ReadPump() {
 			break
 		}
 
+		if c.rateLimited() {
+			c.Close(CloseRateLimited, "rate limited")
+			break
+		}
+
 		var msg Message
 		if err := json.Unmarshal(data, &msg); err != nil {
-			c.SendError(4000, "invalid message format")
-			continue
+			c.Close(CloseInvalidPayload, "invalid message format")
+			break
+		}
+
+		target := c
+		if msg.User != nil && *msg.User != c.userID {
+			if target = c.Identity(*msg.User); target == nil {
+				c.SendError(4004, "unknown identity")
+				continue
+			}
 		}
 
-		c.hub.HandleMessage(c, &msg)
+		c.hub.HandleMessage(target, &msg)
+	}
+}
+
+// rateLimited counts an incoming message and reports whether the window is used up
+func (c *Client) rateLimited() bool {
+	now := time.Now()
+	if now.Sub(c.windowStart) > rateLimitWindow {
+		c.windowStart = now
+		c.windowCount = 0
 	}
+	c.windowCount++
+	return c.windowCount > rateLimitMessages
 }
 
 func (c *Client) WritePump() {
@@ -259,12 +386,31 @@ func (c *Client) WritePump() {
 }
 
 func (c *Client) Send(msg *Message) {
+	if c.parent != nil {
+		// the message may be shared between clients, tag a copy
+		tagged := *msg
+		tagged.User = &c.userID
+		c.parent.Send(&tagged)
+		return
+	}
+
 	data, err := json.Marshal(msg)
 	if err != nil {
 		log.Printf("[ws] marshal error: %v", err)
 		return
 	}
 
+	c.sendEncoded(data)
+}
+
+// sendEncoded queues an already marshalled message, dropping it when the buffer is full
+func (c *Client) sendEncoded(data []byte) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return
+	}
+
 	select {
 	case c.send <- data:
 	default:
@@ -272,6 +418,21 @@ func (c *Client) Send(msg *Message) {
 	}
 }
 
+// Close sends a close frame and drops the connection, the read pump then unregisters the client.
+// an extra account is only dropped from the connection, the others stay
+func (c *Client) Close(code int, reason string) {
+	if c.parent != nil {
+		if c.parent.RemoveIdentity(c.userID) == c {
+			c.Send(&Message{Op: OpInvalidSession})
+			c.hub.unregister <- c
+		}
+		return
+	}
+
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	c.conn.Close()
+}
+
 func (c *Client) SendDispatch(event EventType, data any) {
 	c.Send(&Message{
 		Op:    OpDispatch,
@@ -280,6 +441,34 @@ func (c *Client) SendDispatch(event EventType, data any) {
 	})
 }
 
+// firstDispatch records a keyed dispatch and reports whether this client hasnt had it yet
+func (c *Client) firstDispatch(event EventType, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.dispatched == nil {
+		c.dispatched = make(map[string]time.Time)
+	}
+
+	id := string(event) + ":" + key
+	if at, ok := c.dispatched[id]; ok && now.Sub(at) < dispatchDedupeTTL {
+		return false
+	}
+
+	// forget expired keys now and then so long lived connections dont grow
+	if len(c.dispatched) >= 256 {
+		for k, at := range c.dispatched {
+			if now.Sub(at) >= dispatchDedupeTTL {
+				delete(c.dispatched, k)
+			}
+		}
+	}
+
+	c.dispatched[id] = now
+	return true
+}
+
 func (c *Client) SendError(code int, message string) {
 	c.Send(&Message{
 		Op: OpDispatch,
@@ -290,6 +479,18 @@ func (c *Client) SendError(code int, message string) {
 	})
 }
 
+// SendQuotaError tells the client a limit would be exceeded, the details name the limit
+func (c *Client) SendQuotaError(err *instance.QuotaError) {
+	c.Send(&Message{
+		Op: OpDispatch,
+		Data: ErrorPayload{
+			Code:    4013,
+			Message: err.Error(),
+			Details: err,
+		},
+	})
+}
+
 func (c *Client) SendAck(nonce string, data any) {
 	c.Send(&Message{
 		Op:    OpDispatch,
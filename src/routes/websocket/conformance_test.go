@@ -0,0 +1,11 @@
+package websocket_test
+
+import (
+	"testing"
+
+	"github.com/hindsightchat/backend/src/testserver"
+)
+
+func TestGatewayConformance(t *testing.T) {
+	testserver.RunConformance(t, testserver.Start(t))
+}
@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"github.com/hindsightchat/backend/src/lib/contentsignal"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	uuid "github.com/satori/go.uuid"
+)
+
+// notifyModerators is the content signal hook, it tells connected members who may manage messages
+// in the server. they get ids only and open the message themselves
+func (h *Hub) notifyModerators(signal contentsignal.Signal) {
+	if signal.ServerID == nil {
+		return
+	}
+	serverID := *signal.ServerID
+
+	checked := make(map[uuid.UUID]bool)
+	for _, client := range h.serverClients.get(serverID) {
+		userID := client.userID
+		if userID == signal.AuthorID {
+			continue
+		}
+
+		moderator, ok := checked[userID]
+		if !ok {
+			moderator = permissions.MemberHas(serverID, userID, permissions.ManageMessages)
+			checked[userID] = moderator
+		}
+		if moderator {
+			client.SendDispatch(EventContentSignal, signal)
+		}
+	}
+}
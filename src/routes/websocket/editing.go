@@ -0,0 +1,149 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	uuid "github.com/satori/go.uuid"
+)
+
+// an editing state ends on its own after this, clients resend OpEditingStart while the editor stays open
+const editingTimeout = 15 * time.Second
+
+type editingKey struct {
+	userID    uuid.UUID
+	messageID uuid.UUID
+}
+
+// open editing states of this instance, each stops itself when its timer fires
+var (
+	editing   = make(map[editingKey]*time.Timer)
+	editingMu sync.Mutex
+)
+
+func (h *Hub) handleEditingStart(client *Client, msg *Message) {
+	payload, ok := parseEditingPayload(client, msg)
+	if !ok {
+		return
+	}
+
+	// hiding typing hides editing too
+	if typingHidden(client.userID, payload.ConversationID) {
+		return
+	}
+
+	key := editingKey{userID: client.userID, messageID: payload.MessageID}
+
+	editingMu.Lock()
+	timer, refreshing := editing[key]
+	editingMu.Unlock()
+
+	// only authors edit, checked once per editing state rather than on every refresh
+	if !refreshing && !ownsMessage(client.userID, payload) {
+		return
+	}
+
+	if refreshing {
+		timer.Reset(editingTimeout)
+	} else {
+		stopped := payload
+		timer = time.AfterFunc(editingTimeout, func() {
+			editingMu.Lock()
+			delete(editing, key)
+			editingMu.Unlock()
+
+			h.dispatchEditing(EventEditingStop, stopped)
+		})
+
+		editingMu.Lock()
+		if existing, ok := editing[key]; ok {
+			// a refresh raced us, keep the one already there
+			timer.Stop()
+			existing.Reset(editingTimeout)
+		} else {
+			editing[key] = timer
+		}
+		editingMu.Unlock()
+	}
+
+	h.dispatchEditing(EventEditingStart, payload)
+}
+
+func (h *Hub) handleEditingStop(client *Client, msg *Message) {
+	payload, ok := parseEditingPayload(client, msg)
+	if !ok {
+		return
+	}
+
+	h.stopEditing(client.userID, payload)
+}
+
+// stopEditing ends the users editing state of the message, if there is one
+func (h *Hub) stopEditing(userID uuid.UUID, payload EditingPayload) {
+	key := editingKey{userID: userID, messageID: payload.MessageID}
+
+	editingMu.Lock()
+	timer, ok := editing[key]
+	if ok {
+		delete(editing, key)
+	}
+	editingMu.Unlock()
+
+	if !ok || !timer.Stop() {
+		// never started, or the timer already sent the stop
+		return
+	}
+
+	h.dispatchEditing(EventEditingStop, payload)
+}
+
+// parseEditingPayload reads the payload and checks the client can see where the message is
+func parseEditingPayload(client *Client, msg *Message) (EditingPayload, bool) {
+	var payload EditingPayload
+
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return payload, false
+	}
+	if err := json.Unmarshal(data, &payload); err != nil || payload.MessageID == uuid.Nil {
+		return payload, false
+	}
+
+	payload.UserID = client.userID
+	payload.User = client.user
+	payload.ExpiresIn = int(editingTimeout / time.Second)
+
+	switch {
+	case payload.ChannelID != nil && payload.ServerID != nil:
+		payload.ConversationID = nil
+		return payload, client.IsInServer(*payload.ServerID)
+	case payload.ConversationID != nil:
+		return payload, client.IsInConversation(*payload.ConversationID)
+	}
+	return payload, false
+}
+
+func ownsMessage(userID uuid.UUID, payload EditingPayload) bool {
+	var count int64
+	if payload.ConversationID != nil {
+		database.DB.Model(&database.DirectMessage{}).
+			Where("id = ? AND conversation_id = ? AND author_id = ?", payload.MessageID, *payload.ConversationID, userID).
+			Count(&count)
+	} else {
+		database.DB.Model(&database.ChannelMessage{}).
+			Where("id = ? AND channel_id = ? AND author_id = ?", payload.MessageID, *payload.ChannelID, userID).
+			Count(&count)
+	}
+	return count > 0
+}
+
+// editing states go to focused clients only, like typing
+func (h *Hub) dispatchEditing(event EventType, payload EditingPayload) {
+	if payload.ConversationID != nil {
+		h.DispatchTypingToConversation(*payload.ConversationID, event, payload)
+		return
+	}
+	h.DispatchTypingToChannel(*payload.ServerID, *payload.ChannelID, event, payload)
+}
@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/push"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// how often upcoming events are checked for reminders
+	eventReminderInterval = time.Minute
+
+	// how long before an event starts its rsvps are reminded
+	eventReminderLead = 15 * time.Minute
+)
+
+// EventReminderPayload is sent to everyone who answered an event shortly before it starts
+type EventReminderPayload struct {
+	ID        uuid.UUID  `json:"id"`
+	ServerID  uuid.UUID  `json:"server_id"`
+	Title     string     `json:"title"`
+	StartsAt  time.Time  `json:"starts_at"`
+	ChannelID *uuid.UUID `json:"channel_id,omitempty"`
+	Location  string     `json:"location,omitempty"`
+}
+
+// RunEventReminders reminds rsvps of events that are about to start
+func (h *Hub) RunEventReminders() {
+	ticker := time.NewTicker(eventReminderInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.sendEventReminders()
+	}
+}
+
+func (h *Hub) sendEventReminders() {
+	now := time.Now()
+	var events []database.ScheduledEvent
+	err := database.DB.
+		Where("reminded_at IS NULL AND starts_at > ? AND starts_at <= ?", now, now.Add(eventReminderLead)).
+		Find(&events).Error
+	if err != nil {
+		log.Printf("[ws] failed to load upcoming events: %v", err)
+		return
+	}
+
+	for _, event := range events {
+		// claim the event, a reminder goes out once even with several instances
+		result := database.DB.Model(&database.ScheduledEvent{}).
+			Where("id = ? AND reminded_at IS NULL", event.ID).
+			UpdateColumn("reminded_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+
+		var userIDs []uuid.UUID
+		if err := database.DB.Model(&database.EventRSVP{}).Where("event_id = ?", event.ID).Pluck("user_id", &userIDs).Error; err != nil {
+			log.Printf("[ws] failed to load rsvps of event %s: %v", event.ID, err)
+			continue
+		}
+
+		payload := EventReminderPayload{
+			ID:        event.ID,
+			ServerID:  event.ServerID,
+			Title:     event.Title,
+			StartsAt:  event.StartsAt,
+			ChannelID: event.ChannelID,
+			Location:  event.Location,
+		}
+
+		offline := make([]uuid.UUID, 0, len(userIDs))
+		for _, userID := range userIDs {
+			if h.IsUserOnline(userID) {
+				h.DispatchToUser(userID, EventScheduledEventReminder, payload)
+			} else {
+				offline = append(offline, userID)
+			}
+		}
+
+		if push.Enabled() && len(offline) > 0 {
+			push.Send(offline, push.Notification{
+				Title: event.Title,
+				Body:  "Starts in " + event.StartsAt.Sub(now).Round(time.Minute).String(),
+				Data: map[string]string{
+					"server_id": event.ServerID.String(),
+					"event_id":  event.ID.String(),
+				},
+			})
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	fanoutWorkers   = 16
+	fanoutQueueSize = 256 // jobs per worker
+
+	// audiences up to this size are sent on the callers goroutine, queueing costs more than it saves
+	fanoutInlineLimit = 64
+)
+
+// fanoutJob is one message for the clients of a server or conversation
+type fanoutJob struct {
+	clients []*Client
+	msg     *Message
+}
+
+// FanoutStats describes the dispatch workers for instance stats
+type FanoutStats struct {
+	Workers    int   `json:"workers"`
+	QueueDepth int   `json:"queue_depth"` // jobs waiting over all workers
+	QueueSize  int   `json:"queue_size"`
+	Queued     int64 `json:"queued"`  // jobs handed to workers since start
+	Blocked    int64 `json:"blocked"` // of those, how many waited for a full queue
+}
+
+type fanoutPool struct {
+	queues  []chan fanoutJob
+	queued  atomic.Int64
+	blocked atomic.Int64
+}
+
+func newFanoutPool() *fanoutPool {
+	pool := &fanoutPool{queues: make([]chan fanoutJob, fanoutWorkers)}
+	for i := range pool.queues {
+		pool.queues[i] = make(chan fanoutJob, fanoutQueueSize)
+	}
+	return pool
+}
+
+// RunFanout starts the dispatch workers
+func (h *Hub) RunFanout() {
+	for _, queue := range h.fanout.queues {
+		go func(queue chan fanoutJob) {
+			for job := range queue {
+				deliver(job.clients, job.msg)
+			}
+		}(queue)
+	}
+}
+
+// sendToAudience sends msg to every client. large audiences go to the worker owning the target,
+// so events for one server or conversation keep their order while the caller returns right away.
+// a full queue makes the caller wait rather than drop or reorder events
+func (h *Hub) sendToAudience(targetID uuid.UUID, clients []*Client, msg *Message) {
+	if len(clients) == 0 {
+		return
+	}
+	if len(clients) <= fanoutInlineLimit {
+		deliver(clients, msg)
+		return
+	}
+
+	queue := h.fanout.queues[hashID(targetID)%fanoutWorkers]
+	job := fanoutJob{clients: clients, msg: msg}
+	h.fanout.queued.Add(1)
+
+	select {
+	case queue <- job:
+	default:
+		h.fanout.blocked.Add(1)
+		queue <- job
+	}
+}
+
+// FanoutStats returns the current state of the dispatch workers
+func (h *Hub) FanoutStats() FanoutStats {
+	depth := 0
+	for _, queue := range h.fanout.queues {
+		depth += len(queue)
+	}
+	return FanoutStats{
+		Workers:    fanoutWorkers,
+		QueueDepth: depth,
+		QueueSize:  fanoutWorkers * fanoutQueueSize,
+		Queued:     h.fanout.queued.Load(),
+		Blocked:    h.fanout.blocked.Load(),
+	}
+}
+
+// deliver encodes the message once for all clients, extra identities tag their own copy
+func deliver(clients []*Client, msg *Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[ws] marshal error: %v", err)
+		return
+	}
+
+	for _, client := range clients {
+		if client.parent != nil {
+			client.Send(msg)
+			continue
+		}
+		client.sendEncoded(data)
+	}
+}
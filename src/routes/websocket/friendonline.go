@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"github.com/hindsightchat/backend/src/lib/friendwatch"
+	"github.com/hindsightchat/backend/src/lib/push"
+	uuid "github.com/satori/go.uuid"
+)
+
+// notifyFriendOnline tells the friends who asked for it that the user just came online. watchers without a
+// connected client get a push instead, when they wanted one
+func (h *Hub) notifyFriendOnline(user *UserBrief) {
+	watchers := friendwatch.Watchers(user.ID)
+	if len(watchers) == 0 {
+		return
+	}
+
+	payload := map[string]any{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"domain":   user.Domain,
+	}
+
+	var offline []uuid.UUID
+	for watcherID, wantsPush := range watchers {
+		if h.IsUserOnline(watcherID) {
+			h.DispatchToUser(watcherID, EventFriendOnline, payload)
+			continue
+		}
+		if wantsPush {
+			offline = append(offline, watcherID)
+		}
+	}
+
+	if len(offline) > 0 && push.Enabled() {
+		push.Send(offline, push.Notification{
+			Title: user.Username,
+			Body:  user.Username + " is online",
+			Data: map[string]string{
+				"type":    "friend_online",
+				"user_id": user.ID.String(),
+			},
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package websocket_test
+
+import (
+	"testing"
+
+	"github.com/hindsightchat/backend/src/routes/websocket"
+	"github.com/hindsightchat/backend/src/testserver"
+)
+
+func TestGatewayIdentifyReady(t *testing.T) {
+	s := testserver.Start(t)
+	f := s.NewFixture(t)
+
+	ready := f.GA.Ready()
+
+	var payload websocket.ReadyPayload
+	ready.Decode(t, &payload)
+
+	if payload.User.ID.String() != f.A.ID {
+		t.Fatalf("READY is for %s, want %s", payload.User.ID, f.A.ID)
+	}
+	if payload.SessionID == "" {
+		t.Fatal("READY has no session id")
+	}
+
+	found := false
+	for _, user := range payload.Users {
+		found = found || user.ID.String() == f.B.ID
+	}
+	if !found {
+		t.Fatalf("READY users %+v dont include the friend %s", payload.Users, f.B.ID)
+	}
+}
+
+func TestGatewayIdentifyBadTokenCloses(t *testing.T) {
+	s := testserver.Start(t)
+
+	g := s.Dial(t)
+	g.Send(t, map[string]any{"op": 2, "d": map[string]string{"token": "not-a-token"}})
+	g.ExpectClose(t, websocket.CloseAuthFailed)
+}
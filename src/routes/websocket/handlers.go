@@ -5,10 +5,26 @@ import (
 	"log"
 	"time"
 
+	"github.com/hindsightchat/backend/src/lib/activity"
+	"github.com/hindsightchat/backend/src/lib/agegate"
 	"github.com/hindsightchat/backend/src/lib/authhelper"
+	"github.com/hindsightchat/backend/src/lib/consent"
+	"github.com/hindsightchat/backend/src/lib/contentsignal"
 	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/imageproxy"
+	"github.com/hindsightchat/backend/src/lib/inbox"
+	"github.com/hindsightchat/backend/src/lib/instance"
+	"github.com/hindsightchat/backend/src/lib/maintenance"
+	"github.com/hindsightchat/backend/src/lib/mentions"
+	"github.com/hindsightchat/backend/src/lib/msgcount"
+	"github.com/hindsightchat/backend/src/lib/permissions"
+	"github.com/hindsightchat/backend/src/lib/restriction"
+	"github.com/hindsightchat/backend/src/lib/stats"
+	"github.com/hindsightchat/backend/src/lib/tokenreuse"
+	"github.com/hindsightchat/backend/src/lib/usersettings"
 	"github.com/hindsightchat/backend/src/types"
 	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm/clause"
 )
 
 // routes incoming messages to handlers
@@ -18,19 +34,35 @@ func (h *Hub) HandleMessage(client *Client, msg *Message) {
 		return
 	}
 
+	// writes are refused while the instance is read-only
+	if !isReadOnlyOp(msg.Op) && maintenance.Enabled() {
+		client.SendError(5030, maintenance.Current().Message)
+		return
+	}
+
 	switch msg.Op {
 	case OpIdentify:
 		h.handleIdentify(client, msg)
+	case OpIdentifyExtra:
+		h.handleIdentifyExtra(client, msg)
+	case OpRemoveIdentity:
+		h.handleRemoveIdentity(client, msg)
 	case OpHeartbeat:
 		h.handleHeartbeat(client, msg)
 	case OpPresenceUpdate:
 		h.handlePresenceUpdate(client, msg)
+	case OpPresenceQuery:
+		h.handlePresenceQuery(client, msg)
 	case OpFocusChange:
 		h.handleFocusChange(client, msg)
 	case OpTypingStart:
 		h.handleTypingStart(client, msg)
 	case OpTypingStop:
 		h.handleTypingStop(client, msg)
+	case OpEditingStart:
+		h.handleEditingStart(client, msg)
+	case OpEditingStop:
+		h.handleEditingStop(client, msg)
 	case OpMessageCreate:
 		h.handleMessageCreate(client, msg)
 	case OpMessageEdit:
@@ -39,11 +71,23 @@ func (h *Hub) HandleMessage(client *Client, msg *Message) {
 		h.handleMessageDelete(client, msg)
 	case OpMessageAck:
 		h.handleMessageAck(client, msg)
+	case OpDeliveryAck:
+		h.handleDeliveryAck(client, msg)
 	default:
 		client.SendError(4002, "unknown opcode")
 	}
 }
 
+// isReadOnlyOp reports whether the op can be served in read-only mode. everything else writes, e.g acks move
+// read states and the inbox, presence updates store the status. new ops are refused until listed here
+func isReadOnlyOp(op OpCode) bool {
+	switch op {
+	case OpIdentify, OpIdentifyExtra, OpRemoveIdentity, OpHeartbeat, OpPresenceQuery, OpFocusChange, OpTypingStart, OpTypingStop:
+		return true
+	}
+	return false
+}
+
 func (h *Hub) handleIdentify(client *Client, msg *Message) {
 	if client.IsIdentified() {
 		client.SendError(4003, "already identified")
@@ -63,27 +107,40 @@ func (h *Hub) handleIdentify(client *Client, msg *Message) {
 	}
 
 	if payload.Token == "" {
-		client.Send(&Message{Op: OpInvalidSession})
+		h.failIdentify(client)
 		return
 	}
 
 	// validate token
 	userIDStr, err := authhelper.GetUserIDFromToken(payload.Token)
 	if err != nil || userIDStr == "" {
-		client.Send(&Message{Op: OpInvalidSession})
+		go tokenreuse.Report(payload.Token, client.ip)
+		h.failIdentify(client)
 		return
 	}
 
 	userID, err := uuid.FromString(userIDStr)
 	if err != nil {
-		client.Send(&Message{Op: OpInvalidSession})
+		h.failIdentify(client)
 		return
 	}
 
 	// fetch user
 	var user database.User
 	if err := database.DB.Where("id = ?", userID).First(&user).Error; err != nil {
-		client.Send(&Message{Op: OpInvalidSession})
+		h.failIdentify(client)
+		return
+	}
+
+	// disabled accounts cant connect
+	if user.DisabledAt != nil {
+		h.failIdentify(client)
+		return
+	}
+
+	// terms have to be accepted over rest first
+	if !consent.UpToDate(&user) {
+		client.SendError(4028, "terms must be accepted")
 		return
 	}
 
@@ -92,7 +149,15 @@ func (h *Hub) handleIdentify(client *Client, msg *Message) {
 		Username:      user.Username,
 		Domain:        user.Domain,
 		Email:         user.Email,
-		ProfilePicURL: user.ProfilePicURL,
+		ProfilePicURL: imageproxy.URL(user.ProfilePicURL),
+		Bot:           user.IsBot,
+	}
+
+	// bots get a single connection, the newest one wins
+	if user.IsBot {
+		for _, previous := range h.GetUserClients(userID) {
+			previous.Close(CloseSessionReplaced, "replaced by a newer connection")
+		}
 	}
 
 	// register and subscribe
@@ -107,137 +172,172 @@ func (h *Hub) handleIdentify(client *Client, msg *Message) {
 	if status == "" {
 		status = "online"
 	}
+	client.SetStatus(status)
+
+	// an active status schedule overrides the saved status
+	h.loadScheduledStatus(userID)
+	status = h.EffectiveStatus(userID, status)
+
+	// checked before presence is set, so only the first connection counts
+	cameOnline := !h.presence.IsOnline(userID)
 
 	h.presence.SetOnline(userID, status, nil)
 
-	// load all relevant users with presence
-	users := h.loadRelevantUsers(userID)
+	// friends and dm participants up front, server members follow in READY_SUPPLEMENTAL
+	directIDs := h.directUserIDs(userID)
+	users := h.loadUsersWithPresence(directIDs)
+
+	ready := ReadyPayload{
+		User:      *userBrief,
+		SessionID: client.sessionID,
+		Users:     users,
+		Status:    status,
+
+		Supplemental: true,
+	}
+	if state := maintenance.Current(); state.Enabled {
+		ready.Maintenance = &MaintenancePayload{Enabled: true, Message: state.Message}
+	}
 
 	client.Send(&Message{
-		Op: OpReady,
-		Data: ReadyPayload{
-			User:      *userBrief,
-			SessionID: client.sessionID,
-			Users:     users,
-			Status:    status,
-		},
+		Op:   OpReady,
+		Data: ready,
 	})
 
+	go h.streamReadySupplemental(client, directIDs)
+
 	go h.broadcastPresenceChange(userID, status, &types.Activity{})
 
+	// invisible users stay quiet
+	if cameOnline && status != "offline" {
+		go h.notifyFriendOnline(userBrief)
+	}
+
 	log.Printf("[ws] user identified: %s (%s)", user.Username, userID)
 }
 
-// loadRelevantUsers gathers all users the client needs to know about:
-// friends, conversation participants, server members
-func (h *Hub) loadRelevantUsers(userID uuid.UUID) []UserWithPresence {
-	userMap := make(map[uuid.UUID]database.User)
-
-	// get friends
-	var friendships []database.Friendship
-	database.DB.Where("user1_id = ? OR user2_id = ?", userID, userID).Find(&friendships)
+// failIdentify ends a connection whose token didnt authenticate. the invalid session op stays for older
+// clients, the close code tells them not to reconnect with the same token
+func (h *Hub) failIdentify(client *Client) {
+	client.Send(&Message{Op: OpInvalidSession})
+	client.Close(CloseAuthFailed, "authentication failed")
+}
 
-	var friendIDs []uuid.UUID
-	for _, f := range friendships {
-		if f.User1ID == userID {
-			friendIDs = append(friendIDs, f.User2ID)
-		} else {
-			friendIDs = append(friendIDs, f.User1ID)
-		}
+// handleIdentifyExtra adds another account to the connection so clients can switch without reconnecting.
+// it goes through the regular identify flow, with everything for it tagged with its user id
+func (h *Hub) handleIdentifyExtra(client *Client, msg *Message) {
+	if client.parent != nil {
+		client.SendError(4003, "accounts can only be added on the first identity")
+		return
 	}
 
-	// get conversation participants
-	var myParticipations []database.DMParticipant
-	database.DB.Where("user_id = ?", userID).Find(&myParticipations)
-
-	var convIDs []uuid.UUID
-	for _, p := range myParticipations {
-		convIDs = append(convIDs, p.ConversationID)
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		client.SendError(4000, "invalid payload")
+		return
 	}
 
-	var otherParticipants []database.DMParticipant
-	if len(convIDs) > 0 {
-		database.DB.Where("conversation_id IN ? AND user_id != ?", convIDs, userID).Find(&otherParticipants)
+	var payload IdentifyPayload
+	if err := json.Unmarshal(data, &payload); err != nil || payload.Token == "" {
+		client.SendError(4000, "invalid payload")
+		return
 	}
 
-	var participantIDs []uuid.UUID
-	for _, p := range otherParticipants {
-		participantIDs = append(participantIDs, p.UserID)
+	userIDStr, err := authhelper.GetUserIDFromToken(payload.Token)
+	if err != nil || userIDStr == "" {
+		go tokenreuse.Report(payload.Token, client.ip)
+		client.SendError(4004, "invalid token")
+		return
 	}
 
-	// get server members
-	var myMemberships []database.ServerMember
-	database.DB.Where("user_id = ?", userID).Find(&myMemberships)
-
-	var serverIDs []uuid.UUID
-	for _, m := range myMemberships {
-		serverIDs = append(serverIDs, m.ServerID)
+	userID, err := uuid.FromString(userIDStr)
+	if err != nil {
+		client.SendError(4004, "invalid token")
+		return
 	}
 
-	var otherMembers []database.ServerMember
-	if len(serverIDs) > 0 {
-		database.DB.Where("server_id IN ? AND user_id != ?", serverIDs, userID).Find(&otherMembers)
+	if userID == client.userID || client.Identity(userID) != nil {
+		client.SendError(4003, "already identified")
+		return
 	}
 
-	var memberIDs []uuid.UUID
-	for _, m := range otherMembers {
-		memberIDs = append(memberIDs, m.UserID)
+	if client.IdentityCount()+1 >= maxIdentities {
+		client.SendError(4005, "too many accounts on this connection")
+		return
 	}
 
-	// combine all unique user IDs
-	allIDs := make(map[uuid.UUID]bool)
-	for _, id := range friendIDs {
-		allIDs[id] = true
+	identity := newIdentityClient(client, userID)
+	h.register <- identity
+
+	h.handleIdentify(identity, msg)
+
+	if !identity.IsIdentified() {
+		h.unregister <- identity
+		return
 	}
-	for _, id := range participantIDs {
-		allIDs[id] = true
+	client.AddIdentity(identity)
+}
+
+// handleRemoveIdentity drops an account added with OpIdentifyExtra, the connection stays open
+func (h *Hub) handleRemoveIdentity(client *Client, msg *Message) {
+	if client.parent != nil {
+		client.SendError(4003, "accounts can only be removed on the first identity")
+		return
 	}
-	for _, id := range memberIDs {
-		allIDs[id] = true
+
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		client.SendError(4000, "invalid payload")
+		return
 	}
 
-	if len(allIDs) == 0 {
-		return []UserWithPresence{}
+	var payload RemoveIdentityPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		client.SendError(4000, "invalid payload")
+		return
 	}
 
-	// fetch all users
-	var uniqueIDs []uuid.UUID
-	for id := range allIDs {
-		uniqueIDs = append(uniqueIDs, id)
+	identity := client.RemoveIdentity(payload.UserID)
+	if identity == nil {
+		client.SendError(4004, "unknown identity")
+		return
 	}
 
-	var users []database.User
-	database.DB.Where("id IN ?", uniqueIDs).Find(&users)
+	identity.Send(&Message{Op: OpInvalidSession})
+	h.unregister <- identity
+}
 
-	for _, u := range users {
-		userMap[u.ID] = u
+// shadowRecipients returns who may receive a dm from a restricted author,
+// nil when the author isnt restricted or every participant is a friend
+func (h *Hub) shadowRecipients(authorID, convID uuid.UUID) map[uuid.UUID]bool {
+	if !restriction.IsRestricted(authorID) {
+		return nil
 	}
 
-	// get presence for all users
-	presences := h.presence.GetMultiplePresences(uniqueIDs)
+	friends, err := restriction.FriendIDs(authorID)
+	if err != nil {
+		friends = map[uuid.UUID]bool{}
+	}
+	friends[authorID] = true
 
-	// build result
-	result := make([]UserWithPresence, 0, len(userMap))
-	for id, u := range userMap {
-		uwp := UserWithPresence{
-			ID:            u.ID,
-			Username:      u.Username,
-			Domain:        u.Domain,
-			ProfilePicURL: u.ProfilePicURL,
-		}
-		if p, ok := presences[id]; ok {
-			uwp.Presence = p
+	var participantIDs []uuid.UUID
+	database.DB.Model(&database.DMParticipant{}).
+		Where("conversation_id = ?", convID).
+		Pluck("user_id", &participantIDs)
+
+	for _, id := range participantIDs {
+		if !friends[id] {
+			return friends
 		}
-		result = append(result, uwp)
 	}
-
-	return result
+	return nil
 }
 
 func (h *Hub) handleHeartbeat(client *Client, msg *Message) {
 	// refresh presence TTL to keep user online
 	if client.IsIdentified() {
 		h.presence.RefreshPresence(client.userID)
+		stats.RecordActive(client.userID.String())
 	}
 
 	client.Send(&Message{
@@ -265,6 +365,12 @@ func (h *Hub) handleFocusChange(client *Client, msg *Message) {
 		return
 	}
 
+	// focused channels get full messages
+	if payload.ChannelID != nil && !agegate.CanReadChannel(client.userID, *payload.ChannelID) {
+		client.SendError(4003, agegate.ErrorMessage)
+		return
+	}
+
 	client.SetFocus(payload.ChannelID, payload.ServerID, payload.ConversationID)
 	// send akcnowledgement back
 	client.SendAck(msg.Nonce, map[string]any{
@@ -274,6 +380,48 @@ func (h *Hub) handleFocusChange(client *Client, msg *Message) {
 	})
 }
 
+// handlePresenceQuery answers with the presence of the requested users the client may see
+func (h *Hub) handlePresenceQuery(client *Client, msg *Message) {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		client.SendError(4000, "invalid payload")
+		return
+	}
+
+	var payload PresenceQueryPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		client.SendError(4000, "invalid payload")
+		return
+	}
+
+	if len(payload.UserIDs) == 0 || len(payload.UserIDs) > maxPresenceQuery {
+		client.SendError(4000, "user_ids must have between 1 and 200 entries")
+		return
+	}
+
+	visible, showActivity := h.visibleUserIDs(client.userID, payload.UserIDs)
+	presences := h.presence.GetMultiplePresences(visible)
+
+	batch := PresenceBatchPayload{Presences: make([]PresenceUpdatePayload, 0, len(visible))}
+	for _, id := range visible {
+		entry := PresenceUpdatePayload{UserID: id, Status: "offline"}
+		if p, ok := presences[id]; ok {
+			entry.Status = p.Status
+			if showActivity[id] {
+				entry.Activity = p.Activity
+			}
+		}
+		batch.Presences = append(batch.Presences, entry)
+	}
+
+	client.Send(&Message{
+		Op:    OpDispatch,
+		Event: EventPresenceBatch,
+		Nonce: msg.Nonce,
+		Data:  batch,
+	})
+}
+
 func (h *Hub) handlePresenceUpdate(client *Client, msg *Message) {
 	data, err := json.Marshal(msg.Data)
 	if err != nil {
@@ -291,17 +439,27 @@ func (h *Hub) handlePresenceUpdate(client *Client, msg *Message) {
 		return
 	}
 
+	sanitized, err := activity.Sanitize(payload.Activity)
+	if err != nil {
+		client.SendError(4000, "invalid activity")
+		return
+	}
+	payload.Activity = sanitized
+
 	client.SetStatus(payload.Status)
 	client.SetActivity(payload.Activity)
 
-	h.presence.SetOnline(client.userID, payload.Status, payload.Activity)
+	// during a scheduled window the schedule wins, the requested status applies once it ends
+	status := h.EffectiveStatus(client.userID, payload.Status)
+
+	h.presence.SetOnline(client.userID, status, payload.Activity)
 
 	// persist status to database (not activity, that's session-based)
 	go func() {
 		database.DB.Model(&database.User{}).Where("id = ?", client.userID).Update("status", payload.Status)
 	}()
 
-	go h.broadcastPresenceChange(client.userID, payload.Status, payload.Activity)
+	go h.broadcastPresenceChange(client.userID, status, payload.Activity)
 }
 
 func (h *Hub) handleTypingStart(client *Client, msg *Message) {
@@ -318,6 +476,11 @@ func (h *Hub) handleTypingStart(client *Client, msg *Message) {
 	payload.UserID = client.userID
 	payload.User = client.user
 
+	// dropped here so a client ignoring the setting cant leak it
+	if typingHidden(client.userID, payload.ConversationID) {
+		return
+	}
+
 	if payload.ChannelID != nil && payload.ServerID != nil {
 		if !client.IsInServer(*payload.ServerID) {
 			return
@@ -331,6 +494,23 @@ func (h *Hub) handleTypingStart(client *Client, msg *Message) {
 	}
 }
 
+// typingHidden reports whether the user turned typing indicators off, everywhere or for the conversation
+func typingHidden(userID uuid.UUID, convID *uuid.UUID) bool {
+	if settings, err := usersettings.Get(userID); err == nil && settings.HideTyping {
+		return true
+	}
+
+	if convID == nil {
+		return false
+	}
+
+	var hidden int64
+	database.DB.Model(&database.DMParticipant{}).
+		Where("conversation_id = ? AND user_id = ? AND hide_typing = ?", *convID, userID, true).
+		Count(&hidden)
+	return hidden > 0
+}
+
 func (h *Hub) handleTypingStop(client *Client, msg *Message) {
 	data, err := json.Marshal(msg.Data)
 	if err != nil {
@@ -392,6 +572,11 @@ func (h *Hub) handleChannelMessageCreate(client *Client, msg *Message, data []by
 		return
 	}
 
+	if quota := instance.CheckMessageLength(payload.Content, false); quota != nil {
+		client.SendQuotaError(quota)
+		return
+	}
+
 	var channel database.Channel
 	if err := database.DB.Where("id = ? AND server_id = ?", payload.ChannelID, payload.ServerID).First(&channel).Error; err != nil {
 		client.SendError(4004, "channel not found")
@@ -406,25 +591,33 @@ func (h *Hub) handleChannelMessageCreate(client *Client, msg *Message, data []by
 		ReplyToID:   payload.ReplyToID,
 	}
 
-	if err := database.DB.Create(&dbMsg).Error; err != nil {
+	// without the permission @everyone / @here stays plain text
+	if mentions.Parse(payload.Content).Mass() {
+		dbMsg.MentionEveryone = permissions.MemberHas(payload.ServerID, client.userID, permissions.MentionEveryone)
+	}
+
+	if err := msgcount.CreateChannelMessage(&dbMsg); err != nil {
 		client.SendError(5000, "failed to create message")
 		return
 	}
 
 	responsePayload := ChannelMessagePayload{
-		ID:        dbMsg.ID,
-		ChannelID: dbMsg.ChannelID,
-		ServerID:  payload.ServerID,
-		AuthorID:  dbMsg.AuthorID,
-		Author:    client.user,
-		Content:   dbMsg.Content,
-		ReplyToID: dbMsg.ReplyToID,
-		CreatedAt: dbMsg.CreatedAt,
+		ID:              dbMsg.ID,
+		ChannelID:       dbMsg.ChannelID,
+		ServerID:        payload.ServerID,
+		AuthorID:        dbMsg.AuthorID,
+		Author:          client.user,
+		Content:         dbMsg.Content,
+		ReplyToID:       dbMsg.ReplyToID,
+		CreatedAt:       dbMsg.CreatedAt,
+		MentionEveryone: dbMsg.MentionEveryone,
 	}
 
 	// focus-aware dispatch
 	h.DispatchChannelMessage(payload.ServerID, payload.ChannelID, responsePayload)
 
+	go contentsignal.CheckChannelMessage(payload.ServerID, channel.ID, dbMsg.ID, client.userID, dbMsg.Content)
+
 	if msg.Nonce != "" {
 		client.SendAck(msg.Nonce, map[string]any{"id": dbMsg.ID})
 	}
@@ -442,15 +635,39 @@ func (h *Hub) handleDMMessageCreate(client *Client, msg *Message, data []byte) {
 		return
 	}
 
+	if !CanPostInConversation(payload.ConversationID, client.userID) {
+		client.SendError(4003, "only the owner can post in this conversation")
+		return
+	}
+
+	// ciphertext is stored as is, it just has to name the device whose sender key encrypted it
+	if payload.Encrypted && payload.SenderDeviceID == "" {
+		client.SendError(4000, "encrypted messages need a sender device")
+		return
+	}
+
+	if quota := instance.CheckMessageLength(payload.Content, payload.Encrypted); quota != nil {
+		client.SendQuotaError(quota)
+		return
+	}
+
 	dbMsg := database.DirectMessage{
 		ConversationID: payload.ConversationID,
 		AuthorID:       client.userID,
 		Content:        payload.Content,
 		Attachments:    "[]",
 		ReplyToID:      payload.ReplyToID,
+		Encrypted:      payload.Encrypted,
+	}
+	if payload.Encrypted {
+		dbMsg.SenderDeviceID = payload.SenderDeviceID
 	}
 
-	if err := database.DB.Create(&dbMsg).Error; err != nil {
+	// restricted authors only reach their friends, everyone else never sees the message
+	recipients := h.shadowRecipients(client.userID, payload.ConversationID)
+	dbMsg.Shadowed = recipients != nil
+
+	if err := msgcount.CreateDirectMessage(&dbMsg); err != nil {
 		client.SendError(5000, "failed to create message")
 		return
 	}
@@ -463,10 +680,14 @@ func (h *Hub) handleDMMessageCreate(client *Client, msg *Message, data []byte) {
 		Content:        dbMsg.Content,
 		ReplyToID:      dbMsg.ReplyToID,
 		CreatedAt:      dbMsg.CreatedAt,
+		Encrypted:      dbMsg.Encrypted,
+		SenderDeviceID: dbMsg.SenderDeviceID,
 	}
 
 	// focus-aware dispatch
-	h.DispatchDMMessage(payload.ConversationID, responsePayload)
+	h.DispatchDMMessageToUsers(payload.ConversationID, responsePayload, recipients)
+
+	go contentsignal.CheckDirectMessage(payload.ConversationID, dbMsg.ID, client.userID, dbMsg.Content, dbMsg.Encrypted)
 
 	database.DB.Model(&database.DMParticipant{}).
 		Where("conversation_id = ? AND user_id = ?", payload.ConversationID, client.userID).
@@ -510,8 +731,14 @@ func (h *Hub) handleMessageEdit(client *Client, msg *Message) {
 			return
 		}
 
+		if quota := instance.CheckMessageLength(content, false); quota != nil {
+			client.SendQuotaError(quota)
+			return
+		}
+
 		result := database.DB.Model(&database.ChannelMessage{}).
 			Where("id = ? AND channel_id = ? AND author_id = ?", messageID, channelID, client.userID).
+			Where("source_message_id IS NULL"). // mirrored posts only change at the source
 			Updates(map[string]any{"content": content, "edited_at": now})
 
 		if result.RowsAffected == 0 {
@@ -527,6 +754,7 @@ func (h *Hub) handleMessageEdit(client *Client, msg *Message) {
 			Content:   content,
 			EditedAt:  &now,
 		})
+		h.stopEditing(client.userID, EditingPayload{MessageID: messageID, ChannelID: &channelID, ServerID: &serverID, UserID: client.userID, User: client.user})
 		return
 	}
 
@@ -538,8 +766,16 @@ func (h *Hub) handleMessageEdit(client *Client, msg *Message) {
 			return
 		}
 
+		// ciphertext gets more room, so the flag has to match the stored message
+		encrypted, _ := raw["encrypted"].(bool)
+		if quota := instance.CheckMessageLength(content, encrypted); quota != nil {
+			client.SendQuotaError(quota)
+			return
+		}
+
 		result := database.DB.Model(&database.DirectMessage{}).
 			Where("id = ? AND conversation_id = ? AND author_id = ?", messageID, convID, client.userID).
+			Where("encrypted = ?", encrypted).
 			Updates(map[string]any{"content": content, "edited_at": now})
 
 		if result.RowsAffected == 0 {
@@ -547,13 +783,14 @@ func (h *Hub) handleMessageEdit(client *Client, msg *Message) {
 			return
 		}
 
-		h.DispatchToConversation(convID, EventDMMessageUpdate, DMMessagePayload{
+		h.DispatchToConversationUsers(convID, EventDMMessageUpdate, h.shadowRecipients(client.userID, convID), DMMessagePayload{
 			ID:             messageID,
 			ConversationID: convID,
 			AuthorID:       client.userID,
 			Content:        content,
 			EditedAt:       &now,
 		})
+		h.stopEditing(client.userID, EditingPayload{MessageID: messageID, ConversationID: &convID, UserID: client.userID, User: client.user})
 	}
 }
 
@@ -584,6 +821,7 @@ func (h *Hub) handleMessageDelete(client *Client, msg *Message) {
 			client.SendError(4004, "message not found or not authorized")
 			return
 		}
+		msgcount.Deleted(*payload.ChannelID, result.RowsAffected)
 
 		h.DispatchToServer(*payload.ServerID, EventChannelMessageDelete, payload)
 
@@ -601,8 +839,9 @@ func (h *Hub) handleMessageDelete(client *Client, msg *Message) {
 			client.SendError(4004, "message not found or not authorized")
 			return
 		}
+		msgcount.Deleted(*payload.ConversationID, result.RowsAffected)
 
-		h.DispatchToConversation(*payload.ConversationID, EventDMMessageDelete, payload)
+		h.DispatchToConversationUsers(*payload.ConversationID, EventDMMessageDelete, h.shadowRecipients(client.userID, *payload.ConversationID), payload)
 	}
 }
 
@@ -627,16 +866,92 @@ func (h *Hub) handleMessageAck(client *Client, msg *Message) {
 		database.DB.Model(&database.DMParticipant{}).
 			Where("conversation_id = ? AND user_id = ?", payload.ConversationID, client.userID).
 			Updates(map[string]any{"last_read_at": now})
+		inbox.MarkConversationRead(client.userID, *payload.ConversationID)
 
-		h.DispatchToConversation(*payload.ConversationID, EventMessageAck, map[string]any{
+		ack := map[string]any{
 			"user_id":         client.userID,
 			"conversation_id": payload.ConversationID,
 			"message_id":      payload.MessageID,
 			"read_at":         now,
+		}
+
+		// with read receipts off only the users own devices hear about it
+		if settings, err := usersettings.Get(client.userID); err == nil && settings.HideReadReceipts {
+			h.DispatchToUser(client.userID, EventMessageAck, ack)
+			return
+		}
+
+		h.DispatchToConversation(*payload.ConversationID, EventMessageAck, ack)
+		return
+	}
+
+	if payload.ChannelID != nil {
+		var channel database.Channel
+		if err := database.DB.Select("id, server_id").Where("id = ?", payload.ChannelID).First(&channel).Error; err != nil {
+			return
+		}
+		if !client.IsInServer(channel.ServerID) {
+			return
+		}
+
+		state := database.ChannelReadState{UserID: client.userID, ChannelID: channel.ID, LastReadAt: now}
+		err := database.DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "channel_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"last_read_at", "updated_at"}),
+		}).Create(&state).Error
+		if err != nil {
+			return
+		}
+
+		// channel reads are private, only the users other devices follow along
+		h.DispatchToUser(client.userID, EventMessageAck, map[string]any{
+			"user_id":    client.userID,
+			"server_id":  channel.ServerID,
+			"channel_id": channel.ID,
+			"message_id": payload.MessageID,
+			"read_at":    now,
 		})
 	}
 }
 
+// handleDeliveryAck moves the participants delivered mark forward, it never goes back
+// when acks for older messages arrive late
+func (h *Hub) handleDeliveryAck(client *Client, msg *Message) {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return
+	}
+
+	var payload MessageAckPayload
+	if err := json.Unmarshal(data, &payload); err != nil || payload.ConversationID == nil {
+		return
+	}
+
+	if !client.IsInConversation(*payload.ConversationID) {
+		return
+	}
+
+	var message database.DirectMessage
+	if err := database.DB.Select("id, created_at").Where("id = ? AND conversation_id = ?", payload.MessageID, payload.ConversationID).First(&message).Error; err != nil {
+		return
+	}
+
+	result := database.DB.Model(&database.DMParticipant{}).
+		Where("conversation_id = ? AND user_id = ?", payload.ConversationID, client.userID).
+		Where("last_delivered_at IS NULL OR last_delivered_at < ?", message.CreatedAt).
+		Update("last_delivered_at", message.CreatedAt)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	h.DispatchToConversation(*payload.ConversationID, EventMessageDelivered, map[string]any{
+		"user_id":         client.userID,
+		"conversation_id": payload.ConversationID,
+		"message_id":      payload.MessageID,
+		"delivered_at":    message.CreatedAt,
+	})
+}
+
 // external helpers for rest api usage
 
 func NotifyChannelMessage(serverID, channelID uuid.UUID, payload ChannelMessagePayload) {
@@ -645,12 +960,265 @@ func NotifyChannelMessage(serverID, channelID uuid.UUID, payload ChannelMessageP
 	}
 }
 
+// PublishChannelMessage dispatches an already stored channel message that wasnt sent over the gateway,
+// e.g by bots answering interactions or webhooks
+func PublishChannelMessage(serverID uuid.UUID, msg *database.ChannelMessage, author *UserBrief) {
+	NotifyChannelMessage(serverID, msg.ChannelID, storedMessagePayload(serverID, msg, author))
+}
+
+// PublishFollowedMessage is PublishChannelMessage for posts mirrored from a followed announcement channel
+func PublishFollowedMessage(serverID uuid.UUID, msg *database.ChannelMessage, author *UserBrief, followed FollowInfo) {
+	payload := storedMessagePayload(serverID, msg, author)
+	payload.Followed = &followed
+	NotifyChannelMessage(serverID, msg.ChannelID, payload)
+}
+
+func storedMessagePayload(serverID uuid.UUID, msg *database.ChannelMessage, author *UserBrief) ChannelMessagePayload {
+	payload := ChannelMessagePayload{
+		ID:            msg.ID,
+		ChannelID:     msg.ChannelID,
+		ServerID:      serverID,
+		AuthorID:      msg.AuthorID,
+		Author:        author,
+		Content:       msg.Content,
+		ReplyToID:     msg.ReplyToID,
+		CreatedAt:     msg.CreatedAt,
+		InteractionID: msg.InteractionID,
+		WebhookID:     msg.WebhookID,
+
+		MentionEveryone: msg.MentionEveryone,
+	}
+
+	if msg.Embeds != nil {
+		json.Unmarshal([]byte(*msg.Embeds), &payload.Embeds)
+		imageproxy.ProxyEmbeds(payload.Embeds)
+	}
+
+	if msg.RemoteID != nil {
+		payload.Bridge = &BridgeInfo{Protocol: msg.RemoteProtocol, RemoteID: *msg.RemoteID, PuppetID: msg.PuppetID}
+	}
+
+	// the stored author of webhook posts is whoever created the webhook, that stays private
+	if msg.WebhookID != nil {
+		payload.AuthorID = *msg.WebhookID
+	}
+
+	return payload
+}
+
+// WebhookAuthor is who a webhook post shows up as: the webhook under the name and avatar it posted with.
+// messages from before those were stored fall back to the webhook's current ones when Webhook is preloaded.
+// nil for messages that werent posted by a webhook
+func WebhookAuthor(msg *database.ChannelMessage) *UserBrief {
+	if msg.WebhookID == nil {
+		return nil
+	}
+
+	name, avatar := msg.WebhookName, msg.WebhookAvatar
+	if name == "" && msg.Webhook != nil {
+		name, avatar = msg.Webhook.Name, msg.Webhook.Avatar
+	}
+
+	return &UserBrief{
+		ID:            *msg.WebhookID,
+		Username:      name,
+		ProfilePicURL: imageproxy.URL(avatar),
+		Bot:           true,
+	}
+}
+
+// StoredChannelMessage is the payload of a stored message with its Author preloaded, for reading history over
+// rest. deleted messages come out as tombstones
+func StoredChannelMessage(serverID uuid.UUID, msg *database.ChannelMessage) ChannelMessagePayload {
+	if msg.DeletedAt.Valid {
+		return ChannelMessagePayload{
+			ID:        msg.ID,
+			ChannelID: msg.ChannelID,
+			ServerID:  serverID,
+			AuthorID:  msg.AuthorID,
+			CreatedAt: msg.CreatedAt,
+			Deleted:   true,
+		}
+	}
+
+	author := WebhookAuthor(msg)
+	if author == nil {
+		author = &UserBrief{
+			ID:            msg.Author.ID,
+			Username:      msg.Author.Username,
+			Domain:        msg.Author.Domain,
+			ProfilePicURL: imageproxy.URL(msg.Author.ProfilePicURL),
+			Bot:           msg.Author.IsBot,
+		}
+	}
+
+	payload := storedMessagePayload(serverID, msg, author)
+	payload.EditedAt = msg.EditedAt
+	if msg.Attachments != "" {
+		json.Unmarshal([]byte(msg.Attachments), &payload.Attachments)
+	}
+
+	return payload
+}
+
+func NotifyChannelMessageUpdate(serverID uuid.UUID, payload ChannelMessagePayload) {
+	if hub != nil {
+		hub.DispatchToServer(serverID, EventChannelMessageUpdate, payload)
+	}
+}
+
+func NotifyChannelMessageDelete(serverID uuid.UUID, payload MessageDeletePayload) {
+	if hub != nil {
+		hub.DispatchToServer(serverID, EventChannelMessageDelete, payload)
+	}
+}
+
 func NotifyDMMessage(convID uuid.UUID, payload DMMessagePayload) {
 	if hub != nil {
 		hub.DispatchDMMessage(convID, payload)
 	}
 }
 
+// SendDirectMessage stores a dm composed over rest and dispatches it like one sent over the gateway,
+// shadowing it when the author is restricted
+// CanPostInConversation reports whether the participant may post, everyone may unless the group is announcement only
+func CanPostInConversation(convID, userID uuid.UUID) bool {
+	var conv database.DMConversation
+	if err := database.DB.Select("id", "owner_id", "announcement_only").Where("id = ?", convID).First(&conv).Error; err != nil {
+		return false
+	}
+	return !conv.AnnouncementOnly || (conv.OwnerID != nil && *conv.OwnerID == userID)
+}
+
+func SendDirectMessage(msg *database.DirectMessage, author *UserBrief) error {
+	var recipients map[uuid.UUID]bool
+	if hub != nil {
+		recipients = hub.shadowRecipients(msg.AuthorID, msg.ConversationID)
+	}
+	msg.Shadowed = recipients != nil
+
+	if err := msgcount.CreateDirectMessage(msg); err != nil {
+		return err
+	}
+
+	payload := DMMessagePayload{
+		ID:             msg.ID,
+		ConversationID: msg.ConversationID,
+		AuthorID:       msg.AuthorID,
+		Author:         author,
+		Content:        msg.Content,
+		ReplyToID:      msg.ReplyToID,
+		CreatedAt:      msg.CreatedAt,
+	}
+	if msg.Embeds != nil {
+		json.Unmarshal([]byte(*msg.Embeds), &payload.Embeds)
+		imageproxy.ProxyEmbeds(payload.Embeds)
+	}
+
+	if hub != nil {
+		hub.DispatchDMMessageToUsers(msg.ConversationID, payload, recipients)
+	}
+
+	go contentsignal.CheckDirectMessage(msg.ConversationID, msg.ID, msg.AuthorID, msg.Content, msg.Encrypted)
+
+	database.DB.Model(&database.DMParticipant{}).
+		Where("conversation_id = ? AND user_id = ?", msg.ConversationID, msg.AuthorID).
+		Updates(map[string]any{"last_read_at": time.Now()})
+
+	return nil
+}
+
+// SetUserActivity sets the activity of every connected client of the user, e.g reported by a desktop
+// client over rest. returns false when the user has no connected client
+func SetUserActivity(userID uuid.UUID, activity *types.Activity) bool {
+	if hub == nil {
+		return false
+	}
+
+	clients := hub.GetUserClients(userID)
+	if len(clients) == 0 {
+		return false
+	}
+
+	status := "online"
+	for _, client := range clients {
+		client.SetActivity(activity)
+		if s := client.Status(); s != "" {
+			status = s
+		}
+	}
+	status = hub.EffectiveStatus(userID, status)
+
+	hub.presence.SetOnline(userID, status, activity)
+	go hub.broadcastPresenceChange(userID, status, activity)
+
+	return true
+}
+
+// Shutdown closes every connection with CloseServerShutdown so clients reconnect to another instance
+func Shutdown() {
+	if hub == nil {
+		return
+	}
+
+	hub.mu.RLock()
+	clients := make([]*Client, 0, len(hub.clients))
+	for client := range hub.clients {
+		if client.parent == nil {
+			clients = append(clients, client)
+		}
+	}
+	hub.mu.RUnlock()
+
+	for _, client := range clients {
+		client.Close(CloseServerShutdown, "server shutting down")
+	}
+}
+
+// RebroadcastPresence sends the users current presence again, e.g after who may see their activity changed
+func RebroadcastPresence(userID uuid.UUID) {
+	if hub == nil {
+		return
+	}
+
+	presence, err := hub.presence.GetPresence(userID)
+	if err != nil || presence == nil {
+		return
+	}
+	go hub.broadcastPresenceChange(userID, presence.Status, presence.Activity)
+}
+
+// RefreshStatusSchedule re-evaluates the users status schedule after it changed
+func RefreshStatusSchedule(userID uuid.UUID) {
+	if hub != nil {
+		hub.RefreshScheduledStatus(userID)
+	}
+}
+
+// NotifyDeviceKeysUpdate tells everyone sharing a dm with the user, and their other devices, that a device key changed
+func NotifyDeviceKeysUpdate(userID uuid.UUID, payload DeviceKeysUpdatePayload) {
+	if hub == nil {
+		return
+	}
+
+	var convIDs []uuid.UUID
+	database.DB.Model(&database.DMParticipant{}).Where("user_id = ?", userID).Pluck("conversation_id", &convIDs)
+	for _, convID := range convIDs {
+		hub.DispatchToConversation(convID, EventDeviceKeysUpdate, payload)
+	}
+
+	// their own clients are only reached through conversations when they have any
+	if len(convIDs) == 0 {
+		hub.DispatchToUser(userID, EventDeviceKeysUpdate, payload)
+	}
+}
+
+func NotifySenderKeyDistribution(recipientID uuid.UUID, payload SenderKeyDistributionPayload) {
+	if hub != nil {
+		hub.DispatchToUser(recipientID, EventSenderKeyDistribution, payload)
+	}
+}
+
 func NotifyUserUpdate(userID uuid.UUID, fields map[string]any) {
 	if hub != nil {
 		hub.DispatchToUser(userID, EventUserUpdate, map[string]any{
@@ -660,6 +1228,22 @@ func NotifyUserUpdate(userID uuid.UUID, fields map[string]any) {
 	}
 }
 
+// BroadcastUserUpdate sends public profile changes to everyone who can see the user
+func BroadcastUserUpdate(userID uuid.UUID, fields map[string]any) {
+	if hub != nil {
+		hub.dispatchToUserAudience(userID, EventUserUpdate, map[string]any{
+			"user_id": userID,
+			"fields":  fields,
+		})
+	}
+}
+
+func DisconnectUser(userID uuid.UUID, reason string) {
+	if hub != nil {
+		hub.DisconnectUser(userID, CloseAuthFailed, reason)
+	}
+}
+
 func NotifyServerMemberJoin(serverID uuid.UUID, user UserBrief) {
 	if hub != nil {
 		hub.DispatchToServer(serverID, EventServerMemberAdd, map[string]any{
@@ -669,11 +1253,43 @@ func NotifyServerMemberJoin(serverID uuid.UUID, user UserBrief) {
 	}
 }
 
+// NotifyServerCreate subscribes the online members to a server they now belong to, new or joined, and hands
+// them its summary
+func NotifyServerCreate(server ServerSummary, memberIDs []uuid.UUID) {
+	if hub == nil {
+		return
+	}
+	for _, userID := range memberIDs {
+		for _, client := range hub.GetUserClients(userID) {
+			hub.SubscribeToServer(client, server.ID)
+		}
+		hub.DispatchToUser(userID, EventServerCreate, server)
+	}
+}
+
+// NotifyServerMemberLeave tells the server a member is gone, whether they left, were kicked or banned,
+// and cuts the users clients off from the servers events
 func NotifyServerMemberLeave(serverID uuid.UUID, userID uuid.UUID) {
 	if hub != nil {
+		// sent before revoking so the user hears about it too
 		hub.DispatchToServer(serverID, EventServerMemberRemove, map[string]any{
 			"server_id": serverID,
 			"user_id":   userID,
 		})
+		hub.RevokeServer(userID, serverID)
+	}
+}
+
+// NotifyConversationLeave is NotifyServerMemberLeave for conversations
+func NotifyConversationLeave(convID uuid.UUID, userID uuid.UUID, data map[string]any) {
+	if hub != nil {
+		hub.DispatchToConversation(convID, EventDMParticipantLeft, data)
+		hub.RevokeConversation(userID, convID)
+	}
+}
+
+func NotifyMaintenance(enabled bool, message string) {
+	if hub != nil {
+		hub.DispatchToAll(EventMaintenance, MaintenancePayload{Enabled: enabled, Message: message})
 	}
 }
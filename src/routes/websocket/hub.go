@@ -3,22 +3,34 @@ package websocket
 import (
 	"log"
 	"sync"
+	"time"
 
 	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/hiddenconv"
+	"github.com/hindsightchat/backend/src/lib/inbox"
+	"github.com/hindsightchat/backend/src/lib/mentions"
+	"github.com/hindsightchat/backend/src/lib/notifyprefs"
 	"github.com/hindsightchat/backend/src/types"
 	uuid "github.com/satori/go.uuid"
 )
 
 type Hub struct {
-	clients             map[*Client]bool
-	userClients         map[uuid.UUID]map[*Client]bool
-	serverClients       map[uuid.UUID]map[*Client]bool
-	conversationClients map[uuid.UUID]map[*Client]bool
+	// every connection, guarded by mu
+	clients map[*Client]bool
+
+	// identified clients by target, each index has its own sharded locks
+	userClients         *clientIndex
+	serverClients       *clientIndex
+	conversationClients *clientIndex
+
+	// status overrides from active status schedules
+	scheduled map[uuid.UUID]string
 
 	register   chan *Client
 	unregister chan *Client
 
 	presence *PresenceManager
+	fanout   *fanoutPool
 	mu       sync.RWMutex
 }
 
@@ -31,12 +43,14 @@ func GetHub() *Hub {
 func NewHub() *Hub {
 	h := &Hub{
 		clients:             make(map[*Client]bool),
-		userClients:         make(map[uuid.UUID]map[*Client]bool),
-		serverClients:       make(map[uuid.UUID]map[*Client]bool),
-		conversationClients: make(map[uuid.UUID]map[*Client]bool),
+		userClients:         newClientIndex(),
+		serverClients:       newClientIndex(),
+		conversationClients: newClientIndex(),
+		scheduled:           make(map[uuid.UUID]string),
 		register:            make(chan *Client),
 		unregister:          make(chan *Client),
 		presence:            NewPresenceManager(),
+		fanout:              newFanoutPool(),
 	}
 	hub = h
 	return h
@@ -71,136 +85,98 @@ func (h *Hub) handleUnregister(client *Client) {
 
 	delete(h.clients, client)
 
-	if client.identified {
-		if clients, ok := h.userClients[client.userID]; ok {
-			delete(clients, client)
-			if len(clients) == 0 {
-				delete(h.userClients, client.userID)
-				go h.presence.SetOffline(client.userID)
-				go h.broadcastPresenceChange(client.userID, "offline", nil)
-			}
+	if client.IsIdentified() {
+		if h.userClients.remove(client.userID, client) {
+			delete(h.scheduled, client.userID)
+			go database.DB.Model(&database.User{}).Where("id = ?", client.userID).Update("last_seen_at", time.Now())
+			go h.presence.SetOffline(client.userID)
+			go h.broadcastPresenceChange(client.userID, "offline", nil)
 		}
 
-		for serverID := range client.servers {
-			if clients, ok := h.serverClients[serverID]; ok {
-				delete(clients, client)
-				if len(clients) == 0 {
-					delete(h.serverClients, serverID)
-				}
-			}
+		for _, serverID := range client.GetServerIDs() {
+			h.serverClients.remove(serverID, client)
 		}
 
-		for convID := range client.conversations {
-			if clients, ok := h.conversationClients[convID]; ok {
-				delete(clients, client)
-				if len(clients) == 0 {
-					delete(h.conversationClients, convID)
-				}
-			}
+		for _, convID := range client.GetConversationIDs() {
+			h.conversationClients.remove(convID, client)
 		}
 	}
 
-	close(client.send)
+	// extra accounts share the send buffer of their connection
+	if client.parent == nil {
+		client.mu.Lock()
+		client.closed = true
+		close(client.send)
+		client.mu.Unlock()
+	}
 	log.Printf("[ws] client disconnected: session=%s user=%s", client.sessionID, client.userID)
 }
 
 // registers client after successful auth
 func (h *Hub) RegisterIdentifiedClient(client *Client, userID uuid.UUID, user *UserBrief) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
+	client.mu.Lock()
 	client.userID = userID
 	client.user = user
 	client.identified = true
+	client.mu.Unlock()
 
-	if h.userClients[userID] == nil {
-		h.userClients[userID] = make(map[*Client]bool)
-	}
-	h.userClients[userID][client] = true
+	h.userClients.add(userID, client)
 }
 
 // subscription management
 func (h *Hub) SubscribeToServer(client *Client, serverID uuid.UUID) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
 	client.SubscribeServer(serverID)
-
-	if h.serverClients[serverID] == nil {
-		h.serverClients[serverID] = make(map[*Client]bool)
-	}
-	h.serverClients[serverID][client] = true
+	h.serverClients.add(serverID, client)
 }
 
 func (h *Hub) UnsubscribeFromServer(client *Client, serverID uuid.UUID) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
 	client.UnsubscribeServer(serverID)
-
-	if clients, ok := h.serverClients[serverID]; ok {
-		delete(clients, client)
-		if len(clients) == 0 {
-			delete(h.serverClients, serverID)
-		}
-	}
+	h.serverClients.remove(serverID, client)
 }
 
 func (h *Hub) SubscribeToConversation(client *Client, convID uuid.UUID) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
 	client.SubscribeConversation(convID)
-
-	if h.conversationClients[convID] == nil {
-		h.conversationClients[convID] = make(map[*Client]bool)
-	}
-	h.conversationClients[convID][client] = true
+	h.conversationClients.add(convID, client)
 }
 
 func (h *Hub) UnsubscribeFromConversation(client *Client, convID uuid.UUID) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
 	client.UnsubscribeConversation(convID)
+	h.conversationClients.remove(convID, client)
+}
 
-	if clients, ok := h.conversationClients[convID]; ok {
-		delete(clients, client)
-		if len(clients) == 0 {
-			delete(h.conversationClients, convID)
-		}
+// RevokeServer drops every client of the user from the server at once, for kick, ban and leave flows.
+// they stop getting its events right away, their focus on it is cleared too
+func (h *Hub) RevokeServer(userID, serverID uuid.UUID) {
+	for _, client := range h.userClients.get(userID) {
+		h.UnsubscribeFromServer(client, serverID)
+		client.clearServerFocus(serverID)
+	}
+}
+
+// RevokeConversation is RevokeServer for conversations, message and typing events stop reaching the
+// user as soon as they leave or are removed
+func (h *Hub) RevokeConversation(userID, convID uuid.UUID) {
+	for _, client := range h.userClients.get(userID) {
+		h.UnsubscribeFromConversation(client, convID)
+		client.clearConversationFocus(convID)
 	}
 }
 
 // send methods
 func (h *Hub) SendToUser(userID uuid.UUID, msg *Message) {
-	h.mu.RLock()
-	clients := h.userClients[userID]
-	h.mu.RUnlock()
+	clients := h.userClients.get(userID)
 
-	for client := range clients {
+	for _, client := range clients {
 		client.Send(msg)
 	}
 }
 
 func (h *Hub) SendToServer(serverID uuid.UUID, msg *Message) {
-	h.mu.RLock()
-	clients := h.serverClients[serverID]
-	h.mu.RUnlock()
-
-	for client := range clients {
-		client.Send(msg)
-	}
+	h.sendToAudience(serverID, h.serverClients.get(serverID), msg)
 }
 
 func (h *Hub) SendToConversation(convID uuid.UUID, msg *Message) {
-	h.mu.RLock()
-	clients := h.conversationClients[convID]
-	h.mu.RUnlock()
-
-	for client := range clients {
-		client.Send(msg)
-	}
+	h.sendToAudience(convID, h.conversationClients.get(convID), msg)
 }
 
 // dispatch helpers
@@ -216,11 +192,35 @@ func (h *Hub) DispatchToConversation(convID uuid.UUID, event EventType, data any
 	h.SendToConversation(convID, &Message{Op: OpDispatch, Event: event, Data: data})
 }
 
+// DispatchToUserOnce is DispatchToUser where each client gets the event for an idempotency key once,
+// so flows that overlap (e.g accepting crossing friend requests) dont hand out duplicates
+func (h *Hub) DispatchToUserOnce(userID uuid.UUID, event EventType, key string, data any) {
+	for _, client := range h.userClients.get(userID) {
+		if client.firstDispatch(event, key) {
+			client.SendDispatch(event, data)
+		}
+	}
+}
+
+// DispatchToConversationUsers dispatches only to the given users, nil means everyone in the conversation
+func (h *Hub) DispatchToConversationUsers(convID uuid.UUID, event EventType, recipients map[uuid.UUID]bool, data any) {
+	if recipients == nil {
+		h.DispatchToConversation(convID, event, data)
+		return
+	}
+
+	clients := h.conversationClients.get(convID)
+
+	for _, client := range clients {
+		if recipients[client.userID] {
+			client.SendDispatch(event, data)
+		}
+	}
+}
+
 // focus-aware dispatch for channel messages
 func (h *Hub) DispatchChannelMessage(serverID, channelID uuid.UUID, fullPayload ChannelMessagePayload) {
-	h.mu.RLock()
-	clients := h.serverClients[serverID]
-	h.mu.RUnlock()
+	clients := h.serverClients.get(serverID)
 
 	notifyPayload := ChannelMessageNotifyPayload{
 		ChannelID: channelID,
@@ -229,20 +229,63 @@ func (h *Hub) DispatchChannelMessage(serverID, channelID uuid.UUID, fullPayload
 		AuthorID:  fullPayload.AuthorID,
 	}
 
-	for client := range clients {
-		if client.IsFocusedOnChannel(channelID) {
-			client.SendDispatch(EventChannelMessageCreate, fullPayload)
-		} else {
-			client.SendDispatch(EventChannelMessageNotify, notifyPayload)
+	// notify dispatches respect per server notification settings, the author always gets theirs
+	unfocused := make([]uuid.UUID, 0, len(clients))
+	for _, client := range clients {
+		if !client.IsFocusedOnChannel(channelID) && client.userID != fullPayload.AuthorID {
+			unfocused = append(unfocused, client.userID)
 		}
 	}
+	settings := notifyprefs.ForServer(serverID, unfocused)
+	mentioned := mentions.Parse(fullPayload.Content)
+	if !fullPayload.MentionEveryone {
+		mentioned.Everyone, mentioned.Here = false, false
+	}
+
+	fullPayload.MentionRoles = mentions.ResolveRoles(serverID, &mentioned)
+
+	// mentions delivered in full dont hand out nsfw content to clients that couldnt focus the channel
+	inFull := mentioned.Mass() || len(mentioned.RoleUsers) > 0
+	if inFull {
+		var nsfw bool
+		database.DB.Model(&database.Channel{}).Where("id = ?", channelID).Select("nsfw").Scan(&nsfw)
+		inFull = !nsfw
+	}
+
+	var full, notify []*Client
+	for _, client := range clients {
+		setting, ok := settings[client.userID]
+		switch {
+		case client.IsFocusedOnChannel(channelID):
+			full = append(full, client)
+		case !ok:
+			notify = append(notify, client)
+		case inFull && mentioned.Mass() && setting.AcceptsMass():
+			// mass mentions reach everyone in full, not just as an unread hint
+			full = append(full, client)
+		case inFull && mentioned.RoleUsers[client.userID] && setting.Allows(client.userID, mentioned):
+			// and role mentions the members of the role
+			full = append(full, client)
+		case setting.Allows(client.userID, mentioned):
+			notify = append(notify, client)
+		}
+	}
+
+	h.sendToAudience(serverID, full, &Message{Op: OpDispatch, Event: EventChannelMessageCreate, Data: fullPayload})
+	h.sendToAudience(serverID, notify, &Message{Op: OpDispatch, Event: EventChannelMessageNotify, Data: notifyPayload})
+
+	go h.pushChannelMessage(serverID, fullPayload, mentioned)
+	go inbox.RecordChannelMessage(serverID, channelID, fullPayload.ID, fullPayload.AuthorID, fullPayload.ReplyToID, mentioned)
 }
 
 // focus-aware dispatch for dm messages
 func (h *Hub) DispatchDMMessage(convID uuid.UUID, fullPayload DMMessagePayload) {
-	h.mu.RLock()
-	clients := h.conversationClients[convID]
-	h.mu.RUnlock()
+	h.DispatchDMMessageToUsers(convID, fullPayload, nil)
+}
+
+// DispatchDMMessageToUsers is DispatchDMMessage limited to the given users, nil means everyone in the conversation
+func (h *Hub) DispatchDMMessageToUsers(convID uuid.UUID, fullPayload DMMessagePayload, recipients map[uuid.UUID]bool) {
+	clients := h.conversationClients.get(convID)
 
 	notifyPayload := DMMessageNotifyPayload{
 		ConversationID: convID,
@@ -250,72 +293,94 @@ func (h *Hub) DispatchDMMessage(convID uuid.UUID, fullPayload DMMessagePayload)
 		AuthorID:       fullPayload.AuthorID,
 	}
 
-	for client := range clients {
+	// participants who hid the conversation don't get notified about it
+	var hidden map[uuid.UUID]bool
+	if len(clients) > 0 {
+		hidden = hiddenconv.HiddenBy(convID)
+	}
+
+	for _, client := range clients {
+		if recipients != nil && !recipients[client.userID] {
+			continue
+		}
 		if client.IsFocusedOnConversation(convID) {
 			client.SendDispatch(EventDMMessageCreate, fullPayload)
-		} else {
+		} else if !hidden[client.userID] {
 			client.SendDispatch(EventDMMessageNotify, notifyPayload)
 		}
 	}
+
+	// the server can't read mentions in ciphertext, replies still count
+	var mentioned mentions.Mentions
+	if !fullPayload.Encrypted {
+		mentioned = mentions.Parse(fullPayload.Content)
+	}
+	go inbox.RecordDirectMessage(convID, fullPayload.ID, fullPayload.AuthorID, fullPayload.ReplyToID, mentioned, recipients)
 }
 
-// focus-aware dispatch for typing events (only sends to focused clients)
-func (h *Hub) DispatchTypingToConversation(convID uuid.UUID, event EventType, payload TypingPayload) {
-	h.mu.RLock()
-	clients := h.conversationClients[convID]
-	h.mu.RUnlock()
+// focus-aware dispatch for typing and editing events (only sends to focused clients)
+func (h *Hub) DispatchTypingToConversation(convID uuid.UUID, event EventType, payload any) {
+	clients := h.conversationClients.get(convID)
 
-	for client := range clients {
+	for _, client := range clients {
 		if client.IsFocusedOnConversation(convID) {
 			client.SendDispatch(event, payload)
 		}
 	}
 }
 
-func (h *Hub) DispatchTypingToChannel(serverID, channelID uuid.UUID, event EventType, payload TypingPayload) {
-	h.mu.RLock()
-	clients := h.serverClients[serverID]
-	h.mu.RUnlock()
+func (h *Hub) DispatchTypingToChannel(serverID, channelID uuid.UUID, event EventType, payload any) {
+	clients := h.serverClients.get(serverID)
 
-	for client := range clients {
+	for _, client := range clients {
 		if client.IsFocusedOnChannel(channelID) {
 			client.SendDispatch(event, payload)
 		}
 	}
 }
 
-// query methods
-func (h *Hub) GetOnlineUsers() []uuid.UUID {
+// DispatchToAll dispatches to every identified client on this instance
+func (h *Hub) DispatchToAll(event EventType, data any) {
 	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	users := make([]uuid.UUID, 0, len(h.userClients))
-	for userID, clients := range h.userClients {
-		if len(clients) > 0 {
-			users = append(users, userID)
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		if client.IsIdentified() {
+			clients = append(clients, client)
 		}
 	}
-	return users
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		client.SendDispatch(event, data)
+	}
+}
+
+// query methods
+func (h *Hub) GetOnlineUsers() []uuid.UUID {
+	return h.userClients.keys()
 }
 
 func (h *Hub) IsUserOnline(userID uuid.UUID) bool {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	return h.userClients.has(userID)
+}
 
-	clients, ok := h.userClients[userID]
-	return ok && len(clients) > 0
+// ConnectionCount returns open connections and distinct identified users on this instance
+func (h *Hub) ConnectionCount() (connections int, users int) {
+	h.mu.RLock()
+	connections = len(h.clients)
+	h.mu.RUnlock()
+	return connections, h.userClients.len()
 }
 
 func (h *Hub) GetUserClients(userID uuid.UUID) []*Client {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	return h.userClients.get(userID)
+}
 
-	clientMap := h.userClients[userID]
-	clients := make([]*Client, 0, len(clientMap))
-	for client := range clientMap {
-		clients = append(clients, client)
+// DisconnectUser closes every connection belonging to the user (e.g. forced logout)
+func (h *Hub) DisconnectUser(userID uuid.UUID, code int, reason string) {
+	for _, client := range h.GetUserClients(userID) {
+		client.Close(code, reason)
 	}
-	return clients
 }
 
 // internal helpers
@@ -332,15 +397,53 @@ func (h *Hub) broadcastPresenceChange(userID uuid.UUID, status string, activity
 	var memberships []database.ServerMember
 	database.DB.Where("user_id = ?", userID).Find(&memberships)
 
-	for _, m := range memberships {
-		h.DispatchToServer(m.ServerID, EventPresenceUpdate, payload)
-	}
-
 	var participants []database.DMParticipant
 	database.DB.Where("user_id = ?", userID).Find(&participants)
 
+	// each client gets one update, with the activity unless it only shares servers that hide it
+	full := make(map[*Client]bool)
+	stripped := make(map[*Client]bool)
+
+	h.userClients.collect([]uuid.UUID{userID}, full)
 	for _, p := range participants {
-		h.DispatchToConversation(p.ConversationID, EventPresenceUpdate, payload)
+		h.conversationClients.collect([]uuid.UUID{p.ConversationID}, full)
+	}
+	for _, m := range memberships {
+		if m.HideActivity {
+			h.serverClients.collect([]uuid.UUID{m.ServerID}, stripped)
+		} else {
+			h.serverClients.collect([]uuid.UUID{m.ServerID}, full)
+		}
+	}
+
+	for client := range full {
+		client.SendDispatch(EventPresenceUpdate, payload)
+	}
+
+	hidden := payload
+	hidden.Activity = nil
+	for client := range stripped {
+		if !full[client] {
+			client.SendDispatch(EventPresenceUpdate, hidden)
+		}
+	}
+}
+
+// dispatchToUserAudience sends an event once to every client that shares a server or conversation
+// with the user, and to the users own clients
+func (h *Hub) dispatchToUserAudience(userID uuid.UUID, event EventType, data any) {
+	var serverIDs, convIDs []uuid.UUID
+	database.DB.Model(&database.ServerMember{}).Where("user_id = ?", userID).Pluck("server_id", &serverIDs)
+	database.DB.Model(&database.DMParticipant{}).Where("user_id = ?", userID).Pluck("conversation_id", &convIDs)
+
+	recipients := make(map[*Client]bool)
+
+	h.userClients.collect([]uuid.UUID{userID}, recipients)
+	h.serverClients.collect(serverIDs, recipients)
+	h.conversationClients.collect(convIDs, recipients)
+
+	for client := range recipients {
+		client.SendDispatch(event, data)
 	}
 }
 
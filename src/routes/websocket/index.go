@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"sync"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// number of independently locked shards per index
+const indexShards = 64
+
+// clientIndex maps user, server or conversation ids to their clients. ids are spread over shards
+// with their own locks so dispatches to different targets dont wait on each other or on connects
+type clientIndex struct {
+	shards [indexShards]indexShard
+}
+
+type indexShard struct {
+	mu      sync.RWMutex
+	clients map[uuid.UUID]map[*Client]bool
+}
+
+func newClientIndex() *clientIndex {
+	ix := &clientIndex{}
+	for i := range ix.shards {
+		ix.shards[i].clients = make(map[uuid.UUID]map[*Client]bool)
+	}
+	return ix
+}
+
+// hashID is fnv-1a over the whole id, v1 uuids share their trailing bytes
+func hashID(id uuid.UUID) uint32 {
+	hash := uint32(2166136261)
+	for _, b := range id {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	return hash
+}
+
+func (ix *clientIndex) shard(id uuid.UUID) *indexShard {
+	return &ix.shards[hashID(id)%indexShards]
+}
+
+func (ix *clientIndex) add(id uuid.UUID, client *Client) {
+	s := ix.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.clients[id] == nil {
+		s.clients[id] = make(map[*Client]bool)
+	}
+	s.clients[id][client] = true
+}
+
+// remove drops the client and reports whether it was the last one for the id
+func (ix *clientIndex) remove(id uuid.UUID, client *Client) bool {
+	s := ix.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clients, ok := s.clients[id]
+	if !ok {
+		return false
+	}
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(s.clients, id)
+		return true
+	}
+	return false
+}
+
+// get returns a copy of the clients for the id, safe to use after the shard is unlocked
+func (ix *clientIndex) get(id uuid.UUID) []*Client {
+	s := ix.shard(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(s.clients[id]))
+	for client := range s.clients[id] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+func (ix *clientIndex) has(id uuid.UUID) bool {
+	s := ix.shard(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients[id]) > 0
+}
+
+// collect adds the clients of every id to set, deduplicating clients shared between ids
+func (ix *clientIndex) collect(ids []uuid.UUID, set map[*Client]bool) {
+	for _, id := range ids {
+		s := ix.shard(id)
+		s.mu.RLock()
+		for client := range s.clients[id] {
+			set[client] = true
+		}
+		s.mu.RUnlock()
+	}
+}
+
+func (ix *clientIndex) keys() []uuid.UUID {
+	ids := make([]uuid.UUID, 0)
+	for i := range ix.shards {
+		s := &ix.shards[i]
+		s.mu.RLock()
+		for id := range s.clients {
+			ids = append(ids, id)
+		}
+		s.mu.RUnlock()
+	}
+	return ids
+}
+
+func (ix *clientIndex) len() int {
+	n := 0
+	for i := range ix.shards {
+		s := &ix.shards[i]
+		s.mu.RLock()
+		n += len(s.clients)
+		s.mu.RUnlock()
+	}
+	return n
+}
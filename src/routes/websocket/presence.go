@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/hindsightchat/backend/src/lib/activityhistory"
+	responsecache "github.com/hindsightchat/backend/src/lib/cache/response"
 	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
 	"github.com/hindsightchat/backend/src/types"
 	uuid "github.com/satori/go.uuid"
@@ -43,13 +45,26 @@ func (p *PresenceManager) SetOnline(userID uuid.UUID, status string, activity *t
 		return err
 	}
 
-	return rdb.Set(ctx, p.key(userID), jsonData, presenceTTL).Err()
+	if err := rdb.Set(ctx, p.key(userID), jsonData, presenceTTL).Err(); err != nil {
+		return err
+	}
+	activityhistory.Track(userID, activity)
+
+	// GET /users/{id} includes presence
+	responsecache.Invalidate(ctx, responsecache.UserKey(userID.String()))
+	return nil
 }
 
 func (p *PresenceManager) SetOffline(userID uuid.UUID) error {
 	ctx := context.Background()
 	rdb := valkeydb.GetValkeyClient()
-	return rdb.Del(ctx, p.key(userID)).Err()
+	if err := rdb.Del(ctx, p.key(userID)).Err(); err != nil {
+		return err
+	}
+	activityhistory.Track(userID, nil)
+
+	responsecache.Invalidate(ctx, responsecache.UserKey(userID.String()))
+	return nil
 }
 
 func (p *PresenceManager) GetPresence(userID uuid.UUID) (*PresenceData, error) {
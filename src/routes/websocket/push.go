@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/mentions"
+	"github.com/hindsightchat/backend/src/lib/notifyprefs"
+	"github.com/hindsightchat/backend/src/lib/push"
+	uuid "github.com/satori/go.uuid"
+)
+
+// push notification bodies are cut to this
+const maxPushBody = 200
+
+// pushChannelMessage sends push notifications to server members without a connected client
+func (h *Hub) pushChannelMessage(serverID uuid.UUID, payload ChannelMessagePayload, mentioned mentions.Mentions) {
+	if !push.Enabled() {
+		return
+	}
+
+	var memberIDs []uuid.UUID
+	if err := database.DB.Model(&database.ServerMember{}).Where("server_id = ? AND user_id != ?", serverID, payload.AuthorID).Pluck("user_id", &memberIDs).Error; err != nil {
+		return
+	}
+
+	offline := make([]uuid.UUID, 0, len(memberIDs))
+	for _, userID := range memberIDs {
+		if !h.IsUserOnline(userID) {
+			offline = append(offline, userID)
+		}
+	}
+
+	// @here is only for members who are around
+	mentioned.Here = false
+
+	settings := notifyprefs.ForServer(serverID, offline)
+	recipients := make([]uuid.UUID, 0, len(offline))
+	for _, userID := range offline {
+		if settings[userID].Allows(userID, mentioned) {
+			recipients = append(recipients, userID)
+		}
+	}
+
+	title := "New message"
+	if payload.Author != nil {
+		title = payload.Author.Username
+	}
+
+	body := payload.Content
+	if len(body) > maxPushBody {
+		body = body[:maxPushBody-3] + "..."
+	}
+
+	push.Send(recipients, push.Notification{
+		Title: title,
+		Body:  body,
+		Data: map[string]string{
+			"server_id":  serverID.String(),
+			"channel_id": payload.ChannelID.String(),
+			"message_id": payload.ID.String(),
+		},
+	})
+}
@@ -0,0 +1,212 @@
+package websocket
+
+import (
+	"log"
+
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	"github.com/hindsightchat/backend/src/lib/imageproxy"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// servers or users per READY_SUPPLEMENTAL event, also the size of the IN lists used to load them
+	readyBatchSize = 500
+
+	// user ids per OpPresenceQuery
+	maxPresenceQuery = 200
+)
+
+// directUserIDs returns friends and everyone the user shares a dm with
+func (h *Hub) directUserIDs(userID uuid.UUID) []uuid.UUID {
+	var friendIDs, participantIDs []uuid.UUID
+
+	database.DB.Model(&database.Friendship{}).Where("user1_id = ?", userID).Pluck("user2_id", &friendIDs)
+
+	var reverse []uuid.UUID
+	database.DB.Model(&database.Friendship{}).Where("user2_id = ?", userID).Pluck("user1_id", &reverse)
+	friendIDs = append(friendIDs, reverse...)
+
+	database.DB.Model(&database.DMParticipant{}).
+		Distinct("user_id").
+		Where("conversation_id IN (?)", database.DB.Model(&database.DMParticipant{}).Select("conversation_id").Where("user_id = ?", userID)).
+		Where("user_id <> ?", userID).
+		Pluck("user_id", &participantIDs)
+
+	seen := make(map[uuid.UUID]bool, len(friendIDs)+len(participantIDs))
+	ids := make([]uuid.UUID, 0, len(friendIDs)+len(participantIDs))
+	for _, id := range append(friendIDs, participantIDs...) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// loadUsersWithPresence loads the users and their presence, batchwise so large accounts dont build huge queries
+func (h *Hub) loadUsersWithPresence(userIDs []uuid.UUID) []UserWithPresence {
+	result := make([]UserWithPresence, 0, len(userIDs))
+
+	for start := 0; start < len(userIDs); start += readyBatchSize {
+		batch := userIDs[start:min(start+readyBatchSize, len(userIDs))]
+
+		var users []database.User
+		database.DB.Where("id IN ?", batch).Find(&users)
+
+		presences := h.presence.GetMultiplePresences(batch)
+
+		for _, u := range users {
+			result = append(result, UserWithPresence{
+				ID:            u.ID,
+				Username:      u.Username,
+				Domain:        u.Domain,
+				ProfilePicURL: imageproxy.URL(u.ProfilePicURL),
+				Presence:      presences[u.ID],
+			})
+		}
+	}
+
+	return result
+}
+
+// streamReadySupplemental sends the servers of the user and then their members in batches after READY.
+// it stops early when the client goes away
+func (h *Hub) streamReadySupplemental(client *Client, sent []uuid.UUID) {
+	userID := client.UserID()
+
+	var serverIDs []uuid.UUID
+	if err := database.DB.Model(&database.ServerMember{}).Where("user_id = ?", userID).Pluck("server_id", &serverIDs).Error; err != nil {
+		log.Printf("[ws] failed to load servers for ready: %v", err)
+	}
+
+	for start := 0; start < len(serverIDs); start += readyBatchSize {
+		if !h.isConnected(client) {
+			return
+		}
+		batch := serverIDs[start:min(start+readyBatchSize, len(serverIDs))]
+
+		var servers []database.Server
+		database.DB.Where("id IN ?", batch).Find(&servers)
+
+		summaries := make([]ServerSummary, 0, len(servers))
+		for _, s := range servers {
+			summaries = append(summaries, ServerSummary{
+				ID:          s.ID,
+				Name:        s.Name,
+				Icon:        s.Icon,
+				MemberCount: s.MemberCount,
+			})
+		}
+
+		client.SendDispatch(EventReadySupplemental, ReadySupplementalPayload{Servers: summaries})
+	}
+
+	// members of those servers not already in READY
+	var memberIDs []uuid.UUID
+	if len(serverIDs) > 0 {
+		database.DB.Model(&database.ServerMember{}).
+			Distinct("user_id").
+			Where("server_id IN (?)", database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", userID)).
+			Where("user_id <> ?", userID).
+			Pluck("user_id", &memberIDs)
+	}
+
+	known := make(map[uuid.UUID]bool, len(sent))
+	for _, id := range sent {
+		known[id] = true
+	}
+	pending := make([]uuid.UUID, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		if !known[id] {
+			pending = append(pending, id)
+		}
+	}
+
+	for start := 0; start < len(pending); start += readyBatchSize {
+		if !h.isConnected(client) {
+			return
+		}
+		batch := pending[start:min(start+readyBatchSize, len(pending))]
+
+		// only shared through servers, so activity depends on their per server setting
+		users := h.loadUsersWithPresence(batch)
+		showActivity := h.activityVisibleInServers(userID, batch)
+		for i := range users {
+			if users[i].Presence != nil && !showActivity[users[i].ID] {
+				presence := *users[i].Presence
+				presence.Activity = nil
+				users[i].Presence = &presence
+			}
+		}
+
+		client.SendDispatch(EventReadySupplemental, ReadySupplementalPayload{Users: users})
+	}
+
+	client.SendDispatch(EventReadySupplemental, ReadySupplementalPayload{Done: true})
+}
+
+// activityVisibleInServers returns which users show their activity in at least one server they share with the viewer
+func (h *Hub) activityVisibleInServers(viewerID uuid.UUID, userIDs []uuid.UUID) map[uuid.UUID]bool {
+	var ids []uuid.UUID
+	database.DB.Model(&database.ServerMember{}).
+		Distinct("user_id").
+		Where("user_id IN ? AND hide_activity = ?", userIDs, false).
+		Where("server_id IN (?)", database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", viewerID)).
+		Pluck("user_id", &ids)
+
+	result := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		result[id] = true
+	}
+	return result
+}
+
+// visibleUserIDs narrows ids down to the user, their friends, dm participants and server co-members.
+// showActivity is false for users only shared through servers where they hide their activity
+func (h *Hub) visibleUserIDs(userID uuid.UUID, ids []uuid.UUID) (visible []uuid.UUID, showActivity map[uuid.UUID]bool) {
+	var friends, participants, members []uuid.UUID
+
+	database.DB.Model(&database.Friendship{}).Where("user1_id = ? AND user2_id IN ?", userID, ids).Pluck("user2_id", &friends)
+
+	var reverse []uuid.UUID
+	database.DB.Model(&database.Friendship{}).Where("user2_id = ? AND user1_id IN ?", userID, ids).Pluck("user1_id", &reverse)
+	friends = append(friends, reverse...)
+
+	database.DB.Model(&database.DMParticipant{}).
+		Distinct("user_id").
+		Where("user_id IN ?", ids).
+		Where("conversation_id IN (?)", database.DB.Model(&database.DMParticipant{}).Select("conversation_id").Where("user_id = ?", userID)).
+		Pluck("user_id", &participants)
+
+	database.DB.Model(&database.ServerMember{}).
+		Distinct("user_id").
+		Where("user_id IN ?", ids).
+		Where("server_id IN (?)", database.DB.Model(&database.ServerMember{}).Select("server_id").Where("user_id = ?", userID)).
+		Pluck("user_id", &members)
+
+	showActivity = h.activityVisibleInServers(userID, members)
+	showActivity[userID] = true
+	for _, id := range append(friends, participants...) {
+		showActivity[id] = true
+	}
+
+	allowed := map[uuid.UUID]bool{userID: true}
+	for _, id := range append(append(friends, participants...), members...) {
+		allowed[id] = true
+	}
+
+	visible = make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if allowed[id] {
+			visible = append(visible, id)
+			delete(allowed, id) // once per id
+		}
+	}
+	return visible, showActivity
+}
+
+func (h *Hub) isConnected(client *Client) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.clients[client]
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
+	"github.com/hindsightchat/backend/src/lib/contentsignal"
+	"github.com/hindsightchat/backend/src/lib/ipban"
 )
 
 var upgrader = websocket.Upgrader{
@@ -20,6 +22,10 @@ var upgrader = websocket.Upgrader{
 func RegisterRoutes(r chi.Router) *Hub {
 	hub := NewHub()
 	go hub.Run()
+	hub.RunFanout()
+	go hub.RunStatusScheduler()
+	go hub.RunEventReminders()
+	contentsignal.Register(hub.notifyModerators)
 
 	r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		handleWebSocket(hub, w, r)
@@ -35,6 +41,12 @@ func RegisterRoutes(r chi.Router) *Hub {
 }
 
 func handleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	// refuse banned networks before upgrading
+	if banned, reason := ipban.IsRequestBanned(r); banned {
+		http.Error(w, ipban.RejectionMessage(reason), http.StatusForbidden)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("[ws] upgrade error: %v", err)
@@ -42,6 +54,7 @@ func handleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := NewClient(hub, conn)
+	client.ip = ipban.ClientIP(r)
 	hub.register <- client
 
 	go client.WritePump()
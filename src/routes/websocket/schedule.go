@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"github.com/hindsightchat/backend/src/lib/statusschedule"
+	"github.com/hindsightchat/backend/src/lib/usersettings"
+	uuid "github.com/satori/go.uuid"
+)
+
+// how often scheduled statuses are re-evaluated
+const statusScheduleInterval = 30 * time.Second
+
+// RunStatusScheduler applies scheduled status windows to connected users
+func (h *Hub) RunStatusScheduler() {
+	ticker := time.NewTicker(statusScheduleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.applyStatusSchedules()
+	}
+}
+
+func (h *Hub) applyStatusSchedules() {
+	online := h.GetOnlineUsers()
+	settings, err := usersettings.ForUsers(online)
+	if err != nil {
+		log.Printf("[ws] failed to load status schedules: %v", err)
+		return
+	}
+
+	now := time.Now()
+	wanted := make(map[uuid.UUID]string)
+	for userID, s := range settings {
+		if status, ok := statusschedule.Active(statusschedule.Parse(s.StatusSchedule), usersettings.Location(s), now); ok {
+			wanted[userID] = status
+		}
+	}
+
+	h.mu.Lock()
+	changed := make([]uuid.UUID, 0)
+	for userID, status := range wanted {
+		if h.scheduled[userID] != status {
+			h.scheduled[userID] = status
+			changed = append(changed, userID)
+		}
+	}
+	for userID := range h.scheduled {
+		if _, ok := wanted[userID]; !ok {
+			delete(h.scheduled, userID)
+			changed = append(changed, userID)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, userID := range changed {
+		h.republishStatus(userID)
+	}
+}
+
+// RefreshScheduledStatus evaluates one users schedule right away, e.g after they edited it
+func (h *Hub) RefreshScheduledStatus(userID uuid.UUID) {
+	if h.loadScheduledStatus(userID) {
+		h.republishStatus(userID)
+	}
+}
+
+// loadScheduledStatus evaluates the users schedule and reports whether the override changed
+func (h *Hub) loadScheduledStatus(userID uuid.UUID) bool {
+	s, err := usersettings.Get(userID)
+	if err != nil {
+		return false
+	}
+
+	status, active := statusschedule.Active(statusschedule.Parse(s.StatusSchedule), usersettings.Location(s), time.Now())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	previous, had := h.scheduled[userID]
+	if active {
+		h.scheduled[userID] = status
+	} else {
+		delete(h.scheduled, userID)
+	}
+
+	return had != active || previous != status
+}
+
+// EffectiveStatus is the scheduled status while a window is active, otherwise what the client asked for
+func (h *Hub) EffectiveStatus(userID uuid.UUID, requested string) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if status, ok := h.scheduled[userID]; ok {
+		return status
+	}
+	return requested
+}
+
+// republishStatus stores and broadcasts the users current effective status
+func (h *Hub) republishStatus(userID uuid.UUID) {
+	clients := h.GetUserClients(userID)
+	if len(clients) == 0 {
+		return
+	}
+
+	// clients of a user all share one status, any of them will do
+	client := clients[len(clients)-1]
+	status := h.EffectiveStatus(userID, client.Status())
+	activity := client.Activity()
+
+	h.presence.SetOnline(userID, status, activity)
+	go h.broadcastPresenceChange(userID, status, activity)
+}
@@ -16,6 +16,9 @@ const (
 	OpIdentify       OpCode = 2 // sent to identify/authenticate the client after connecting, contains auth token
 	OpPresenceUpdate OpCode = 3 // sent when user updates presence (status or activity)
 	OpFocusChange    OpCode = 4 // sent when user changes focus (e.g focuses a different channel, server or conversation, or unfocuses)
+	OpIdentifyExtra  OpCode = 5 // adds another account to an identified connection, contains its auth token - everything for it is then tagged with "u"
+	OpRemoveIdentity OpCode = 6 // drops an account added with OpIdentifyExtra, contains its user id
+	OpPresenceQuery  OpCode = 7 // asks for the presence of a list of user ids (e.g the visible part of a member list), answered with PRESENCE_BATCH
 
 	// server -> client
 	OpDispatch       OpCode = 0  // e.g for events
@@ -30,6 +33,19 @@ const (
 	OpMessageEdit   OpCode = 23 // sent when a message is edited in a channel or conversation
 	OpMessageDelete OpCode = 24 // sent when a message is deleted in a channel or conversation
 	OpMessageAck    OpCode = 25 // sent when a message is read by the client, contains message ID and channel/conversation ID
+	OpDeliveryAck   OpCode = 26 // sent when a dm reaches the client (not read yet), contains message ID and conversation ID
+	OpEditingStart  OpCode = 27 // sent while the user edits one of their messages, resend within expires_in to keep it up
+	OpEditingStop   OpCode = 28 // sent when the user closes the editor, the server also stops it after expires_in
+)
+
+// close codes the server ends a connection with, so clients know whether and when to reconnect.
+// errors that keep the connection open are sent as dispatches with their own codes instead
+const (
+	CloseInvalidPayload  = 4400 // a frame wasnt valid json, fix the client before reconnecting
+	CloseAuthFailed      = 4401 // token revoked, account disabled or logged out, dont reconnect with the same token
+	CloseSessionReplaced = 4409 // a newer connection took over (bots get one connection), dont reconnect automatically
+	CloseRateLimited     = 4429 // too many messages, reconnect after backing off
+	CloseServerShutdown  = 4503 // the instance is going down, reconnect right away
 )
 
 // event types for dispatch
@@ -52,22 +68,44 @@ const (
 	EventTypingStart EventType = "TYPING_START"
 	EventTypingStop  EventType = "TYPING_STOP"
 
+	// editing, like typing but for a specific message
+	EventEditingStart EventType = "EDITING_START"
+	EventEditingStop  EventType = "EDITING_STOP"
+
 	// presence
 	EventPresenceUpdate EventType = "PRESENCE_UPDATE"
+	EventPresenceBatch  EventType = "PRESENCE_BATCH" // answer to OpPresenceQuery, carries its nonce
 
 	// server events
-	EventServerUpdate       EventType = "SERVER_UPDATE"
-	EventServerMemberAdd    EventType = "SERVER_MEMBER_ADD"
-	EventServerMemberRemove EventType = "SERVER_MEMBER_REMOVE"
-	EventServerMemberUpdate EventType = "SERVER_MEMBER_UPDATE"
-	EventChannelCreate      EventType = "CHANNEL_CREATE"
-	EventChannelUpdate      EventType = "CHANNEL_UPDATE"
-	EventChannelDelete      EventType = "CHANNEL_DELETE"
+	EventServerCreate        EventType = "SERVER_CREATE" // to the members of a server that was just created, or to whoever just joined one
+	EventServerUpdate        EventType = "SERVER_UPDATE"
+	EventServerMemberAdd     EventType = "SERVER_MEMBER_ADD"
+	EventServerMemberRemove  EventType = "SERVER_MEMBER_REMOVE"
+	EventServerMemberUpdate  EventType = "SERVER_MEMBER_UPDATE"
+	EventServerMembersUpdate EventType = "SERVER_MEMBERS_UPDATE" // batched role changes of many members
+	EventServerRoleCreate    EventType = "SERVER_ROLE_CREATE"
+	EventServerRoleUpdate    EventType = "SERVER_ROLE_UPDATE"
+	EventServerRoleDelete    EventType = "SERVER_ROLE_DELETE"
+	EventServerRolesUpdate   EventType = "SERVER_ROLES_UPDATE" // every role with its position after a reorder
+	EventChannelCreate       EventType = "CHANNEL_CREATE"
+	EventChannelUpdate       EventType = "CHANNEL_UPDATE" // one channel, or server_id and every channel after a reorder
+	EventChannelDelete       EventType = "CHANNEL_DELETE"
+	EventCategoryCreate      EventType = "CHANNEL_CATEGORY_CREATE"
+	EventCategoryUpdate      EventType = "CHANNEL_CATEGORY_UPDATE" // one category, or server_id and every category after a reorder
+	EventCategoryDelete      EventType = "CHANNEL_CATEGORY_DELETE" // its channels are moved out of it first
+
+	// scheduled server events
+	EventScheduledEventCreate   EventType = "EVENT_CREATE"
+	EventScheduledEventUpdate   EventType = "EVENT_UPDATE"
+	EventScheduledEventDelete   EventType = "EVENT_DELETE"
+	EventScheduledEventReminder EventType = "EVENT_REMINDER" // to the rsvps shortly before the start
 
 	// dm events
 	EventDMCreate          EventType = "DM_CREATE"
 	EventDMParticipantAdd  EventType = "DM_PARTICIPANT_ADD"
 	EventDMParticipantLeft EventType = "DM_PARTICIPANT_LEFT"
+	EventDMUpgrade         EventType = "DM_UPGRADE" // the group was turned into a server
+	EventDMUpdate          EventType = "DM_UPDATE"  // the group was renamed or a participant became or stopped being admin
 
 	// user
 	EventUserUpdate EventType = "USER_UPDATE"
@@ -76,9 +114,30 @@ const (
 	EventFriendRequestCreate   EventType = "FRIEND_REQUEST_CREATE"
 	EventFriendRequestAccepted EventType = "FRIEND_REQUEST_ACCEPTED"
 	EventFriendRemove          EventType = "FRIEND_REMOVE"
+	EventFriendshipRestored    EventType = "FRIENDSHIP_RESTORED" // friends added back, carries the dm they kept
+	EventFriendOnline          EventType = "FRIEND_ONLINE"       // a friend you turned online notifications on for connected
 
 	// read state
-	EventMessageAck EventType = "MESSAGE_ACK"
+	EventMessageAck       EventType = "MESSAGE_ACK"
+	EventMessageDelivered EventType = "MESSAGE_DELIVERED"
+
+	// moderation
+	EventReportResolved EventType = "REPORT_RESOLVED"
+	EventContentSignal  EventType = "CONTENT_SIGNAL" // a message in a server you moderate matched a configured signal, ids only
+
+	// applications
+	EventInteractionCreate EventType = "INTERACTION_CREATE"       // sent to the bot when a user runs one of its commands
+	EventEphemeralMessage  EventType = "EPHEMERAL_MESSAGE_CREATE" // interaction reply only the invoking user can see
+
+	// instance
+	EventMaintenance EventType = "MAINTENANCE"
+
+	// rest of the initial state, streamed after READY
+	EventReadySupplemental EventType = "READY_SUPPLEMENTAL"
+
+	// encryption
+	EventDeviceKeysUpdate      EventType = "DEVICE_KEYS_UPDATE"      // a device of someone you share a dm with was added, changed or removed
+	EventSenderKeyDistribution EventType = "SENDER_KEY_DISTRIBUTION" // a sender key was shared with one of your devices
 )
 
 // base message structure
@@ -87,6 +146,9 @@ type Message struct {
 	Data  any       `json:"d,omitempty"`
 	Event EventType `json:"t,omitempty"`
 	Nonce string    `json:"nonce,omitempty"`
+
+	// which account the message is for on connections with several identities, unset means the first one
+	User *uuid.UUID `json:"u,omitempty"`
 }
 
 // payloads
@@ -95,11 +157,38 @@ type IdentifyPayload struct {
 	Token string `json:"token"`
 }
 
+type RemoveIdentityPayload struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
 type ReadyPayload struct {
-	User      UserBrief            `json:"user"`
-	SessionID string               `json:"session_id"`
-	Users     []UserWithPresence   `json:"users"`
-	Status    string               `json:"status"` // user's saved status preference
+	User        UserBrief           `json:"user"`
+	SessionID   string              `json:"session_id"`
+	Users       []UserWithPresence  `json:"users"`
+	Status      string              `json:"status"`                // user's saved status preference
+	Maintenance *MaintenancePayload `json:"maintenance,omitempty"` // set while the instance is read-only
+
+	// users only has friends and dm participants, servers and their members follow in READY_SUPPLEMENTAL
+	// events until one has done set
+	Supplemental bool `json:"supplemental"`
+}
+
+type ReadySupplementalPayload struct {
+	Servers []ServerSummary    `json:"servers,omitempty"`
+	Users   []UserWithPresence `json:"users,omitempty"`
+	Done    bool               `json:"done,omitempty"`
+}
+
+type ServerSummary struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Icon        string    `json:"icon,omitempty"`
+	MemberCount int64     `json:"member_count"`
+}
+
+type MaintenancePayload struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
 }
 
 type UserWithPresence struct {
@@ -126,6 +215,15 @@ type PresenceUpdatePayload struct {
 	Activity *types.Activity `json:"activity,omitempty"`
 }
 
+type PresenceQueryPayload struct {
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+// users you share nothing with are left out, users without presence are offline
+type PresenceBatchPayload struct {
+	Presences []PresenceUpdatePayload `json:"presences"`
+}
+
 type TypingPayload struct {
 	ChannelID      *uuid.UUID `json:"channel_id,omitempty"`
 	ServerID       *uuid.UUID `json:"server_id,omitempty"`
@@ -134,6 +232,16 @@ type TypingPayload struct {
 	User           *UserBrief `json:"user,omitempty"`
 }
 
+type EditingPayload struct {
+	MessageID      uuid.UUID  `json:"message_id"`
+	ChannelID      *uuid.UUID `json:"channel_id,omitempty"`
+	ServerID       *uuid.UUID `json:"server_id,omitempty"`
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+	UserID         uuid.UUID  `json:"user_id"`
+	User           *UserBrief `json:"user,omitempty"`
+	ExpiresIn      int        `json:"expires_in"` // seconds until the state ends unless refreshed
+}
+
 type ChannelMessagePayload struct {
 	ID          uuid.UUID  `json:"id"`
 	ChannelID   uuid.UUID  `json:"channel_id"`
@@ -145,6 +253,31 @@ type ChannelMessagePayload struct {
 	ReplyToID   *uuid.UUID `json:"reply_to_id,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 	EditedAt    *time.Time `json:"edited_at,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"` // tombstone in history, only the ids and created_at are kept
+
+	InteractionID *uuid.UUID    `json:"interaction_id,omitempty"`
+	WebhookID     *uuid.UUID    `json:"webhook_id,omitempty"`
+	Embeds        []types.Embed `json:"embeds,omitempty"`
+	Bridge        *BridgeInfo   `json:"bridge,omitempty"`
+	Followed      *FollowInfo   `json:"followed,omitempty"`
+
+	MentionEveryone bool        `json:"mention_everyone,omitempty"`
+	MentionRoles    []uuid.UUID `json:"mention_roles,omitempty"` // mentionable roles the message mentions
+}
+
+// BridgeInfo marks a message mirrored from another network, bridges use it to avoid echoing their own messages
+type BridgeInfo struct {
+	Protocol string     `json:"protocol"`
+	RemoteID string     `json:"remote_id"`
+	PuppetID *uuid.UUID `json:"puppet_id,omitempty"`
+}
+
+// FollowInfo marks a post mirrored from an announcement channel the channel follows
+type FollowInfo struct {
+	ServerID   uuid.UUID `json:"server_id"`
+	ServerName string    `json:"server_name"`
+	ChannelID  uuid.UUID `json:"channel_id"`
+	MessageID  uuid.UUID `json:"message_id"`
 }
 
 type DMMessagePayload struct {
@@ -157,6 +290,28 @@ type DMMessagePayload struct {
 	ReplyToID      *uuid.UUID `json:"reply_to_id,omitempty"`
 	CreatedAt      time.Time  `json:"created_at"`
 	EditedAt       *time.Time `json:"edited_at,omitempty"`
+
+	// cards attached by the server, e.g the one of a shared channel message
+	Embeds []types.Embed `json:"embeds,omitempty"`
+
+	// content is ciphertext for the conversation's sender keys
+	Encrypted      bool   `json:"encrypted,omitempty"`
+	SenderDeviceID string `json:"sender_device_id,omitempty"`
+}
+
+type DeviceKeysUpdatePayload struct {
+	UserID   uuid.UUID `json:"user_id"`
+	DeviceID string    `json:"device_id"`
+	Removed  bool      `json:"removed,omitempty"`
+}
+
+type SenderKeyDistributionPayload struct {
+	ID                uuid.UUID `json:"id"`
+	ConversationID    uuid.UUID `json:"conversation_id"`
+	SenderID          uuid.UUID `json:"sender_id"`
+	SenderDeviceID    string    `json:"sender_device_id"`
+	RecipientDeviceID string    `json:"recipient_device_id"`
+	Ciphertext        string    `json:"ciphertext"`
 }
 
 // lightweight notify payloads (for unfocused clients)
@@ -192,9 +347,11 @@ type UserBrief struct {
 	Domain        string    `json:"domain"`
 	ProfilePicURL string    `json:"profilePicURL,omitempty"`
 	Email         string    `json:"email"`
+	Bot           bool      `json:"bot,omitempty"`
 }
 
 type ErrorPayload struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	Details any    `json:"details,omitempty"` // machine readable context, e.g the limit that was exceeded
 }
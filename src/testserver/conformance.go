@@ -0,0 +1,224 @@
+package testserver
+
+// gateway protocol conformance: every client opcode with valid and malformed payloads and what the
+// server has to answer. TestGatewayConformance in routes/websocket runs them whenever the test server can start
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// how long silent cases and ExpectNone checks wait for something that mustnt arrive
+const quietPeriod = 300 * time.Millisecond
+
+// Fixture is two friends with their dm, both connected and identified
+type Fixture struct {
+	A, B           *User
+	GA, GB         *Gateway
+	ConversationID string
+}
+
+// ConformanceCase sends one message and checks the answer. exactly one of the expectations is set
+type ConformanceCase struct {
+	Name string
+
+	Raw  string               // sent as is instead of Op and Data, e.g malformed json
+	Op   int                  // client opcode
+	Data func(f *Fixture) any // payload, nil sends none
+
+	// sent on a fresh connection that never identified
+	Unidentified bool
+
+	ErrorCode  int    // error dispatch with this code
+	CloseCode  int    // the server closes the connection with this code
+	ReplyOp    int    // a message with this opcode
+	Ack        bool   // dispatch carrying the nonce of the request
+	ReplyEvent string // event to the sender, e.g PRESENCE_BATCH
+	PeerEvent  string // event to the other participant
+	Silent     bool   // nothing comes back
+}
+
+func randomID() string {
+	return uuid.NewV4().String()
+}
+
+func conversation(f *Fixture) any {
+	return map[string]any{"conversation_id": f.ConversationID}
+}
+
+// ConformanceCases covers HandleMessage, add a case with every opcode or error path
+var ConformanceCases = []ConformanceCase{
+	// framing and session
+	{Name: "malformed json closes", Raw: `{"op": 1,`, CloseCode: 4400},
+	{Name: "op before identify", Unidentified: true, Op: 1, ErrorCode: 4001},
+	{Name: "identify with malformed payload", Unidentified: true, Op: 2, Data: func(*Fixture) any { return "token" }, ErrorCode: 4000},
+	{Name: "identify with unknown token", Unidentified: true, Op: 2, Data: func(*Fixture) any { return map[string]string{"token": randomID()} }, ReplyOp: 13},
+	{Name: "identify with empty token", Unidentified: true, Op: 2, Data: func(*Fixture) any { return map[string]string{} }, ReplyOp: 13},
+	{Name: "identify twice", Op: 2, Data: func(*Fixture) any { return map[string]string{"token": randomID()} }, ErrorCode: 4003},
+	{Name: "unknown opcode", Op: 99, ErrorCode: 4002},
+	{Name: "heartbeat", Op: 1, Data: func(*Fixture) any { return map[string]int64{"ts": time.Now().UnixMilli()} }, ReplyOp: 11},
+
+	// extra identities
+	{Name: "identify extra with unknown token", Op: 5, Data: func(*Fixture) any { return map[string]string{"token": randomID()} }, ErrorCode: 4004},
+	{Name: "identify extra with own token", Op: 5, Data: func(f *Fixture) any { return map[string]string{"token": f.A.Token} }, ErrorCode: 4003},
+	{Name: "remove unknown identity", Op: 6, Data: func(*Fixture) any { return map[string]string{"user_id": randomID()} }, ErrorCode: 4004},
+	{Name: "remove identity with malformed payload", Op: 6, Data: func(*Fixture) any { return []int{1} }, ErrorCode: 4000},
+
+	// presence
+	{Name: "presence update", Op: 3, Data: func(*Fixture) any { return map[string]string{"status": "idle"} }, PeerEvent: "PRESENCE_UPDATE"},
+	{Name: "presence update with invalid status", Op: 3, Data: func(*Fixture) any { return map[string]string{"status": "asleep"} }, ErrorCode: 4000},
+	{Name: "presence query", Op: 7, Data: func(f *Fixture) any { return map[string]any{"user_ids": []string{f.B.ID}} }, ReplyEvent: "PRESENCE_BATCH"},
+	{Name: "presence query without ids", Op: 7, Data: func(*Fixture) any { return map[string]any{"user_ids": []string{}} }, ErrorCode: 4000},
+	{Name: "presence query over the limit", Op: 7, Data: func(*Fixture) any {
+		ids := make([]string, 201)
+		for i := range ids {
+			ids[i] = randomID()
+		}
+		return map[string]any{"user_ids": ids}
+	}, ErrorCode: 4000},
+
+	// focus and typing
+	{Name: "focus own conversation", Op: 4, Data: conversation, Ack: true},
+	{Name: "focus foreign conversation", Op: 4, Data: func(*Fixture) any { return map[string]string{"conversation_id": randomID()} }, Silent: true},
+	{Name: "typing in conversation", Op: 20, Data: conversation, PeerEvent: "TYPING_START"},
+	{Name: "typing in foreign conversation", Op: 20, Data: func(*Fixture) any { return map[string]string{"conversation_id": randomID()} }, Silent: true},
+	{Name: "typing stop in conversation", Op: 21, Data: conversation, PeerEvent: "TYPING_STOP"},
+
+	// messages
+	{Name: "message create", Op: 22, Data: func(f *Fixture) any {
+		return map[string]string{"conversation_id": f.ConversationID, "content": "conformance"}
+	}, Ack: true},
+	{Name: "message create reaches the peer", Op: 22, Data: func(f *Fixture) any {
+		return map[string]string{"conversation_id": f.ConversationID, "content": "conformance"}
+	}, PeerEvent: "DM_MESSAGE_NOTIFY"},
+	{Name: "message create without target", Op: 22, Data: func(*Fixture) any { return map[string]string{"content": "lost"} }, ErrorCode: 4000},
+	{Name: "message create in foreign conversation", Op: 22, Data: func(*Fixture) any {
+		return map[string]string{"conversation_id": randomID(), "content": "nope"}
+	}, ErrorCode: 4003},
+	{Name: "message create with malformed payload", Op: 22, Data: func(*Fixture) any { return "hello" }, ErrorCode: 4000},
+	{Name: "message edit without id", Op: 23, Data: func(f *Fixture) any {
+		return map[string]string{"conversation_id": f.ConversationID, "content": "edited"}
+	}, ErrorCode: 4000},
+	{Name: "message edit of unknown message", Op: 23, Data: func(f *Fixture) any {
+		return map[string]string{"id": randomID(), "conversation_id": f.ConversationID, "content": "edited"}
+	}, ErrorCode: 4004},
+	{Name: "message delete of unknown message", Op: 24, Data: func(f *Fixture) any {
+		return map[string]string{"message_id": randomID(), "conversation_id": f.ConversationID}
+	}, ErrorCode: 4004},
+	{Name: "message delete in foreign conversation", Op: 24, Data: func(*Fixture) any {
+		return map[string]string{"message_id": randomID(), "conversation_id": randomID()}
+	}, ErrorCode: 4003},
+	{Name: "message delete with malformed payload", Op: 24, Data: func(*Fixture) any { return 42 }, ErrorCode: 4000},
+
+	// acks
+	{Name: "read ack", Op: 25, Data: func(f *Fixture) any {
+		return map[string]string{"conversation_id": f.ConversationID, "message_id": randomID()}
+	}, PeerEvent: "MESSAGE_ACK"},
+	{Name: "read ack in foreign conversation", Op: 25, Data: func(*Fixture) any {
+		return map[string]string{"conversation_id": randomID(), "message_id": randomID()}
+	}, Silent: true},
+	{Name: "delivery ack of unknown message", Op: 26, Data: func(f *Fixture) any {
+		return map[string]string{"conversation_id": f.ConversationID, "message_id": randomID()}
+	}, Silent: true},
+}
+
+// NewFixture registers two users, makes them friends and connects both
+func (s *Server) NewFixture(t testing.TB) *Fixture {
+	t.Helper()
+
+	f := &Fixture{A: s.Register(t, "conformancea"), B: s.Register(t, "conformanceb")}
+
+	var request struct {
+		ID string `json:"id"`
+	}
+	if status := s.Request(t, f.A, http.MethodPost, "/friends/requests", map[string]string{"user_id": f.B.ID}, &request); status != http.StatusOK {
+		t.Fatalf("testserver: friend request: status %d", status)
+	}
+
+	var friendship struct {
+		ConversationID string `json:"conversation_id"`
+	}
+	if status := s.Request(t, f.B, http.MethodPost, "/friends/requests/"+request.ID+"/accept", nil, &friendship); status != http.StatusOK {
+		t.Fatalf("testserver: accept friend request: status %d", status)
+	}
+	f.ConversationID = friendship.ConversationID
+
+	f.GA = s.Connect(t, f.A)
+	f.GB = s.Connect(t, f.B)
+	return f
+}
+
+// RunConformance runs every case as a subtest, sent by A of a fresh fixture
+func RunConformance(t *testing.T, s *Server) {
+	for _, c := range ConformanceCases {
+		t.Run(c.Name, func(t *testing.T) {
+			f := s.NewFixture(t)
+			c.run(t, s, f)
+		})
+	}
+}
+
+func (c ConformanceCase) run(t *testing.T, s *Server, f *Fixture) {
+	t.Helper()
+
+	g := f.GA
+	if c.Unidentified {
+		g = s.Dial(t)
+	}
+
+	nonce := fmt.Sprintf("conformance-%d", time.Now().UnixNano())
+	if c.Raw != "" {
+		g.SendRaw(t, c.Raw)
+	} else {
+		message := map[string]any{"op": c.Op, "nonce": nonce}
+		if c.Data != nil {
+			message["d"] = c.Data(f)
+		}
+		g.Send(t, message)
+	}
+
+	switch {
+	case c.ErrorCode != 0:
+		g.ExpectError(t, c.ErrorCode)
+	case c.CloseCode != 0:
+		g.ExpectClose(t, c.CloseCode)
+	case c.ReplyOp != 0:
+		g.ExpectOp(t, c.ReplyOp)
+	case c.Ack:
+		g.ExpectAck(t, nonce)
+	case c.ReplyEvent != "":
+		if e := g.Expect(t, c.ReplyEvent); e.Nonce != nonce {
+			t.Fatalf("%s answered with nonce %q, want %q", c.ReplyEvent, e.Nonce, nonce)
+		}
+	case c.PeerEvent != "":
+		f.GB.Expect(t, c.PeerEvent)
+	case c.Silent:
+		g.expectQuiet(t, quietPeriod)
+	default:
+		t.Fatalf("case %q has no expectation", c.Name)
+	}
+}
+
+// expectQuiet fails when anything but presence and other background events arrives within d
+func (g *Gateway) expectQuiet(t testing.TB, d time.Duration) {
+	t.Helper()
+
+	deadline := time.After(d)
+	for {
+		select {
+		case e, ok := <-g.events:
+			if !ok {
+				t.Fatalf("testserver: connection closed: %v", g.closeErr)
+			}
+			if e.Op == 0 && e.Type == "" {
+				t.Fatalf("testserver: unexpected answer: %s", e.Data)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package testserver
+
+// in process server for end to end tests of the http routes and the gateway.
+// it runs the real router against the database in TEST_DATABASE_DSN and the valkey in TEST_VALKEY_URL
+// (e.g. the docker-compose services with a throwaway database), tests are skipped when they arent set.
+// the gateway hub is global, so every test in a package shares one server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	database "github.com/hindsightchat/backend/src/lib/dbs/tidb"
+	valkeydb "github.com/hindsightchat/backend/src/lib/dbs/valkey"
+	"github.com/hindsightchat/backend/src/router"
+	uuid "github.com/satori/go.uuid"
+)
+
+// how long Expect waits for an event by default
+const DefaultTimeout = 5 * time.Second
+
+type Server struct {
+	URL string
+}
+
+// User is a registered account with its auth token
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Token    string `json:"token"`
+}
+
+var (
+	shared    *Server
+	sharedErr error
+	startOnce sync.Once
+
+	// makes usernames unique between runs against the same database
+	runID   = uuid.NewV4().String()[:8]
+	counter atomic.Int64
+)
+
+// Start returns the shared server, booting it on first use
+func Start(t testing.TB) *Server {
+	t.Helper()
+
+	dsn, valkeyURL := os.Getenv("TEST_DATABASE_DSN"), os.Getenv("TEST_VALKEY_URL")
+	if dsn == "" || valkeyURL == "" {
+		t.Skip("TEST_DATABASE_DSN and TEST_VALKEY_URL not set")
+	}
+
+	startOnce.Do(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				sharedErr = fmt.Errorf("%v", r)
+			}
+		}()
+
+		os.Setenv("TIDB_DATABASE_DSN", dsn)
+		os.Setenv("VALKEY_URL", valkeyURL)
+		os.Setenv("VALKEY_PASSWORD", os.Getenv("TEST_VALKEY_PASSWORD"))
+		// Register forwards a different address each time, registration is rate limited per ip
+		os.Setenv("TRUSTED_PROXIES", "127.0.0.1,::1")
+
+		database.InitDatabase()
+		valkeydb.WaitUntilReady()
+
+		shared = &Server{URL: httptest.NewServer(router.New()).URL}
+	})
+	if sharedErr != nil {
+		t.Fatalf("testserver: failed to start: %v", sharedErr)
+	}
+	return shared
+}
+
+// Register creates a new account, name only needs to be unique within the test
+func (s *Server) Register(t testing.TB, name string) *User {
+	t.Helper()
+
+	n := counter.Add(1)
+	username := fmt.Sprintf("%s%s%d", name, runID, n)
+
+	header := http.Header{}
+	header.Set("X-Forwarded-For", fmt.Sprintf("198.18.%d.%d", n/256%256, n%256))
+
+	var user User
+	status := s.request(t, nil, http.MethodPost, "/auth/register", header, map[string]any{
+		"username":    username,
+		"password":    "password123",
+		"email":       username + "@test.localhost",
+		"acceptTerms": true,
+	}, &user)
+	if status != http.StatusOK {
+		t.Fatalf("testserver: register %s: status %d", name, status)
+	}
+	return &user
+}
+
+// Request sends body as json, as user when set, and decodes the data of a success response into out.
+// returns the status code
+func (s *Server) Request(t testing.TB, user *User, method, path string, body, out any) int {
+	t.Helper()
+	return s.request(t, user, method, path, nil, body, out)
+}
+
+func (s *Server) request(t testing.TB, user *User, method, path string, header http.Header, body, out any) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("testserver: encode body: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		t.Fatalf("testserver: %s %s: %v", method, path, err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if user != nil {
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("testserver: %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode == http.StatusOK {
+		var envelope struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+			t.Fatalf("testserver: %s %s: decode response: %v", method, path, err)
+		}
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			t.Fatalf("testserver: %s %s: decode data: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// Event is a message received on the gateway
+type Event struct {
+	Op    int             `json:"op"`
+	Type  string          `json:"t"`
+	Data  json.RawMessage `json:"d"`
+	Nonce string          `json:"nonce"`
+}
+
+// Decode unmarshals the event data into out
+func (e Event) Decode(t testing.TB, out any) {
+	t.Helper()
+	if err := json.Unmarshal(e.Data, out); err != nil {
+		t.Fatalf("testserver: decode %s: %v", e.Type, err)
+	}
+}
+
+// Gateway is a websocket connection, closed when the test ends
+type Gateway struct {
+	conn   *websocket.Conn
+	events chan Event
+	ready  Event
+
+	// set before events is closed
+	closeErr error
+}
+
+// Connect opens a gateway connection for the user and waits for READY
+func (s *Server) Connect(t testing.TB, user *User) *Gateway {
+	t.Helper()
+
+	g := s.Dial(t)
+	g.Send(t, map[string]any{"op": 2, "d": map[string]string{"token": user.Token}})
+
+	// READY is its own opcode
+	g.ready = g.ExpectOp(t, 12)
+	return g
+}
+
+// Dial opens a gateway connection without identifying
+func (s *Server) Dial(t testing.TB) *Gateway {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/gateway", nil)
+	if err != nil {
+		t.Fatalf("testserver: dial gateway: %v", err)
+	}
+
+	g := &Gateway{conn: conn, events: make(chan Event, 256)}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		defer close(g.events)
+		for {
+			var event Event
+			if err := conn.ReadJSON(&event); err != nil {
+				g.closeErr = err
+				return
+			}
+			g.events <- event
+		}
+	}()
+
+	return g
+}
+
+// Ready returns the READY payload the connection got
+func (g *Gateway) Ready() Event {
+	return g.ready
+}
+
+// Send writes a raw gateway message
+func (g *Gateway) Send(t testing.TB, message any) {
+	t.Helper()
+	if err := g.conn.WriteJSON(message); err != nil {
+		t.Fatalf("testserver: gateway write: %v", err)
+	}
+}
+
+// SendRaw writes a text frame as is, e.g for malformed json
+func (g *Gateway) SendRaw(t testing.TB, data string) {
+	t.Helper()
+	if err := g.conn.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+		t.Fatalf("testserver: gateway write: %v", err)
+	}
+}
+
+// Expect waits for the next dispatch of the event, skipping everything else
+func (g *Gateway) Expect(t testing.TB, event string) Event {
+	t.Helper()
+	return g.expect(t, DefaultTimeout, func(e Event) bool { return e.Op == 0 && e.Type == event })
+}
+
+// ExpectOp waits for the next message with the opcode
+func (g *Gateway) ExpectOp(t testing.TB, op int) Event {
+	t.Helper()
+	return g.expect(t, DefaultTimeout, func(e Event) bool { return e.Op == op })
+}
+
+// ExpectAck waits for the untyped dispatch answering the nonce
+func (g *Gateway) ExpectAck(t testing.TB, nonce string) Event {
+	t.Helper()
+	return g.expect(t, DefaultTimeout, func(e Event) bool { return e.Op == 0 && e.Type == "" && e.Nonce == nonce })
+}
+
+// ExpectError waits for an error dispatch and fails unless it has the code
+func (g *Gateway) ExpectError(t testing.TB, code int) {
+	t.Helper()
+
+	e := g.expect(t, DefaultTimeout, func(e Event) bool { return e.Op == 0 && e.Type == "" && e.Nonce == "" })
+
+	var payload struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	e.Decode(t, &payload)
+	if payload.Code != code {
+		t.Fatalf("testserver: expected error %d, got %d (%s)", code, payload.Code, payload.Message)
+	}
+}
+
+// ExpectClose waits for the server to close the connection with the close code
+func (g *Gateway) ExpectClose(t testing.TB, code int) {
+	t.Helper()
+
+	deadline := time.After(DefaultTimeout)
+	for {
+		select {
+		case _, ok := <-g.events:
+			if ok {
+				continue
+			}
+			if !websocket.IsCloseError(g.closeErr, code) {
+				t.Fatalf("testserver: expected close %d, got %v", code, g.closeErr)
+			}
+			return
+		case <-deadline:
+			t.Fatalf("testserver: connection still open after %v", DefaultTimeout)
+		}
+	}
+}
+
+// ExpectNone fails when the event is dispatched within d
+func (g *Gateway) ExpectNone(t testing.TB, event string, d time.Duration) {
+	t.Helper()
+
+	deadline := time.After(d)
+	for {
+		select {
+		case e, ok := <-g.events:
+			if !ok {
+				return
+			}
+			if e.Op == 0 && e.Type == event {
+				t.Fatalf("testserver: unexpected %s: %s", event, e.Data)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func (g *Gateway) expect(t testing.TB, timeout time.Duration, match func(Event) bool) Event {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case e, ok := <-g.events:
+			if !ok {
+				t.Fatalf("testserver: gateway closed while waiting")
+			}
+			if match(e) {
+				return e
+			}
+		case <-deadline:
+			t.Fatalf("testserver: nothing matching after %v", timeout)
+		}
+	}
+}
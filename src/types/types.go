@@ -17,4 +17,42 @@ type Activity struct {
 	AppName string `json:"app_name"`
 
 	Timestamps *ActivityTimestamps `json:"timestamps,omitempty"`
+
+	// registered application the activity belongs to, images are asset keys of that application
+	ApplicationID string `json:"application_id,omitempty"`
+	LargeImage    string `json:"large_image,omitempty"`
+	SmallImage    string `json:"small_image,omitempty"`
+
+	// filled in by the server
+	Assets   *ActivityAssets `json:"assets,omitempty"`
+	Verified bool            `json:"verified,omitempty"`
+}
+
+type ActivityAssets struct {
+	LargeURL string `json:"large_url,omitempty"`
+	SmallURL string `json:"small_url,omitempty"`
+}
+
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type EmbedAuthor struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+// rich card attached to a message, used by integrations and webhooks
+type Embed struct {
+	Title       string       `json:"title,omitempty"`
+	Description string       `json:"description,omitempty"`
+	URL         string       `json:"url,omitempty"`
+	Color       int          `json:"color,omitempty"` // 0xRRGGBB
+	Author      *EmbedAuthor `json:"author,omitempty"`
+	Fields      []EmbedField `json:"fields,omitempty"`
+	Footer      string       `json:"footer,omitempty"`
+	Timestamp   string       `json:"timestamp,omitempty"` // rfc3339
 }